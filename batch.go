@@ -360,6 +360,13 @@ type batchInternal struct {
 
 	commitErr error
 
+	// replicatedSeqNum, if non-zero, is the sequence number the batch was given
+	// by a primary DB (see DB.ApplyReplicated).
+	replicatedSeqNum uint64
+	// replicatedErr is set if the batch was rejected by the commit pipeline
+	// because its replicated sequence numbers precede those of the DB.
+	replicatedErr error
+
 	// Position bools together to reduce the sizeof the struct.
 
 	// ingestedSSTBatch indicates that the batch contains one or more key kinds
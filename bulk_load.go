@@ -0,0 +1,863 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"slices"
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/keyspan/keyspanimpl"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ErrBulkLoadDuplicateKey is returned by BulkLoader.Finish when a key was added
+// more than once and the duplicate policy is BulkLoadDuplicateError.
+var ErrBulkLoadDuplicateKey = errors.New("pebble: duplicate key in bulk load")
+
+// BulkLoadDuplicatePolicy determines which of the operations on a key added
+// more than once to a BulkLoader is loaded.
+type BulkLoadDuplicatePolicy int8
+
+const (
+	// BulkLoadKeepLast loads the last operation added for a key, as if the
+	// operations had been applied in order.
+	BulkLoadKeepLast BulkLoadDuplicatePolicy = iota
+	// BulkLoadKeepFirst loads the first operation added for a key.
+	BulkLoadKeepFirst
+	// BulkLoadDuplicateError fails the load with ErrBulkLoadDuplicateKey.
+	BulkLoadDuplicateError
+)
+
+// String implements fmt.Stringer.
+func (p BulkLoadDuplicatePolicy) String() string {
+	switch p {
+	case BulkLoadKeepLast:
+		return "keep-last"
+	case BulkLoadKeepFirst:
+		return "keep-first"
+	case BulkLoadDuplicateError:
+		return "error"
+	default:
+		return fmt.Sprintf("BulkLoadDuplicatePolicy(%d)", int8(p))
+	}
+}
+
+// BulkLoadPhase is the phase of a bulk load reported in BulkLoadProgress.
+type BulkLoadPhase int8
+
+const (
+	// BulkLoadAdding is the phase during which operations are added, and
+	// sorted runs spilled to temporary files.
+	BulkLoadAdding BulkLoadPhase = iota
+	// BulkLoadWriting is the phase during which the sorted runs are merged and
+	// the sstables to ingest written.
+	BulkLoadWriting
+	// BulkLoadIngesting is the phase during which the sstables are ingested.
+	BulkLoadIngesting
+	// BulkLoadDone is reported once the sstables are ingested.
+	BulkLoadDone
+)
+
+// String implements fmt.Stringer.
+func (p BulkLoadPhase) String() string {
+	switch p {
+	case BulkLoadAdding:
+		return "adding"
+	case BulkLoadWriting:
+		return "writing"
+	case BulkLoadIngesting:
+		return "ingesting"
+	case BulkLoadDone:
+		return "done"
+	default:
+		return fmt.Sprintf("BulkLoadPhase(%d)", int8(p))
+	}
+}
+
+// BulkLoadProgress describes the progress of a bulk load.
+type BulkLoadProgress struct {
+	Phase BulkLoadPhase
+	// KeysAdded is the number of operations added to the loader.
+	KeysAdded uint64
+	// RunsSpilled is the number of sorted runs spilled to temporary files.
+	RunsSpilled int
+	// KeysWritten is the number of point keys written to the sstables to
+	// ingest, after the resolution of duplicates.
+	KeysWritten uint64
+	// TablesWritten and BytesWritten are the number and total size of the
+	// sstables to ingest written so far.
+	TablesWritten int
+	BytesWritten  uint64
+}
+
+// BulkLoadOptions configures a BulkLoader.
+type BulkLoadOptions struct {
+	// TempDir is the directory, on the DB's filesystem, in which the sorted
+	// runs and the sstables to ingest are written. It is created if needed and
+	// removed when the loader is finished or closed, so it must not be used by
+	// anything else. Defaults to a directory within the DB's directory.
+	TempDir string
+
+	// MemoryLimit is the amount of memory used to buffer the added operations,
+	// including range deletions, before they are sorted and spilled to a run.
+	// Defaults to 64MB.
+	MemoryLimit int64
+
+	// TargetFileSize is the size above which an sstable to ingest is finished
+	// and the next one started. Defaults to the target file size of L6.
+	TargetFileSize int64
+
+	// DuplicatePolicy determines how keys added more than once are loaded.
+	// Defaults to BulkLoadKeepLast.
+	DuplicatePolicy BulkLoadDuplicatePolicy
+
+	// OnProgress, if set, is called with the progress of the load after every
+	// spilled run and written sstable, and when the phase changes.
+	OnProgress func(BulkLoadProgress)
+}
+
+const defaultBulkLoadMemoryLimit = 64 << 20
+
+// bulkLoadEntry is a buffered operation of a BulkLoader. The ord is the order
+// in which the operation was added, and is used as the sequence number of the
+// keys of the sorted runs so that the newest operation on a key sorts first.
+type bulkLoadEntry struct {
+	key   []byte
+	value []byte
+	ord   uint64
+	kind  InternalKeyKind
+}
+
+// bulkLoadRangeDel is a buffered range deletion of a BulkLoader.
+type bulkLoadRangeDel struct {
+	start, end []byte
+	ord        uint64
+}
+
+// bulkLoadFragment is a fragment of the range deletions of a BulkLoader.
+// maxOrd is the order of the last range deletion covering the fragment, which
+// deletes the points added before it.
+type bulkLoadFragment struct {
+	start, end []byte
+	maxOrd     uint64
+}
+
+// bulkLoadFragmentIter iterates over the fragments of the range deletions of
+// a BulkLoader in increasing order, as they are read from the sorted runs.
+type bulkLoadFragmentIter struct {
+	iter keyspan.FragmentIterator
+	// frag is the current fragment, or nil once the iterator is exhausted. It
+	// is only valid until the next call to next.
+	frag *bulkLoadFragment
+	buf  bulkLoadFragment
+}
+
+func newBulkLoadFragmentIter(iter keyspan.FragmentIterator) (*bulkLoadFragmentIter, error) {
+	i := &bulkLoadFragmentIter{iter: iter}
+	if err := i.set(iter.First()); err != nil {
+		_ = iter.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+// next moves to the next fragment.
+func (i *bulkLoadFragmentIter) next() error {
+	return i.set(i.iter.Next())
+}
+
+func (i *bulkLoadFragmentIter) set(s *keyspan.Span, err error) error {
+	// The merged fragments of the sorted runs may include spans without keys
+	// between the fragments of different runs.
+	for err == nil && s != nil && len(s.Keys) == 0 {
+		s, err = i.iter.Next()
+	}
+	if s == nil || err != nil {
+		i.frag = nil
+		return err
+	}
+	i.buf.start = append(i.buf.start[:0], s.Start...)
+	i.buf.end = append(i.buf.end[:0], s.End...)
+	// The keys are sorted by decreasing sequence number.
+	i.buf.maxOrd = s.Keys[0].SeqNum()
+	i.frag = &i.buf
+	return nil
+}
+
+func (i *bulkLoadFragmentIter) close() error {
+	return i.iter.Close()
+}
+
+// A BulkLoader loads a large number of operations, added in any order, into a
+// DB. The operations are buffered and spilled to sorted runs in temporary
+// files, which are merged by Finish into sstables that are then ingested. The
+// sstables are split at the boundaries of the sstables of the LSM, so that as
+// many of them as possible are ingested into L6.
+//
+// The operations are ingested together, and replace the previous values of
+// their keys in the DB. Operations on the same key are resolved according to
+// BulkLoadOptions.DuplicatePolicy; a range deletion deletes the keys added
+// before it, and the keys of the DB.
+//
+// A BulkLoader is not safe for concurrent use, and must be closed.
+type BulkLoader struct {
+	db          *DB
+	opts        BulkLoadOptions
+	cmp         Compare
+	tableFormat sstable.TableFormat
+
+	entries   []bulkLoadEntry
+	rangeDels []bulkLoadRangeDel
+	// alloc is the chunk of memory from which the keys and values of entries,
+	// and the bounds of rangeDels, are allocated.
+	alloc    []byte
+	memUsed  int64
+	nextOrd  uint64
+	runs     []string
+	tables   []string
+	progress BulkLoadProgress
+	finished bool
+	closed   bool
+}
+
+// NewBulkLoader returns a BulkLoader that loads operations into the DB.
+func (d *DB) NewBulkLoader(opts BulkLoadOptions) (*BulkLoader, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if opts.TempDir == "" {
+		opts.TempDir = d.opts.FS.PathJoin(d.dirname, fmt.Sprintf("bulkload-%d", d.newJobID()))
+	}
+	if opts.MemoryLimit <= 0 {
+		opts.MemoryLimit = defaultBulkLoadMemoryLimit
+	}
+	if opts.TargetFileSize <= 0 {
+		opts.TargetFileSize = d.opts.Level(numLevels - 1).TargetFileSize
+	}
+	if err := d.opts.FS.MkdirAll(opts.TempDir, 0755); err != nil {
+		return nil, err
+	}
+	return &BulkLoader{
+		db:          d,
+		opts:        opts,
+		cmp:         d.cmp,
+		tableFormat: d.FormatMajorVersion().MaxTableFormat(),
+	}, nil
+}
+
+// Set adds an operation setting the value of the key. The key and value may be
+// reused once Set returns.
+func (l *BulkLoader) Set(key, value []byte) error {
+	return l.add(key, value, InternalKeyKindSet)
+}
+
+// Delete adds an operation deleting the key. The key may be reused once Delete
+// returns.
+func (l *BulkLoader) Delete(key []byte) error {
+	return l.add(key, nil, InternalKeyKindDelete)
+}
+
+// DeleteRange adds an operation deleting the keys in [start, end). The keys may
+// be reused once DeleteRange returns.
+func (l *BulkLoader) DeleteRange(start, end []byte) error {
+	if err := l.checkAdding(); err != nil {
+		return err
+	}
+	if l.cmp(start, end) >= 0 {
+		return errors.Errorf("pebble: invalid range deletion [%s, %s)",
+			l.db.opts.Comparer.FormatKey(start), l.db.opts.Comparer.FormatKey(end))
+	}
+	rd := bulkLoadRangeDel{ord: l.nextOrd}
+	rd.start, rd.end = l.copyBytes(start), l.copyBytes(end)
+	l.rangeDels = append(l.rangeDels, rd)
+	l.nextOrd++
+	l.progress.KeysAdded++
+	l.memUsed += int64(len(start)+len(end)) + int64(unsafe.Sizeof(bulkLoadRangeDel{}))
+	if l.memUsed >= l.opts.MemoryLimit {
+		return l.spill()
+	}
+	return nil
+}
+
+// Progress returns the progress of the load.
+func (l *BulkLoader) Progress() BulkLoadProgress {
+	return l.progress
+}
+
+func (l *BulkLoader) checkAdding() error {
+	if l.closed {
+		return errors.New("pebble: bulk loader closed")
+	}
+	if l.finished {
+		return errors.New("pebble: bulk loader already finished")
+	}
+	return nil
+}
+
+func (l *BulkLoader) add(key, value []byte, kind InternalKeyKind) error {
+	if err := l.checkAdding(); err != nil {
+		return err
+	}
+	e := bulkLoadEntry{ord: l.nextOrd, kind: kind}
+	e.key, e.value = l.copyBytes(key), l.copyBytes(value)
+	l.entries = append(l.entries, e)
+	l.nextOrd++
+	l.progress.KeysAdded++
+	l.memUsed += int64(len(key)+len(value)) + int64(unsafe.Sizeof(bulkLoadEntry{}))
+	if l.memUsed >= l.opts.MemoryLimit {
+		return l.spill()
+	}
+	return nil
+}
+
+// copyBytes copies b into the memory of the loader.
+func (l *BulkLoader) copyBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) > cap(l.alloc)-len(l.alloc) {
+		n := max(len(b), 64<<10)
+		l.alloc = make([]byte, 0, n)
+	}
+	start := len(l.alloc)
+	l.alloc = append(l.alloc, b...)
+	return l.alloc[start:len(l.alloc):len(l.alloc)]
+}
+
+// sortEntries sorts the buffered operations by user key, and by decreasing
+// order for the same user key.
+func (l *BulkLoader) sortEntries() {
+	slices.SortFunc(l.entries, func(a, b bulkLoadEntry) int {
+		if c := l.cmp(a.key, b.key); c != 0 {
+			return c
+		}
+		// The ords are unique.
+		if a.ord > b.ord {
+			return -1
+		}
+		return +1
+	})
+}
+
+// spill writes the buffered operations to a sorted run. The range deletions
+// are written fragmented, as a single tombstone per fragment whose sequence
+// number is the fragment's maxOrd.
+func (l *BulkLoader) spill() error {
+	if len(l.entries) == 0 && len(l.rangeDels) == 0 {
+		return nil
+	}
+	l.sortEntries()
+	fs := l.db.opts.FS
+	path := fs.PathJoin(l.opts.TempDir, fmt.Sprintf("run-%06d.sst", len(l.runs)))
+	f, err := fs.Create(path, vfs.WriteCategoryUnspecified)
+	if err != nil {
+		return err
+	}
+	l.runs = append(l.runs, path)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), l.db.opts.MakeWriterOptions(0, l.tableFormat))
+	for i := range l.entries {
+		e := &l.entries[i]
+		if err := w.Add(base.MakeInternalKey(e.key, e.ord, e.kind), e.value); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	for _, f := range l.fragmentRangeDels() {
+		if err := w.Add(base.MakeInternalKey(f.start, f.maxOrd, InternalKeyKindRangeDelete), f.end); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	clear(l.entries)
+	l.entries = l.entries[:0]
+	clear(l.rangeDels)
+	l.rangeDels = l.rangeDels[:0]
+	l.alloc = l.alloc[:0]
+	l.memUsed = 0
+	l.progress.RunsSpilled++
+	l.reportProgress()
+	return nil
+}
+
+func (l *BulkLoader) reportProgress() {
+	if l.opts.OnProgress != nil {
+		l.opts.OnProgress(l.progress)
+	}
+}
+
+// Finish merges the added operations into sstables and ingests them into the
+// DB. The loader must still be closed once Finish returns.
+func (l *BulkLoader) Finish() (IngestOperationStats, error) {
+	if err := l.checkAdding(); err != nil {
+		return IngestOperationStats{}, err
+	}
+	l.finished = true
+	if err := l.write(); err != nil {
+		return IngestOperationStats{}, err
+	}
+	l.progress.Phase = BulkLoadIngesting
+	l.reportProgress()
+	var stats IngestOperationStats
+	if len(l.tables) > 0 {
+		var err error
+		if stats, err = l.db.IngestWithStats(l.tables); err != nil {
+			return IngestOperationStats{}, err
+		}
+	}
+	l.progress.Phase = BulkLoadDone
+	l.reportProgress()
+	return stats, nil
+}
+
+// bulkLoadSource is the iterator over the sorted operations of a BulkLoader.
+type bulkLoadSource interface {
+	First() *base.InternalKV
+	Next() *base.InternalKV
+	Error() error
+	Close() error
+}
+
+// bulkLoadEntryIter iterates over sorted bulkLoadEntries.
+type bulkLoadEntryIter struct {
+	entries []bulkLoadEntry
+	pos     int
+	kv      base.InternalKV
+}
+
+func (i *bulkLoadEntryIter) First() *base.InternalKV {
+	i.pos = -1
+	return i.Next()
+}
+
+func (i *bulkLoadEntryIter) Next() *base.InternalKV {
+	i.pos++
+	if i.pos >= len(i.entries) {
+		return nil
+	}
+	e := &i.entries[i.pos]
+	i.kv = base.InternalKV{
+		K: base.MakeInternalKey(e.key, e.ord, e.kind),
+		V: base.MakeInPlaceValue(e.value),
+	}
+	return &i.kv
+}
+
+func (i *bulkLoadEntryIter) Error() error { return nil }
+
+func (i *bulkLoadEntryIter) Close() error { return nil }
+
+// bulkLoadRuns are the sorted operations of a BulkLoader: the buffered
+// operations if no run was spilled, or else the sorted runs.
+type bulkLoadRuns struct {
+	l       *BulkLoader
+	readers []*sstable.Reader
+	// spans are the fragmented range deletions, if no run was spilled.
+	spans []keyspan.Span
+}
+
+// openRuns sorts the buffered operations if no run was spilled, and otherwise
+// spills them and opens the sorted runs.
+func (l *BulkLoader) openRuns() (*bulkLoadRuns, error) {
+	r := &bulkLoadRuns{l: l}
+	if len(l.runs) == 0 {
+		l.sortEntries()
+		for _, f := range l.fragmentRangeDels() {
+			r.spans = append(r.spans, keyspan.Span{
+				Start: f.start,
+				End:   f.end,
+				Keys:  []keyspan.Key{{Trailer: base.MakeTrailer(f.maxOrd, InternalKeyKindRangeDelete)}},
+			})
+		}
+		return r, nil
+	}
+	if err := l.spill(); err != nil {
+		return nil, err
+	}
+	for _, path := range l.runs {
+		f, err := l.db.opts.FS.Open(path)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		readable, err := sstable.NewSimpleReadable(f)
+		if err != nil {
+			_ = f.Close()
+			r.close()
+			return nil, err
+		}
+		reader, err := sstable.NewReader(readable, l.db.opts.MakeReaderOptions())
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.readers = append(r.readers, reader)
+	}
+	return r, nil
+}
+
+// points returns an iterator over the sorted point operations, merging the
+// sorted runs if any were spilled.
+func (r *bulkLoadRuns) points() (bulkLoadSource, error) {
+	l := r.l
+	if len(l.runs) == 0 {
+		return &bulkLoadEntryIter{entries: l.entries}, nil
+	}
+	iters := make([]internalIterator, 0, len(r.readers))
+	for _, reader := range r.readers {
+		iter, err := reader.NewIter(sstable.NoTransforms, nil /* lower */, nil /* upper */)
+		if err != nil {
+			for _, iter := range iters {
+				_ = iter.Close()
+			}
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+	return newMergingIter(l.db.opts.Logger, &base.InternalIteratorStats{}, l.cmp, nil /* split */, iters...), nil
+}
+
+// fragments returns an iterator over the fragmented range deletions, merging
+// those of the sorted runs if any were spilled.
+func (r *bulkLoadRuns) fragments() (*bulkLoadFragmentIter, error) {
+	l := r.l
+	if len(l.runs) == 0 {
+		return newBulkLoadFragmentIter(keyspan.NewIter(l.cmp, r.spans))
+	}
+	var iters []keyspan.FragmentIterator
+	for _, reader := range r.readers {
+		iter, err := reader.NewRawRangeDelIter(sstable.NoTransforms)
+		if err != nil {
+			for _, iter := range iters {
+				_ = iter.Close()
+			}
+			return nil, err
+		}
+		if iter != nil {
+			iters = append(iters, iter)
+		}
+	}
+	mi := &keyspanimpl.MergingIter{}
+	mi.Init(l.cmp, keyspan.NoopTransform, new(keyspanimpl.MergingBuffers), iters...)
+	return newBulkLoadFragmentIter(mi)
+}
+
+func (r *bulkLoadRuns) close() {
+	for _, reader := range r.readers {
+		_ = reader.Close()
+	}
+}
+
+// fragmentRangeDels fragments the range deletions.
+func (l *BulkLoader) fragmentRangeDels() []bulkLoadFragment {
+	slices.SortFunc(l.rangeDels, func(a, b bulkLoadRangeDel) int {
+		return l.cmp(a.start, b.start)
+	})
+	var frags []bulkLoadFragment
+	frag := keyspan.Fragmenter{
+		Cmp:    l.cmp,
+		Format: l.db.opts.Comparer.FormatKey,
+		Emit: func(s keyspan.Span) {
+			// The keys are sorted by decreasing sequence number.
+			frags = append(frags, bulkLoadFragment{start: s.Start, end: s.End, maxOrd: s.Keys[0].SeqNum()})
+		},
+	}
+	for _, rd := range l.rangeDels {
+		frag.Add(keyspan.Span{
+			Start: rd.start,
+			End:   rd.end,
+			Keys:  []keyspan.Key{{Trailer: base.MakeTrailer(rd.ord, InternalKeyKindRangeDelete)}},
+		})
+	}
+	frag.Finish()
+	return frags
+}
+
+// write merges the added operations into the sstables to ingest.
+func (l *BulkLoader) write() error {
+	l.progress.Phase = BulkLoadWriting
+	l.reportProgress()
+	runs, err := l.openRuns()
+	if err != nil {
+		return err
+	}
+	defer runs.close()
+	// The fragments are iterated over twice: to drop the points they delete,
+	// and to write them to the sstables.
+	frags, err := runs.fragments()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = frags.close() }()
+	outFrags, err := runs.fragments()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = outFrags.close() }()
+	out := &bulkLoadOutput{
+		l:          l,
+		writerOpts: l.db.opts.MakeWriterOptions(numLevels-1, l.tableFormat),
+		boundaries: l.lsmBoundaries(),
+		frags:      outFrags,
+	}
+	src, err := runs.points()
+	if err != nil {
+		return err
+	}
+	err = l.writePoints(src, frags, out)
+	err = firstError(err, src.Error())
+	err = firstError(err, src.Close())
+	if err != nil {
+		_ = out.abort()
+		return err
+	}
+	return out.finish()
+}
+
+func (l *BulkLoader) writePoints(
+	src bulkLoadSource, frags *bulkLoadFragmentIter, out *bulkLoadOutput,
+) error {
+	var keyBuf, valueBuf []byte
+	kv := src.First()
+	for kv != nil {
+		// The first operation on a user key is the last one added.
+		keyBuf = append(keyBuf[:0], kv.K.UserKey...)
+		kind, ord := kv.K.Kind(), kv.K.SeqNum()
+		v, _, err := kv.Value(nil)
+		if err != nil {
+			return err
+		}
+		valueBuf = append(valueBuf[:0], v...)
+		n := 1
+		for kv = src.Next(); kv != nil && l.cmp(kv.K.UserKey, keyBuf) == 0; kv = src.Next() {
+			n++
+			if l.opts.DuplicatePolicy == BulkLoadKeepFirst {
+				kind, ord = kv.K.Kind(), kv.K.SeqNum()
+				v, _, err := kv.Value(nil)
+				if err != nil {
+					return err
+				}
+				valueBuf = append(valueBuf[:0], v...)
+			}
+		}
+		if n > 1 && l.opts.DuplicatePolicy == BulkLoadDuplicateError {
+			return errors.Wrapf(ErrBulkLoadDuplicateKey, "%s", l.db.opts.Comparer.FormatKey(keyBuf))
+		}
+		// Drop the operation if a range deletion added after it covers the key.
+		for frags.frag != nil && l.cmp(frags.frag.end, keyBuf) <= 0 {
+			if err := frags.next(); err != nil {
+				return err
+			}
+		}
+		if f := frags.frag; f != nil && l.cmp(f.start, keyBuf) <= 0 && f.maxOrd > ord {
+			continue
+		}
+		if kind != InternalKeyKindDelete {
+			kind = InternalKeyKindSet
+		} else {
+			valueBuf = valueBuf[:0]
+		}
+		if err := out.addPoint(base.MakeInternalKey(keyBuf, 0, kind), valueBuf); err != nil {
+			return err
+		}
+		l.progress.KeysWritten++
+	}
+	return nil
+}
+
+// bulkLoadBoundary is a boundary of an sstable of the LSM: the position just
+// before its smallest user key, or just after its largest user key if after is
+// set.
+type bulkLoadBoundary struct {
+	key   []byte
+	after bool
+}
+
+// lsmBoundaries returns the sorted boundaries of the sstables of the LSM.
+func (l *BulkLoader) lsmBoundaries() []bulkLoadBoundary {
+	var boundaries []bulkLoadBoundary
+	d := l.db
+	d.mu.Lock()
+	v := d.mu.versions.currentVersion()
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			boundaries = append(boundaries,
+				bulkLoadBoundary{key: slices.Clone(f.Smallest.UserKey)},
+				bulkLoadBoundary{key: slices.Clone(f.Largest.UserKey), after: true})
+		}
+	}
+	d.mu.Unlock()
+	slices.SortFunc(boundaries, func(a, b bulkLoadBoundary) int {
+		if c := l.cmp(a.key, b.key); c != 0 {
+			return c
+		}
+		switch {
+		case a.after == b.after:
+			return 0
+		case b.after:
+			return -1
+		default:
+			return +1
+		}
+	})
+	return boundaries
+}
+
+// bulkLoadOutput writes the sstables to ingest.
+type bulkLoadOutput struct {
+	l          *BulkLoader
+	writerOpts sstable.WriterOptions
+	w          *sstable.Writer
+
+	// boundaries are the sorted boundaries of the sstables of the LSM, and
+	// boundaryIdx the index of the first boundary not before the last key
+	// added. A new sstable is started whenever a boundary is crossed.
+	boundaries  []bulkLoadBoundary
+	boundaryIdx int
+
+	// frags iterates over the fragmented range deletions, and is positioned at
+	// the first one not yet entirely written. If fragStart is set, the
+	// fragment was partially written to the previous sstable up to fragStart.
+	frags     *bulkLoadFragmentIter
+	fragStart []byte
+}
+
+// addPoint adds a point key, in increasing order of user keys.
+func (o *bulkLoadOutput) addPoint(key InternalKey, value []byte) error {
+	cmp := o.l.cmp
+	for o.frags.frag != nil && cmp(o.frags.frag.end, key.UserKey) <= 0 {
+		if err := o.writeFragment(o.frags.frag.end); err != nil {
+			return err
+		}
+		if err := o.frags.next(); err != nil {
+			return err
+		}
+	}
+	crossed := false
+	for o.boundaryIdx < len(o.boundaries) {
+		b := &o.boundaries[o.boundaryIdx]
+		if c := cmp(b.key, key.UserKey); c > 0 || (c == 0 && b.after) {
+			break
+		}
+		o.boundaryIdx++
+		crossed = true
+	}
+	if o.w != nil && (crossed || o.w.EstimatedSize() >= uint64(o.l.opts.TargetFileSize)) {
+		// The sstable ends before the key; the fragment that spans the key is
+		// split between the sstables.
+		if o.frags.frag != nil && cmp(o.currentFragmentStart(), key.UserKey) < 0 {
+			if err := o.writeFragment(key.UserKey); err != nil {
+				return err
+			}
+			o.fragStart = slices.Clone(key.UserKey)
+		}
+		if err := o.finishTable(); err != nil {
+			return err
+		}
+	}
+	if err := o.ensureWriter(); err != nil {
+		return err
+	}
+	return o.w.Add(key, value)
+}
+
+func (o *bulkLoadOutput) currentFragmentStart() []byte {
+	if o.fragStart != nil {
+		return o.fragStart
+	}
+	return o.frags.frag.start
+}
+
+// writeFragment writes the current fragment of the range deletions up to end.
+func (o *bulkLoadOutput) writeFragment(end []byte) error {
+	if err := o.ensureWriter(); err != nil {
+		return err
+	}
+	start := o.currentFragmentStart()
+	o.fragStart = nil
+	return o.w.DeleteRange(start, end)
+}
+
+func (o *bulkLoadOutput) ensureWriter() error {
+	if o.w != nil {
+		return nil
+	}
+	l := o.l
+	fs := l.db.opts.FS
+	path := fs.PathJoin(l.opts.TempDir, fmt.Sprintf("table-%06d.sst", len(l.tables)))
+	f, err := fs.Create(path, vfs.WriteCategoryUnspecified)
+	if err != nil {
+		return err
+	}
+	l.tables = append(l.tables, path)
+	o.w = sstable.NewWriter(objstorageprovider.NewFileWritable(f), o.writerOpts)
+	return nil
+}
+
+func (o *bulkLoadOutput) finishTable() error {
+	w := o.w
+	o.w = nil
+	if err := w.Close(); err != nil {
+		return err
+	}
+	meta, err := w.Metadata()
+	if err != nil {
+		return err
+	}
+	o.l.progress.TablesWritten++
+	o.l.progress.BytesWritten += meta.Size
+	o.l.reportProgress()
+	return nil
+}
+
+// finish writes the remaining range deletions and finishes the last sstable.
+func (o *bulkLoadOutput) finish() error {
+	for o.frags.frag != nil {
+		err := o.writeFragment(o.frags.frag.end)
+		if err == nil {
+			err = o.frags.next()
+		}
+		if err != nil {
+			_ = o.abort()
+			return err
+		}
+	}
+	if o.w == nil {
+		return nil
+	}
+	return o.finishTable()
+}
+
+func (o *bulkLoadOutput) abort() error {
+	if o.w == nil {
+		return nil
+	}
+	w := o.w
+	o.w = nil
+	return w.Close()
+}
+
+// Close removes the temporary files of the loader. The operations of a loader
+// closed before Finish are discarded.
+func (l *BulkLoader) Close() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.entries, l.rangeDels, l.alloc = nil, nil, nil
+	return l.db.opts.FS.RemoveAll(l.opts.TempDir)
+}
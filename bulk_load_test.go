@@ -0,0 +1,200 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkLoader(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest}
+	opts.Levels = []LevelOptions{{TargetFileSize: 2 << 10}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// model is the expected contents of the DB.
+	model := make(map[string]string)
+	for i := 0; i < 500; i += 2 {
+		k := fmt.Sprintf("k%04d", i)
+		require.NoError(t, d.Set([]byte(k), []byte("db"), nil))
+		model[k] = "db"
+	}
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), false))
+
+	var progress []BulkLoadProgress
+	l, err := d.NewBulkLoader(BulkLoadOptions{
+		TempDir:        "tmp",
+		MemoryLimit:    4 << 10,
+		TargetFileSize: 4 << 10,
+		OnProgress:     func(p BulkLoadProgress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("k%04d", rng.Intn(600))
+		switch n := rng.Intn(100); {
+		case n < 80:
+			v := fmt.Sprintf("v%d", i)
+			require.NoError(t, l.Set([]byte(k), []byte(v)))
+			model[k] = v
+		case n < 98:
+			require.NoError(t, l.Delete([]byte(k)))
+			delete(model, k)
+		default:
+			end := fmt.Sprintf("k%04d", rng.Intn(600))
+			if end <= k {
+				continue
+			}
+			require.NoError(t, l.DeleteRange([]byte(k), []byte(end)))
+			for mk := range model {
+				if mk >= k && mk < end {
+					delete(model, mk)
+				}
+			}
+		}
+	}
+	_, err = l.Finish()
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	_, err = mem.Stat("tmp")
+	require.True(t, oserror.IsNotExist(err))
+
+	var keys []string
+	for k := range model {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var expected strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&expected, "%s=%s\n", k, model[k])
+	}
+	require.Equal(t, expected.String(), dumpDBContents(t, d, KeyRange{}))
+
+	last := progress[len(progress)-1]
+	require.Equal(t, BulkLoadDone, last.Phase)
+	require.Greater(t, last.RunsSpilled, 1)
+	require.Greater(t, last.TablesWritten, 1)
+	require.Greater(t, last.BytesWritten, uint64(0))
+	require.Equal(t, l.Progress(), last)
+	for i := 1; i < len(progress); i++ {
+		require.LessOrEqual(t, progress[i-1].Phase, progress[i].Phase)
+	}
+}
+
+func TestBulkLoaderSpillRangeDels(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("db"), nil))
+	require.NoError(t, d.Set([]byte("z"), []byte("db"), nil))
+
+	// The range deletions count against the memory limit, and are spilled to
+	// the sorted runs along with the points.
+	l, err := d.NewBulkLoader(BulkLoadOptions{MemoryLimit: 200})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.DeleteRange(key(200+i), key(300)))
+	}
+	require.Greater(t, l.Progress().RunsSpilled, 0)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.Set(key(i), []byte("v")))
+	}
+	// Overlapping range deletions, each of which deletes the points added
+	// before it, but not those added after.
+	for i := 0; i < 100; i += 10 {
+		require.NoError(t, l.DeleteRange(key(i), key(i+15)))
+		require.NoError(t, l.Set(key(i+5), []byte("w")))
+	}
+	require.NoError(t, l.DeleteRange([]byte("y"), []byte("zz")))
+	_, err = l.Finish()
+	require.NoError(t, err)
+
+	var expected strings.Builder
+	expected.WriteString("a=db\n")
+	for i := 5; i < 100; i += 10 {
+		fmt.Fprintf(&expected, "%s=w\n", key(i))
+	}
+	require.Equal(t, expected.String(), dumpDBContents(t, d, KeyRange{}))
+}
+
+func TestBulkLoaderL6Placement(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatNewest}
+	opts.Levels = []LevelOptions{{TargetFileSize: 1 << 10}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for i := 0; i < 200; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%04d", i*10)), bytes.Repeat([]byte("x"), 100), nil))
+	}
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), false))
+	require.Greater(t, d.Metrics().Levels[6].NumFiles, int64(1))
+
+	// The loaded keys are in the gaps between the sstables of L6: the sstables
+	// to ingest are split at the boundaries of L6, and are all ingested into
+	// L6.
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	l, err := d.NewBulkLoader(BulkLoadOptions{})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, l.Close()) }()
+	for i := len(tables[6]) - 1; i >= 0; i-- {
+		k := append(slices.Clone(tables[6][i].Largest.UserKey), 'x')
+		require.NoError(t, l.Set(k, []byte("v")))
+	}
+	stats, err := l.Finish()
+	require.NoError(t, err)
+	require.Equal(t, len(tables[6]), l.Progress().TablesWritten)
+	require.Zero(t, stats.ApproxIngestedIntoL0Bytes)
+	require.Equal(t, uint64(l.Progress().TablesWritten), d.Metrics().Levels[6].TablesIngested)
+}
+
+func TestBulkLoaderDuplicatePolicy(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	load := func(policy BulkLoadDuplicatePolicy) error {
+		l, err := d.NewBulkLoader(BulkLoadOptions{DuplicatePolicy: policy, MemoryLimit: 100})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, l.Close()) }()
+		require.NoError(t, l.Set([]byte("a"), []byte("1")))
+		require.NoError(t, l.Set([]byte("b"), []byte("1")))
+		require.NoError(t, l.Set([]byte("a"), []byte("2")))
+		require.NoError(t, l.Delete([]byte("b")))
+		require.NoError(t, l.Set([]byte("c"), []byte("1")))
+		_, err = l.Finish()
+		return err
+	}
+	require.NoError(t, load(BulkLoadKeepFirst))
+	require.Equal(t, "a=1\nb=1\nc=1\n", dumpDBContents(t, d, KeyRange{}))
+	require.NoError(t, load(BulkLoadKeepLast))
+	require.Equal(t, "a=2\nc=1\n", dumpDBContents(t, d, KeyRange{}))
+	err = load(BulkLoadDuplicateError)
+	require.True(t, errors.Is(err, ErrBulkLoadDuplicateKey))
+	require.Equal(t, "a=2\nc=1\n", dumpDBContents(t, d, KeyRange{}))
+
+	l, err := d.NewBulkLoader(BulkLoadOptions{})
+	require.NoError(t, err)
+	require.Error(t, l.DeleteRange([]byte("b"), []byte("a")))
+	_, err = l.Finish()
+	require.NoError(t, err)
+	require.Error(t, l.Set([]byte("a"), nil))
+	require.NoError(t, l.Close())
+	require.Error(t, l.Set([]byte("a"), nil))
+}
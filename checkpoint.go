@@ -26,6 +26,13 @@ type checkpointOptions struct {
 
 	// If set, any SSTs that don't overlap with these spans are excluded from a checkpoint.
 	restrictToSpans []CheckpointSpan
+
+	// If set, the SSTs contained in the checkpoint in this directory are
+	// referenced rather than linked or copied.
+	incrementalFrom string
+
+	// verify set to true will verify the checkpoint once it is constructed.
+	verify bool
 }
 
 // CheckpointOption set optional parameters used by `DB.Checkpoint`.
@@ -59,6 +66,33 @@ func WithRestrictToSpans(spans []CheckpointSpan) CheckpointOption {
 	}
 }
 
+// WithIncrementalFrom makes the checkpoint incremental with respect to the
+// checkpoint in baseDir, which must be a checkpoint of the same DB. The SSTs
+// that are part of the base checkpoint (according to its MANIFEST) are not
+// linked or copied; the checkpoint instead records baseDir and the SSTs it
+// references in a CHECKPOINT-BASE file. The base may itself be incremental.
+//
+// The base is recorded relative to the checkpoint's directory (or as an
+// absolute path if it cannot be), and must have been created with the same
+// comparer and at most the DB's format major version.
+//
+// An incremental checkpoint cannot be opened until it is materialized by
+// MaterializeCheckpoint, which requires the base checkpoint to still be found
+// at the recorded location.
+func WithIncrementalFrom(baseDir string) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.incrementalFrom = baseDir
+	}
+}
+
+// WithVerification makes DB.Checkpoint verify the checkpoint once it is
+// constructed (see VerifyCheckpoint), and fail if it is incomplete.
+func WithVerification() CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.verify = true
+	}
+}
+
 // CheckpointSpan is a key range [Start, End) (inclusive on Start, exclusive on
 // End) of interest for a checkpoint.
 type CheckpointSpan struct {
@@ -164,6 +198,26 @@ func (d *DB) Checkpoint(
 		return err
 	}
 
+	var baseTables checkpointTables
+	if opt.incrementalFrom != "" {
+		ct, err := readCheckpointTables(d.opts.FS, opt.incrementalFrom)
+		if err != nil {
+			return errors.Wrapf(err, "pebble: reading base checkpoint %q", opt.incrementalFrom)
+		}
+		// A base that cannot be a checkpoint of this DB is rejected. The
+		// tables of a base from another DB with the same comparer are not
+		// reused, as their bounds and sequence numbers differ.
+		if ct.comparerName != d.opts.Comparer.Name {
+			return errors.Errorf("pebble: base checkpoint %q uses comparer %q, not %q",
+				opt.incrementalFrom, errors.Safe(ct.comparerName), errors.Safe(d.opts.Comparer.Name))
+		}
+		if fmv := d.FormatMajorVersion(); ct.formatVers > fmv {
+			return errors.Errorf("pebble: base checkpoint %q has format major version %s, newer than the DB's %s",
+				opt.incrementalFrom, ct.formatVers, fmv)
+		}
+		baseTables = ct
+	}
+
 	if opt.flushWAL && !d.opts.DisableWAL {
 		// Write an empty log-data record to flush and sync the WAL.
 		if err := d.LogData(nil /* data */, Sync); err != nil {
@@ -275,6 +329,9 @@ func (d *DB) Checkpoint(
 
 	var excludedFiles map[deletedFileEntry]*fileMetadata
 	var remoteFiles []base.DiskFileNum
+	// The SSTs of the base checkpoint that are referenced by an incremental
+	// checkpoint.
+	var baseFiles []base.DiskFileNum
 	// Set of FileBacking.DiskFileNum which will be required by virtual sstables
 	// in the checkpoint.
 	requiredVirtualBackingFiles := make(map[base.DiskFileNum]struct{})
@@ -315,6 +372,10 @@ func (d *DB) Checkpoint(
 				remoteFiles = append(remoteFiles, meta.DiskFileNum)
 				continue
 			}
+			if baseTables.contains(f) {
+				baseFiles = append(baseFiles, fileBacking.DiskFileNum)
+				continue
+			}
 
 			srcPath := base.MakeFilepath(fs, d.dirname, fileTypeTable, fileBacking.DiskFileNum)
 			destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
@@ -347,6 +408,12 @@ func (d *DB) Checkpoint(
 			return ckErr
 		}
 	}
+	if opt.incrementalFrom != "" {
+		ckErr = writeCheckpointBase(fs, destDir, opt.incrementalFrom, baseFiles)
+		if ckErr != nil {
+			return ckErr
+		}
+	}
 
 	// Copy the WAL files. We copy rather than link because WAL file recycling
 	// will cause the WAL files to be reused which would invalidate the
@@ -373,6 +440,12 @@ func (d *DB) Checkpoint(
 	}
 	ckErr = dir.Close()
 	dir = nil
+	if ckErr != nil {
+		return ckErr
+	}
+	if opt.verify {
+		ckErr = VerifyCheckpoint(fs, destDir)
+	}
 	return ckErr
 }
 
@@ -0,0 +1,309 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/remoteobjcat"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// checkpointBaseFilename is the name of the file that records the base of an
+// incremental checkpoint (see WithIncrementalFrom): the directory of the base
+// checkpoint on the first line, relative to the directory of the checkpoint
+// unless it is absolute, followed by the filenames of the SSTs of the base
+// that the checkpoint references, one per line.
+const checkpointBaseFilename = "CHECKPOINT-BASE"
+
+// checkpointBase is the decoded contents of a CHECKPOINT-BASE file. The dir
+// is resolved against the directory of the checkpoint.
+type checkpointBase struct {
+	dir    string
+	tables []string
+}
+
+func writeCheckpointBase(fs vfs.FS, destDir, baseDir string, tables []base.DiskFileNum) error {
+	// The base is recorded relative to the checkpoint, so that the two can be
+	// moved together and do not depend on the working directory; if it cannot
+	// be made relative (e.g. destDir is absolute but baseDir is not), it is
+	// recorded as an absolute path.
+	recordedDir := baseDir
+	if !filepath.IsAbs(baseDir) {
+		var err error
+		if recordedDir, err = filepath.Rel(destDir, baseDir); err != nil {
+			if recordedDir, err = filepath.Abs(baseDir); err != nil {
+				return err
+			}
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, recordedDir)
+	for _, fileNum := range tables {
+		fmt.Fprintln(&buf, base.MakeFilename(fileTypeTable, fileNum))
+	}
+	f, err := fs.Create(fs.PathJoin(destDir, checkpointBaseFilename), vfs.WriteCategoryUnspecified)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return errors.CombineErrors(err, f.Close())
+	}
+	if err := f.Sync(); err != nil {
+		return errors.CombineErrors(err, f.Close())
+	}
+	return f.Close()
+}
+
+// readCheckpointBase reads the CHECKPOINT-BASE file of the checkpoint in dir.
+// It returns false if the checkpoint is not incremental.
+func readCheckpointBase(fs vfs.FS, dir string) (_ checkpointBase, ok bool, _ error) {
+	path := fs.PathJoin(dir, checkpointBaseFilename)
+	f, err := fs.Open(path)
+	if oserror.IsNotExist(err) {
+		return checkpointBase{}, false, nil
+	} else if err != nil {
+		return checkpointBase{}, false, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return checkpointBase{}, false, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] == "" {
+		return checkpointBase{}, false, base.CorruptionErrorf("pebble: corrupt %s", errors.Safe(path))
+	}
+	baseDir := lines[0]
+	if !filepath.IsAbs(baseDir) {
+		baseDir = fs.PathJoin(dir, baseDir)
+	}
+	return checkpointBase{dir: baseDir, tables: lines[1:]}, true, nil
+}
+
+// checkpointTables describes the SSTs referenced by the MANIFEST of a
+// checkpoint (see readCheckpointTables).
+type checkpointTables struct {
+	// comparerName is the name of the comparer the checkpoint's DB was
+	// created with.
+	comparerName string
+	// formatVers is the format major version of the checkpoint.
+	formatVers FormatMajorVersion
+	// sizes holds the sizes of the SSTs, keyed by their DiskFileNum.
+	sizes map[base.DiskFileNum]uint64
+	// tables holds the tables of the checkpoint's version, keyed by the
+	// DiskFileNum of their backing SST.
+	tables map[base.DiskFileNum][]checkpointTable
+}
+
+// checkpointTable describes a table of the version of a checkpoint.
+type checkpointTable struct {
+	fileNum                       base.FileNum
+	smallest, largest             InternalKey
+	smallestSeqNum, largestSeqNum uint64
+}
+
+// contains returns true if the checkpoint holds the table f, with the same
+// backing SST. File numbers are only unique within a DB, so the table's bounds
+// and sequence numbers must also match, to avoid referencing the SST of
+// another DB.
+func (ct *checkpointTables) contains(f *fileMetadata) bool {
+	if size, ok := ct.sizes[f.FileBacking.DiskFileNum]; !ok || size != f.FileBacking.Size {
+		return false
+	}
+	for _, t := range ct.tables[f.FileBacking.DiskFileNum] {
+		if t.fileNum == f.FileNum &&
+			t.smallestSeqNum == f.SmallestSeqNum && t.largestSeqNum == f.LargestSeqNum &&
+			t.smallest.Trailer == f.Smallest.Trailer && bytes.Equal(t.smallest.UserKey, f.Smallest.UserKey) &&
+			t.largest.Trailer == f.Largest.Trailer && bytes.Equal(t.largest.UserKey, f.Largest.UserKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCheckpointTables replays the MANIFEST of the checkpoint (or DB) in dir,
+// and returns the SSTs it references. The SSTs backing virtual SSTs are
+// included.
+func readCheckpointTables(fs vfs.FS, dir string) (checkpointTables, error) {
+	desc, err := Peek(dir, fs)
+	if err != nil {
+		return checkpointTables{}, err
+	} else if !desc.Exists {
+		return checkpointTables{}, errors.Wrapf(oserror.ErrNotExist, "pebble: no checkpoint in %q", dir)
+	}
+	f, err := fs.Open(desc.ManifestFilename)
+	if err != nil {
+		return checkpointTables{}, err
+	}
+	defer f.Close()
+
+	ct := checkpointTables{formatVers: desc.FormatMajorVersion}
+
+	// live maps the files of the version to their backing SST and metadata.
+	type liveTable struct {
+		backing base.DiskFileNum
+		table   checkpointTable
+	}
+	live := make(map[manifest.DeletedFileEntry]liveTable)
+	sizes := make(map[base.DiskFileNum]uint64)
+	rr := record.NewReader(f, 0 /* logNum */)
+	for {
+		r, err := rr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return checkpointTables{}, errors.Wrapf(err, "pebble: reading %q", desc.ManifestFilename)
+		}
+		var ve manifest.VersionEdit
+		if err := ve.Decode(r); err != nil {
+			return checkpointTables{}, err
+		}
+		if ve.ComparerName != "" {
+			ct.comparerName = ve.ComparerName
+		}
+		for _, b := range ve.CreatedBackingTables {
+			sizes[b.DiskFileNum] = b.Size
+		}
+		for e := range ve.DeletedFiles {
+			delete(live, e)
+		}
+		for _, nf := range ve.NewFiles {
+			e := manifest.DeletedFileEntry{Level: nf.Level, FileNum: nf.Meta.FileNum}
+			t := liveTable{table: checkpointTable{
+				fileNum:        nf.Meta.FileNum,
+				smallest:       nf.Meta.Smallest.Clone(),
+				largest:        nf.Meta.Largest.Clone(),
+				smallestSeqNum: nf.Meta.SmallestSeqNum,
+				largestSeqNum:  nf.Meta.LargestSeqNum,
+			}}
+			if nf.Meta.Virtual {
+				t.backing = nf.BackingFileNum
+			} else {
+				t.backing = nf.Meta.FileBacking.DiskFileNum
+				sizes[nf.Meta.FileBacking.DiskFileNum] = nf.Meta.FileBacking.Size
+			}
+			live[e] = t
+		}
+	}
+	ct.sizes = make(map[base.DiskFileNum]uint64, len(live))
+	ct.tables = make(map[base.DiskFileNum][]checkpointTable, len(live))
+	for _, t := range live {
+		ct.sizes[t.backing] = sizes[t.backing]
+		ct.tables[t.backing] = append(ct.tables[t.backing], t.table)
+	}
+	return ct, nil
+}
+
+// locateCheckpointTable returns the path of an SST of the checkpoint in dir,
+// which is either in dir or in its chain of base checkpoints.
+func locateCheckpointTable(fs vfs.FS, dir, filename string) (string, error) {
+	visited := make(map[string]struct{})
+	for {
+		path := fs.PathJoin(dir, filename)
+		if _, err := fs.Stat(path); err == nil {
+			return path, nil
+		} else if !oserror.IsNotExist(err) {
+			return "", err
+		}
+		visited[dir] = struct{}{}
+		b, ok, err := readCheckpointBase(fs, dir)
+		if err != nil {
+			return "", err
+		} else if !ok {
+			return "", errors.Errorf("pebble: checkpoint sstable %s not found in %q or its base checkpoints",
+				errors.Safe(filename), dir)
+		}
+		if _, ok := visited[b.dir]; ok {
+			return "", errors.Errorf("pebble: checkpoint %q is its own base", b.dir)
+		}
+		dir = b.dir
+	}
+}
+
+// VerifyCheckpoint verifies that every local SST referenced by the MANIFEST of
+// the checkpoint in dir is present, with the expected size, in dir or, if the
+// checkpoint is incremental, in its chain of base checkpoints (see
+// WithIncrementalFrom).
+func VerifyCheckpoint(fs vfs.FS, dir string) error {
+	ct, err := readCheckpointTables(fs, dir)
+	if err != nil {
+		return err
+	}
+	tables := ct.sizes
+	cat, contents, err := remoteobjcat.Open(fs, dir)
+	if err != nil {
+		return err
+	}
+	if err := cat.Close(); err != nil {
+		return err
+	}
+	remote := make(map[base.DiskFileNum]struct{}, len(contents.Objects))
+	for _, meta := range contents.Objects {
+		remote[meta.FileNum] = struct{}{}
+	}
+
+	fileNums := make([]base.DiskFileNum, 0, len(tables))
+	for fileNum := range tables {
+		fileNums = append(fileNums, fileNum)
+	}
+	slices.Sort(fileNums)
+	for _, fileNum := range fileNums {
+		if _, ok := remote[fileNum]; ok {
+			continue
+		}
+		path, err := locateCheckpointTable(fs, dir, base.MakeFilename(fileTypeTable, fileNum))
+		if err != nil {
+			return err
+		}
+		stat, err := fs.Stat(path)
+		if err != nil {
+			return err
+		}
+		if size := uint64(stat.Size()); size != tables[fileNum] {
+			return errors.Errorf("pebble: checkpoint sstable %q has size %d, expected %d",
+				path, errors.Safe(size), errors.Safe(tables[fileNum]))
+		}
+	}
+	return nil
+}
+
+// MaterializeCheckpoint turns the incremental checkpoint in dir into a
+// regular checkpoint that can be opened, by linking or copying the SSTs it
+// references from its chain of base checkpoints into dir. It does nothing if
+// the checkpoint is not incremental.
+func MaterializeCheckpoint(fs vfs.FS, dir string) error {
+	b, ok, err := readCheckpointBase(fs, dir)
+	if err != nil || !ok {
+		return err
+	}
+	for _, filename := range b.tables {
+		srcPath, err := locateCheckpointTable(fs, b.dir, filename)
+		if err != nil {
+			return err
+		}
+		if err := vfs.LinkOrCopy(fs, srcPath, fs.PathJoin(dir, filename)); err != nil {
+			return err
+		}
+	}
+	if err := fs.Remove(fs.PathJoin(dir, checkpointBaseFilename)); err != nil {
+		return err
+	}
+	d, err := fs.OpenDir(dir)
+	if err != nil {
+		return err
+	}
+	return errors.CombineErrors(d.Sync(), d.Close())
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"runtime"
 	"sort"
@@ -16,6 +17,7 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/vfs"
@@ -437,3 +439,147 @@ func TestCheckpointManyFiles(t *testing.T) {
 		require.Equal(t, 10, n)
 	}
 }
+
+func TestCheckpointIncremental(t *testing.T) {
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:                          fs,
+		DisableAutomaticCompactions: true,
+		Logger:                      testLogger{t},
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	set := func(prefix string) {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%d", prefix, i)), []byte(prefix), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	countTables := func(dir string) int {
+		ls, err := fs.List(dir)
+		require.NoError(t, err)
+		n := 0
+		for _, filename := range ls {
+			if strings.HasSuffix(filename, ".sst") {
+				n++
+			}
+		}
+		return n
+	}
+
+	set("a")
+	set("b")
+	require.NoError(t, d.Checkpoint("full", WithVerification()))
+	require.Equal(t, 2, countTables("full"))
+
+	// Only the new sstable is materialized by an incremental checkpoint.
+	set("c")
+	require.NoError(t, d.Checkpoint("incr1", WithIncrementalFrom("full"), WithVerification()))
+	require.Equal(t, 1, countTables("incr1"))
+
+	// The base of an incremental checkpoint may itself be incremental.
+	set("d")
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false /* parallelize */))
+	require.NoError(t, d.Checkpoint("incr2", WithIncrementalFrom("incr1"), WithVerification()))
+	require.Equal(t, 1, countTables("incr2"))
+	require.NoError(t, VerifyCheckpoint(fs, "incr2"))
+
+	require.Error(t, d.Checkpoint("incr3", WithIncrementalFrom("non-existent")))
+
+	// The base is recorded relative to the checkpoint, so the two can be
+	// moved together.
+	require.NoError(t, d.Checkpoint("nested/incr", WithIncrementalFrom("incr2")))
+	b, ok, err := readCheckpointBase(fs, "nested/incr")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "incr2", b.dir)
+	f, err := fs.Open(fs.PathJoin("nested/incr", checkpointBaseFilename))
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.True(t, strings.HasPrefix(string(data), "../../incr2\n"), string(data))
+	require.NoError(t, VerifyCheckpoint(fs, "nested/incr"))
+
+	// A base of another DB is rejected.
+	otherCmp := *DefaultComparer
+	otherCmp.Name = "other-comparer"
+	other, err := Open("other", &Options{FS: fs, Comparer: &otherCmp})
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+	require.ErrorContains(t, d.Checkpoint("incr3", WithIncrementalFrom("other")), "other-comparer")
+
+	// Verification fails if a referenced sstable is missing from the bases.
+	ls, err := fs.List("full")
+	require.NoError(t, err)
+	require.NoError(t, fs.MkdirAll("full-copy", 0755))
+	for _, filename := range ls {
+		require.NoError(t, vfs.Copy(fs, fs.PathJoin("full", filename), fs.PathJoin("full-copy", filename)))
+	}
+	for _, filename := range ls {
+		if strings.HasSuffix(filename, ".sst") {
+			require.NoError(t, fs.Remove(fs.PathJoin("full", filename)))
+			require.Error(t, VerifyCheckpoint(fs, "incr2"))
+			require.NoError(t, vfs.Copy(fs, fs.PathJoin("full-copy", filename), fs.PathJoin("full", filename)))
+		}
+	}
+
+	// A materialized checkpoint contains all of its sstables.
+	require.NoError(t, MaterializeCheckpoint(fs, "incr2"))
+	require.NoError(t, VerifyCheckpoint(fs, "incr2"))
+	_, err = fs.Stat(fs.PathJoin("incr2", checkpointBaseFilename))
+	require.True(t, oserror.IsNotExist(err))
+	c, err := Open("incr2", opts)
+	require.NoError(t, err)
+	iter, _ := c.NewIter(nil)
+	n := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.NoError(t, c.Close())
+	require.Equal(t, 40, n)
+}
+
+func TestCheckpointIncrementalOtherDB(t *testing.T) {
+	fs := vfs.NewMem()
+	// write creates a DB holding a single sstable of the keys with the given
+	// prefix, so that the sstables of two DBs have the same file number and
+	// size.
+	write := func(dir, prefix string) (*DB, *SSTableInfo) {
+		d, err := Open(dir, &Options{FS: fs, DisableAutomaticCompactions: true})
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%d", prefix, i)), []byte(prefix), nil))
+		}
+		require.NoError(t, d.Flush())
+		tables, err := d.SSTables()
+		require.NoError(t, err)
+		require.Len(t, tables[0], 1)
+		return d, &tables[0][0]
+	}
+	d, table := write("db", "a")
+	defer func() { require.NoError(t, d.Close()) }()
+	other, otherTable := write("other", "b")
+	require.Equal(t, table.FileNum, otherTable.FileNum)
+	require.Equal(t, table.Size, otherTable.Size)
+	require.NoError(t, other.Checkpoint("other-checkpoint"))
+	require.NoError(t, other.Close())
+
+	// The sstable of the other DB is not referenced, despite its matching file
+	// number and size.
+	require.NoError(t, d.Checkpoint("checkpoint", WithIncrementalFrom("other-checkpoint"), WithVerification()))
+	b, ok, err := readCheckpointBase(fs, "checkpoint")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, b.tables)
+	c, err := Open("checkpoint", &Options{FS: fs})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, c.Close()) }()
+	v, closer, err := c.Get([]byte("a0"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(v))
+	require.NoError(t, closer.Close())
+}
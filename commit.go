@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/batchrepr"
 	"github.com/cockroachdb/pebble/record"
 )
@@ -139,6 +140,10 @@ type commitEnv struct {
 	// the memtable the batch should be applied to. Serial execution enforced by
 	// commitPipeline.mu.
 	write func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error)
+	// Invoked with each batch after its sequence numbers have been published,
+	// while watching is enabled (see commitPipeline.startWatching). Batches are
+	// passed in sequence number order. Optional.
+	published func(b *Batch)
 }
 
 // A commitPipeline manages the stages of committing a set of mutations
@@ -247,6 +252,32 @@ type commitPipeline struct {
 	// The mutex to use for synchronizing access to logSeqNum and serializing
 	// calls to commitEnv.write().
 	mu sync.Mutex
+	// visible lets goroutines wait for the visible sequence number to reach a
+	// given value (see waitVisible). Publishers only acquire the mutex when
+	// there are waiters.
+	visible struct {
+		mu      sync.Mutex
+		cond    sync.Cond
+		waiters atomic.Int32
+	}
+	// watch serializes the dequeuing and publishing of batches while enabled is
+	// set, so that commitEnv.published is invoked in sequence number order.
+	watch struct {
+		sync.Mutex
+		enabled atomic.Bool
+		// refs is the number of calls to startWatching that have not been
+		// undone by stopWatching. It is protected by commitPipeline.mu.
+		refs int
+		// unlocked is the number of goroutines publishing a batch without
+		// holding the mutex, because they observed enabled unset.
+		unlocked atomic.Int32
+		// drained is signaled, with drainMu held, when unlocked drops to zero
+		// while enabled is set, for startWatching to stop waiting. It does not
+		// use the watch mutex, which the publishers holding it may hold while
+		// waiting for the caller of startWatching.
+		drainMu sync.Mutex
+		drained sync.Cond
+	}
 }
 
 func newCommitPipeline(env commitEnv) *commitPipeline {
@@ -270,6 +301,8 @@ func newCommitPipeline(env commitEnv) *commitPipeline {
 		logSyncQSem:    make(chan struct{}, record.SyncConcurrency-1),
 		ingestSem:      make(chan struct{}, 1),
 	}
+	p.visible.cond.L = &p.visible.mu
+	p.watch.drained.L = &p.watch.drainMu
 	return p
 }
 
@@ -314,6 +347,15 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	// NB: We set Batch.commitErr on error so that the batch won't be a candidate
 	// for reuse. See Batch.release().
 	mem, err := p.prepare(b, syncWAL, noSyncWait)
+	if b.replicatedErr != nil {
+		// The replicated batch was rejected before it was enqueued (see
+		// DB.ApplyReplicated).
+		<-p.commitQueueSem
+		if syncWAL {
+			<-p.logSyncQSem
+		}
+		return nil
+	}
 	if err != nil {
 		b.db = nil // prevent batch reuse on error
 		// NB: we are not doing <-p.commitQueueSem since the batch is still
@@ -425,6 +467,49 @@ func (p *commitPipeline) AllocateSeqNum(
 	<-p.commitQueueSem
 }
 
+// ratchetSeqNum ensures that the sequence numbers given to subsequent batches
+// are at least seqNum, and that reads are performed at a sequence number of at
+// least seqNum. It waits for any outstanding writes to the memtable to
+// complete.
+func (p *commitPipeline) ratchetSeqNum(seqNum uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waitVisible(p.env.logSeqNum.Load())
+	if p.env.logSeqNum.Load() < seqNum {
+		p.env.logSeqNum.Store(seqNum)
+		p.env.visibleSeqNum.Store(seqNum)
+		p.notifyVisible()
+	}
+}
+
+// waitVisible blocks until the visible sequence number is at least seqNum.
+func (p *commitPipeline) waitVisible(seqNum uint64) {
+	if p.env.visibleSeqNum.Load() >= seqNum {
+		return
+	}
+	// Register as a waiter before checking the visible sequence number again,
+	// so that a publisher either observes the waiter or ratcheted the visible
+	// sequence number before the check.
+	p.visible.waiters.Add(1)
+	defer p.visible.waiters.Add(-1)
+	p.visible.mu.Lock()
+	defer p.visible.mu.Unlock()
+	for p.env.visibleSeqNum.Load() < seqNum {
+		p.visible.cond.Wait()
+	}
+}
+
+// notifyVisible wakes up the goroutines blocked in waitVisible after the
+// visible sequence number has been ratcheted.
+func (p *commitPipeline) notifyVisible() {
+	if p.visible.waiters.Load() == 0 {
+		return
+	}
+	p.visible.mu.Lock()
+	p.visible.cond.Broadcast()
+	p.visible.mu.Unlock()
+}
+
 func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memTable, error) {
 	n := uint64(b.Count())
 	if n == invalidBatchCount {
@@ -453,6 +538,19 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 
 	p.mu.Lock()
 
+	if b.replicatedSeqNum != 0 {
+		// A batch applied by a replica is checked against logSeqNum while holding
+		// commitPipeline.mu, as concurrent batches may advance it. A rejected
+		// batch is not enqueued, so nothing waits for it to be published or
+		// synced.
+		if b.replicatedErr = p.checkReplicatedLocked(b.replicatedSeqNum, n); b.replicatedErr != nil {
+			p.mu.Unlock()
+			b.commit = sync.WaitGroup{}
+			b.fsyncWait = sync.WaitGroup{}
+			return nil, nil
+		}
+	}
+
 	// Enqueue the batch in the pending queue. Note that while the pending queue
 	// is lock-free, we want the order of batches to be the same as the sequence
 	// number order.
@@ -460,7 +558,9 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 
 	// Assign the batch a sequence number. Note that we use atomic operations
 	// here to handle concurrent reads of logSeqNum. commitPipeline.mu provides
-	// mutual exclusion for other goroutines writing to logSeqNum.
+	// mutual exclusion for other goroutines writing to logSeqNum. A batch
+	// applied by a replica was checked to start at logSeqNum, so it keeps the
+	// sequence number it was given by the primary.
 	b.setSeqNum(p.env.logSeqNum.Add(n) - n)
 
 	// Write the data to the WAL.
@@ -471,6 +571,30 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 	return mem, err
 }
 
+// checkReplicatedLocked checks the sequence numbers [seqNum, seqNum+count) of
+// a batch applied by a replica against the next sequence number, which the
+// batch must start at. It returns errReplicatedBatchContained if the DB
+// already contains the batch. A batch that follows a gap is rejected: the
+// primary does not skip sequence numbers (an ingestion fails its streams), so
+// a gap means batches were lost.
+//
+// commitPipeline.mu must be held when calling this.
+func (p *commitPipeline) checkReplicatedLocked(seqNum, count uint64) error {
+	next := p.env.logSeqNum.Load()
+	switch {
+	case seqNum == next:
+		return nil
+	case seqNum > next:
+		return errors.Errorf("pebble: replicated batch seqnum %d follows a gap after the DB's next seqnum %d",
+			errors.Safe(seqNum), errors.Safe(next))
+	case seqNum+count <= next:
+		return errReplicatedBatchContained
+	default:
+		return errors.Errorf("pebble: replicated batch seqnums [%d,%d) overlap the DB's next seqnum %d",
+			errors.Safe(seqNum), errors.Safe(seqNum+count), errors.Safe(next))
+	}
+}
+
 func (p *commitPipeline) publish(b *Batch) {
 	// Mark the batch as applied.
 	b.applied.Store(true)
@@ -483,8 +607,10 @@ func (p *commitPipeline) publish(b *Batch) {
 	// batch applies it will go through the same process and publish our batch
 	// for us.
 	for {
+		watching := p.beginPublish()
 		t := p.pending.dequeueApplied()
 		if t == nil {
+			p.endPublish(watching)
 			// Wait for another goroutine to publish us. We might also be waiting for
 			// the WAL sync to finish.
 			now := time.Now()
@@ -509,10 +635,91 @@ func (p *commitPipeline) publish(b *Batch) {
 			}
 			if p.env.visibleSeqNum.CompareAndSwap(curSeqNum, newSeqNum) {
 				// We successfully published t's sequence number.
+				p.notifyVisible()
 				break
 			}
 		}
 
+		if watching {
+			p.env.published(t)
+		}
+		p.endPublish(watching)
 		t.commit.Done()
 	}
 }
+
+// beginPublish is called before dequeuing a batch to publish. It returns
+// whether the batch must be passed to commitEnv.published, in which case the
+// watch mutex is held until endPublish.
+func (p *commitPipeline) beginPublish() (watching bool) {
+	if p.env.published == nil {
+		return false
+	}
+	if !p.watch.enabled.Load() {
+		// Announce ourselves before checking enabled again, so that startWatching
+		// either waits for us or we observe that watching is enabled.
+		p.watch.unlocked.Add(1)
+		if !p.watch.enabled.Load() {
+			return false
+		}
+		p.releaseUnlocked()
+	}
+	p.watch.Lock()
+	return true
+}
+
+func (p *commitPipeline) endPublish(watching bool) {
+	if p.env.published == nil {
+		return
+	}
+	if watching {
+		p.watch.Unlock()
+	} else {
+		p.releaseUnlocked()
+	}
+}
+
+// releaseUnlocked decrements the number of goroutines publishing a batch
+// without holding the watch mutex, and wakes up startWatching once there are
+// none left.
+func (p *commitPipeline) releaseUnlocked() {
+	if p.watch.unlocked.Add(-1) == 0 && p.watch.enabled.Load() {
+		p.watch.drainMu.Lock()
+		p.watch.drained.Broadcast()
+		p.watch.drainMu.Unlock()
+	}
+}
+
+// startWatching enables passing published batches to commitEnv.published,
+// until a matching call to stopWatching. It returns the next sequence number
+// to be assigned: every batch at or above it is passed to commitEnv.published.
+func (p *commitPipeline) startWatching() (nextSeqNum uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.startWatchingLocked()
+}
+
+// startWatchingLocked is like startWatching. commitPipeline.mu must be held.
+func (p *commitPipeline) startWatchingLocked() (nextSeqNum uint64) {
+	p.watch.refs++
+	p.watch.enabled.Store(true)
+	// Wait for the goroutines that may not have observed the change to finish
+	// publishing. No batch can be assigned a sequence number until we release
+	// commitPipeline.mu, so every later batch is passed to published.
+	p.watch.drainMu.Lock()
+	for p.watch.unlocked.Load() != 0 {
+		p.watch.drained.Wait()
+	}
+	p.watch.drainMu.Unlock()
+	return p.env.logSeqNum.Load()
+}
+
+// stopWatching undoes a call to startWatching. Published batches are no
+// longer passed to commitEnv.published once every call has been undone.
+func (p *commitPipeline) stopWatching() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.watch.refs--; p.watch.refs == 0 {
+		p.watch.enabled.Store(false)
+	}
+}
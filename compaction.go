@@ -183,12 +183,39 @@ type compaction struct {
 	// to cancel, such as if a conflicting excise operation raced it to manifest
 	// application. Only holders of the manifest lock will write to this atomic.
 	cancel atomic.Bool
+	// userCancel is set when the caller of a manual compaction abandons it.
+	// Unlike cancel, it may be written without holding the manifest lock, so
+	// it may be set after the compaction's version edit has been checked for
+	// cancellation; the compaction is then installed regardless.
+	userCancel atomic.Bool
+	// parent is set for subcompactions to the compaction whose key range they
+	// partition. A subcompaction is cancelled along with its parent.
+	parent *compaction
+	// subcompactions is the number of subcompactions the compaction was split
+	// into, or zero if it was not split.
+	subcompactions int
+	// inputNewIters opens the compaction's input tables, charging their reads
+	// against the disk I/O budget. It is shared with the compaction's
+	// subcompactions, so that a table straddling the bounds of several
+	// subcompactions is charged once.
+	inputNewIters tableNewIters
+	// remote is set if the compaction was run by the CompactionExecutor.
+	remote bool
 
 	kind compactionKind
+	// fifoDrop is true for delete-only compactions that drop the oldest tables
+	// of a DB using CompactionStyleFIFO.
+	fifoDrop bool
 	// isDownload is true if this compaction was started as part of a Download
 	// operation. In this case kind is compactionKindCopy or
 	// compactionKindRewrite.
 	isDownload bool
+	// tiering is set for copy compactions started by the tiering policy to
+	// migrate a table between local disk and shared storage.
+	tiering tieringDirection
+	// usesSlot is set for automatic compactions that acquired a slot of the
+	// Options.CompactionScheduler.
+	usesSlot bool
 
 	cmp       Compare
 	equal     Equal
@@ -233,6 +260,17 @@ type compaction struct {
 	flushing flushableList
 	// bytesWritten contains the number of bytes that have been written to outputs.
 	bytesWritten int64
+	// startLevelBytesProcessed is an estimate of the number of bytes of the
+	// start level's input tables that have been compacted so far. The
+	// subcompactions of a compaction accumulate it in their parent. It is
+	// read by DB.CompactWithOptions to report progress.
+	startLevelBytesProcessed atomic.Uint64
+	// progressTables holds the start level's tables sorted by largest key,
+	// the first progressIndex of which, totalling progressBytes, have been
+	// counted by updateProgress. It is built by the first call.
+	progressTables []*fileMetadata
+	progressIndex  int
+	progressBytes  uint64
 
 	// The boundaries of the input data.
 	smallest InternalKey
@@ -261,6 +299,11 @@ type compaction struct {
 	// lower level in the LSM during runCompaction.
 	allowedZeroSeqNum bool
 
+	// historyRetentionSeqNum is the sequence number from which every version
+	// is preserved, as configured by Options.Experimental.HistoryRetention. It
+	// is zero if history retention is disabled.
+	historyRetentionSeqNum uint64
+
 	metrics map[int]*LevelMetrics
 
 	pickerMetrics compactionPickerMetrics
@@ -288,6 +331,9 @@ func (c *compaction) makeInfo(jobID JobID) CompactionInfo {
 	if c.isDownload {
 		info.Reason = "download," + info.Reason
 	}
+	if c.tiering != tierNone {
+		info.Reason = c.tiering.String() + "," + info.Reason
+	}
 	for _, cl := range c.inputs {
 		inputInfo := LevelInfo{Level: cl.level, Tables: nil}
 		iter := cl.files.Iter()
@@ -323,13 +369,104 @@ func (c *compaction) makeInfo(jobID JobID) CompactionInfo {
 	return info
 }
 
+// cancelled returns true if the compaction has been cancelled by a
+// concurrent operation or by the caller of a manual compaction.
+func (c *compaction) cancelled() bool {
+	if c.parent != nil && c.parent.cancelled() {
+		return true
+	}
+	return c.cancel.Load() || c.userCancel.Load()
+}
+
+// compactionProgressInterval is the number of keys a compaction processes
+// between checks for cancellation and updates of its progress.
+const compactionProgressInterval = 1000
+
+// updateProgress updates the compaction's estimate of the bytes of the start
+// level processed, given that all the keys preceding key have been compacted.
+// Tables are counted once all their keys have been processed. For
+// subcompactions, only the subcompaction whose range holds a table's largest
+// key can count the table, so tables straddling subcompaction bounds are
+// counted once.
+//
+// The keys passed to updateProgress must be increasing, so that the tables
+// are counted incrementally rather than rescanned on every call.
+func (c *compaction) updateProgress(key []byte) {
+	if c.progressTables == nil {
+		c.progressTables = make([]*fileMetadata, 0, c.startLevel.files.Len())
+		iter := c.startLevel.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			c.progressTables = append(c.progressTables, f)
+		}
+		slices.SortFunc(c.progressTables, func(a, b *fileMetadata) int {
+			return c.cmp(a.Largest.UserKey, b.Largest.UserKey)
+		})
+	}
+	for ; c.progressIndex < len(c.progressTables); c.progressIndex++ {
+		f := c.progressTables[c.progressIndex]
+		if c.cmp(f.Largest.UserKey, key) >= 0 {
+			break
+		}
+		c.progressBytes += f.Size
+	}
+	c.addProgress(c.progressBytes)
+}
+
+// addProgress raises the compaction's count of start level bytes processed to
+// processed, propagating the increase to the parent of a subcompaction.
+func (c *compaction) addProgress(processed uint64) {
+	prev := c.startLevelBytesProcessed.Load()
+	if processed <= prev {
+		return
+	}
+	c.startLevelBytesProcessed.Store(processed)
+	if c.parent != nil {
+		c.parent.startLevelBytesProcessed.Add(processed - prev)
+	}
+}
+
 func (c *compaction) userKeyBounds() base.UserKeyBounds {
 	return base.UserKeyBoundsFromInternal(c.smallest, c.largest)
 }
 
+// initMetrics initializes c.metrics with the bytes read by the compaction,
+// returning the metrics of the output level.
+func (c *compaction) initMetrics() *LevelMetrics {
+	startLevelBytes := c.startLevel.files.SizeSum()
+	outputMetrics := &LevelMetrics{
+		BytesIn:   startLevelBytes,
+		BytesRead: c.outputLevel.files.SizeSum(),
+	}
+	for _, l := range c.extraLevels {
+		outputMetrics.BytesIn += l.files.SizeSum()
+	}
+	outputMetrics.BytesRead += outputMetrics.BytesIn
+
+	c.metrics = map[int]*LevelMetrics{
+		c.outputLevel.level: outputMetrics,
+	}
+	if len(c.flushing) == 0 && c.metrics[c.startLevel.level] == nil {
+		c.metrics[c.startLevel.level] = &LevelMetrics{}
+	}
+	if len(c.extraLevels) > 0 {
+		for _, l := range c.extraLevels {
+			c.metrics[l.level] = &LevelMetrics{}
+		}
+		outputMetrics.MultiLevel.BytesInTop = startLevelBytes
+		outputMetrics.MultiLevel.BytesIn = outputMetrics.BytesIn
+		outputMetrics.MultiLevel.BytesRead = outputMetrics.BytesRead
+	}
+	return outputMetrics
+}
+
 func newCompaction(
 	pc *pickedCompaction, opts *Options, beganAt time.Time, provider objstorage.Provider,
 ) *compaction {
+	if pc.kind == compactionKindDeleteOnly {
+		c := newDeleteOnlyCompaction(opts, pc.version, pc.inputs, beganAt)
+		c.fifoDrop = pc.fifoDrop
+		return c
+	}
 	c := &compaction{
 		kind:              compactionKindDefault,
 		cmp:               pc.cmp,
@@ -605,16 +742,16 @@ func newFlush(
 }
 
 func (c *compaction) hasExtraLevelData() bool {
-	if len(c.extraLevels) == 0 {
-		// not a multi level compaction
-		return false
-	} else if c.extraLevels[0].files.Empty() {
-		// a multi level compaction without data in the intermediate input level;
-		// e.g. for a multi level compaction with levels 4,5, and 6, this could
-		// occur if there is no files to compact in 5, or in 5 and 6 (i.e. a move).
-		return false
+	// A multi level compaction may have no data in the intermediate input
+	// levels; e.g. for a multi level compaction with levels 4,5, and 6, this
+	// could occur if there is no files to compact in 5, or in 5 and 6 (i.e. a
+	// move).
+	for _, l := range c.extraLevels {
+		if !l.files.Empty() {
+			return true
+		}
 	}
-	return true
+	return false
 }
 
 // findGrandparentLimit takes the start user key for a table and returns the
@@ -738,11 +875,7 @@ func (c *compaction) newInputIters(
 				}
 			}
 		}
-		if len(c.extraLevels) > 0 {
-			if len(c.extraLevels) > 1 {
-				panic("n>2 multi level compaction not implemented yet")
-			}
-			interLevel := c.extraLevels[0]
+		for _, interLevel := range c.extraLevels {
 			err := manifest.CheckOrdering(c.cmp, c.formatKey,
 				manifest.Level(interLevel.level), interLevel.files.Iter())
 			if err != nil {
@@ -1013,6 +1146,16 @@ type manualCompaction struct {
 	start       []byte
 	end         []byte
 	split       bool
+	// maxOutputLevel, if non-zero, is the deepest level the compaction may
+	// output to. It prevents the picker from expanding the compaction into a
+	// multi-level compaction that writes below this level.
+	maxOutputLevel int
+	// compaction is the compaction performed on behalf of this manual
+	// compaction, set once it has been scheduled. Protected by DB.mu.
+	compaction *compaction
+	// inputBytes is the size of the sstables in the start level that were
+	// picked as inputs, set once the compaction has been scheduled.
+	inputBytes uint64
 }
 
 type readCompaction struct {
@@ -1603,6 +1746,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 	if err == nil {
 		d.mu.snapshots.cumulativePinnedCount += stats.cumulativePinnedKeys
 		d.mu.snapshots.cumulativePinnedSize += stats.cumulativePinnedSize
+		d.updateNamedSnapshotPinnedLocked(&stats)
 		d.mu.versions.metrics.Keys.MissizedTombstonesCount += stats.countMissizedDels
 	}
 
@@ -1771,7 +1915,7 @@ func (d *DB) maybeScheduleCompactionPicker(
 	maxCompactions := d.opts.MaxConcurrentCompactions()
 	maxDownloads := d.opts.MaxConcurrentDownloads()
 
-	if d.mu.compact.compactingCount >= maxCompactions &&
+	if d.runningCompactionsLocked() >= maxCompactions &&
 		(len(d.mu.compact.downloads) == 0 || d.mu.compact.downloadingCount >= maxDownloads) {
 		if len(d.mu.compact.manual) > 0 {
 			// Inability to run head blocks later manual compactions.
@@ -1795,11 +1939,12 @@ func (d *DB) maybeScheduleCompactionPicker(
 
 	env := compactionEnv{
 		diskAvailBytes:          d.diskAvailBytes.Load(),
-		earliestSnapshotSeqNum:  d.mu.snapshots.earliest(),
+		earliestSnapshotSeqNum:  d.earliestRetainedSeqNumLocked(),
 		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
+		now:                     d.timeNow(),
 	}
 
-	if d.mu.compact.compactingCount < maxCompactions {
+	if d.runningCompactionsLocked() < maxCompactions {
 		// Check for delete-only compactions first, because they're expected to be
 		// cheap and reduce future compaction work.
 		if !d.opts.private.disableDeleteOnlyCompactions &&
@@ -1808,7 +1953,7 @@ func (d *DB) maybeScheduleCompactionPicker(
 			d.tryScheduleDeleteOnlyCompaction()
 		}
 
-		for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < maxCompactions {
+		for len(d.mu.compact.manual) > 0 && d.runningCompactionsLocked() < maxCompactions {
 			if manual := d.mu.compact.manual[0]; !d.tryScheduleManualCompaction(env, manual) {
 				// Inability to run head blocks later manual compactions.
 				manual.retries++
@@ -1817,9 +1962,13 @@ func (d *DB) maybeScheduleCompactionPicker(
 			d.mu.compact.manual = d.mu.compact.manual[1:]
 		}
 
-		for !d.opts.DisableAutomaticCompactions && d.mu.compact.compactingCount < maxCompactions &&
+		for !d.opts.DisableAutomaticCompactions && d.runningCompactionsLocked() < maxCompactions &&
 			d.tryScheduleAutoCompaction(env, pickFunc) {
 		}
+
+		if len(d.mu.tiering.pending) > 0 {
+			d.tryScheduleTieringMigrations(env, maxCompactions)
+		}
 	}
 
 	for len(d.mu.compact.downloads) > 0 && d.mu.compact.downloadingCount < maxDownloads &&
@@ -1834,8 +1983,25 @@ func (d *DB) maybeScheduleCompactionPicker(
 func (d *DB) tryScheduleDeleteOnlyCompaction() {
 	v := d.mu.versions.currentVersion()
 	snapshots := d.mu.snapshots.toSlice()
-	inputs, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, d.mu.compact.deletionHints, snapshots)
-	d.mu.compact.deletionHints = unresolvedHints
+	hints := d.mu.compact.deletionHints
+	var retainedHints []deleteCompactionHint
+	if r := d.historyRetentionSeqNumLocked(); r != 0 {
+		// Tombstones within the history retention window must not delete the
+		// tables they cover, since reads at earlier retained sequence numbers
+		// observe them. Those hints are left unresolved until the window moves
+		// past them.
+		hints, retainedHints = nil, nil
+		for _, h := range d.mu.compact.deletionHints {
+			if h.tombstoneLargestSeqNum >= r {
+				retainedHints = append(retainedHints, h)
+			} else {
+				hints = append(hints, h)
+			}
+		}
+		snapshots = insertSnapshot(snapshots, r)
+	}
+	inputs, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, hints, snapshots)
+	d.mu.compact.deletionHints = append(unresolvedHints, retainedHints...)
 
 	if len(inputs) > 0 {
 		c := newDeleteOnlyCompaction(d.opts, v, inputs, d.timeNow())
@@ -1865,6 +2031,8 @@ func (d *DB) tryScheduleManualCompaction(env compactionEnv, manual *manualCompac
 	}
 
 	c := newCompaction(pc, d.opts, d.timeNow(), d.ObjProvider())
+	manual.compaction = c
+	manual.inputBytes = c.startLevel.files.SizeSum()
 	d.mu.compact.compactingCount++
 	d.addInProgressCompaction(c)
 	go d.compact(c, manual.done)
@@ -1880,6 +2048,9 @@ func (d *DB) tryScheduleManualCompaction(env compactionEnv, manual *manualCompac
 func (d *DB) tryScheduleAutoCompaction(
 	env compactionEnv, pickFunc func(compactionPicker, compactionEnv) *pickedCompaction,
 ) bool {
+	if !d.compactionSlots.tryAcquire(d.compactionSlotPriorityLocked) {
+		return false
+	}
 	env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 	env.readCompactionEnv = readCompactionEnv{
 		readCompactions:          &d.mu.compact.readCompactions,
@@ -1888,9 +2059,11 @@ func (d *DB) tryScheduleAutoCompaction(
 	}
 	pc := pickFunc(d.mu.versions.picker, env)
 	if pc == nil {
+		d.compactionSlots.release()
 		return false
 	}
 	c := newCompaction(pc, d.opts, d.timeNow(), d.ObjProvider())
+	c.usesSlot = d.compactionSlots != nil
 	d.mu.compact.compactingCount++
 	d.addInProgressCompaction(c)
 	go d.compact(c, nil)
@@ -2141,6 +2314,12 @@ func (d *DB) compact(c *compaction, errChannel chan error) {
 		} else {
 			d.mu.compact.compactingCount--
 		}
+		if c.tiering != tierNone {
+			d.mu.tiering.migrating--
+		}
+		if c.usesSlot {
+			d.compactionSlots.release()
+		}
 		delete(d.mu.compact.inProgress, c)
 		// Add this compaction's duration to the cumulative duration. NB: This
 		// must be atomic with the above removal of c from
@@ -2172,6 +2351,12 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	startTime := d.timeNow()
 
 	ve, pendingOutputs, stats, err := d.runCompaction(jobID, c)
+	if c.subcompactions > 0 {
+		info.Annotations = append(info.Annotations, fmt.Sprintf("subcompactions=%d", c.subcompactions))
+	}
+	if c.remote {
+		info.Annotations = append(info.Annotations, "remote")
+	}
 
 	info.Duration = d.timeNow().Sub(startTime)
 	if err == nil {
@@ -2183,7 +2368,9 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 			// that necessitates it restarting from scratch. Note that since we hold
 			// the manifest lock, we don't expect this bool to change its value
 			// as only the holder of the manifest lock will ever write to it.
-			if c.cancel.Load() {
+			// userCancel may still be set concurrently, in which case the
+			// completed compaction is installed.
+			if c.cancel.Load() || c.userCancel.Load() {
 				err = firstError(err, ErrCancelledCompaction)
 			}
 			if err != nil {
@@ -2224,8 +2411,23 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 			e := &ve.NewFiles[i]
 			info.Output.Tables = append(info.Output.Tables, e.Meta.TableInfo())
 		}
+		if c.fifoDrop {
+			for _, cl := range c.inputs {
+				d.mu.versions.metrics.Compact.FIFODroppedTables += int64(cl.files.Len())
+				d.mu.versions.metrics.Compact.FIFODroppedBytes += cl.files.SizeSum()
+			}
+		}
+		switch c.tiering {
+		case tierDemote:
+			d.mu.versions.metrics.Tiering.TablesDemoted++
+			d.mu.versions.metrics.Tiering.BytesDemoted += ve.NewFiles[0].Meta.Size
+		case tierPromote:
+			d.mu.versions.metrics.Tiering.TablesPromoted++
+			d.mu.versions.metrics.Tiering.BytesPromoted += ve.NewFiles[0].Meta.Size
+		}
 		d.mu.snapshots.cumulativePinnedCount += stats.cumulativePinnedKeys
 		d.mu.snapshots.cumulativePinnedSize += stats.cumulativePinnedSize
+		d.updateNamedSnapshotPinnedLocked(&stats)
 		d.mu.versions.metrics.Keys.MissizedTombstonesCount += stats.countMissizedDels
 	}
 
@@ -2255,6 +2457,44 @@ type compactStats struct {
 	cumulativePinnedKeys uint64
 	cumulativePinnedSize uint64
 	countMissizedDels    uint64
+	// pinnedBySnapshot breaks down the snapshot-pinned keys by the sequence
+	// number of the earliest snapshot that observes them.
+	pinnedBySnapshot map[uint64]snapshotPinnedStats
+}
+
+type snapshotPinnedStats struct {
+	keys, size uint64
+}
+
+// addPinned records a snapshot-pinned key with the given sequence number.
+func (s *compactStats) addPinned(snapshots compact.Snapshots, seqNum uint64, size uint64) {
+	i := snapshots.Index(seqNum)
+	if i == len(snapshots) {
+		return
+	}
+	if s.pinnedBySnapshot == nil {
+		s.pinnedBySnapshot = make(map[uint64]snapshotPinnedStats)
+	}
+	p := s.pinnedBySnapshot[snapshots[i]]
+	p.keys++
+	p.size += size
+	s.pinnedBySnapshot[snapshots[i]] = p
+}
+
+// merge adds the stats of a subcompaction.
+func (s *compactStats) merge(o *compactStats) {
+	s.cumulativePinnedKeys += o.cumulativePinnedKeys
+	s.cumulativePinnedSize += o.cumulativePinnedSize
+	s.countMissizedDels += o.countMissizedDels
+	for seqNum, p := range o.pinnedBySnapshot {
+		if s.pinnedBySnapshot == nil {
+			s.pinnedBySnapshot = make(map[uint64]snapshotPinnedStats)
+		}
+		q := s.pinnedBySnapshot[seqNum]
+		q.keys += p.keys
+		q.size += p.size
+		s.pinnedBySnapshot[seqNum] = q
+	}
 }
 
 // runCopyCompaction runs a copy compaction where a new FileNum is created that
@@ -2293,23 +2533,7 @@ func (d *DB) runCopyCompaction(
 	// To ease up cleanup of the local file and tracking of refs, we create
 	// a new FileNum. This has the potential of making the block cache less
 	// effective, however.
-	newMeta := &fileMetadata{
-		Size:                  inputMeta.Size,
-		CreationTime:          inputMeta.CreationTime,
-		SmallestSeqNum:        inputMeta.SmallestSeqNum,
-		LargestSeqNum:         inputMeta.LargestSeqNum,
-		LargestSeqNumAbsolute: inputMeta.LargestSeqNumAbsolute,
-		Stats:                 inputMeta.Stats,
-		Virtual:               inputMeta.Virtual,
-		SyntheticPrefix:       inputMeta.SyntheticPrefix,
-		SyntheticSuffix:       inputMeta.SyntheticSuffix,
-	}
-	if inputMeta.HasPointKeys {
-		newMeta.ExtendPointKeyBounds(c.cmp, inputMeta.SmallestPointKey, inputMeta.LargestPointKey)
-	}
-	if inputMeta.HasRangeKeys {
-		newMeta.ExtendRangeKeyBounds(c.cmp, inputMeta.SmallestRangeKey, inputMeta.LargestRangeKey)
-	}
+	newMeta := copyFileMetadata(c.cmp, inputMeta)
 	newMeta.FileNum = d.mu.versions.getNextFileNum()
 	if objMeta.IsExternal() {
 		// external -> local/shared copy. File must be virtual.
@@ -2363,6 +2587,7 @@ func (d *DB) runCopyCompaction(
 		if err != nil {
 			return pendingOutputs, err
 		}
+		w = d.diskIO.writable(w, c.diskIOClass(), c.cancelled)
 		pendingOutputs = append(pendingOutputs, compactionOutput{
 			meta:    newMeta,
 			isLocal: !outObjMeta.IsRemote(),
@@ -2399,7 +2624,12 @@ func (d *DB) runCopyCompaction(
 		})
 		_, err := d.objProvider.LinkOrCopyFromLocal(context.TODO(), d.opts.FS,
 			d.objProvider.Path(objMeta), fileTypeTable, newMeta.FileBacking.DiskFileNum,
-			objstorage.CreateOptions{PreferSharedStorage: true})
+			objstorage.CreateOptions{
+				PreferSharedStorage: true,
+				BeforeCopy: func() error {
+					return d.diskIO.waitRead(c.diskIOClass(), int64(inputMeta.Size), c.cancelled)
+				},
+			})
 
 		if err != nil {
 			return pendingOutputs, err
@@ -2416,6 +2646,29 @@ func (d *DB) runCopyCompaction(
 	return pendingOutputs, nil
 }
 
+// copyFileMetadata returns the metadata of a copy of the table described by
+// m. The FileNum and FileBacking of the returned metadata are left unset.
+func copyFileMetadata(cmp Compare, m *fileMetadata) *fileMetadata {
+	newMeta := &fileMetadata{
+		Size:                  m.Size,
+		CreationTime:          m.CreationTime,
+		SmallestSeqNum:        m.SmallestSeqNum,
+		LargestSeqNum:         m.LargestSeqNum,
+		LargestSeqNumAbsolute: m.LargestSeqNumAbsolute,
+		Stats:                 m.Stats,
+		Virtual:               m.Virtual,
+		SyntheticPrefix:       m.SyntheticPrefix,
+		SyntheticSuffix:       m.SyntheticSuffix,
+	}
+	if m.HasPointKeys {
+		newMeta.ExtendPointKeyBounds(cmp, m.SmallestPointKey, m.LargestPointKey)
+	}
+	if m.HasRangeKeys {
+		newMeta.ExtendRangeKeyBounds(cmp, m.SmallestRangeKey, m.LargestRangeKey)
+	}
+	return newMeta
+}
+
 type compactionOutput struct {
 	meta    *fileMetadata
 	isLocal bool
@@ -2452,7 +2705,7 @@ func (d *DB) runMoveOrCopyCompaction(
 			panic("got more than one file for a move or copy compaction")
 		}
 	}
-	if c.cancel.Load() {
+	if c.cancelled() {
 		return ve, nil, stats, ErrCancelledCompaction
 	}
 	objMeta, err := d.objProvider.Lookup(fileTypeTable, meta.FileBacking.DiskFileNum)
@@ -2476,6 +2729,10 @@ func (d *DB) runMoveOrCopyCompaction(
 	if c.kind == compactionKindMove {
 		return ve, nil, stats, nil
 	}
+	if c.tiering != tierNone {
+		pendingOutputs, err = d.runTieringCompaction(jobID, c, meta, objMeta, ve)
+		return ve, pendingOutputs, stats, err
+	}
 
 	pendingOutputs, err = d.runCopyCompaction(jobID, c, meta, objMeta, ve)
 	return ve, pendingOutputs, stats, err
@@ -2507,6 +2764,7 @@ func (d *DB) runCompaction(
 	}()
 
 	snapshots := d.mu.snapshots.toSlice()
+	c.historyRetentionSeqNum = d.historyRetentionSeqNumLocked()
 	formatVers := d.FormatMajorVersion()
 
 	if c.flushing == nil {
@@ -2521,15 +2779,80 @@ func (d *DB) runCompaction(
 		defer vers.UnrefLocked()
 	}
 
-	if c.cancel.Load() {
+	if c.cancelled() {
 		return ve, nil, stats, ErrCancelledCompaction
 	}
+	numSubcompactions := d.numSubcompactionsLocked(c)
+	// Every split adds a subcompaction. The subcompactions that are run are
+	// accounted for until they complete, by which time d.mu is held again.
+	var splits [][]byte
+	defer func() { d.mu.compact.subcompactingCount -= len(splits) }()
+	c.inputNewIters = d.diskIO.newIters(d.newIters, c.diskIOClass(), c.cancelled)
 
 	// Release the d.mu lock while doing I/O.
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	e := d.opts.Experimental.CompactionExecutor
+	remote := e != nil && d.canOffloadCompaction(c)
+	if !remote {
+		splits = c.subcompactionSplits(numSubcompactions)
+	}
+	// The subcompactions that are not run are released right away.
+	if unused := numSubcompactions - 1 - len(splits); unused > 0 {
+		d.compactionSlots.releaseExtra(unused)
+		d.mu.Lock()
+		d.mu.compact.subcompactingCount -= unused
+		d.mu.Unlock()
+	}
+	defer d.compactionSlots.releaseExtra(len(splits))
+
+	if remote {
+		ve, pendingOutputs, stats, retErr = d.runRemoteCompaction(jobID, c, e, snapshots, formatVers)
+	} else if len(splits) > 0 {
+		ve, pendingOutputs, stats, retErr = d.runSubcompactions(jobID, c, snapshots, formatVers, splits)
+	} else {
+		ve, pendingOutputs, stats, retErr = d.compactAndWrite(jobID, c, snapshots, formatVers, nil, nil)
+	}
+	if retErr != nil {
+		return nil, pendingOutputs, stats, retErr
+	}
+
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			ve.DeletedFiles[deletedFileEntry{
+				Level:   cl.level,
+				FileNum: f.FileNum,
+			}] = f
+		}
+	}
+
+	if err := d.objProvider.Sync(); err != nil {
+		return nil, pendingOutputs, stats, err
+	}
+
+	// Refresh the disk available statistic whenever a compaction/flush
+	// completes, before re-acquiring the mutex.
+	_ = d.calculateDiskAvailableBytes()
+
+	return ve, pendingOutputs, stats, nil
+}
+
+// compactAndWrite iterates over the compaction's inputs, writing the output
+// tables. If lower or upper are non-nil, only the keys within [lower, upper)
+// are processed; see runSubcompactions. The returned versionEdit holds the new
+// tables.
+//
+// d.mu must not be held when calling this method.
+func (d *DB) compactAndWrite(
+	jobID JobID,
+	c *compaction,
+	snapshots compact.Snapshots,
+	formatVers FormatMajorVersion,
+	lower, upper []byte,
+) (ve *versionEdit, pendingOutputs []compactionOutput, stats compactStats, retErr error) {
 	// Compactions use a pool of buffers to read blocks, avoiding polluting the
 	// block cache with blocks that will not be read again. We initialize the
 	// buffer pool with a size 12. This initial size does not need to be
@@ -2556,10 +2879,15 @@ func (d *DB) runCompaction(
 	c.bufferPool.Init(12)
 	defer c.bufferPool.Release()
 
-	pointIter, rangeDelIter, rangeKeyIter, err := c.newInputIters(d.newIters, d.tableNewRangeKeyIter)
+	pointIter, rangeDelIter, rangeKeyIter, err := c.newInputIters(
+		c.inputNewIters, tableNewRangeKeyIter(context.TODO(), c.inputNewIters))
 	if err != nil {
 		return nil, pendingOutputs, stats, err
 	}
+	if lower != nil || upper != nil {
+		pointIter, rangeDelIter, rangeKeyIter = c.boundInputIters(
+			pointIter, rangeDelIter, rangeKeyIter, lower, upper)
+	}
 	c.allowedZeroSeqNum = c.allowZeroSeqNum()
 	cfg := compact.IterConfig{
 		Comparer:                               c.comparer,
@@ -2567,26 +2895,18 @@ func (d *DB) runCompaction(
 		TombstoneElision:                       c.delElision,
 		RangeKeyElision:                        c.rangeKeyElision,
 		Snapshots:                              snapshots,
+		HistoryRetentionSeqNum:                 c.historyRetentionSeqNum,
 		AllowZeroSeqNum:                        c.allowedZeroSeqNum,
 		IneffectualSingleDeleteCallback:        d.opts.Experimental.IneffectualSingleDeleteCallback,
 		SingleDeleteInvariantViolationCallback: d.opts.Experimental.SingleDeleteInvariantViolationCallback,
 	}
 	iter := compact.NewIter(cfg, pointIter, rangeDelIter, rangeKeyIter)
 
-	var (
-		createdFiles    []base.DiskFileNum
-		tw              *sstable.Writer
-		pinnedKeySize   uint64
-		pinnedValueSize uint64
-		pinnedCount     uint64
-	)
+	var createdFiles []base.DiskFileNum
 	defer func() {
 		if iter != nil {
 			retErr = firstError(retErr, iter.Close())
 		}
-		if tw != nil {
-			retErr = firstError(retErr, tw.Close())
-		}
 		if retErr != nil {
 			for _, fileNum := range createdFiles {
 				_ = d.objProvider.Remove(fileTypeTable, fileNum)
@@ -2601,43 +2921,10 @@ func (d *DB) runCompaction(
 		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
 	}
 
-	startLevelBytes := c.startLevel.files.SizeSum()
-	outputMetrics := &LevelMetrics{
-		BytesIn:   startLevelBytes,
-		BytesRead: c.outputLevel.files.SizeSum(),
-	}
-	if len(c.extraLevels) > 0 {
-		outputMetrics.BytesIn += c.extraLevels[0].files.SizeSum()
-	}
-	outputMetrics.BytesRead += outputMetrics.BytesIn
+	outputMetrics := c.initMetrics()
 
-	c.metrics = map[int]*LevelMetrics{
-		c.outputLevel.level: outputMetrics,
-	}
-	if len(c.flushing) == 0 && c.metrics[c.startLevel.level] == nil {
-		c.metrics[c.startLevel.level] = &LevelMetrics{}
-	}
-	if len(c.extraLevels) > 0 {
-		c.metrics[c.extraLevels[0].level] = &LevelMetrics{}
-		outputMetrics.MultiLevel.BytesInTop = startLevelBytes
-		outputMetrics.MultiLevel.BytesIn = outputMetrics.BytesIn
-		outputMetrics.MultiLevel.BytesRead = outputMetrics.BytesRead
-	}
-
-	// The table is typically written at the maximum allowable format implied by
-	// the current format major version of the DB.
-	tableFormat := formatVers.MaxTableFormat()
+	tableFormat := d.compactionTableFormat(formatVers)
 	inputLargestSeqNumAbsolute := c.inputLargestSeqNumAbsolute()
-
-	// In format major versions with maximum table formats of Pebblev3, value
-	// blocks were conditional on an experimental setting. In format major
-	// versions with maximum table formats of Pebblev4 and higher, value blocks
-	// are always enabled.
-	if tableFormat == sstable.TableFormatPebblev3 &&
-		(d.opts.Experimental.EnableValueBlocks == nil || !d.opts.Experimental.EnableValueBlocks()) {
-		tableFormat = sstable.TableFormatPebblev2
-	}
-
 	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
 
 	// prevPointKey is a sstable.WriterOption that provides access to
@@ -2655,16 +2942,17 @@ func (d *DB) runCompaction(
 		}
 	}()
 
-	newOutput := func() error {
+	newOutput := func() (*sstable.Writer, error) {
 		// Check if we've been cancelled by a concurrent operation.
-		if c.cancel.Load() {
-			return ErrCancelledCompaction
+		if c.cancelled() {
+			return nil, ErrCancelledCompaction
 		}
 		var objMeta objstorage.ObjectMetadata
+		var tw *sstable.Writer
 		var err error
 		objMeta, tw, cpuWorkHandle, err = d.newCompactionOutput(jobID, c, writerOpts)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		fileMeta := &fileMetadata{
 			FileNum:      base.PhysicalTableFileNum(objMeta.DiskFileNum),
@@ -2680,87 +2968,15 @@ func (d *DB) runCompaction(
 			Level: c.outputLevel.level,
 			Meta:  fileMeta,
 		})
-		return nil
+		return tw, nil
 	}
 
-	// finishOutput is called with the a user key up to which all tombstones
-	// should be flushed. Typically, this is the first key of the next
-	// sstable or an empty key if this output is the final sstable.
-	finishOutput := func(splitKey []byte) error {
-		for _, v := range iter.TombstonesUpTo(splitKey) {
-			if tw == nil {
-				if err := newOutput(); err != nil {
-					return err
-				}
-			}
-			// The tombstone being added could be completely outside the
-			// eventual bounds of the sstable. Consider this example (bounds
-			// in square brackets next to table filename):
-			//
-			// ./000240.sst   [tmgc#391,MERGE-tmgc#391,MERGE]
-			// tmgc#391,MERGE [786e627a]
-			// tmgc-udkatvs#331,RANGEDEL
-			//
-			// ./000241.sst   [tmgc#384,MERGE-tmgc#384,MERGE]
-			// tmgc#384,MERGE [666c7070]
-			// tmgc-tvsalezade#383,RANGEDEL
-			// tmgc-tvsalezade#331,RANGEDEL
-			//
-			// ./000242.sst   [tmgc#383,RANGEDEL-tvsalezade#72057594037927935,RANGEDEL]
-			// tmgc-tvsalezade#383,RANGEDEL
-			// tmgc#375,SET [72646c78766965616c72776865676e79]
-			// tmgc-tvsalezade#356,RANGEDEL
-			//
-			// Note that both of the top two SSTables have range tombstones
-			// that start after the file's end keys. Since the file bound
-			// computation happens well after all range tombstones have been
-			// added to the writer, eliding out-of-file range tombstones based
-			// on sequence number at this stage is difficult, and necessitates
-			// read-time logic to ignore range tombstones outside file bounds.
-			if err := rangedel.Encode(&v, tw.Add); err != nil {
-				return err
-			}
-		}
-		for _, v := range iter.RangeKeysUpTo(splitKey) {
-			// Same logic as for range tombstones, except added using tw.AddRangeKey.
-			if tw == nil {
-				if err := newOutput(); err != nil {
-					return err
-				}
-			}
-			if err := rangekey.Encode(&v, tw.AddRangeKey); err != nil {
-				return err
-			}
-		}
-
-		if tw == nil {
-			return nil
-		}
-		{
-			// Set internal sstable properties.
-			p := getInternalWriterProperties(tw)
-			// Set the snapshot pinned totals.
-			p.SnapshotPinnedKeys = pinnedCount
-			p.SnapshotPinnedKeySize = pinnedKeySize
-			p.SnapshotPinnedValueSize = pinnedValueSize
-			stats.cumulativePinnedKeys += pinnedCount
-			stats.cumulativePinnedSize += pinnedKeySize + pinnedValueSize
-			pinnedCount = 0
-			pinnedKeySize = 0
-			pinnedValueSize = 0
-		}
-		if err := tw.Close(); err != nil {
-			tw = nil
-			return err
-		}
+	// finishOutput is called with the metadata of each output once its writer
+	// is closed, and the user key up to which all tombstones were flushed to
+	// it.
+	finishOutput := func(writerMeta *sstable.WriterMetadata, splitKey []byte) error {
 		d.opts.Experimental.CPUWorkPermissionGranter.CPUWorkDone(cpuWorkHandle)
 		cpuWorkHandle = nil
-		writerMeta, err := tw.Metadata()
-		if err != nil {
-			tw = nil
-			return err
-		}
-		tw = nil
 		meta := ve.NewFiles[len(ve.NewFiles)-1].Meta
 		meta.Size = writerMeta.Size
 		meta.SmallestSeqNum = writerMeta.SmallestSeqNum
@@ -2888,6 +3104,160 @@ func (d *DB) runCompaction(
 		}
 		return limit
 	}
+	err = c.writeOutputs(iter, compactionOutputs{
+		newOutput:    newOutput,
+		finishOutput: finishOutput,
+		splitLimit:   splitLimitFunc,
+		checkProgress: func(key []byte) error {
+			if c.cancelled() {
+				return ErrCancelledCompaction
+			}
+			c.updateProgress(key)
+			return nil
+		},
+		keyPinned: func(key *InternalKey, size uint64) {
+			stats.cumulativePinnedKeys++
+			stats.cumulativePinnedSize += size
+			stats.addPinned(snapshots, key.SeqNum(), size)
+		},
+	})
+	if err != nil {
+		return nil, pendingOutputs, stats, err
+	}
+
+	// The compaction iterator keeps track of a count of the number of DELSIZED
+	// keys that encoded an incorrect size. Propagate it up as a part of
+	// compactStats.
+	stats.countMissizedDels = iter.Stats().CountMissizedDels
+	if upper != nil {
+		c.updateProgress(upper)
+	} else {
+		c.addProgress(c.startLevel.files.SizeSum())
+	}
+	return ve, pendingOutputs, stats, nil
+}
+
+// compactionOutputs holds the callbacks through which compaction.writeOutputs
+// creates and finishes output tables. They allow the same loop to write the
+// outputs of local compactions and of remote compaction jobs.
+type compactionOutputs struct {
+	// newOutput returns the writer of a new output table.
+	newOutput func() (*sstable.Writer, error)
+	// finishOutput is called with the metadata of an output table once its
+	// writer is closed, and the user key up to which all tombstones were
+	// flushed to it.
+	finishOutput func(writerMeta *sstable.WriterMetadata, splitKey []byte) error
+	// splitLimit, if non-nil, returns the user key at which an output table
+	// beginning at start must be split.
+	splitLimit func(start []byte) []byte
+	// checkProgress is called with the current user key every
+	// compactionProgressInterval keys. Compaction stops if it returns an
+	// error.
+	checkProgress func(key []byte) error
+	// keyPinned, if non-nil, is called with each written key that was only
+	// surfaced because an open snapshot prevented its elision, and the size of
+	// the key and its value.
+	keyPinned func(key *InternalKey, size uint64)
+}
+
+// writeOutputs writes the keys surfaced by iter into output tables, created
+// and finished through the callbacks of o.
+func (c *compaction) writeOutputs(iter *compact.Iter, o compactionOutputs) (retErr error) {
+	var (
+		tw              *sstable.Writer
+		pinnedKeySize   uint64
+		pinnedValueSize uint64
+		pinnedCount     uint64
+		keysProcessed   int
+	)
+	defer func() {
+		if tw != nil {
+			retErr = firstError(retErr, tw.Close())
+		}
+	}()
+
+	newOutput := func() error {
+		var err error
+		tw, err = o.newOutput()
+		return err
+	}
+
+	// finishOutput is called with the a user key up to which all tombstones
+	// should be flushed. Typically, this is the first key of the next
+	// sstable or an empty key if this output is the final sstable.
+	finishOutput := func(splitKey []byte) error {
+		for _, v := range iter.TombstonesUpTo(splitKey) {
+			if tw == nil {
+				if err := newOutput(); err != nil {
+					return err
+				}
+			}
+			// The tombstone being added could be completely outside the
+			// eventual bounds of the sstable. Consider this example (bounds
+			// in square brackets next to table filename):
+			//
+			// ./000240.sst   [tmgc#391,MERGE-tmgc#391,MERGE]
+			// tmgc#391,MERGE [786e627a]
+			// tmgc-udkatvs#331,RANGEDEL
+			//
+			// ./000241.sst   [tmgc#384,MERGE-tmgc#384,MERGE]
+			// tmgc#384,MERGE [666c7070]
+			// tmgc-tvsalezade#383,RANGEDEL
+			// tmgc-tvsalezade#331,RANGEDEL
+			//
+			// ./000242.sst   [tmgc#383,RANGEDEL-tvsalezade#72057594037927935,RANGEDEL]
+			// tmgc-tvsalezade#383,RANGEDEL
+			// tmgc#375,SET [72646c78766965616c72776865676e79]
+			// tmgc-tvsalezade#356,RANGEDEL
+			//
+			// Note that both of the top two SSTables have range tombstones
+			// that start after the file's end keys. Since the file bound
+			// computation happens well after all range tombstones have been
+			// added to the writer, eliding out-of-file range tombstones based
+			// on sequence number at this stage is difficult, and necessitates
+			// read-time logic to ignore range tombstones outside file bounds.
+			if err := rangedel.Encode(&v, tw.Add); err != nil {
+				return err
+			}
+		}
+		for _, v := range iter.RangeKeysUpTo(splitKey) {
+			// Same logic as for range tombstones, except added using tw.AddRangeKey.
+			if tw == nil {
+				if err := newOutput(); err != nil {
+					return err
+				}
+			}
+			if err := rangekey.Encode(&v, tw.AddRangeKey); err != nil {
+				return err
+			}
+		}
+
+		if tw == nil {
+			return nil
+		}
+		{
+			// Set internal sstable properties.
+			p := getInternalWriterProperties(tw)
+			// Set the snapshot pinned totals.
+			p.SnapshotPinnedKeys = pinnedCount
+			p.SnapshotPinnedKeySize = pinnedKeySize
+			p.SnapshotPinnedValueSize = pinnedValueSize
+			pinnedCount = 0
+			pinnedKeySize = 0
+			pinnedValueSize = 0
+		}
+		err := tw.Close()
+		var writerMeta *sstable.WriterMetadata
+		if err == nil {
+			writerMeta, err = tw.Metadata()
+		}
+		tw = nil
+		if err != nil {
+			return err
+		}
+		return o.finishOutput(writerMeta, splitKey)
+	}
+
 	lastUserKeyFn := func() []byte {
 		return tw.UnsafeLastPointUserKey()
 	}
@@ -2906,10 +3276,22 @@ func (d *DB) runCompaction(
 		if key != nil && firstKey == nil {
 			firstKey = key.UserKey
 		}
-		splitter := compact.NewOutputSplitter(c.cmp, firstKey, splitLimitFunc(firstKey), c.maxOutputFileSize, c.grandparents.Iter(), iter.Frontiers())
+		var limit []byte
+		if o.splitLimit != nil {
+			limit = o.splitLimit(firstKey)
+		}
+		splitter := compact.NewOutputSplitter(c.cmp, firstKey, limit, c.maxOutputFileSize, c.grandparents.Iter(), iter.Frontiers())
 
 		// Each inner loop iteration processes one key from the input iterator.
 		for ; key != nil; key, val = iter.Next() {
+			// Periodically check whether the compaction should stop, so that a
+			// compaction writing a large output does not run to completion
+			// once cancelled.
+			if keysProcessed++; keysProcessed%compactionProgressInterval == 0 {
+				if err := o.checkProgress(key.UserKey); err != nil {
+					return err
+				}
+			}
 			if splitter.ShouldSplitBefore(key.UserKey, tw.EstimatedSize(), lastUserKeyFn) {
 				break
 			}
@@ -2935,11 +3317,11 @@ func (d *DB) runCompaction(
 			}
 			if tw == nil {
 				if err := newOutput(); err != nil {
-					return nil, pendingOutputs, stats, err
+					return err
 				}
 			}
 			if err := tw.AddWithForceObsolete(*key, val, iter.ForceObsoleteDueToRangeDel()); err != nil {
-				return nil, pendingOutputs, stats, err
+				return err
 			}
 			if iter.SnapshotPinned() {
 				// The kv pair we just added to the sstable was only surfaced by
@@ -2948,37 +3330,34 @@ func (d *DB) runCompaction(
 				pinnedCount++
 				pinnedKeySize += uint64(len(key.UserKey)) + base.InternalTrailerLen
 				pinnedValueSize += uint64(len(val))
+				if o.keyPinned != nil {
+					o.keyPinned(key, uint64(len(key.UserKey))+base.InternalTrailerLen+uint64(len(val)))
+				}
 			}
 		}
 		if err := finishOutput(splitter.SplitKey()); err != nil {
-			return nil, pendingOutputs, stats, err
-		}
-	}
-
-	for _, cl := range c.inputs {
-		iter := cl.files.Iter()
-		for f := iter.First(); f != nil; f = iter.Next() {
-			ve.DeletedFiles[deletedFileEntry{
-				Level:   cl.level,
-				FileNum: f.FileNum,
-			}] = f
+			return err
 		}
 	}
+	return nil
+}
 
-	// The compaction iterator keeps track of a count of the number of DELSIZED
-	// keys that encoded an incorrect size. Propagate it up as a part of
-	// compactStats.
-	stats.countMissizedDels = iter.Stats().CountMissizedDels
+// compactionTableFormat returns the format of the tables written by flushes
+// and compactions.
+func (d *DB) compactionTableFormat(formatVers FormatMajorVersion) sstable.TableFormat {
+	// The table is typically written at the maximum allowable format implied by
+	// the current format major version of the DB.
+	tableFormat := formatVers.MaxTableFormat()
 
-	if err := d.objProvider.Sync(); err != nil {
-		return nil, pendingOutputs, stats, err
+	// In format major versions with maximum table formats of Pebblev3, value
+	// blocks were conditional on an experimental setting. In format major
+	// versions with maximum table formats of Pebblev4 and higher, value blocks
+	// are always enabled.
+	if tableFormat == sstable.TableFormatPebblev3 &&
+		(d.opts.Experimental.EnableValueBlocks == nil || !d.opts.Experimental.EnableValueBlocks()) {
+		tableFormat = sstable.TableFormatPebblev2
 	}
-
-	// Refresh the disk available statistic whenever a compaction/flush
-	// completes, before re-acquiring the mutex.
-	_ = d.calculateDiskAvailableBytes()
-
-	return ve, pendingOutputs, stats, nil
+	return tableFormat
 }
 
 // newCompactionOutput creates an object for a new table produced by a
@@ -3036,6 +3415,7 @@ func (d *DB) newCompactionOutput(
 			written:  &c.bytesWritten,
 		}
 	}
+	writable = d.diskIO.writable(writable, c.diskIOClass(), c.cancelled)
 	d.opts.EventListener.TableCreated(TableCreateInfo{
 		JobID:   int(jobID),
 		Reason:  reason,
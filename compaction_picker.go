@@ -10,6 +10,7 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
@@ -37,6 +38,9 @@ type compactionEnv struct {
 	earliestSnapshotSeqNum  uint64
 	inProgressCompactions   []compactionInfo
 	readCompactionEnv       readCompactionEnv
+	// now is the current time, according to the DB's clock. It is used to
+	// determine whether tables have expired.
+	now time.Time
 }
 
 type compactionPicker interface {
@@ -191,6 +195,9 @@ type pickedCompaction struct {
 	score float64
 	// kind indicates the kind of compaction.
 	kind compactionKind
+	// fifoDrop is true for delete-only compactions that drop the oldest tables
+	// of a DB using CompactionStyleFIFO.
+	fifoDrop bool
 	// startLevel is the level that is being compacted. Inputs from startLevel
 	// and outputLevel will be merged to produce a set of outputLevel files.
 	startLevel *compactionLevel
@@ -577,6 +584,29 @@ func anyTablesCompacting(inputs manifest.LevelSlice) bool {
 	return false
 }
 
+// newCompactionPicker creates the compactionPicker for the newest version,
+// according to the configured compaction style.
+func newCompactionPicker(
+	v *version,
+	virtualBackings *manifest.VirtualBackings,
+	opts *Options,
+	inProgressCompactions []compactionInfo,
+) compactionPicker {
+	var p compactionPicker
+	switch opts.CompactionStyle {
+	case CompactionStyleUniversal:
+		p = newCompactionPickerUniversal(v, virtualBackings, opts, inProgressCompactions)
+	case CompactionStyleFIFO:
+		p = newCompactionPickerFIFO(v, virtualBackings, opts, inProgressCompactions)
+	default:
+		p = newCompactionPickerByScore(v, virtualBackings, opts, inProgressCompactions)
+	}
+	if opts.Experimental.CompactionPicker != nil {
+		p = newCompactionPickerCustom(p, v, opts)
+	}
+	return p
+}
+
 // newCompactionPickerByScore creates a compactionPickerByScore associated with
 // the newest version. The picker is used under logLock (until a new version is
 // installed).
@@ -1757,10 +1787,16 @@ func pickL0(env compactionEnv, opts *Options, vers *version, baseLevel int) (pc
 	}
 
 	// Couldn't choose a base compaction. Try choosing an intra-L0
-	// compaction. Note that we pass in L0CompactionThreshold here as opposed to
-	// 1, since choosing a single sublevel intra-L0 compaction is
-	// counterproductive.
-	lcf, err = vers.L0Sublevels.PickIntraL0Compaction(env.earliestUnflushedSeqNum, minIntraL0Count)
+	// compaction.
+	return pickIntraL0(env, opts, vers)
+}
+
+// pickIntraL0 picks an intra-L0 compaction, or returns nil if there is no
+// productive intra-L0 compaction.
+func pickIntraL0(env compactionEnv, opts *Options, vers *version) (pc *pickedCompaction) {
+	// Note that we pass in L0CompactionThreshold here as opposed to 1, since
+	// choosing a single sublevel intra-L0 compaction is counterproductive.
+	lcf, err := vers.L0Sublevels.PickIntraL0Compaction(env.earliestUnflushedSeqNum, minIntraL0Count)
 	if err != nil {
 		opts.Logger.Errorf("error when picking intra-L0 compaction: %s", err)
 		return
@@ -1821,8 +1857,10 @@ func pickManualCompaction(
 		// concurrent compaction.
 		return nil, true
 	}
-	if pc = pc.maybeAddLevel(opts, env.diskAvailBytes); pc == nil {
-		return nil, false
+	if manual.maxOutputLevel == 0 || pc.outputLevel.level < manual.maxOutputLevel {
+		if pc = pc.maybeAddLevel(opts, env.diskAvailBytes); pc == nil {
+			return nil, false
+		}
 	}
 	if pc.outputLevel.level != outputLevel {
 		if len(pc.extraLevels) > 0 {
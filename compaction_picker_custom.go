@@ -0,0 +1,342 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// TableStats contains statistics on a table used for compaction heuristics.
+type TableStats = manifest.TableStats
+
+// CompactionPicker picks automatic compactions. It is configured through
+// Options.Experimental.CompactionPicker and allows experimenting with
+// compaction heuristics without modifying Pebble.
+//
+// Pick is called with DB.mu held whenever Pebble is able to schedule another
+// automatic compaction, and must not call back into the DB. It returns the
+// compaction to run, or nil if no compaction should be run. Pick may delegate
+// to DefaultCompactionPicker to obtain the compaction the built-in picker
+// would have chosen.
+//
+// A returned candidate is validated before it is scheduled. Candidates that
+// would violate the invariants of the LSM are logged and ignored; see
+// CompactionCandidate.
+type CompactionPicker interface {
+	Pick(view *CompactionView) *CompactionCandidate
+}
+
+// DefaultCompactionPicker is the built-in picker for the configured
+// CompactionStyle: the score-based leveled picker by default.
+var DefaultCompactionPicker CompactionPicker = defaultCompactionPicker{}
+
+type defaultCompactionPicker struct{}
+
+// Pick implements CompactionPicker.
+func (defaultCompactionPicker) Pick(view *CompactionView) *CompactionCandidate {
+	pc := view.picker.pickAuto(view.env)
+	if pc == nil {
+		return nil
+	}
+	c := &CompactionCandidate{
+		StartLevel:  pc.startLevel.level,
+		OutputLevel: pc.outputLevel.level,
+		pc:          pc,
+	}
+	iter := pc.startLevel.files.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		c.Tables = append(c.Tables, f.FileNum)
+	}
+	return c
+}
+
+// CompactionView is a read-only view of the current version of the LSM passed
+// to CompactionPicker.Pick. The tables of a level are gathered when they are
+// first requested, since Pick is called with DB.mu held: a picker should
+// consult NumTables and LevelSize before requesting the tables of every
+// level. The view, and the slices it returns, must not be retained or
+// modified.
+type CompactionView struct {
+	// BaseLevel is the level into which L0 is compacted. Levels between L0
+	// and BaseLevel are empty.
+	BaseLevel int
+	// InProgress describes the compactions that are currently running.
+	InProgress []CompactionViewInProgress
+
+	vers *version
+	// levels and l0Sublevels memoize Level and L0Sublevels.
+	levels      [numLevels][]CompactionViewTable
+	l0Sublevels [][]CompactionViewTable
+	picker      compactionPicker
+	env         compactionEnv
+}
+
+// NumTables returns the number of tables in the given level.
+func (v *CompactionView) NumTables(level int) int {
+	return v.vers.Levels[level].Len()
+}
+
+// LevelSize returns the total size of the tables in the given level.
+func (v *CompactionView) LevelSize(level int) uint64 {
+	return v.vers.Levels[level].Size()
+}
+
+// Level returns the tables of the given level. The tables of L0 are ordered by
+// sequence number, and the tables of the remaining levels are ordered by key.
+func (v *CompactionView) Level(level int) []CompactionViewTable {
+	if v.levels[level] == nil {
+		v.levels[level] = newCompactionViewTables(v.vers.Levels[level].Slice())
+	}
+	return v.levels[level]
+}
+
+// L0Sublevels returns the tables of each L0 sublevel, ordered from the oldest
+// sublevel to the newest.
+func (v *CompactionView) L0Sublevels() [][]CompactionViewTable {
+	if v.l0Sublevels == nil {
+		v.l0Sublevels = make([][]CompactionViewTable, 0, len(v.vers.L0SublevelFiles))
+		for _, sublevel := range v.vers.L0SublevelFiles {
+			v.l0Sublevels = append(v.l0Sublevels, newCompactionViewTables(sublevel))
+		}
+	}
+	return v.l0Sublevels
+}
+
+// CompactionViewTable describes a table in a CompactionView.
+type CompactionViewTable struct {
+	TableInfo
+	// Compacting is true if the table is an input to an in-progress
+	// compaction. Compacting tables may not be picked.
+	Compacting bool
+	// Stats holds the table's statistics. Stats are loaded asynchronously
+	// after a table is created, and are only meaningful if StatsValid is true.
+	Stats      TableStats
+	StatsValid bool
+}
+
+// CompactionViewInProgress describes an in-progress compaction in a
+// CompactionView.
+type CompactionViewInProgress struct {
+	// Inputs holds the input tables of each level participating in the
+	// compaction.
+	Inputs []LevelInfo
+	// OutputLevel is the level the compaction writes to.
+	OutputLevel int
+}
+
+// CompactionCandidate describes a compaction picked by a CompactionPicker.
+//
+// A candidate is legal if:
+//
+//   - OutputLevel is below StartLevel, and both levels are either L0 or at
+//     or below the base level.
+//   - Every table exists in StartLevel and is not compacting.
+//   - If StartLevel is L0, no older L0 table overlapping the tables is left
+//     out. Otherwise, no table in StartLevel overlapping the key range of the
+//     tables is left out.
+//   - No level between StartLevel and OutputLevel holds tables overlapping the
+//     key range of the tables.
+//   - None of the tables of OutputLevel overlapping the key range of the
+//     tables is compacting, and no in-progress compaction writes to the same
+//     key range of OutputLevel.
+//
+// The overlapping tables of OutputLevel are added to the compaction
+// automatically.
+type CompactionCandidate struct {
+	StartLevel  int
+	OutputLevel int
+	Tables      []FileNum
+
+	// pc is set if the candidate was picked by DefaultCompactionPicker.
+	pc *pickedCompaction
+}
+
+// compactionPickerCustom wraps the built-in compactionPicker for the
+// configured compaction style, picking automatic compactions with the
+// CompactionPicker configured in Options.Experimental.CompactionPicker.
+type compactionPickerCustom struct {
+	compactionPicker
+	opts *Options
+	vers *version
+}
+
+var _ compactionPicker = &compactionPickerCustom{}
+
+func newCompactionPickerCustom(
+	p compactionPicker, v *version, opts *Options,
+) *compactionPickerCustom {
+	return &compactionPickerCustom{compactionPicker: p, opts: opts, vers: v}
+}
+
+// pickAuto implements compactionPicker.
+func (p *compactionPickerCustom) pickAuto(env compactionEnv) *pickedCompaction {
+	c := p.opts.Experimental.CompactionPicker.Pick(p.newView(env))
+	if c == nil {
+		return nil
+	}
+	if c.pc != nil && c.unmodified() {
+		return c.pc
+	}
+	pc, err := p.validate(env, c)
+	if err != nil {
+		p.opts.Logger.Errorf("pebble: ignoring compaction candidate L%d->L%d: %v",
+			c.StartLevel, c.OutputLevel, err)
+		return nil
+	}
+	return pc
+}
+
+// unmodified returns true if the candidate still describes the compaction
+// picked by DefaultCompactionPicker.
+func (c *CompactionCandidate) unmodified() bool {
+	if c.StartLevel != c.pc.startLevel.level || c.OutputLevel != c.pc.outputLevel.level ||
+		len(c.Tables) != c.pc.startLevel.files.Len() {
+		return false
+	}
+	i := 0
+	iter := c.pc.startLevel.files.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if c.Tables[i] != f.FileNum {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+func (p *compactionPickerCustom) newView(env compactionEnv) *CompactionView {
+	view := &CompactionView{
+		BaseLevel: p.getBaseLevel(),
+		vers:      p.vers,
+		picker:    p.compactionPicker,
+		env:       env,
+	}
+	for _, info := range env.inProgressCompactions {
+		c := CompactionViewInProgress{OutputLevel: info.outputLevel}
+		for _, cl := range info.inputs {
+			c.Inputs = append(c.Inputs, LevelInfo{Level: cl.level, Tables: tableInfos(cl.files)})
+		}
+		view.InProgress = append(view.InProgress, c)
+	}
+	return view
+}
+
+func newCompactionViewTables(files manifest.LevelSlice) []CompactionViewTable {
+	tables := make([]CompactionViewTable, 0, files.Len())
+	iter := files.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		tables = append(tables, CompactionViewTable{
+			TableInfo:  f.TableInfo(),
+			Compacting: f.IsCompacting(),
+			Stats:      f.Stats,
+			StatsValid: f.StatsValid(),
+		})
+	}
+	return tables
+}
+
+func tableInfos(files manifest.LevelSlice) []TableInfo {
+	infos := make([]TableInfo, 0, files.Len())
+	iter := files.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		infos = append(infos, f.TableInfo())
+	}
+	return infos
+}
+
+// validate checks that the candidate is legal, returning the corresponding
+// pickedCompaction. See CompactionCandidate for the rules.
+func (p *compactionPickerCustom) validate(
+	env compactionEnv, c *CompactionCandidate,
+) (*pickedCompaction, error) {
+	baseLevel := p.getBaseLevel()
+	switch {
+	case c.StartLevel < 0 || c.OutputLevel >= numLevels:
+		return nil, errors.Errorf("levels out of range")
+	case c.OutputLevel <= c.StartLevel:
+		return nil, errors.Errorf("output level must be below start level")
+	case c.StartLevel > 0 && c.StartLevel < baseLevel, c.OutputLevel < baseLevel:
+		return nil, errors.Errorf("levels above base level L%d", baseLevel)
+	case len(c.Tables) == 0:
+		return nil, errors.Errorf("no tables")
+	}
+
+	cmp := p.opts.Comparer.Compare
+	want := make(map[base.FileNum]bool, len(c.Tables))
+	for _, fileNum := range c.Tables {
+		want[fileNum] = true
+	}
+	included := make(map[base.FileNum]bool, len(c.Tables))
+	var files []*fileMetadata
+	iter := p.vers.Levels[c.StartLevel].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if !want[f.FileNum] {
+			continue
+		}
+		if f.IsCompacting() {
+			return nil, errors.Errorf("table %s is compacting", f.FileNum)
+		}
+		delete(want, f.FileNum)
+		included[f.FileNum] = true
+		files = append(files, f)
+	}
+	for fileNum := range want {
+		return nil, errors.Errorf("table %s not found in L%d", fileNum, c.StartLevel)
+	}
+
+	pc := newPickedCompaction(p.opts, p.vers, c.StartLevel, c.OutputLevel, baseLevel)
+	if c.StartLevel == 0 {
+		pc.startLevel.files = manifest.NewLevelSliceSeqSorted(files)
+	} else {
+		pc.startLevel.files = manifest.NewLevelSliceKeySorted(cmp, files)
+	}
+	smallest, largest := manifest.KeyRange(cmp, pc.startLevel.files.Iter())
+	bounds := base.UserKeyBoundsFromInternal(smallest, largest)
+
+	// Leaving out an overlapping table of the start level would leave newer
+	// data for the same keys below the output (L>0), or older data above it
+	// (L0), which inverts the sequence number ordering of the LSM. An L0
+	// table may only be left out if it lies in a higher sublevel, and holds
+	// newer keys, than every included table it overlaps.
+	overlaps := p.vers.Overlaps(c.StartLevel, bounds)
+	overlapsIter := overlaps.Iter()
+	for f := overlapsIter.First(); f != nil; f = overlapsIter.Next() {
+		if included[f.FileNum] {
+			continue
+		}
+		if c.StartLevel > 0 {
+			return nil, errors.Errorf("overlapping table %s in L%d not included", f.FileNum, c.StartLevel)
+		}
+		fBounds := f.UserKeyBounds()
+		for _, g := range files {
+			if !g.Overlaps(cmp, &fBounds) {
+				continue
+			}
+			if f.SubLevel <= g.SubLevel || f.LargestSeqNum <= g.LargestSeqNum {
+				return nil, errors.Errorf("overlapping table %s in L0 not included", f.FileNum)
+			}
+		}
+	}
+	for level := c.StartLevel + 1; level < c.OutputLevel; level++ {
+		if overlaps := p.vers.Overlaps(level, bounds); !overlaps.Empty() {
+			return nil, errors.Errorf("overlapping tables in intermediate level L%d", level)
+		}
+	}
+
+	pc.outputLevel.files = p.vers.Overlaps(c.OutputLevel, bounds)
+	if anyTablesCompacting(pc.outputLevel.files) {
+		return nil, errors.Errorf("overlapping tables in L%d are compacting", c.OutputLevel)
+	}
+	if c.StartLevel == 0 {
+		pc.startLevel.l0SublevelInfo = generateSublevelInfo(cmp, pc.startLevel.files)
+	}
+	pc.smallest, pc.largest = manifest.KeyRange(cmp, pc.startLevel.files.Iter(), pc.outputLevel.files.Iter())
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil, errors.Errorf("key range is already being compacted")
+	}
+	return pc, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type compactionPickerFunc func(view *CompactionView) *CompactionCandidate
+
+func (f compactionPickerFunc) Pick(view *CompactionView) *CompactionCandidate {
+	return f(view)
+}
+
+func TestCompactionPickerCustom(t *testing.T) {
+	// The picker compacts the L0 tables holding the key "z" into the base
+	// level, and leaves every other table in L0.
+	var views int
+	picker := compactionPickerFunc(func(view *CompactionView) *CompactionCandidate {
+		views++
+		for _, table := range view.Level(0) {
+			if string(table.Smallest.UserKey) == "z" && !table.Compacting {
+				return &CompactionCandidate{
+					StartLevel:  0,
+					OutputLevel: view.BaseLevel,
+					Tables:      []FileNum{table.FileNum},
+				}
+			}
+		}
+		return nil
+	})
+	opts := &Options{FS: vfs.NewMem(), L0CompactionThreshold: 1}
+	opts.Experimental.CompactionPicker = picker
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "z", "b"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	v := d.mu.versions.currentVersion()
+	require.NotZero(t, views)
+	require.Equal(t, 2, v.Levels[0].Len())
+	require.Equal(t, 1, v.Levels[numLevels-1].Len())
+	d.mu.Unlock()
+}
+
+func TestCompactionPickerCustomDefault(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), L0CompactionThreshold: 1}
+	opts.Experimental.CompactionPicker = DefaultCompactionPicker
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.NotZero(t, d.Metrics().Compact.Count)
+}
+
+func TestCompactionPickerCustomValidate(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), L0CompactionThreshold: 1}
+	opts.Experimental.CompactionPicker = compactionPickerFunc(func(*CompactionView) *CompactionCandidate {
+		return nil
+	})
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Two overlapping L0 tables, and a table in L6.
+	require.NoError(t, d.Set([]byte("c"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("c"), []byte("d"), false))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte("b"), nil, nil))
+		require.NoError(t, d.Set([]byte("d"), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := d.mu.versions.picker.(*compactionPickerCustom)
+	v := d.mu.versions.currentVersion()
+	var l0 []FileNum
+	iter := v.Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		l0 = append(l0, f.FileNum)
+	}
+	require.Len(t, l0, 2)
+	l6Iter := v.Levels[numLevels-1].Iter()
+	l6 := l6Iter.First().FileNum
+
+	testCases := []struct {
+		name string
+		c    CompactionCandidate
+		err  string
+	}{
+		{
+			name: "all-l0",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 6, Tables: l0},
+		},
+		{
+			name: "oldest-l0",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 6, Tables: l0[:1]},
+		},
+		{
+			name: "newest-l0",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 6, Tables: l0[1:]},
+			err:  "overlapping table",
+		},
+		{
+			name: "wrong-level",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 6, Tables: []FileNum{l6}},
+			err:  "not found in L0",
+		},
+		{
+			name: "intra-l0",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 0, Tables: l0},
+			err:  "output level must be below start level",
+		},
+		{
+			name: "above-base",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 5, Tables: l0},
+			err:  "above base level",
+		},
+		{
+			name: "no-tables",
+			c:    CompactionCandidate{StartLevel: 0, OutputLevel: 6},
+			err:  "no tables",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pc, err := p.validate(compactionEnv{}, &tc.c)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, len(tc.c.Tables), pc.startLevel.files.Len())
+			require.Equal(t, 1, pc.outputLevel.files.Len())
+		})
+	}
+}
+
+func TestCompactionPickerCustomValidateL0(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.CompactionPicker = compactionPickerFunc(func(*CompactionView) *CompactionCandidate {
+		return nil
+	})
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Two overlapping L0 tables holding "b", followed by a newer table
+	// holding "z", which lies in the oldest sublevel since it overlaps
+	// neither.
+	for _, k := range []string{"b", "b", "z"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := d.mu.versions.picker.(*compactionPickerCustom)
+	view := p.newView(compactionEnv{})
+	require.Equal(t, 3, view.NumTables(0))
+	l0 := view.Level(0)
+	require.Len(t, l0, 3)
+	require.Len(t, view.L0Sublevels(), 2)
+	b0, b1, z := l0[0].FileNum, l0[1].FileNum, l0[2].FileNum
+
+	// The newer table holding "b" may be left out even though the included
+	// "z" table is newer still, since the two do not overlap.
+	_, err = p.validate(compactionEnv{}, &CompactionCandidate{
+		StartLevel: 0, OutputLevel: 6, Tables: []FileNum{b0, z},
+	})
+	require.NoError(t, err)
+	// The older table holding "b" may not be left out.
+	_, err = p.validate(compactionEnv{}, &CompactionCandidate{
+		StartLevel: 0, OutputLevel: 6, Tables: []FileNum{b1, z},
+	})
+	require.ErrorContains(t, err, "overlapping table")
+}
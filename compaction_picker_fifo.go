@@ -0,0 +1,167 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// compactionPickerFIFO picks compactions for CompactionStyleFIFO. Data is
+// never rewritten into lower levels; instead, the oldest tables are dropped by
+// delete-only compactions once the DB exceeds FIFOCompactionOptions.MaxTotalSize
+// or the tables exceed FIFOCompactionOptions.TTL. Tables are ordered by their
+// sequence numbers, so tables are always dropped oldest first, irrespective of
+// the level they reside in. Intra-L0 compactions may produce tables whose
+// sequence number ranges overlap those of other tables; such tables are only
+// dropped together, so that a table is never dropped while older data it
+// shadows (e.g. through a tombstone) is retained.
+//
+// If FIFOCompactionOptions.IntraL0FileThreshold is set, overlapping L0 tables
+// are merged by intra-L0 compactions. Rewrite and elision-only compactions
+// are delegated to the embedded leveled picker.
+type compactionPickerFIFO struct {
+	*compactionPickerByScore
+	// totalSize is the total size of all tables in the version.
+	totalSize uint64
+}
+
+var _ compactionPicker = &compactionPickerFIFO{}
+
+func newCompactionPickerFIFO(
+	v *version,
+	virtualBackings *manifest.VirtualBackings,
+	opts *Options,
+	inProgressCompactions []compactionInfo,
+) *compactionPickerFIFO {
+	p := &compactionPickerFIFO{
+		compactionPickerByScore: newCompactionPickerByScore(v, virtualBackings, opts, inProgressCompactions),
+	}
+	p.compactionPickerByScore.forceBaseLevel1()
+	for level := 0; level < numLevels; level++ {
+		p.totalSize += v.Levels[level].Size()
+	}
+	return p
+}
+
+// getScores implements compactionPicker. The L0 score is the ratio of the
+// total size of the DB to FIFOCompactionOptions.MaxTotalSize, if set; the
+// remaining levels are not scored.
+func (p *compactionPickerFIFO) getScores([]compactionInfo) [numLevels]float64 {
+	var scores [numLevels]float64
+	if maxSize := p.opts.FIFOCompaction.MaxTotalSize; maxSize > 0 {
+		scores[0] = float64(p.totalSize) / float64(maxSize)
+	}
+	return scores
+}
+
+// estimatedCompactionDebt implements compactionPicker. FIFO compactions never
+// rewrite data, so there is no debt.
+func (p *compactionPickerFIFO) estimatedCompactionDebt(l0ExtraSize uint64) uint64 {
+	return 0
+}
+
+// pickAuto implements compactionPicker.
+func (p *compactionPickerFIFO) pickAuto(env compactionEnv) *pickedCompaction {
+	if pc := p.pickDrop(env.now); pc != nil {
+		return pc
+	}
+	if t := p.opts.FIFOCompaction.IntraL0FileThreshold; t > 0 && p.vers.Levels[0].Len() > t {
+		return pickIntraL0(env, p.opts, p.vers)
+	}
+	return nil
+}
+
+// pickReadTriggeredCompaction implements compactionPicker. Read-triggered
+// compactions would move data into lower levels, so they are disabled.
+func (p *compactionPickerFIFO) pickReadTriggeredCompaction(env compactionEnv) *pickedCompaction {
+	return nil
+}
+
+// pickDrop returns a delete-only compaction dropping the oldest tables that
+// exceed the configured size or age limits, or nil if no tables need to be
+// dropped. The tables are partitioned into groups whose sequence number ranges
+// do not overlap, and whole groups are dropped, oldest first.
+func (p *compactionPickerFIFO) pickDrop(now time.Time) *pickedCompaction {
+	opts := &p.opts.FIFOCompaction
+	var files []*fileMetadata
+	var levels []int
+	for level := 0; level < numLevels; level++ {
+		iter := p.vers.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, f)
+			levels = append(levels, level)
+		}
+	}
+	idx := make([]int, len(files))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return files[idx[i]].SmallestSeqNum < files[idx[j]].SmallestSeqNum
+	})
+
+	remaining := p.totalSize
+	var byLevel [numLevels][]*fileMetadata
+	var dropped int
+	for start := 0; start < len(idx); {
+		// Find the end of the group of tables starting at idx[start]: the
+		// tables whose sequence number ranges transitively overlap.
+		end := start + 1
+		largestSeqNum := files[idx[start]].LargestSeqNum
+		for end < len(idx) && files[idx[end]].SmallestSeqNum <= largestSeqNum {
+			largestSeqNum = max(largestSeqNum, files[idx[end]].LargestSeqNum)
+			end++
+		}
+		// A group expires once all of its tables have expired. Tables without
+		// a recorded creation time never expire.
+		overSize := opts.MaxTotalSize > 0 && remaining > uint64(opts.MaxTotalSize)
+		expired := opts.TTL > 0
+		compacting := false
+		var size uint64
+		for _, i := range idx[start:end] {
+			f := files[i]
+			expired = expired && f.CreationTime != 0 &&
+				now.Sub(time.Unix(f.CreationTime, 0)) > opts.TTL
+			compacting = compacting || f.IsCompacting()
+			size += f.Size
+		}
+		// Tables are only ever dropped oldest first; if the oldest tables are
+		// being compacted, wait for them.
+		if (!overSize && !expired) || compacting {
+			break
+		}
+		for _, i := range idx[start:end] {
+			byLevel[levels[i]] = append(byLevel[levels[i]], files[i])
+		}
+		remaining -= size
+		dropped += end - start
+		start = end
+	}
+	if dropped == 0 {
+		return nil
+	}
+
+	pc := &pickedCompaction{
+		cmp:       p.opts.Comparer.Compare,
+		kind:      compactionKindDeleteOnly,
+		fifoDrop:  true,
+		version:   p.vers,
+		baseLevel: p.baseLevel,
+	}
+	for level, files := range byLevel {
+		if len(files) == 0 {
+			continue
+		}
+		slice := manifest.NewLevelSliceKeySorted(pc.cmp, files)
+		if level == 0 {
+			slice = manifest.NewLevelSliceSeqSorted(files)
+		}
+		pc.inputs = append(pc.inputs, compactionLevel{level: level, files: slice})
+	}
+	return pc
+}
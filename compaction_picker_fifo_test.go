@@ -0,0 +1,147 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionPickerFIFO(t *testing.T) {
+	const maxTotalSize = 64 << 10
+	opts := &Options{
+		FS:              vfs.NewMem(),
+		CompactionStyle: CompactionStyleFIFO,
+		FIFOCompaction: FIFOCompactionOptions{
+			MaxTotalSize:         maxTotalSize,
+			IntraL0FileThreshold: 4,
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	waitForCompactions := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	key := func(round, i int) []byte { return []byte(fmt.Sprintf("%04d/%04d", round, i)) }
+	rng := rand.New(rand.NewSource(0))
+	const rounds = 30
+	for round := 0; round < rounds; round++ {
+		for i := 0; i < 16; i++ {
+			v := make([]byte, 1<<10)
+			rng.Read(v)
+			require.NoError(t, d.Set(key(round, i), v, nil))
+		}
+		require.NoError(t, d.Flush())
+		waitForCompactions()
+	}
+
+	m := d.Metrics()
+	require.NotZero(t, m.Compact.FIFODroppedTables)
+	require.NotZero(t, m.Compact.FIFODroppedBytes)
+	require.Zero(t, m.Compact.DefaultCount)
+	require.Contains(t, m.String(), "fifo dropped:")
+	require.LessOrEqual(t, m.Total().Size, int64(maxTotalSize))
+
+	// The oldest data was dropped and the newest data was retained.
+	_, _, err = d.Get(key(0, 0))
+	require.ErrorIs(t, err, ErrNotFound)
+	v, closer, err := d.Get(key(rounds-1, 0))
+	require.NoError(t, err)
+	require.Len(t, v, 1<<10)
+	require.NoError(t, closer.Close())
+}
+
+func TestCompactionPickerFIFOTTL(t *testing.T) {
+	opts := &Options{
+		FS:              vfs.NewMem(),
+		CompactionStyle: CompactionStyleFIFO,
+		FIFOCompaction: FIFOCompactionOptions{
+			TTL: time.Hour,
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	var now atomic.Int64
+	now.Store(time.Now().Unix())
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return time.Unix(now.Load(), 0) }
+	d.mu.Unlock()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	// Flushing an empty memtable schedules compactions without adding a table.
+	require.NoError(t, d.Flush())
+	require.Zero(t, d.Metrics().Compact.FIFODroppedTables)
+
+	// The table expires once the DB's clock passes the TTL.
+	now.Add(int64(2 * time.Hour / time.Second))
+	require.Eventually(t, func() bool {
+		require.NoError(t, d.Flush())
+		return d.Metrics().Compact.FIFODroppedTables == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	_, _, err = d.Get([]byte("a"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// FIFO compaction requires a limit to be configured.
+	opts = &Options{CompactionStyle: CompactionStyleFIFO}
+	require.Error(t, opts.EnsureDefaults().Validate())
+}
+
+func TestCompactionPickerFIFOSeqNumOverlap(t *testing.T) {
+	opts := (&Options{
+		CompactionStyle: CompactionStyleFIFO,
+		FIFOCompaction:  FIFOCompactionOptions{MaxTotalSize: 250},
+	}).EnsureDefaults()
+	file := func(num base.FileNum, smallest, largest string, smallestSeq, largestSeq uint64) *fileMetadata {
+		m := (&fileMetadata{
+			FileNum:        num,
+			Size:           100,
+			SmallestSeqNum: smallestSeq,
+			LargestSeqNum:  largestSeq,
+
+			LargestSeqNumAbsolute: largestSeq,
+		}).ExtendPointKeyBounds(opts.Comparer.Compare,
+			base.MakeInternalKey([]byte(smallest), largestSeq, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(largest), smallestSeq, InternalKeyKindSet))
+		m.InitPhysicalBacking()
+		return m
+	}
+	// Table 2 is the output of an intra-L0 compaction, and its sequence numbers
+	// overlap those of table 3. Dropping the tables in the order of their
+	// largest sequence numbers would drop table 3 but retain table 2.
+	var files [numLevels][]*fileMetadata
+	files[0] = []*fileMetadata{
+		file(1, "a", "b", 1, 2),
+		file(3, "d", "e", 5, 6),
+		file(2, "a", "c", 3, 10),
+		file(4, "a", "z", 11, 12),
+	}
+	vers := newVersion(opts, files)
+	p := newCompactionPickerFIFO(vers, nil, opts, nil)
+	pc := p.pickDrop(time.Now())
+	require.NotNil(t, pc)
+	var dropped []base.FileNum
+	for _, cl := range pc.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			dropped = append(dropped, f.FileNum)
+		}
+	}
+	require.ElementsMatch(t, []base.FileNum{1, 2, 3}, dropped)
+}
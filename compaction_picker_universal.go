@@ -0,0 +1,226 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/pebble/internal/manifest"
+
+// sortedRun describes a single sorted run of the LSM: either an L0 sublevel or
+// a non-empty level below L0.
+type sortedRun struct {
+	level int
+	// sublevel is the L0 sublevel of the run. It is only meaningful if level
+	// is 0.
+	sublevel int
+	size     uint64
+}
+
+// compactionPickerUniversal picks size-tiered ("universal") compactions. It
+// views the LSM as a sequence of sorted runs ordered from newest to oldest:
+// every L0 sublevel, followed by every non-empty level below L0. Automatic
+// compactions always consume whole sorted runs and merge a prefix of the
+// newest runs into a single run.
+//
+// Elision-only, rewrite and read-triggered compactions operate on individual
+// files irrespective of the compaction style; these are delegated to the
+// embedded leveled picker, which is configured with a base level of 1 so that
+// every level below L0 may hold a sorted run.
+type compactionPickerUniversal struct {
+	*compactionPickerByScore
+	// runs holds the version's sorted runs, ordered from newest to oldest.
+	runs []sortedRun
+}
+
+var _ compactionPicker = &compactionPickerUniversal{}
+
+func newCompactionPickerUniversal(
+	v *version,
+	virtualBackings *manifest.VirtualBackings,
+	opts *Options,
+	inProgressCompactions []compactionInfo,
+) *compactionPickerUniversal {
+	p := &compactionPickerUniversal{
+		compactionPickerByScore: newCompactionPickerByScore(v, virtualBackings, opts, inProgressCompactions),
+	}
+	p.compactionPickerByScore.forceBaseLevel1()
+	for i := len(v.L0SublevelFiles) - 1; i >= 0; i-- {
+		if v.L0SublevelFiles[i].Empty() {
+			continue
+		}
+		p.runs = append(p.runs, sortedRun{
+			level:    0,
+			sublevel: i,
+			size:     v.L0SublevelFiles[i].SizeSum(),
+		})
+	}
+	for level := 1; level < numLevels; level++ {
+		if v.Levels[level].Empty() {
+			continue
+		}
+		p.runs = append(p.runs, sortedRun{level: level, size: v.Levels[level].Size()})
+	}
+	return p
+}
+
+// getScores implements compactionPicker. The L0 score is the ratio of the
+// number of sorted runs to the maximum number of sorted runs; the remaining
+// levels are not scored.
+func (p *compactionPickerUniversal) getScores([]compactionInfo) [numLevels]float64 {
+	var scores [numLevels]float64
+	scores[0] = float64(len(p.runs)) / float64(p.opts.UniversalCompaction.MaxSortedRuns)
+	return scores
+}
+
+// estimatedCompactionDebt implements compactionPicker. Every byte in a sorted
+// run other than the oldest is eventually merged into the oldest run.
+func (p *compactionPickerUniversal) estimatedCompactionDebt(l0ExtraSize uint64) uint64 {
+	debt := l0ExtraSize
+	for i := 0; i+1 < len(p.runs); i++ {
+		debt += p.runs[i].size
+	}
+	return debt
+}
+
+// pickAuto implements compactionPicker. Compactions are triggered, in order of
+// precedence, by:
+//
+//   - size amplification: the runs other than the oldest are larger than
+//     MaxSizeAmplificationPercent of the oldest run. All runs are merged into
+//     the bottommost level.
+//   - size ratio: a prefix of at least MinMergeWidth of the newest runs have
+//     similar sizes. The prefix is merged.
+//   - sorted run count: there are more than MaxSortedRuns runs. The newest
+//     runs are merged so that the count no longer exceeds the maximum.
+//
+// No compaction is picked until the number of sorted runs reaches
+// L0CompactionThreshold. If no sorted runs need to be merged, the
+// elision-only, read-triggered and rewrite compactions of the leveled picker
+// are picked, in the same order of precedence.
+func (p *compactionPickerUniversal) pickAuto(env compactionEnv) *pickedCompaction {
+	if k := p.numRunsToMerge(); k > 0 {
+		// Universal compactions consume whole sorted runs, so any concurrent
+		// compaction is likely to conflict. Pick a single compaction at a
+		// time.
+		if len(env.inProgressCompactions) > 0 {
+			return nil
+		}
+		if pc := p.pickNewestRuns(k); pc != nil {
+			return pc
+		}
+	}
+
+	if pc := p.pickElisionOnlyCompaction(env); pc != nil {
+		return pc
+	}
+	if pc := p.pickReadTriggeredCompaction(env); pc != nil {
+		return pc
+	}
+	// See compactionPickerByScore.pickAuto.
+	if env.readCompactionEnv.rescheduleReadCompaction != nil {
+		*env.readCompactionEnv.rescheduleReadCompaction = true
+	}
+	if p.vers.Stats.MarkedForCompaction > 0 {
+		return p.pickRewriteCompaction(env)
+	}
+	return nil
+}
+
+// numRunsToMerge returns the number of newest sorted runs that should be
+// merged, or zero if no compaction is necessary. See pickAuto.
+func (p *compactionPickerUniversal) numRunsToMerge() int {
+	n := len(p.runs)
+	if n < 2 || n < p.opts.L0CompactionThreshold {
+		return 0
+	}
+	opts := &p.opts.UniversalCompaction
+
+	var newerSize uint64
+	for i := 0; i < n-1; i++ {
+		newerSize += p.runs[i].size
+	}
+	if newerSize*100 > uint64(opts.MaxSizeAmplificationPercent)*p.runs[n-1].size {
+		return n
+	}
+	sum := p.runs[0].size
+	j := 1
+	for ; j < n && p.runs[j].size*100 <= sum*uint64(100+opts.SizeRatioPercent); j++ {
+		sum += p.runs[j].size
+	}
+	if j >= opts.MinMergeWidth {
+		return j
+	}
+	if n > opts.MaxSortedRuns {
+		return n - opts.MaxSortedRuns + 1
+	}
+	return 0
+}
+
+// pickNewestRuns returns a compaction merging at least the k newest sorted
+// runs, or nil if any of the runs' files are already compacting.
+func (p *compactionPickerUniversal) pickNewestRuns(k int) *pickedCompaction {
+	k, outputLevel := p.mergeTarget(k)
+	var levels []int
+	for i := 0; i < k; i++ {
+		if l := p.runs[i].level; len(levels) == 0 || levels[len(levels)-1] != l {
+			levels = append(levels, l)
+		}
+	}
+	if levels[len(levels)-1] != outputLevel {
+		levels = append(levels, outputLevel)
+	}
+	for _, l := range levels {
+		if anyTablesCompacting(p.vers.Levels[l].Slice()) {
+			return nil
+		}
+	}
+
+	pc := newPickedCompaction(p.opts, p.vers, levels[0], outputLevel, p.baseLevel)
+	pc.inputs = make([]compactionLevel, len(levels))
+	iters := make([]manifest.LevelIterator, len(levels))
+	for i, l := range levels {
+		pc.inputs[i] = compactionLevel{level: l, files: p.vers.Levels[l].Slice()}
+		iters[i] = pc.inputs[i].files.Iter()
+	}
+	pc.startLevel = &pc.inputs[0]
+	pc.outputLevel = &pc.inputs[len(pc.inputs)-1]
+	for i := 1; i < len(pc.inputs)-1; i++ {
+		pc.extraLevels = append(pc.extraLevels, &pc.inputs[i])
+	}
+	if pc.startLevel.level == 0 {
+		pc.startLevel.l0SublevelInfo = generateSublevelInfo(pc.cmp, pc.startLevel.files)
+	}
+	pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, iters...)
+	return pc
+}
+
+// mergeTarget adjusts the number of newest sorted runs to merge so that the
+// merged runs may be replaced by a single run, and returns it along with the
+// level the merged run should be written to.
+func (p *compactionPickerUniversal) mergeTarget(k int) (_ int, outputLevel int) {
+	n := len(p.runs)
+	// Compactions out of L0 always consume all of L0 so that the remaining L0
+	// sublevels never hold keys older than those in the output.
+	for k < n && p.runs[k-1].level == 0 && p.runs[k].level == 0 {
+		k++
+	}
+
+	// The output is written to a level that lies below every merged run, and
+	// above every run that is not merged.
+	switch last := p.runs[k-1]; {
+	case k == n:
+		return k, numLevels - 1
+	case last.level > 0:
+		return k, last.level
+	case p.runs[k].level > 1:
+		return k, p.runs[k].level - 1
+	default:
+		// The run following L0 occupies L1; there is no level in between in
+		// which to place the output, so merge it too.
+		k++
+		if k == n {
+			return k, numLevels - 1
+		}
+		return k, p.runs[k-1].level
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionPickerUniversal(t *testing.T) {
+	opts := &Options{
+		FS:                    vfs.NewMem(),
+		CompactionStyle:       CompactionStyleUniversal,
+		L0CompactionThreshold: 2,
+		UniversalCompaction: UniversalCompactionOptions{
+			MaxSortedRuns: 4,
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numKeys = 200
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	expected := make(map[string]string)
+	var snap *Snapshot
+	var snapExpected map[string]string
+	for round := 0; round < 20; round++ {
+		for i := round % 3; i < numKeys; i += 3 {
+			v := fmt.Sprintf("v%d", round)
+			require.NoError(t, d.Set(key(i), []byte(v), nil))
+			expected[string(key(i))] = v
+		}
+		if round%5 == 4 {
+			lo, hi := round*5, round*5+20
+			require.NoError(t, d.DeleteRange(key(lo), key(hi), nil))
+			for i := lo; i < hi; i++ {
+				delete(expected, string(key(i)))
+			}
+		}
+		if round == 10 {
+			snap = d.NewSnapshot()
+			snapExpected = make(map[string]string, len(expected))
+			for k, v := range expected {
+				snapExpected[k] = v
+			}
+		}
+		require.NoError(t, d.Flush())
+	}
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	p := d.mu.versions.picker.(*compactionPickerUniversal)
+	numRuns := len(p.runs)
+	d.mu.Unlock()
+	require.LessOrEqual(t, numRuns, opts.UniversalCompaction.MaxSortedRuns)
+	require.NotZero(t, d.Metrics().Compact.Count)
+
+	check := func(r Reader, expected map[string]string) {
+		t.Helper()
+		keys := make([]string, 0, len(expected))
+		for k := range expected {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		var buf strings.Builder
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "%s=%s\n", k, expected[k])
+		}
+		require.Equal(t, buf.String(), dumpDBContents(t, r, KeyRange{}))
+	}
+	check(d, expected)
+	check(snap, snapExpected)
+	require.NoError(t, snap.Close())
+
+	// A full compaction leaves a single sorted run in the bottommost level.
+	require.NoError(t, d.Compact(key(0), key(numKeys), false /* parallelize */))
+	check(d, expected)
+	m := d.Metrics()
+	for l := 0; l < numLevels-1; l++ {
+		require.Zero(t, m.Levels[l].NumFiles, "L%d", l)
+	}
+}
+
+func TestCompactionPickerUniversalPick(t *testing.T) {
+	opts := (&Options{
+		CompactionStyle:       CompactionStyleUniversal,
+		L0CompactionThreshold: 2,
+		UniversalCompaction: UniversalCompactionOptions{
+			MaxSortedRuns: 4,
+		},
+	}).EnsureDefaults()
+	p := &compactionPickerUniversal{
+		compactionPickerByScore: &compactionPickerByScore{opts: opts, baseLevel: 1},
+	}
+	l0 := func(sublevel int, size uint64) sortedRun {
+		return sortedRun{level: 0, sublevel: sublevel, size: size}
+	}
+	lvl := func(level int, size uint64) sortedRun {
+		return sortedRun{level: level, size: size}
+	}
+	testCases := []struct {
+		runs        []sortedRun
+		k           int
+		outputLevel int
+	}{
+		// Below L0CompactionThreshold.
+		{runs: []sortedRun{lvl(6, 100)}},
+		// Size amplification triggers a full compaction.
+		{runs: []sortedRun{l0(0, 150), lvl(6, 50)}, k: 2, outputLevel: 6},
+		// Similarly sized L0 sublevels are merged into the level above the next
+		// older run.
+		{runs: []sortedRun{l0(1, 10), l0(0, 10), lvl(5, 1000), lvl(6, 10000)}, k: 2, outputLevel: 4},
+		// Runs of similar size below L0 are merged into the older run's level.
+		{runs: []sortedRun{lvl(3, 10), lvl(4, 10), lvl(6, 10000)}, k: 2, outputLevel: 4},
+		// A merge including part of L0 is extended to all of L0, and to the run
+		// in L1 since no level lies between it and L0.
+		{runs: []sortedRun{l0(2, 1), l0(1, 1), l0(0, 100), lvl(1, 1000), lvl(6, 100000)}, k: 4, outputLevel: 1},
+		// Too many sorted runs of dissimilar sizes.
+		{runs: []sortedRun{lvl(2, 1), lvl(3, 10), lvl(4, 100), lvl(5, 1000), lvl(6, 10000)}, k: 2, outputLevel: 3},
+		// No compaction necessary.
+		{runs: []sortedRun{lvl(5, 10), lvl(6, 10000)}},
+	}
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			p.runs = tc.runs
+			k := p.numRunsToMerge()
+			if tc.k == 0 {
+				require.Zero(t, k)
+				return
+			}
+			k, outputLevel := p.mergeTarget(k)
+			require.Equal(t, tc.k, k)
+			require.Equal(t, tc.outputLevel, outputLevel)
+		})
+	}
+}
+
+func TestCompactionPickerUniversalRewrite(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:              vfs.NewMem(),
+		CompactionStyle: CompactionStyleUniversal,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+
+	// With a single sorted run, no sorted runs are merged, but files marked
+	// for compaction are still rewritten.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	vers := d.mu.versions.currentVersion()
+	level := -1
+	for l := range vers.Levels {
+		if vers.Levels[l].Len() > 0 {
+			level = l
+		}
+	}
+	require.Equal(t, 1, vers.Levels[level].Len())
+	iter := vers.Levels[level].Iter()
+	f := iter.First()
+	f.MarkedForCompaction = true
+	vers.Stats.MarkedForCompaction++
+	vers.Levels[level].InvalidateAnnotation(markedForCompactionAnnotator{})
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	vers = d.mu.versions.currentVersion()
+	require.Zero(t, vers.Stats.MarkedForCompaction)
+	require.Equal(t, 1, vers.Levels[level].Len())
+	iter = vers.Levels[level].Iter()
+	require.NotEqual(t, f.FileNum, iter.First().FileNum)
+}
@@ -0,0 +1,297 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"time"
+)
+
+// CompactionScheduler limits the number of automatic compactions running
+// concurrently across multiple DBs (see Options.CompactionScheduler). Every DB
+// still runs at most Options.MaxConcurrentCompactions compactions; in
+// addition, every automatic compaction must acquire one of the scheduler's
+// slots. When no slot is available, the DB waits for one, and every slot that
+// is released is granted to the waiting DB with the highest priority:
+//
+//  1. DBs whose L0 needs compacting (an L0 score of at least 1) come first,
+//     since L0 pressure leads to write stalls.
+//  2. Then, DBs running the fewest compactions, so that every DB makes
+//     progress.
+//  3. Then, DBs with the highest compaction score, and then the largest
+//     estimated compaction debt.
+//  4. Then, the DB that has waited the longest.
+//
+// Flushes, and delete-only, manual and download compactions, do not use
+// slots. The subcompactions of a compaction beyond the first (see
+// Options.Experimental.MaxSubcompactions) use the slots available when the
+// compaction starts, without waiting for them.
+type CompactionScheduler struct {
+	slots int
+
+	mu struct {
+		sync.Mutex
+		// running is the number of slots in use, including those granted to
+		// DBs that have not used them yet.
+		running int
+		members []*compactionSlots
+	}
+}
+
+// NewCompactionScheduler creates a new CompactionScheduler with the given
+// number of slots.
+func NewCompactionScheduler(slots int) *CompactionScheduler {
+	return &CompactionScheduler{slots: max(slots, 1)}
+}
+
+// Slots returns the number of slots of the scheduler.
+func (s *CompactionScheduler) Slots() int {
+	return s.slots
+}
+
+// Running returns the number of slots in use.
+func (s *CompactionScheduler) Running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.running
+}
+
+// register adds a DB to the scheduler.
+func (s *CompactionScheduler) register(d *DB) *compactionSlots {
+	if s == nil {
+		return nil
+	}
+	cs := &compactionSlots{scheduler: s, d: d}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.members = append(s.mu.members, cs)
+	return cs
+}
+
+// grantLocked grants the available slots to the waiting DBs, in priority
+// order. s.mu must be held.
+func (s *CompactionScheduler) grantLocked() {
+	for s.mu.running < s.slots {
+		var next *compactionSlots
+		for _, cs := range s.mu.members {
+			if cs.waiting && (next == nil || cs.before(next)) {
+				next = cs
+			}
+		}
+		if next == nil {
+			return
+		}
+		s.mu.running++
+		next.running++
+		next.granted++
+		next.waiting = false
+		next.waitDuration += time.Since(next.waitingSince)
+		// The DB's mutex may be held by the caller on behalf of another DB,
+		// so the DB schedules its compaction asynchronously. The DB is still
+		// registered, so DB.Close has yet to wait for the goroutine.
+		next.d.compactionSchedulers.Add(1)
+		go next.d.useCompactionSlot()
+	}
+}
+
+// compactionSlotPriority describes how urgently a DB needs a compaction slot.
+type compactionSlotPriority struct {
+	l0    bool
+	score float64
+	debt  uint64
+}
+
+// compactionSlots is the state of a single DB within a CompactionScheduler.
+// All the fields are protected by the scheduler's mutex.
+type compactionSlots struct {
+	scheduler *CompactionScheduler
+	d         *DB
+
+	// running is the number of slots used by the DB, including granted slots.
+	running int
+	// granted is the number of slots granted to the DB while it was waiting,
+	// which it has not used yet.
+	granted int
+	// waiting is set while the DB waits for a slot, since waitingSince, with
+	// the given priority.
+	waiting      bool
+	waitingSince time.Time
+	priority     compactionSlotPriority
+
+	// waitCount is the number of times the DB had to wait for a slot, and
+	// waitDuration the cumulative time it spent waiting.
+	waitCount    int64
+	waitDuration time.Duration
+}
+
+// before returns true if cs should be granted a slot before o.
+func (cs *compactionSlots) before(o *compactionSlots) bool {
+	switch {
+	case cs.priority.l0 != o.priority.l0:
+		return cs.priority.l0
+	case cs.running != o.running:
+		return cs.running < o.running
+	case cs.priority.score != o.priority.score:
+		return cs.priority.score > o.priority.score
+	case cs.priority.debt != o.priority.debt:
+		return cs.priority.debt > o.priority.debt
+	default:
+		return cs.waitingSince.Before(o.waitingSince)
+	}
+}
+
+// tryAcquire acquires a slot for an automatic compaction, returning false if
+// none is available. In that case, the DB waits for a slot with the priority
+// returned by priorityFn, and is granted one asynchronously (see
+// DB.useCompactionSlot). A nil compactionSlots always succeeds.
+func (cs *compactionSlots) tryAcquire(priorityFn func() compactionSlotPriority) bool {
+	if cs == nil {
+		return true
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cs.granted > 0 {
+		cs.granted--
+		return true
+	}
+	if s.mu.running < s.slots {
+		s.mu.running++
+		cs.running++
+		return true
+	}
+	if !cs.waiting {
+		cs.waiting = true
+		cs.waitingSince = time.Now()
+		cs.waitCount++
+	}
+	cs.priority = priorityFn()
+	return false
+}
+
+// release releases a slot acquired by tryAcquire.
+func (cs *compactionSlots) release() {
+	if cs == nil {
+		return
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.running--
+	cs.running--
+	s.grantLocked()
+}
+
+// tryAcquireExtra acquires up to n slots for the subcompactions of a
+// compaction, without waiting, and returns the number of slots acquired. A nil
+// compactionSlots acquires all of them.
+func (cs *compactionSlots) tryAcquireExtra(n int) int {
+	if cs == nil {
+		return n
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n = max(0, min(n, s.slots-s.mu.running))
+	s.mu.running += n
+	cs.running += n
+	return n
+}
+
+// releaseExtra releases n slots acquired by tryAcquireExtra.
+func (cs *compactionSlots) releaseExtra(n int) {
+	if cs == nil || n == 0 {
+		return
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.running -= n
+	cs.running -= n
+	s.grantLocked()
+}
+
+// releaseGranted releases the slots granted to the DB that it did not use.
+func (cs *compactionSlots) releaseGranted() {
+	if cs == nil {
+		return
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cs.granted == 0 {
+		return
+	}
+	s.mu.running -= cs.granted
+	cs.running -= cs.granted
+	cs.granted = 0
+	s.grantLocked()
+}
+
+// unregister removes the DB from the scheduler, once all its compactions have
+// completed.
+func (cs *compactionSlots) unregister() {
+	if cs == nil {
+		return
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.mu.members {
+		if s.mu.members[i] == cs {
+			s.mu.members = append(s.mu.members[:i], s.mu.members[i+1:]...)
+			break
+		}
+	}
+	cs.waiting = false
+	s.mu.running -= cs.granted
+	cs.running -= cs.granted
+	cs.granted = 0
+	s.grantLocked()
+}
+
+// metrics populates the CompactionScheduler metrics.
+func (cs *compactionSlots) metrics(m *Metrics) {
+	if cs == nil {
+		return
+	}
+	s := cs.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.CompactionScheduler.Slots = s.slots
+	m.CompactionScheduler.Running = s.mu.running
+	m.CompactionScheduler.WaitCount = cs.waitCount
+	m.CompactionScheduler.WaitDuration = cs.waitDuration
+	if cs.waiting {
+		m.CompactionScheduler.WaitDuration += time.Since(cs.waitingSince)
+	}
+}
+
+// compactionSlotPriorityLocked returns the priority of the DB for a
+// compaction slot.
+//
+// d.mu must be held when calling this.
+func (d *DB) compactionSlotPriorityLocked() compactionSlotPriority {
+	scores := d.mu.versions.picker.getScores(d.getInProgressCompactionInfoLocked(nil))
+	p := compactionSlotPriority{
+		l0:   scores[0] >= 1,
+		debt: d.mu.versions.picker.estimatedCompactionDebt(0),
+	}
+	for _, score := range scores {
+		p.score = max(p.score, score)
+	}
+	return p
+}
+
+// useCompactionSlot schedules compactions after the DB was granted a slot by
+// its CompactionScheduler, and releases the slot if the DB no longer needs it.
+func (d *DB) useCompactionSlot() {
+	defer d.compactionSchedulers.Done()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maybeScheduleCompaction()
+	d.compactionSlots.releaseGranted()
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionSchedulerPriority(t *testing.T) {
+	now := time.Now()
+	slots := func(running int, p compactionSlotPriority, waitingSince time.Duration) *compactionSlots {
+		return &compactionSlots{running: running, priority: p, waitingSince: now.Add(waitingSince)}
+	}
+	l0 := slots(2, compactionSlotPriority{l0: true, score: 1}, 0)
+	idle := slots(0, compactionSlotPriority{score: 1.5}, 0)
+	busy := slots(1, compactionSlotPriority{score: 10}, 0)
+	highScore := slots(0, compactionSlotPriority{score: 2}, 0)
+	highDebt := slots(0, compactionSlotPriority{score: 1.5, debt: 100}, 0)
+	older := slots(0, compactionSlotPriority{score: 1.5}, -time.Second)
+
+	// L0 pressure takes precedence over everything else.
+	require.True(t, l0.before(idle))
+	require.False(t, idle.before(l0))
+	// Then, the DB running the fewest compactions.
+	require.True(t, idle.before(busy))
+	// Then, the highest score and the largest debt.
+	require.True(t, highScore.before(idle))
+	require.True(t, highDebt.before(idle))
+	// Then, the oldest waiter.
+	require.True(t, older.before(idle))
+	require.False(t, idle.before(older))
+}
+
+func TestCompactionSchedulerExtraSlots(t *testing.T) {
+	s := NewCompactionScheduler(3)
+	cs := s.register(nil)
+	require.True(t, cs.tryAcquire(func() compactionSlotPriority { return compactionSlotPriority{} }))
+	// The extra slots are limited to the available ones.
+	require.Equal(t, 2, cs.tryAcquireExtra(4))
+	require.Equal(t, 3, s.Running())
+	require.Equal(t, 0, cs.tryAcquireExtra(1))
+	cs.releaseExtra(1)
+	require.Equal(t, 2, s.Running())
+	require.Equal(t, 1, cs.tryAcquireExtra(1))
+	cs.releaseExtra(2)
+	cs.release()
+	require.Equal(t, 0, s.Running())
+	require.Equal(t, 4, (*compactionSlots)(nil).tryAcquireExtra(4))
+}
+
+func TestCompactionScheduler(t *testing.T) {
+	s := NewCompactionScheduler(1)
+
+	// Track the number of compactions running across both DBs.
+	var mu sync.Mutex
+	var running, maxRunning int
+	el := EventListener{
+		CompactionBegin: func(info CompactionInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			running++
+			maxRunning = max(maxRunning, running)
+		},
+		CompactionEnd: func(info CompactionInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			running--
+		},
+	}
+	open := func() *DB {
+		d, err := Open("", &Options{
+			FS:                       vfs.NewMem(),
+			CompactionScheduler:      s,
+			EventListener:            &el,
+			L0CompactionThreshold:    1,
+			MaxConcurrentCompactions: func() int { return 4 },
+		})
+		require.NoError(t, err)
+		return d
+	}
+	dbs := []*DB{open(), open()}
+	for i := 0; i < 10; i++ {
+		for _, d := range dbs {
+			for j := 0; j < 10; j++ {
+				key := fmt.Sprintf("%02d-%02d", j, i)
+				require.NoError(t, d.Set([]byte(key), []byte(key), nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+	}
+	// Both DBs eventually compact their L0.
+	for _, d := range dbs {
+		require.Eventually(t, func() bool {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.mu.versions.currentVersion().Levels[0].Empty() && d.mu.compact.compactingCount == 0
+		}, 10*time.Second, time.Millisecond)
+	}
+	mu.Lock()
+	require.Equal(t, 1, maxRunning)
+	mu.Unlock()
+
+	m := dbs[0].Metrics()
+	require.Equal(t, 1, m.CompactionScheduler.Slots)
+	require.Contains(t, m.String(), "Compaction slots: ")
+	for _, d := range dbs {
+		require.NoError(t, d.Close())
+	}
+	require.Zero(t, s.Running())
+}
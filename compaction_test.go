@@ -3049,3 +3049,201 @@ func TestCompactionErrorStats(t *testing.T) {
 	d.mu.Unlock()
 	require.NoError(t, d.Close())
 }
+
+func TestCompactWithOptions(t *testing.T) {
+	var d *DB
+	var cancelOnBegin func()
+	// allCancelled returns true once every in-progress compaction has been
+	// cancelled.
+	allCancelled := func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for c := range d.mu.compact.inProgress {
+			if !c.cancelled() {
+				return false
+			}
+		}
+		return true
+	}
+	opts := &Options{
+		EventListener: &EventListener{
+			CompactionBegin: func(info CompactionInfo) {
+				if cancelOnBegin != nil {
+					cancelOnBegin()
+				}
+			},
+			TableCreated: func(info TableCreateInfo) {
+				if cancelOnBegin != nil && info.Reason == "compacting" {
+					// Hold up the compaction until the cancellation reaches it.
+					require.Eventually(t, allCancelled, 10*time.Second, time.Millisecond)
+				}
+			},
+		},
+		DisableAutomaticCompactions: true,
+	}
+	var err error
+	d, err = runDBDefineCmd(&datadriven.TestData{
+		Cmd: "define",
+		Input: strings.Join([]string{
+			"L4", "  b.SET.3:v",
+			"L5", "  a.SET.2:v", "  c.SET.2:v",
+			"L6", "  a.SET.1:v", "  d.SET.1:v",
+		}, "\n"),
+	}, opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	levelFiles := func() [numLevels]int64 {
+		var n [numLevels]int64
+		m := d.Metrics()
+		for l := range m.Levels {
+			n[l] = m.Levels[l].NumFiles
+		}
+		return n
+	}
+	require.Equal(t, [numLevels]int64{0, 0, 0, 0, 1, 1, 1}, levelFiles())
+
+	spans := []KeyRange{{Start: []byte("a"), End: []byte("e")}}
+	require.Error(t, d.CompactWithOptions(context.Background(), spans, CompactOptions{OutputLevel: numLevels}))
+
+	// A compaction whose context is cancelled while the compaction is running
+	// is aborted and returns the context's error.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelOnBegin = cancel
+	err = d.CompactWithOptions(ctx, spans, CompactOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+	cancelOnBegin = nil
+	require.Equal(t, [numLevels]int64{0, 0, 0, 0, 1, 1, 1}, levelFiles())
+
+	// Compacting into L5 leaves L6 untouched.
+	var progress []CompactProgress
+	require.NoError(t, d.CompactWithOptions(context.Background(), spans, CompactOptions{
+		OutputLevel: 5,
+		Progress: func(p CompactProgress) {
+			progress = append(progress, p)
+		},
+	}))
+	require.Equal(t, [numLevels]int64{0, 0, 0, 0, 0, 1, 1}, levelFiles())
+	for _, p := range progress {
+		require.Equal(t, 4, p.Level)
+		require.NotZero(t, p.BytesProcessed)
+	}
+	if len(progress) > 0 {
+		require.Zero(t, progress[len(progress)-1].BytesRemaining)
+	}
+
+	// Without an output level, the data is compacted into the bottommost
+	// level.
+	progress = progress[:0]
+	require.NoError(t, d.CompactWithOptions(context.Background(), spans, CompactOptions{
+		Parallelize:    true,
+		MaxConcurrency: 1,
+		Progress: func(p CompactProgress) {
+			progress = append(progress, p)
+		},
+	}))
+	require.Equal(t, [numLevels]int64{0, 0, 0, 0, 0, 0, 1}, levelFiles())
+	require.NotEmpty(t, progress)
+	require.Equal(t, 5, progress[len(progress)-1].Level)
+	require.Zero(t, progress[len(progress)-1].BytesRemaining)
+}
+
+// sstWriteCountingFS counts the bytes written to sstables.
+type sstWriteCountingFS struct {
+	vfs.FS
+	written *atomic.Int64
+}
+
+func (fs sstWriteCountingFS) Create(name string, category vfs.DiskWriteCategory) (vfs.File, error) {
+	f, err := fs.FS.Create(name, category)
+	if err != nil || !strings.HasSuffix(name, ".sst") {
+		return f, err
+	}
+	return sstWriteCountingFile{File: f, written: fs.written}, nil
+}
+
+type sstWriteCountingFile struct {
+	vfs.File
+	written *atomic.Int64
+}
+
+func (f sstWriteCountingFile) Write(p []byte) (int, error) {
+	f.written.Add(int64(len(p)))
+	return f.File.Write(p)
+}
+
+func TestCompactWithOptionsCancelRunning(t *testing.T) {
+	var written atomic.Int64
+	var d *DB
+	var cancel func()
+	opts := &Options{
+		FS:                          sstWriteCountingFS{FS: vfs.NewMem(), written: &written},
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if cancel == nil || info.Reason != "compacting" {
+					return
+				}
+				// Hold up the compaction's single output until the cancellation
+				// reaches the compaction.
+				written.Store(0)
+				cancel()
+				require.Eventually(t, func() bool {
+					d.mu.Lock()
+					defer d.mu.Unlock()
+					for c := range d.mu.compact.inProgress {
+						if !c.cancelled() {
+							return false
+						}
+					}
+					return true
+				}, 10*time.Second, time.Millisecond)
+			},
+		},
+	}
+	opts.Levels = []LevelOptions{{TargetFileSize: 1 << 30}}
+	var err error
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numKeys = 20000
+	// Random values are incompressible, so that the bytes written to the
+	// output are proportional to the number of keys processed.
+	value := make([]byte, 100)
+	rng := rand.New(rand.NewSource(1))
+	// Write the keys twice, so that the two overlapping L0 tables must be
+	// rewritten rather than moved.
+	for j := 0; j < 2; j++ {
+		for i := 0; i < numKeys; i++ {
+			rng.Read(value)
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%06d", i)), value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	// A compaction writing a single large output is aborted while iterating
+	// over its input, well before the output is complete.
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancel = cancelFn
+	spans := []KeyRange{{Start: []byte("key"), End: []byte("key999999")}}
+	err = d.CompactWithOptions(ctx, spans, CompactOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, written.Load(), int64(numKeys*len(value)/2))
+	cancel = nil
+
+	// Progress is reported while the compaction runs, and ends with the
+	// entire level processed.
+	var progress []CompactProgress
+	require.NoError(t, d.CompactWithOptions(context.Background(), spans, CompactOptions{
+		Progress:         func(p CompactProgress) { progress = append(progress, p) },
+		ProgressInterval: time.Millisecond,
+	}))
+	require.NotEmpty(t, progress)
+	last := progress[len(progress)-1]
+	require.Zero(t, last.BytesRemaining)
+	require.NotZero(t, last.BytesProcessed)
+	for i := 1; i < len(progress); i++ {
+		require.LessOrEqual(t, progress[i-1].BytesProcessed, progress[i].BytesProcessed)
+	}
+}
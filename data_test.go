@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	crand "crypto/rand"
 	"fmt"
 	"io"
@@ -393,6 +394,31 @@ func writeRangeKeys(b io.Writer, iter *Iterator) {
 	}
 }
 
+// dumpDBContents returns the point and range keys of the reader within the
+// span, or of all of the reader if the span is empty, one per line.
+func dumpDBContents(t testing.TB, r Reader, span KeyRange) string {
+	iter, err := r.NewIter(&IterOptions{
+		KeyTypes:   IterKeyTypePointsAndRanges,
+		LowerBound: span.Start,
+		UpperBound: span.End,
+	})
+	require.NoError(t, err)
+	var buf strings.Builder
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+			fmt.Fprintf(&buf, "%s=%s\n", iter.Key(), iter.Value())
+		}
+		if iter.RangeKeyChanged() {
+			start, end := iter.RangeBounds()
+			for _, rk := range iter.RangeKeys() {
+				fmt.Fprintf(&buf, "[%s,%s)@%s=%s\n", start, end, rk.Suffix, rk.Value)
+			}
+		}
+	}
+	require.NoError(t, iter.Close())
+	return buf.String()
+}
+
 func runBatchDefineCmd(d *datadriven.TestData, b *Batch) error {
 	for _, line := range strings.Split(d.Input, "\n") {
 		parts := strings.Fields(line)
@@ -701,7 +727,8 @@ func runCompactCmd(td *datadriven.TestData, d *DB) error {
 		if err != nil {
 			return err
 		}
-		return d.manualCompact(iStart.UserKey, iEnd.UserKey, level, parallelize)
+		return d.manualCompact(context.Background(), iStart.UserKey, iEnd.UserKey, level,
+			CompactOptions{Parallelize: parallelize})
 	}
 	return d.Compact([]byte(parts[0]), []byte(parts[1]), parallelize)
 }
@@ -294,15 +294,48 @@ type DB struct {
 	// objProvider is used to access and manage SSTs.
 	objProvider objstorage.Provider
 
+	// diskIO charges background disk I/O against Options.DiskIOBudget. It is
+	// nil if no budget is configured.
+	diskIO *diskIOThrottler
+
+	// writeBuffer accounts for the memtables of the DB in
+	// Options.WriteBufferManager. It is nil if no manager is configured.
+	writeBuffer *writeBufferMember
+
+	// compactionSlots acquires slots of Options.CompactionScheduler for
+	// automatic compactions. It is nil if no scheduler is configured.
+	compactionSlots *compactionSlots
+
+	// fileLock is nil for a follower, which does not lock the directory of its
+	// primary.
 	fileLock *Lock
 	dataDir  vfs.File
 
+	// follower is the state of a DB opened with OpenFollower, and nil for any
+	// other DB.
+	follower *followerState
+
 	tableCache           *tableCacheContainer
 	newIters             tableNewIters
 	tableNewRangeKeyIter keyspanimpl.TableNewSpanIter
 
 	commit *commitPipeline
 
+	// replication holds the streams through which the batches committed to the
+	// DB are shipped to replicas.
+	replication struct {
+		// mu protects streams. It is acquired after commit.mu, and after the
+		// commit pipeline's watch mutex by publishReplicated.
+		mu      sync.Mutex
+		streams []*ReplicationStream
+		// syncMu serializes the WAL syncs of syncReplicationWAL with each other
+		// and with DB.Close.
+		syncMu sync.Mutex
+		// durableSeqNum is the sequence number below which the batches are
+		// known to be durable in the WAL.
+		durableSeqNum atomic.Uint64
+	}
+
 	// readState provides access to the state needed for reading without needing
 	// to acquire DB.mu.
 	readState struct {
@@ -316,7 +349,9 @@ type DB struct {
 	cleanupManager *cleanupManager
 
 	// During an iterator close, we may asynchronously schedule read compactions.
-	// We want to wait for those goroutines to finish, before closing the DB.
+	// Compactions are also scheduled asynchronously when a CompactionScheduler
+	// grants the DB a slot. We want to wait for those goroutines to finish,
+	// before closing the DB.
 	// compactionShedulers.Wait() should not be called while the DB.mu is held.
 	compactionSchedulers sync.WaitGroup
 
@@ -414,6 +449,10 @@ type DB struct {
 			flushing bool
 			// The number of ongoing non-download compactions.
 			compactingCount int
+			// The number of subcompactions, beyond the first of each
+			// compaction, that running compactions were granted. They count
+			// against MaxConcurrentCompactions along with compactingCount.
+			subcompactingCount int
 			// The number of download compactions.
 			downloadingCount int
 			// The list of deletion hints, suggesting ranges for delete-only
@@ -463,6 +502,10 @@ type DB struct {
 			// sstables.
 			cumulativePinnedCount uint64
 			cumulativePinnedSize  uint64
+
+			// The named snapshots, indexed by name. Each is also linked into the
+			// list of active snapshots.
+			named map[string]*namedSnapshot
 		}
 
 		tableStats struct {
@@ -483,6 +526,23 @@ type DB struct {
 			pending []manifest.NewFileEntry
 		}
 
+		// tiering holds the state of the tiering policy. See
+		// Options.Experimental.Tiering.
+		tiering struct {
+			// pending is the list of migrations chosen by the last pass of the
+			// tiering policy that have not been scheduled yet.
+			pending []tieringMigration
+			// migrating is the number of in-progress migrations.
+			migrating int
+		}
+
+		// history holds the mapping from wall time to sequence numbers used by
+		// history retention. See Options.Experimental.HistoryRetention.
+		history struct {
+			// samples are ordered by time and sequence number.
+			samples []historySample
+		}
+
 		tableValidation struct {
 			// cond is a condition variable used to signal the completion of a
 			// job to validate one or more sstables.
@@ -496,6 +556,24 @@ type DB struct {
 		}
 	}
 
+	// hotKeys samples reads and writes to track frequently accessed keys. It is
+	// nil unless enabled by Options.Experimental.HotKeys.
+	hotKeys *hotKeyTracker
+
+	// writeAdmission throttles writes while the LSM is overloaded. It is nil
+	// unless enabled by Options.Experimental.WriteAdmission.
+	writeAdmission *writeAdmissionController
+
+	// watches holds the watches created by Watch.
+	watches watchRegistry
+
+	// walTimestamps synchronizes the timestamps recorded in the WAL for
+	// Options.WALArchive with Close (see runWALTimestampLoop).
+	walTimestamps struct {
+		sync.Mutex
+		closed bool
+	}
+
 	// Normally equal to time.Now() but may be overridden in tests.
 	timeNow func() time.Time
 	// the time at database Open; may be used to compute metrics like effective
@@ -539,6 +617,10 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		panic(err)
 	}
 
+	if d.hotKeys != nil {
+		d.hotKeys.sampleRead(key)
+	}
+
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
 	// compaction. The readState is unref'd by Iterator.Close().
@@ -838,6 +920,7 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 			return errNoSplit
 		}
 	}
+	d.writeAdmission.admit(opts.GetPriority(), opts.GetTenant(), int64(len(batch.data)))
 	batch.committing = true
 
 	if batch.db == nil {
@@ -857,6 +940,16 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 		// horked at this point.
 		d.opts.Logger.Fatalf("pebble: fatal commit error: %v", err)
 	}
+	if batch.replicatedErr != nil {
+		// The batch was rejected by the commit pipeline before it was committed
+		// (see ApplyReplicated), so it is not charged to write admission.
+		d.writeAdmission.refund(int64(len(batch.data)))
+		batch.committing = false
+		return nil
+	}
+	if d.hotKeys != nil {
+		d.hotKeys.sampleBatch(batch)
+	}
 	// If this is a large batch, we need to clear the batch contents as the
 	// flushable batch may still be present in the flushables queue.
 	//
@@ -906,6 +999,13 @@ func (d *DB) commitApply(b *Batch, mem *memTable) error {
 	return nil
 }
 
+// published is invoked by the commit pipeline with each published batch, in
+// sequence number order, while watches or replication streams are open.
+func (d *DB) published(b *Batch) {
+	d.watches.published(b)
+	d.publishReplicated(b)
+}
+
 func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
 	var size int64
 	repr := b.Repr()
@@ -1089,6 +1189,7 @@ func (d *DB) newIter(
 		newIterRangeKey:     newIterRangeKey,
 		seqNum:              seqNum,
 		batchOnlyIter:       internalOpts.batch.batchOnly,
+		hotKeys:             d.hotKeys,
 	}
 	if o != nil {
 		dbi.opts = *o
@@ -1601,6 +1702,27 @@ func (d *DB) Close() error {
 	// (illegal) concurrent writes will observe d.closed.Load() != nil, creating
 	// more understable panics if the database is improperly used concurrently
 	// during Close.
+	//
+	// The timestamps recorded in the WAL are written through the commit
+	// pipeline, so they are stopped first.
+	d.walTimestamps.Lock()
+	d.walTimestamps.closed = true
+	d.walTimestamps.Unlock()
+	// Hot key reports are delivered to the event listener asynchronously, so
+	// those in flight are waited for.
+	if d.hotKeys != nil {
+		d.hotKeys.waitReports()
+	}
+	// A follower reads the primary's files and writes its lease without
+	// holding d.mu, so an in-progress catch up is waited for.
+	if d.follower != nil {
+		d.follower.catchUpMu.Lock()
+		defer d.follower.catchUpMu.Unlock()
+	}
+	// Replication streams sync the WAL without holding commit.mu, so an
+	// in-progress sync is waited for.
+	d.replication.syncMu.Lock()
+	defer d.replication.syncMu.Unlock()
 	d.commit.mu.Lock()
 	defer d.commit.mu.Unlock()
 	d.mu.Lock()
@@ -1618,6 +1740,8 @@ func (d *DB) Close() error {
 
 	d.closed.Store(errors.WithStack(ErrClosed))
 	close(d.closedCh)
+	d.writeAdmission.release()
+	d.failReplicationStreams(ErrClosed)
 
 	defer d.opts.Cache.Unref()
 
@@ -1630,6 +1754,7 @@ func (d *DB) Close() error {
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	d.releaseNamedSnapshotsLocked()
 
 	var err error
 	if n := len(d.mu.compact.inProgress); n > 0 {
@@ -1646,7 +1771,12 @@ func (d *DB) Close() error {
 		panic("pebble: log-writer should be nil in read-only mode")
 	}
 	err = firstError(err, d.mu.log.manager.Close())
-	err = firstError(err, d.fileLock.Close())
+	if d.fileLock != nil {
+		err = firstError(err, d.fileLock.Close())
+	}
+	if d.follower != nil {
+		err = firstError(err, d.follower.removeLease(d.opts.FS))
+	}
 
 	// Note that versionSet.close() only closes the MANIFEST. The versions list
 	// is still valid for the checks below.
@@ -1684,6 +1814,8 @@ func (d *DB) Close() error {
 	if reserved := d.memTableReserved.Load(); reserved != 0 {
 		err = firstError(err, errors.Errorf("leaked memtable reservation: %d", errors.Safe(reserved)))
 	}
+	d.writeBuffer.unregister()
+	d.compactionSlots.unregister()
 
 	// Since we called d.readState.val.unrefLocked() above, we are expected to
 	// manually schedule deletion of obsolete files.
@@ -1729,19 +1861,86 @@ func (d *DB) Close() error {
 	return err
 }
 
+// CompactOptions configures a manual compaction performed by
+// DB.CompactWithOptions.
+type CompactOptions struct {
+	// Parallelize, if true, splits the compaction of each level into pieces
+	// with non-overlapping key ranges that may run concurrently.
+	Parallelize bool
+	// OutputLevel, if non-zero, is the deepest level into which data within the
+	// compacted spans is moved; levels at or below OutputLevel are not
+	// compacted. If zero, data is compacted down to the bottommost level that
+	// contains data overlapping the spans. Note that data in L0 is always
+	// compacted into Lbase, which may lie below OutputLevel.
+	OutputLevel int
+	// MaxConcurrency, if positive, bounds the number of compactions started by
+	// this call that may run concurrently. These compactions remain subject to
+	// Options.MaxConcurrentCompactions as well. MaxConcurrency has no effect
+	// unless Parallelize is true.
+	MaxConcurrency int
+	// Progress, if non-nil, is invoked every ProgressInterval while the
+	// compactions started by this call are running, and whenever one of them
+	// completes. It is invoked synchronously from the goroutine calling
+	// CompactWithOptions.
+	Progress func(CompactProgress)
+	// ProgressInterval is the interval at which Progress is invoked while
+	// compactions are running. If zero, it defaults to one second.
+	ProgressInterval time.Duration
+}
+
+// CompactProgress describes the progress of a manual compaction within a
+// single level.
+type CompactProgress struct {
+	// Level is the level being compacted.
+	Level int
+	// BytesProcessed is an estimate of the number of bytes of sstables in Level
+	// that have been compacted so far. The sstables being compacted are
+	// counted once all their keys have been processed.
+	BytesProcessed uint64
+	// BytesRemaining is an estimate of the number of bytes of sstables in Level
+	// overlapping the compacted spans that remain to be compacted.
+	BytesRemaining uint64
+}
+
 // Compact the specified range of keys in the database.
 func (d *DB) Compact(start, end []byte, parallelize bool) error {
+	return d.CompactWithOptions(context.Background(),
+		[]KeyRange{{Start: start, End: end}}, CompactOptions{Parallelize: parallelize})
+}
+
+// CompactWithOptions compacts the specified spans of keys in the database.
+// Each span is compacted as by Compact(span.Start, span.End, ...).
+//
+// If ctx is cancelled, compactions started by this call that have not yet
+// been scheduled are abandoned and those that are running are cancelled.
+// CompactWithOptions waits for the running compactions to wind down before
+// returning ctx.Err(). Work completed before the cancellation is retained.
+func (d *DB) CompactWithOptions(ctx context.Context, spans []KeyRange, opts CompactOptions) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
 	if d.opts.ReadOnly {
 		return ErrReadOnly
 	}
-	if d.cmp(start, end) >= 0 {
-		return errors.Errorf("Compact start %s is not less than end %s",
-			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	for _, span := range spans {
+		if d.cmp(span.Start, span.End) >= 0 {
+			return errors.Errorf("Compact start %s is not less than end %s",
+				d.opts.Comparer.FormatKey(span.Start), d.opts.Comparer.FormatKey(span.End))
+		}
+	}
+	if opts.OutputLevel < 0 || opts.OutputLevel >= numLevels {
+		return errors.Errorf("pebble: invalid compaction output level %d", opts.OutputLevel)
+	}
+	for _, span := range spans {
+		if err := d.compactSpan(ctx, span.Start, span.End, opts); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// compactSpan performs a manual compaction of [start, end].
+func (d *DB) compactSpan(ctx context.Context, start, end []byte, opts CompactOptions) error {
 	d.mu.Lock()
 	maxLevelWithFiles := 1
 	cur := d.mu.versions.currentVersion()
@@ -1751,6 +1950,9 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 			maxLevelWithFiles = level + 1
 		}
 	}
+	if opts.OutputLevel > 0 && opts.OutputLevel < maxLevelWithFiles {
+		maxLevelWithFiles = opts.OutputLevel
+	}
 
 	// Determine if any memtable overlaps with the compaction range. We wait for
 	// any such overlap to flush (initiating a flush if necessary).
@@ -1796,16 +1998,22 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		return err
 	}
 	if mem != nil {
-		<-mem.flushed
+		select {
+		case <-mem.flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	for level := 0; level < maxLevelWithFiles; {
 		for {
-			if err := d.manualCompact(
-				start, end, level, parallelize); err != nil {
-				if errors.Is(err, ErrCancelledCompaction) {
+			if err := d.manualCompact(ctx, start, end, level, opts); err != nil {
+				if errors.Is(err, ErrCancelledCompaction) && ctx.Err() == nil {
 					continue
 				}
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
 				return err
 			}
 			break
@@ -1820,7 +2028,9 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 	return nil
 }
 
-func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error {
+func (d *DB) manualCompact(
+	ctx context.Context, start, end []byte, level int, opts CompactOptions,
+) error {
 	d.mu.Lock()
 	curr := d.mu.versions.currentVersion()
 	files := curr.Overlaps(level, base.UserKeyBoundsInclusive(start, end))
@@ -1828,9 +2038,10 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 		d.mu.Unlock()
 		return nil
 	}
+	totalBytes := files.SizeSum()
 
 	var compactions []*manualCompaction
-	if parallelize {
+	if opts.Parallelize {
 		compactions = append(compactions, d.splitManualCompaction(start, end, level)...)
 	} else {
 		compactions = append(compactions, &manualCompaction{
@@ -1840,7 +2051,14 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 			end:   end,
 		})
 	}
-	d.mu.compact.manual = append(d.mu.compact.manual, compactions...)
+	for _, m := range compactions {
+		m.maxOutputLevel = opts.OutputLevel
+	}
+	maxConcurrency := len(compactions)
+	if opts.MaxConcurrency > 0 && opts.MaxConcurrency < maxConcurrency {
+		maxConcurrency = opts.MaxConcurrency
+	}
+	d.mu.compact.manual = append(d.mu.compact.manual, compactions[:maxConcurrency]...)
 	d.maybeScheduleCompaction()
 	d.mu.Unlock()
 
@@ -1851,14 +2069,107 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 	// a value to the done channel. Since the channels are buffered, it is not
 	// necessary to read from each channel, and so we can exit early in the event
 	// of an error.
-	for _, compaction := range compactions {
-		if err := <-compaction.done; err != nil {
+	var tickerC <-chan time.Time
+	if opts.Progress != nil {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tickerC = ticker.C
+	}
+	// reportProgress invokes opts.Progress with the bytes processed by the
+	// compactions that completed, plus those processed so far by the running
+	// compactions.
+	var completed uint64
+	reportProgress := func(running []*manualCompaction) {
+		processed := completed
+		d.mu.Lock()
+		for _, m := range running {
+			if m.compaction != nil {
+				processed += min(m.inputBytes, m.compaction.startLevelBytesProcessed.Load())
+			}
+		}
+		d.mu.Unlock()
+		var remaining uint64
+		if processed < totalBytes {
+			remaining = totalBytes - processed
+		}
+		if len(running) == 0 {
+			remaining = 0
+		}
+		opts.Progress(CompactProgress{
+			Level:          level,
+			BytesProcessed: processed,
+			BytesRemaining: remaining,
+		})
+	}
+	for i, compaction := range compactions {
+		running := compactions[i:min(len(compactions), i+maxConcurrency)]
+		var err error
+	wait:
+		for {
+			select {
+			case err = <-compaction.done:
+				break wait
+			case <-tickerC:
+				reportProgress(running)
+			case <-ctx.Done():
+				d.cancelManualCompactions(running)
+				return ctx.Err()
+			}
+		}
+		if err != nil {
 			return err
 		}
+		if next := i + maxConcurrency; next < len(compactions) {
+			d.mu.Lock()
+			d.mu.compact.manual = append(d.mu.compact.manual, compactions[next])
+			d.maybeScheduleCompaction()
+			d.mu.Unlock()
+		}
+		if opts.Progress != nil {
+			completed += compaction.inputBytes
+			reportProgress(compactions[i+1 : min(len(compactions), i+1+maxConcurrency)])
+		}
 	}
 	return nil
 }
 
+// cancelManualCompactions abandons the provided manual compactions. Those that
+// are still queued are removed from the queue, and those that are running are
+// cancelled. cancelManualCompactions waits for the running compactions to
+// complete.
+func (d *DB) cancelManualCompactions(compactions []*manualCompaction) {
+	d.mu.Lock()
+	var running []*manualCompaction
+	for _, m := range compactions {
+		queued := false
+		for i := range d.mu.compact.manual {
+			if d.mu.compact.manual[i] == m {
+				d.mu.compact.manual = append(d.mu.compact.manual[:i:i], d.mu.compact.manual[i+1:]...)
+				queued = true
+				break
+			}
+		}
+		if queued {
+			continue
+		}
+		if m.compaction != nil {
+			// The manifest lock is not held, so the compaction's cancel field
+			// cannot be written.
+			m.compaction.userCancel.Store(true)
+		}
+		running = append(running, m)
+	}
+	d.mu.Unlock()
+
+	for _, m := range running {
+		<-m.done
+	}
+}
+
 // splitManualCompaction splits a manual compaction over [start,end] on level
 // such that the resulting compactions have no key overlap.
 func (d *DB) splitManualCompaction(
@@ -1946,6 +2257,7 @@ func (d *DB) Metrics() *Metrics {
 	}
 	metrics.Snapshots.PinnedKeys = d.mu.snapshots.cumulativePinnedCount
 	metrics.Snapshots.PinnedSize = d.mu.snapshots.cumulativePinnedSize
+	metrics.Snapshots.Named = d.namedSnapshotsLocked()
 	metrics.MemTable.Count = int64(len(d.mu.mem.queue))
 	metrics.MemTable.ZombieCount = d.memTableCount.Load() - metrics.MemTable.Count
 	metrics.MemTable.ZombieSize = uint64(d.memTableReserved.Load()) - metrics.MemTable.Size
@@ -2015,6 +2327,10 @@ func (d *DB) Metrics() *Metrics {
 	metrics.SecondaryCacheMetrics = d.objProvider.Metrics()
 
 	metrics.Uptime = d.timeNow().Sub(d.openedAt)
+	d.diskIO.metrics(metrics)
+	d.writeAdmission.metrics(metrics)
+	d.compactionSlots.metrics(metrics)
+	d.hotKeys.metrics(metrics)
 
 	return metrics
 }
@@ -2311,6 +2627,21 @@ func (d *DB) EstimateDiskUsageByBackingType(
 	return totalSize, remoteSize, externalSize, nil
 }
 
+// walCompression returns the algorithm used to compress WAL records.
+func (d *DB) walCompression() record.Compression {
+	if d.FormatMajorVersion() < FormatWALCompression {
+		return record.NoCompression
+	}
+	switch d.opts.WALCompression {
+	case SnappyCompression:
+		return record.SnappyCompression
+	case ZstdCompression:
+		return record.ZstdCompression
+	default:
+		return record.NoCompression
+	}
+}
+
 func (d *DB) walPreallocateSize() int {
 	// Set the WAL preallocate size to 110% of the memtable size. Note that there
 	// is a bit of apples and oranges in units here as the memtabls size
@@ -2360,17 +2691,21 @@ func (d *DB) newMemTable(logNum base.DiskFileNum, logSeqNum uint64) (*memTable,
 	} else {
 		mem = new(memTable)
 		memtblOpts.arenaBuf = manual.New(int(size))
-		memtblOpts.releaseAccountingReservation = d.opts.Cache.Reserve(int(size))
+		memtblOpts.releaseAccountingReservation = d.memTableCache().Reserve(int(size))
 		d.memTableCount.Add(1)
 		d.memTableReserved.Add(int64(size))
+		d.writeBuffer.allocated(int64(size))
 
 		// Note: this is a no-op if invariants are disabled or race is enabled.
 		invariants.SetFinalizer(mem, checkMemTable)
 	}
 	mem.init(memtblOpts)
+	d.writeBuffer.setMutable(mem)
 
 	entry := d.newFlushableEntry(mem, logNum, logSeqNum)
 	entry.releaseMemAccounting = func() {
+		d.writeBuffer.releasedMemTable(mem)
+
 		// If the user leaks iterators, we may be releasing the memtable after
 		// the DB is already closed. In this case, we want to just release the
 		// memory because DB.Close won't come along to free it for us.
@@ -2379,6 +2714,13 @@ func (d *DB) newMemTable(logNum base.DiskFileNum, logSeqNum uint64) (*memTable,
 			return
 		}
 
+		// While the WriteBufferManager is short of memory, release the memory
+		// of the memtable immediately.
+		if !d.writeBuffer.recycleAllowed() {
+			d.freeMemTable(mem)
+			return
+		}
+
 		// The next memtable allocation might be able to reuse this memtable.
 		// Stash it on d.memTableRecycle.
 		if unusedMem := d.memTableRecycle.Swap(mem); unusedMem != nil {
@@ -2393,6 +2735,7 @@ func (d *DB) newMemTable(logNum base.DiskFileNum, logSeqNum uint64) (*memTable,
 func (d *DB) freeMemTable(m *memTable) {
 	d.memTableCount.Add(-1)
 	d.memTableReserved.Add(-int64(len(m.arenaBuf)))
+	d.writeBuffer.freed(int64(len(m.arenaBuf)))
 	m.free()
 }
 
@@ -2407,6 +2750,11 @@ func (d *DB) newFlushableEntry(
 		deleteFn:       d.mu.versions.addObsolete,
 		deleteFnLocked: d.mu.versions.addObsoleteLocked,
 	}
+	if d.follower != nil {
+		// The tables of the flushables of a follower belong to the primary,
+		// which is responsible for deleting them.
+		fe.deleteFn, fe.deleteFnLocked = ignoreObsoleteBackings, ignoreObsoleteBackings
+	}
 	fe.readerRefs.Store(1)
 	return fe
 }
@@ -2475,6 +2823,25 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 				continue
 			}
 		}
+		if b != nil && len(d.mu.mem.queue) > 1 && d.writeBuffer.shouldStall() &&
+			!d.mu.log.manager.ElevateWriteStallThresholdForFailover() {
+			// The WriteBufferManager's budget is exhausted, so we wait for the
+			// immutable memtables to be flushed, regardless of their size.
+			if !stalled {
+				stalled = true
+				d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
+					Reason: "write buffer manager limit reached",
+				})
+			}
+			for _, mem := range d.mu.mem.queue[:len(d.mu.mem.queue)-1] {
+				mem.flushForced = true
+			}
+			d.maybeScheduleFlush()
+			now := time.Now()
+			d.mu.compact.cond.Wait()
+			b.commitStats.MemTableWriteStallDuration += time.Since(now)
+			continue
+		}
 		l0ReadAmp := d.mu.versions.currentVersion().L0Sublevels.ReadAmplification()
 		if l0ReadAmp >= d.opts.L0StopWritesThreshold {
 			// There are too many level-0 files, so we wait.
@@ -2530,7 +2897,15 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			entry := d.newFlushableEntry(b.flushable, imm.logNum, b.SeqNum())
 			// The large batch is by definition large. Reserve space from the cache
 			// for it until it is flushed.
-			entry.releaseMemAccounting = d.opts.Cache.Reserve(int(b.flushable.totalBytes()))
+			size := int64(b.flushable.totalBytes())
+			release := d.memTableCache().Reserve(int(size))
+			d.writeBuffer.allocated(size)
+			d.writeBuffer.queuedBatch(size)
+			entry.releaseMemAccounting = func() {
+				release()
+				d.writeBuffer.releasedBatch(size)
+				d.writeBuffer.freed(size)
+			}
 			d.mu.mem.queue = append(d.mu.mem.queue, entry)
 		}
 
@@ -0,0 +1,500 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/tokenbucket"
+)
+
+// DiskIOBudgetOptions configures a DiskIOBudget.
+type DiskIOBudgetOptions struct {
+	// ReadBytesPerSec is the rate at which background work (flushes,
+	// compactions, downloads and ingestion copies) may read from disk. Zero
+	// disables read throttling.
+	ReadBytesPerSec int64
+	// WriteBytesPerSec is the rate at which background work may write to disk.
+	// Zero disables write throttling.
+	WriteBytesPerSec int64
+
+	// AutoTune enables automatic adjustment of the budget. When enabled, the
+	// budget is halved every time a DiskSlow event is observed (but never
+	// reduced below 1/8th of the configured rate), and is increased again by
+	// 5% of the device throughput every second during which the disk is
+	// healthy, up to the device throughput.
+	AutoTune bool
+	// DeviceReadBytesPerSec and DeviceWriteBytesPerSec are the throughput of
+	// the underlying device. They bound how far AutoTune may raise the budget
+	// above the configured rates. If zero, the configured rate is used as the
+	// upper bound.
+	DeviceReadBytesPerSec  int64
+	DeviceWriteBytesPerSec int64
+}
+
+// DiskIOBudget is a bandwidth budget for disk I/O performed by background
+// work: flushes, compactions, downloads and ingestion copies all draw from
+// the same budget. Work waiting for budget is served in priority order, so
+// that flushes and compactions out of L0 (which unblock foreground writes)
+// preempt compactions into lower levels, which in turn preempt downloads.
+//
+// A DiskIOBudget may be shared by multiple DBs that use the same device.
+type DiskIOBudget struct {
+	read  *diskIOLimiter
+	write *diskIOLimiter
+}
+
+// NewDiskIOBudget creates a new DiskIOBudget.
+func NewDiskIOBudget(opts DiskIOBudgetOptions) *DiskIOBudget {
+	return newDiskIOBudgetWithNowFn(opts, time.Now)
+}
+
+func newDiskIOBudgetWithNowFn(opts DiskIOBudgetOptions, nowFn func() time.Time) *DiskIOBudget {
+	b := &DiskIOBudget{}
+	if opts.ReadBytesPerSec > 0 {
+		b.read = newDiskIOLimiter(opts.ReadBytesPerSec, opts.DeviceReadBytesPerSec, opts.AutoTune, nowFn)
+	}
+	if opts.WriteBytesPerSec > 0 {
+		b.write = newDiskIOLimiter(opts.WriteBytesPerSec, opts.DeviceWriteBytesPerSec, opts.AutoTune, nowFn)
+	}
+	return b
+}
+
+// Rates returns the current read and write rates of the budget, in bytes per
+// second. A zero value indicates that the corresponding direction is not
+// throttled.
+func (b *DiskIOBudget) Rates() (readBytesPerSec, writeBytesPerSec int64) {
+	return b.read.currentRate(), b.write.currentRate()
+}
+
+// ReportDiskSlow informs the budget that the disk was observed to be slow. If
+// AutoTune is enabled, the budget is reduced. DBs configured with a
+// DiskIOBudget call this automatically from the DiskSlow event; it is exported
+// for users that monitor disk health through other means.
+func (b *DiskIOBudget) ReportDiskSlow(info DiskSlowInfo) {
+	b.read.reportDiskSlow()
+	b.write.reportDiskSlow()
+}
+
+// diskIOPriority orders work that is waiting for disk I/O budget. Lower values
+// are served first.
+type diskIOPriority int8
+
+const (
+	diskIOPriorityFlush diskIOPriority = iota
+	diskIOPriorityL0Compaction
+	diskIOPriorityCompaction
+	diskIOPriorityDownload
+)
+
+// diskIOCategory identifies the kind of work that consumed disk I/O budget,
+// for the purpose of metrics.
+type diskIOCategory int8
+
+const (
+	diskIOCategoryFlush diskIOCategory = iota
+	diskIOCategoryCompaction
+	diskIOCategoryDownload
+	diskIOCategoryIngest
+	numDiskIOCategories
+)
+
+// diskIOClass is the category and priority of an operation consuming disk I/O
+// budget.
+type diskIOClass struct {
+	category diskIOCategory
+	priority diskIOPriority
+}
+
+var (
+	diskIOClassFlush  = diskIOClass{category: diskIOCategoryFlush, priority: diskIOPriorityFlush}
+	diskIOClassIngest = diskIOClass{category: diskIOCategoryIngest, priority: diskIOPriorityCompaction}
+)
+
+// diskIOClass returns the class of the disk I/O performed by the compaction.
+func (c *compaction) diskIOClass() diskIOClass {
+	switch {
+	case c.kind == compactionKindFlush:
+		return diskIOClassFlush
+	case c.isDownload:
+		return diskIOClass{category: diskIOCategoryDownload, priority: diskIOPriorityDownload}
+	case c.startLevel.level == 0:
+		return diskIOClass{category: diskIOCategoryCompaction, priority: diskIOPriorityL0Compaction}
+	default:
+		return diskIOClass{category: diskIOCategoryCompaction, priority: diskIOPriorityCompaction}
+	}
+}
+
+// diskIOThrottler charges the disk I/O of a single DB against a (possibly
+// shared) DiskIOBudget and records the time the DB spent waiting for budget.
+type diskIOThrottler struct {
+	budget *DiskIOBudget
+	// closed is closed when the DB is closed, interrupting waits for budget.
+	closed <-chan struct{}
+	// throttled holds the cumulative duration spent waiting for budget, in
+	// nanoseconds, indexed by diskIOCategory.
+	throttled [numDiskIOCategories]atomic.Int64
+}
+
+func newDiskIOThrottler(budget *DiskIOBudget, closed <-chan struct{}) *diskIOThrottler {
+	if budget == nil {
+		return nil
+	}
+	return &diskIOThrottler{budget: budget, closed: closed}
+}
+
+// waitRead blocks until n bytes of read budget are available for the given
+// class. The wait is interrupted when the DB is closed, returning ErrClosed,
+// or when cancelled (if non-nil) returns true, returning
+// ErrCancelledCompaction. A nil throttler never blocks.
+func (t *diskIOThrottler) waitRead(class diskIOClass, n int64, cancelled func() bool) error {
+	if t == nil {
+		return nil
+	}
+	return t.wait(t.budget.read, class, n, cancelled)
+}
+
+// waitWrite blocks until n bytes of write budget are available for the given
+// class. It is interrupted in the same way as waitRead. A nil throttler never
+// blocks.
+func (t *diskIOThrottler) waitWrite(class diskIOClass, n int64, cancelled func() bool) error {
+	if t == nil {
+		return nil
+	}
+	return t.wait(t.budget.write, class, n, cancelled)
+}
+
+func (t *diskIOThrottler) wait(
+	l *diskIOLimiter, class diskIOClass, n int64, cancelled func() bool,
+) error {
+	d, ok := l.wait(class.priority, n, t.closed, cancelled)
+	if d > 0 {
+		t.throttled[class.category].Add(int64(d))
+	}
+	if !ok {
+		if cancelled != nil && cancelled() {
+			return ErrCancelledCompaction
+		}
+		return ErrClosed
+	}
+	return nil
+}
+
+// writable wraps w so that every write is charged against the write budget.
+// Writes waiting for budget are interrupted as described in waitWrite.
+func (t *diskIOThrottler) writable(
+	w objstorage.Writable, class diskIOClass, cancelled func() bool,
+) objstorage.Writable {
+	if t == nil || t.budget.write == nil {
+		return w
+	}
+	return &throttledWritable{Writable: w, throttler: t, class: class, cancelled: cancelled}
+}
+
+// newIters wraps newIters so that the size of every table opened for iteration
+// is charged against the read budget. Compactions read their input tables in
+// their entirety, so charging the table size when the table is first opened
+// is a reasonable approximation of the rate at which bytes are read. A table
+// is charged once, regardless of how many of its point, range deletion and
+// range key iterators are opened. Waits for budget are interrupted as
+// described in waitRead.
+func (t *diskIOThrottler) newIters(
+	newIters tableNewIters, class diskIOClass, cancelled func() bool,
+) tableNewIters {
+	if t == nil || t.budget.read == nil {
+		return newIters
+	}
+	var charged struct {
+		sync.Mutex
+		files map[FileNum]struct{}
+	}
+	charged.files = make(map[FileNum]struct{})
+	return func(
+		ctx context.Context,
+		file *manifest.FileMetadata,
+		opts *IterOptions,
+		internalOpts internalIterOpts,
+		kinds iterKinds,
+	) (iterSet, error) {
+		charged.Lock()
+		_, ok := charged.files[file.FileNum]
+		charged.files[file.FileNum] = struct{}{}
+		charged.Unlock()
+		if !ok {
+			if err := t.waitRead(class, int64(file.Size), cancelled); err != nil {
+				return iterSet{}, err
+			}
+		}
+		return newIters(ctx, file, opts, internalOpts, kinds)
+	}
+}
+
+// metrics populates the DiskIO metrics.
+func (t *diskIOThrottler) metrics(m *Metrics) {
+	if t == nil {
+		return
+	}
+	r, w := t.budget.Rates()
+	m.DiskIO.ReadBytesPerSec = uint64(r)
+	m.DiskIO.WriteBytesPerSec = uint64(w)
+	m.DiskIO.ThrottledDuration.Flush = time.Duration(t.throttled[diskIOCategoryFlush].Load())
+	m.DiskIO.ThrottledDuration.Compaction = time.Duration(t.throttled[diskIOCategoryCompaction].Load())
+	m.DiskIO.ThrottledDuration.Download = time.Duration(t.throttled[diskIOCategoryDownload].Load())
+	m.DiskIO.ThrottledDuration.Ingest = time.Duration(t.throttled[diskIOCategoryIngest].Load())
+}
+
+// throttledWritable is an objstorage.Writable that charges every write
+// against the write budget of a DiskIOBudget before performing it.
+type throttledWritable struct {
+	objstorage.Writable
+
+	throttler *diskIOThrottler
+	class     diskIOClass
+	cancelled func() bool
+}
+
+// Write is part of the objstorage.Writable interface.
+func (w *throttledWritable) Write(p []byte) error {
+	if err := w.throttler.waitWrite(w.class, int64(len(p)), w.cancelled); err != nil {
+		return err
+	}
+	return w.Writable.Write(p)
+}
+
+const (
+	// diskIOAutoTuneInterval is the minimum interval between two consecutive
+	// increases (or decreases) of an auto-tuned rate.
+	diskIOAutoTuneInterval = time.Second
+	// diskIOAutoTuneMinFraction is the fraction of the configured rate below
+	// which an auto-tuned rate is never reduced.
+	diskIOAutoTuneMinFraction = 1.0 / 8
+	// diskIOAutoTuneIncreaseFraction is the fraction of the maximum rate by
+	// which an auto-tuned rate is increased every diskIOAutoTuneInterval.
+	diskIOAutoTuneIncreaseFraction = 0.05
+	// diskIOBurstDuration is the duration's worth of budget that may be
+	// consumed in a single burst.
+	diskIOBurstDuration = 100 * time.Millisecond
+	// diskIOMinBurst is the minimum burst size, in bytes.
+	diskIOMinBurst = 1 << 20 // 1 MB
+	// diskIOCancelPollInterval is the interval at which a waiter polls its
+	// cancellation function.
+	diskIOCancelPollInterval = 100 * time.Millisecond
+)
+
+// diskIOLimiter is a token bucket whose waiters are served in priority order.
+// Only the highest priority (and among those, the oldest) waiter attempts to
+// acquire tokens; the remaining waiters are blocked until they reach the head
+// of the queue. A higher priority waiter that arrives while a lower priority
+// waiter is sleeping takes over the head of the queue.
+type diskIOLimiter struct {
+	autoTune bool
+	minRate  float64
+	maxRate  float64
+	nowFn    func() time.Time
+
+	mu struct {
+		sync.Mutex
+		tb   tokenbucket.TokenBucket
+		rate float64
+		// waiters is sorted by (priority, seq).
+		waiters []*diskIOWaiter
+		nextSeq uint64
+		// lastSlow is the time of the last reported DiskSlow event.
+		// lastIncrease and lastDecrease are the times of the last auto-tuning
+		// adjustments in each direction.
+		lastSlow     time.Time
+		lastIncrease time.Time
+		lastDecrease time.Time
+	}
+}
+
+type diskIOWaiter struct {
+	priority diskIOPriority
+	seq      uint64
+	// signal is notified when the waiter may have reached the head of the
+	// queue. It has a buffer of one, and signals are never blocking.
+	signal chan struct{}
+}
+
+func newDiskIOLimiter(
+	rate, deviceRate int64, autoTune bool, nowFn func() time.Time,
+) *diskIOLimiter {
+	l := &diskIOLimiter{
+		autoTune: autoTune,
+		minRate:  float64(rate) * diskIOAutoTuneMinFraction,
+		maxRate:  float64(max(rate, deviceRate)),
+		nowFn:    nowFn,
+	}
+	l.mu.rate = float64(rate)
+	l.mu.tb.InitWithNowFn(tokenbucket.TokensPerSecond(rate), diskIOBurst(float64(rate)), nowFn)
+	l.mu.lastIncrease = nowFn()
+	return l
+}
+
+func diskIOBurst(rate float64) tokenbucket.Tokens {
+	return tokenbucket.Tokens(max(rate*diskIOBurstDuration.Seconds(), diskIOMinBurst))
+}
+
+// currentRate returns the current rate of the limiter, or zero if l is nil.
+func (l *diskIOLimiter) currentRate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.mu.rate)
+}
+
+// setRateLocked updates the rate of the limiter. l.mu must be held.
+func (l *diskIOLimiter) setRateLocked(rate float64) {
+	l.mu.rate = rate
+	l.mu.tb.UpdateConfig(tokenbucket.TokensPerSecond(rate), diskIOBurst(rate))
+}
+
+func (l *diskIOLimiter) reportDiskSlow() {
+	if l == nil || !l.autoTune {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.nowFn()
+	l.mu.lastSlow = now
+	// DiskSlow events are typically reported in quick succession while the
+	// disk is stalled; only reduce the rate once per interval.
+	if !l.mu.lastDecrease.IsZero() && now.Sub(l.mu.lastDecrease) < diskIOAutoTuneInterval {
+		return
+	}
+	l.mu.lastDecrease = now
+	l.setRateLocked(max(l.minRate, l.mu.rate/2))
+}
+
+// maybeIncreaseRateLocked increases the rate of an auto-tuned limiter if the
+// disk has been healthy for at least diskIOAutoTuneInterval. l.mu must be
+// held.
+func (l *diskIOLimiter) maybeIncreaseRateLocked() {
+	if !l.autoTune || l.mu.rate >= l.maxRate {
+		return
+	}
+	now := l.nowFn()
+	if now.Sub(l.mu.lastIncrease) < diskIOAutoTuneInterval || now.Sub(l.mu.lastSlow) < diskIOAutoTuneInterval {
+		return
+	}
+	l.mu.lastIncrease = now
+	l.setRateLocked(min(l.maxRate, l.mu.rate+l.maxRate*diskIOAutoTuneIncreaseFraction))
+}
+
+// wait blocks until n tokens have been acquired on behalf of a waiter with the
+// given priority, returning the time spent waiting. The wait is abandoned,
+// returning false, once done is closed or cancelled (if non-nil) returns true;
+// cancelled is polled every diskIOCancelPollInterval. A nil limiter never
+// blocks.
+func (l *diskIOLimiter) wait(
+	priority diskIOPriority, n int64, done <-chan struct{}, cancelled func() bool,
+) (time.Duration, bool) {
+	if l == nil || n <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	l.maybeIncreaseRateLocked()
+	// Fast path: nobody is waiting and there are enough tokens.
+	if len(l.mu.waiters) == 0 {
+		if ok, _ := l.mu.tb.TryToFulfill(tokenbucket.Tokens(n)); ok {
+			l.mu.Unlock()
+			return 0, true
+		}
+	}
+	start := l.nowFn()
+	w := &diskIOWaiter{priority: priority, seq: l.mu.nextSeq, signal: make(chan struct{}, 1)}
+	l.mu.nextSeq++
+	i := sort.Search(len(l.mu.waiters), func(i int) bool {
+		return l.mu.waiters[i].priority > priority
+	})
+	l.mu.waiters = append(l.mu.waiters, nil)
+	copy(l.mu.waiters[i+1:], l.mu.waiters[i:])
+	l.mu.waiters[i] = w
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		if cancelled != nil && cancelled() {
+			l.removeWaiterLocked(w)
+			l.mu.Unlock()
+			return l.nowFn().Sub(start), false
+		}
+		// Waiters that are not at the head of the queue sleep until they are
+		// signaled, or until their cancellation function must be polled again.
+		sleep := time.Duration(-1)
+		if l.mu.waiters[0] == w {
+			ok, tryAgainAfter := l.mu.tb.TryToFulfill(tokenbucket.Tokens(n))
+			if ok {
+				l.removeWaiterLocked(w)
+				l.mu.Unlock()
+				return l.nowFn().Sub(start), true
+			}
+			sleep = tryAgainAfter
+		}
+		if cancelled != nil && (sleep < 0 || sleep > diskIOCancelPollInterval) {
+			sleep = diskIOCancelPollInterval
+		}
+		l.mu.Unlock()
+		var timerC <-chan time.Time
+		if sleep >= 0 {
+			if timer == nil {
+				timer = time.NewTimer(sleep)
+			} else {
+				timer.Reset(sleep)
+			}
+			timerC = timer.C
+		}
+		select {
+		case <-timerC:
+		case <-w.signal:
+			if timer != nil && !timer.Stop() && timerC != nil {
+				<-timer.C
+			}
+		case <-done:
+			if timer != nil && !timer.Stop() && timerC != nil {
+				<-timer.C
+			}
+			l.mu.Lock()
+			l.removeWaiterLocked(w)
+			l.mu.Unlock()
+			return l.nowFn().Sub(start), false
+		}
+		l.mu.Lock()
+		l.maybeIncreaseRateLocked()
+	}
+}
+
+// removeWaiterLocked removes w from the queue of waiters, notifying the new
+// head of the queue if w was at the head. l.mu must be held.
+func (l *diskIOLimiter) removeWaiterLocked(w *diskIOWaiter) {
+	for i := range l.mu.waiters {
+		if l.mu.waiters[i] == w {
+			l.mu.waiters = append(l.mu.waiters[:i], l.mu.waiters[i+1:]...)
+			if i == 0 && len(l.mu.waiters) > 0 {
+				l.mu.waiters[0].notify()
+			}
+			return
+		}
+	}
+}
+
+func (w *diskIOWaiter) notify() {
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskIOLimiterPriority(t *testing.T) {
+	// 10 MB/s with a 1 MB burst; every wait of 1 MB that is not served by the
+	// burst takes ~100ms.
+	const MB = 1 << 20
+	l := newDiskIOLimiter(10*MB, 0, false /* autoTune */, time.Now)
+
+	// Exhaust the burst so that subsequent waiters queue up.
+	d, ok := l.wait(diskIOPriorityCompaction, MB, nil, nil)
+	require.True(t, ok)
+	require.Zero(t, d)
+
+	var mu sync.Mutex
+	var order []diskIOPriority
+	var wg sync.WaitGroup
+	start := func(p diskIOPriority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.wait(p, MB, nil, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, p)
+		}()
+	}
+	queued := func(n int) func() bool {
+		return func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.mu.waiters) == n
+		}
+	}
+	start(diskIOPriorityDownload)
+	require.Eventually(t, queued(1), 10*time.Second, time.Millisecond)
+	start(diskIOPriorityCompaction)
+	require.Eventually(t, queued(2), 10*time.Second, time.Millisecond)
+	start(diskIOPriorityFlush)
+	require.Eventually(t, queued(3), 10*time.Second, time.Millisecond)
+	wg.Wait()
+
+	// The download arrived first and may have been at the head of the queue
+	// when the budget became available, but the flush must always be served
+	// before the compaction.
+	require.Len(t, order, 3)
+	pos := func(p diskIOPriority) int {
+		for i := range order {
+			if order[i] == p {
+				return i
+			}
+		}
+		return -1
+	}
+	require.Less(t, pos(diskIOPriorityFlush), pos(diskIOPriorityCompaction), "order: %v", order)
+}
+
+func TestDiskIOLimiterAutoTune(t *testing.T) {
+	const MB = 1 << 20
+	now := time.Unix(0, 0)
+	nowFn := func() time.Time { return now }
+	b := newDiskIOBudgetWithNowFn(DiskIOBudgetOptions{
+		ReadBytesPerSec:        80 * MB,
+		WriteBytesPerSec:       80 * MB,
+		AutoTune:               true,
+		DeviceWriteBytesPerSec: 100 * MB,
+	}, nowFn)
+
+	rates := func() string {
+		r, w := b.Rates()
+		return fmt.Sprintf("read=%d write=%d", r/MB, w/MB)
+	}
+	require.Equal(t, "read=80 write=80", rates())
+
+	// Consecutive slow events within the same interval only reduce the rate
+	// once.
+	b.ReportDiskSlow(vfs.DiskSlowInfo{})
+	b.ReportDiskSlow(vfs.DiskSlowInfo{})
+	require.Equal(t, "read=40 write=40", rates())
+	for i := 0; i < 5; i++ {
+		now = now.Add(diskIOAutoTuneInterval)
+		b.ReportDiskSlow(vfs.DiskSlowInfo{})
+	}
+	// The rate is never reduced below 1/8th of the configured rate.
+	require.Equal(t, "read=10 write=10", rates())
+
+	// While the disk is healthy, the rate increases by 5% of the device rate
+	// every interval, up to the device rate.
+	for i := 0; i < 100; i++ {
+		now = now.Add(diskIOAutoTuneInterval)
+		b.read.wait(diskIOPriorityCompaction, 1, nil, nil)
+		b.write.wait(diskIOPriorityCompaction, 1, nil, nil)
+	}
+	require.Equal(t, "read=80 write=100", rates())
+}
+
+func TestDiskIOThrottlerNewIters(t *testing.T) {
+	now := time.Unix(0, 0)
+	budget := newDiskIOBudgetWithNowFn(DiskIOBudgetOptions{ReadBytesPerSec: 1 << 20}, func() time.Time {
+		return now
+	})
+	th := newDiskIOThrottler(budget, nil)
+	var opened []iterKinds
+	newIters := th.newIters(func(
+		_ context.Context, _ *manifest.FileMetadata, _ *IterOptions, _ internalIterOpts, kinds iterKinds,
+	) (iterSet, error) {
+		opened = append(opened, kinds)
+		return iterSet{}, nil
+	}, diskIOClass{category: diskIOCategoryCompaction, priority: diskIOPriorityCompaction}, nil)
+
+	available := func() int64 {
+		budget.read.mu.Lock()
+		defer budget.read.mu.Unlock()
+		return int64(budget.read.mu.tb.Available())
+	}
+	burst := available()
+	f1 := &manifest.FileMetadata{FileNum: 1, Size: 100}
+	f2 := &manifest.FileMetadata{FileNum: 2, Size: 200}
+	// Each table is charged once, no matter which of its iterators are opened.
+	for _, kinds := range []iterKinds{iterRangeDeletions, iterPointKeys, iterRangeKeys} {
+		_, err := newIters(context.Background(), f1, nil, internalIterOpts{}, kinds)
+		require.NoError(t, err)
+	}
+	require.Equal(t, burst-100, available())
+	_, err := newIters(context.Background(), f2, nil, internalIterOpts{}, iterRangeKeys)
+	require.NoError(t, err)
+	require.Equal(t, burst-300, available())
+	require.Len(t, opened, 4)
+}
+
+func TestDiskIOLimiterInterrupt(t *testing.T) {
+	// 1 MB/s with a 1 MB burst; once the burst is exhausted, a wait of 1 MB
+	// takes ~1s.
+	const MB = 1 << 20
+	l := newDiskIOLimiter(MB, 0, false /* autoTune */, time.Now)
+	_, ok := l.wait(diskIOPriorityCompaction, MB, nil, nil)
+	require.True(t, ok)
+
+	// A waiter is interrupted when done is closed.
+	done := make(chan struct{})
+	close(done)
+	_, ok = l.wait(diskIOPriorityCompaction, MB, done, nil)
+	require.False(t, ok)
+
+	// A waiter is interrupted once cancelled returns true, including when it
+	// is queued behind another waiter.
+	var cancelled atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok := l.wait(diskIOPriorityCompaction, 10*MB, nil, cancelled.Load)
+			require.False(t, ok)
+		}()
+	}
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.mu.waiters) == 2
+	}, 10*time.Second, time.Millisecond)
+	cancelled.Store(true)
+	wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.Empty(t, l.mu.waiters)
+}
+
+func TestDiskIOBudgetMetrics(t *testing.T) {
+	budget := NewDiskIOBudget(DiskIOBudgetOptions{
+		ReadBytesPerSec:  64 << 20,
+		WriteBytesPerSec: 64 << 20,
+	})
+	d, err := Open("", &Options{
+		FS:           vfs.NewMem(),
+		DiskIOBudget: budget,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 1<<10), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false /* parallelize */))
+
+	m := d.Metrics()
+	require.Equal(t, uint64(64<<20), m.DiskIO.ReadBytesPerSec)
+	require.Equal(t, uint64(64<<20), m.DiskIO.WriteBytesPerSec)
+	require.Contains(t, m.String(), "Disk I/O budget: read 64MB/s  write 64MB/s")
+}
@@ -287,6 +287,35 @@ func (i DownloadInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	}
 }
 
+// HotKeysInfo contains the info for a hot keys event: the keys and key
+// prefixes whose sampled rates reached Options.Experimental.HotKeys.
+// ReportThreshold over a window.
+type HotKeysInfo struct {
+	// Window is the duration of the window.
+	Window time.Duration
+	// Keys and Ranges are the hot keys and key prefixes, in decreasing order
+	// of frequency.
+	Keys   []HotKey
+	Ranges []HotRange
+}
+
+func (i HotKeysInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i HotKeysInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("hot keys detected over %s:", redact.Safe(i.Window.Round(time.Second)))
+	for _, k := range i.Keys {
+		w.Printf(" key %q (%.1f reads/s, %.1f writes/s)",
+			k.Key, redact.Safe(k.ReadRate), redact.Safe(k.WriteRate))
+	}
+	for _, r := range i.Ranges {
+		w.Printf(" range [%q, %q) (%.1f reads/s, %.1f writes/s)",
+			r.Start, r.End, redact.Safe(r.ReadRate), redact.Safe(r.WriteRate))
+	}
+}
+
 // ManifestCreateInfo contains info about a manifest creation event.
 type ManifestCreateInfo struct {
 	// JobID is the ID of the job the caused the manifest to be created.
@@ -574,6 +603,11 @@ type EventListener struct {
 	// is upgraded.
 	FormatUpgrade func(FormatMajorVersion)
 
+	// HotKeysDetected is invoked at the end of a hot key tracking window in
+	// which keys or key prefixes reached the reporting threshold. See
+	// Options.Experimental.HotKeys.
+	HotKeysDetected func(HotKeysInfo)
+
 	// ManifestCreated is invoked after a manifest has been created.
 	ManifestCreated func(ManifestCreateInfo)
 
@@ -648,6 +682,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.FormatUpgrade == nil {
 		l.FormatUpgrade = func(v FormatMajorVersion) {}
 	}
+	if l.HotKeysDetected == nil {
+		l.HotKeysDetected = func(info HotKeysInfo) {}
+	}
 	if l.ManifestCreated == nil {
 		l.ManifestCreated = func(info ManifestCreateInfo) {}
 	}
@@ -718,6 +755,9 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		FormatUpgrade: func(v FormatMajorVersion) {
 			logger.Infof("upgraded to format version: %s", v)
 		},
+		HotKeysDetected: func(info HotKeysInfo) {
+			logger.Infof("%s", info)
+		},
 		ManifestCreated: func(info ManifestCreateInfo) {
 			logger.Infof("%s", info)
 		},
@@ -795,6 +835,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.FormatUpgrade(v)
 			b.FormatUpgrade(v)
 		},
+		HotKeysDetected: func(info HotKeysInfo) {
+			a.HotKeysDetected(info)
+			b.HotKeysDetected(info)
+		},
 		ManifestCreated: func(info ManifestCreateInfo) {
 			a.ManifestCreated(info)
 			b.ManifestCreated(info)
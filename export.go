@@ -0,0 +1,310 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	irangekey "github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/rangekey"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ExportSpanOptions configures DB.ExportSpan.
+type ExportSpanOptions struct {
+	// FS and Dir are the filesystem and directory in which the sstables are
+	// written. The sstables are named export-NNNNNN.sst, so Dir should not
+	// contain the sstables of another export. FS defaults to the DB's
+	// filesystem.
+	FS  vfs.FS
+	Dir string
+
+	// TargetFileSize is the size above which an sstable is finished and the
+	// next one started. Defaults to the target file size of L6.
+	TargetFileSize int64
+
+	// TableFormat is the format of the sstables. Defaults to the newest format
+	// supported by the DB's format major version; a DB into which the sstables
+	// are ingested may require an older one.
+	TableFormat sstable.TableFormat
+
+	// SinceSeqNum, if non-zero, selects the export of the full internal history
+	// of the span above the given sequence number, rather than of its visible
+	// state: every point key, range deletion and range key with a sequence
+	// number of at least SinceSeqNum is written with its sequence number,
+	// including shadowed and deleted keys. Such sstables cannot be ingested;
+	// they are meant to be read with sstable.Reader.
+	SinceSeqNum uint64
+
+	// ReferenceSharedFiles and ReferenceExternalFiles, if set, cause the
+	// sstables of the span in L5 and L6 (or in L6, respectively) that are on
+	// shared or external storage to be referenced in the result rather than
+	// copied. The DB must store all the sstables of those levels in the span on
+	// such storage. At most one may be set, and neither is compatible with
+	// SinceSeqNum. The span must be bounded.
+	ReferenceSharedFiles   bool
+	ReferenceExternalFiles bool
+}
+
+// ExportedSpan is the result of DB.ExportSpan. Paths, Shared and External can
+// be passed to DB.IngestAndExcise, with Span as the excise span.
+type ExportedSpan struct {
+	// Span is the exported span.
+	Span KeyRange
+	// SeqNum is the sequence number of the snapshot of the DB that was
+	// exported.
+	SeqNum uint64
+	// Paths are the paths of the sstables written by the export.
+	Paths []string
+	// Shared and External are the referenced sstables (see
+	// ExportSpanOptions.ReferenceSharedFiles and ReferenceExternalFiles).
+	Shared   []SharedSSTMeta
+	External []ExternalFile
+}
+
+// ExportSpan writes the state of the given span of a snapshot of the DB into
+// sstables. An unset Start or End leaves the span unbounded on that side.
+//
+// By default, the visible state of the span is exported: each live point key
+// with its latest value and each range key set, with zero sequence numbers,
+// so that the sstables can be ingested into another DB with IngestAndExcise.
+// Point and range deletions are omitted, as the excise removes the previous
+// contents of the span, unless remote sstables are referenced: the deletions
+// may then apply to the keys of those sstables. See
+// ExportSpanOptions.SinceSeqNum to export the internal history of the span
+// instead. As with ScanInternal, the visible state of a span cannot be
+// exported if the span contains merge or single delete keys.
+func (d *DB) ExportSpan(
+	ctx context.Context, span KeyRange, opts ExportSpanOptions,
+) (ExportedSpan, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if opts.ReferenceSharedFiles && opts.ReferenceExternalFiles {
+		return ExportedSpan{}, errors.New("pebble: cannot reference both shared and external files")
+	}
+	referenceRemote := opts.ReferenceSharedFiles || opts.ReferenceExternalFiles
+	if referenceRemote && opts.SinceSeqNum != 0 {
+		return ExportedSpan{}, errors.New("pebble: cannot reference remote files when exporting history")
+	}
+	if referenceRemote && (span.Start == nil || span.End == nil) {
+		return ExportedSpan{}, errors.New("pebble: span must be bounded to reference remote files")
+	}
+	if opts.FS == nil {
+		opts.FS = d.opts.FS
+	}
+	if opts.TargetFileSize <= 0 {
+		opts.TargetFileSize = d.opts.Level(numLevels - 1).TargetFileSize
+	}
+	if opts.TableFormat == sstable.TableFormatUnspecified {
+		opts.TableFormat = d.FormatMajorVersion().MaxTableFormat()
+	}
+
+	snap := d.NewSnapshot()
+	defer snap.Close()
+	e := &spanExporter{
+		cmp:           d.cmp,
+		opts:          opts,
+		writerOpts:    d.opts.MakeWriterOptions(numLevels-1, opts.TableFormat),
+		result:        ExportedSpan{Span: span, SeqNum: snap.seqNum},
+		keepDeletions: referenceRemote,
+	}
+	scanOpts := &scanInternalOptions{
+		CategoryAndQoS: sstable.CategoryAndQoS{Category: "export"},
+		IterOptions: IterOptions{
+			KeyTypes:   IterKeyTypePointsAndRanges,
+			LowerBound: span.Start,
+			UpperBound: span.End,
+		},
+		visitPointKey:            e.visitPointKey,
+		visitRangeDel:            e.visitRangeDel,
+		visitRangeKey:            e.visitRangeKey,
+		includeObsoleteKeys:      opts.SinceSeqNum != 0,
+		includeObsoletePoints:    opts.SinceSeqNum != 0,
+		includeRangeKeySeqNums:   opts.SinceSeqNum != 0,
+		includeShadowedRangeDels: opts.SinceSeqNum != 0,
+	}
+	if opts.ReferenceSharedFiles {
+		scanOpts.visitSharedFile = func(sst *SharedSSTMeta) error {
+			e.result.Shared = append(e.result.Shared, *sst)
+			return nil
+		}
+	}
+	if opts.ReferenceExternalFiles {
+		scanOpts.visitExternalFile = func(sst *ExternalFile) error {
+			e.result.External = append(e.result.External, *sst)
+			return nil
+		}
+	}
+	iter, err := d.newInternalIter(ctx, snapshotIterOpts{seqNum: snap.seqNum}, scanOpts)
+	if err != nil {
+		return ExportedSpan{}, err
+	}
+	err = scanInternalImpl(ctx, span.Start, span.End, iter, scanOpts)
+	err = firstError(err, iter.close())
+	err = firstError(err, e.finish())
+	if err != nil {
+		e.removeTables()
+		return ExportedSpan{}, err
+	}
+	return e.result, nil
+}
+
+// spanExporter writes the keys visited by DB.ExportSpan into sstables.
+type spanExporter struct {
+	cmp        Compare
+	opts       ExportSpanOptions
+	writerOpts sstable.WriterOptions
+	result     ExportedSpan
+	// keepDeletions is set when exporting the visible state of a span while
+	// referencing remote sstables, whose keys may be deleted by the keys
+	// above them.
+	keepDeletions bool
+
+	w *sstable.Writer
+	// lastUserKey is the user key of the last point key added to w, and spanEnd
+	// the largest end key of the range deletions and range keys added to w. An
+	// sstable is only finished before a point key that follows both, so that
+	// the versions of a user key and the spans are not split across sstables.
+	lastUserKey []byte
+	spanEnd     []byte
+}
+
+func (e *spanExporter) visitPointKey(key *InternalKey, value LazyValue, _ IteratorLevel) error {
+	switch {
+	case e.opts.SinceSeqNum != 0:
+		if key.SeqNum() < e.opts.SinceSeqNum {
+			return nil
+		}
+	case !e.keepDeletions:
+		switch key.Kind() {
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		default:
+			return nil
+		}
+	}
+	if err := e.maybeFinish(key.UserKey); err != nil {
+		return err
+	}
+	if err := e.ensureWriter(); err != nil {
+		return err
+	}
+	v, _, err := value.Value(nil)
+	if err != nil {
+		return err
+	}
+	e.lastUserKey = append(e.lastUserKey[:0], key.UserKey...)
+	if e.opts.SinceSeqNum != 0 {
+		return e.w.Add(*key, v)
+	}
+	return e.w.Add(base.MakeInternalKey(key.UserKey, 0, key.Kind()), v)
+}
+
+func (e *spanExporter) visitRangeDel(start, end []byte, seqNum uint64) error {
+	switch {
+	case e.opts.SinceSeqNum != 0:
+		// Every range deletion of a fragment is visited, from the newest to
+		// the oldest.
+		if seqNum < e.opts.SinceSeqNum {
+			return nil
+		}
+	case !e.keepDeletions:
+		return nil
+	default:
+		seqNum = 0
+	}
+	if err := e.ensureWriter(); err != nil {
+		return err
+	}
+	e.extendSpanEnd(end)
+	return e.w.Add(base.MakeInternalKey(start, seqNum, InternalKeyKindRangeDelete), end)
+}
+
+func (e *spanExporter) visitRangeKey(start, end []byte, keys []rangekey.Key) error {
+	s := keyspan.Span{Start: start, End: end}
+	for _, k := range keys {
+		switch {
+		case e.opts.SinceSeqNum != 0:
+			if k.SeqNum() < e.opts.SinceSeqNum {
+				continue
+			}
+		case !e.keepDeletions:
+			if k.Kind() != InternalKeyKindRangeKeySet {
+				continue
+			}
+		}
+		s.Keys = append(s.Keys, k)
+	}
+	if len(s.Keys) == 0 {
+		return nil
+	}
+	if err := e.ensureWriter(); err != nil {
+		return err
+	}
+	e.extendSpanEnd(end)
+	return irangekey.Encode(&s, e.w.AddRangeKey)
+}
+
+func (e *spanExporter) extendSpanEnd(end []byte) {
+	if e.spanEnd == nil || e.cmp(end, e.spanEnd) > 0 {
+		e.spanEnd = append(e.spanEnd[:0], end...)
+	}
+}
+
+// maybeFinish finishes the current sstable if it reached the target size and
+// the point key with the given user key can start the next one.
+func (e *spanExporter) maybeFinish(userKey []byte) error {
+	if e.w == nil || e.w.EstimatedSize() < uint64(e.opts.TargetFileSize) {
+		return nil
+	}
+	if e.lastUserKey != nil && e.cmp(userKey, e.lastUserKey) == 0 {
+		return nil
+	}
+	if e.spanEnd != nil && e.cmp(userKey, e.spanEnd) < 0 {
+		return nil
+	}
+	return e.finish()
+}
+
+func (e *spanExporter) ensureWriter() error {
+	if e.w != nil {
+		return nil
+	}
+	fs := e.opts.FS
+	path := fs.PathJoin(e.opts.Dir, fmt.Sprintf("export-%06d.sst", len(e.result.Paths)))
+	f, err := fs.Create(path, vfs.WriteCategoryUnspecified)
+	if err != nil {
+		return err
+	}
+	e.w = sstable.NewWriter(objstorageprovider.NewFileWritable(f), e.writerOpts)
+	e.result.Paths = append(e.result.Paths, path)
+	return nil
+}
+
+// removeTables removes the sstables written by a failed export, including the
+// one that was being written when it failed.
+func (e *spanExporter) removeTables() {
+	for _, path := range e.result.Paths {
+		// The export already failed; a table that cannot be removed is left
+		// behind.
+		_ = e.opts.FS.Remove(path)
+	}
+	e.result.Paths = nil
+}
+
+func (e *spanExporter) finish() error {
+	if e.w == nil {
+		return nil
+	}
+	err := e.w.Close()
+	e.w, e.lastUserKey, e.spanEnd = nil, nil, nil
+	return err
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportSpan(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: FormatNewest,
+		Comparer:           testkeys.Comparer,
+	}
+	src, err := Open("src", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, src.Close()) }()
+	dst, err := Open("dst", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dst.Close()) }()
+
+	rng := rand.New(rand.NewSource(1))
+	for _, d := range []*DB{src, dst} {
+		for i := 0; i < 200; i++ {
+			v := make([]byte, 100)
+			rng.Read(v)
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("k%03d", i)), v, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, src.Compact([]byte("k"), []byte("l"), false))
+	require.NoError(t, src.DeleteRange([]byte("k010"), []byte("k020"), nil))
+	require.NoError(t, src.Delete([]byte("k030"), nil))
+	require.NoError(t, src.RangeKeySet([]byte("k050"), []byte("k060"), []byte("@3"), []byte("rk"), nil))
+	require.NoError(t, src.RangeKeyUnset([]byte("k055"), []byte("k058"), []byte("@3"), nil))
+
+	// The visible state of the span replaces the contents of the span in dst.
+	span := KeyRange{Start: []byte("k005"), End: []byte("k150")}
+	require.NoError(t, mem.MkdirAll("export", 0755))
+	exported, err := src.ExportSpan(context.Background(), span, ExportSpanOptions{
+		FS:             mem,
+		Dir:            "export",
+		TargetFileSize: 2048,
+	})
+	require.NoError(t, err)
+	require.Greater(t, len(exported.Paths), 1)
+	require.Equal(t, span, exported.Span)
+	require.Equal(t, src.mu.versions.visibleSeqNum.Load(), exported.SeqNum)
+	_, err = dst.IngestAndExcise(exported.Paths, nil /* shared */, nil /* external */, span, false)
+	require.NoError(t, err)
+	require.Equal(t, dumpDBContents(t, src, span), dumpDBContents(t, dst, span))
+	require.NotEqual(t, dumpDBContents(t, src, KeyRange{}), dumpDBContents(t, dst, KeyRange{}))
+
+	// The internal history above a sequence number keeps the sequence numbers,
+	// and includes deletions and shadowed keys.
+	since := src.mu.versions.visibleSeqNum.Load()
+	require.NoError(t, src.Set([]byte("k100"), []byte("a"), nil))
+	// The snapshot keeps the shadowed key from being dropped by the flush.
+	snap := src.NewSnapshot()
+	defer snap.Close()
+	require.NoError(t, src.Set([]byte("k100"), []byte("b"), nil))
+	require.NoError(t, src.Delete([]byte("k101"), nil))
+	require.NoError(t, src.DeleteRange([]byte("k102"), []byte("k104"), nil))
+	// The range deletion shadowed by the next one is exported too.
+	snap2 := src.NewSnapshot()
+	defer snap2.Close()
+	require.NoError(t, src.DeleteRange([]byte("k102"), []byte("k104"), nil))
+	require.NoError(t, src.Merge([]byte("k099"), []byte("m"), nil))
+	require.NoError(t, src.Flush())
+	require.NoError(t, src.RangeKeySet([]byte("k105"), []byte("k106"), []byte("@1"), []byte("rk"), nil))
+	require.NoError(t, mem.MkdirAll("history", 0755))
+	exported, err = src.ExportSpan(context.Background(), KeyRange{}, ExportSpanOptions{
+		FS:          mem,
+		Dir:         "history",
+		SinceSeqNum: since,
+	})
+	require.NoError(t, err)
+	require.Len(t, exported.Paths, 1)
+
+	f, err := mem.Open(exported.Paths[0])
+	require.NoError(t, err)
+	readable, err := sstable.NewSimpleReadable(f)
+	require.NoError(t, err)
+	r, err := sstable.NewReader(readable, sstable.ReaderOptions{Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	defer r.Close()
+	var buf strings.Builder
+	iter, err := r.NewIter(sstable.NoTransforms, nil /* lower */, nil /* upper */)
+	require.NoError(t, err)
+	for kv := iter.First(); kv != nil; kv = iter.Next() {
+		v, _, err := kv.Value(nil)
+		require.NoError(t, err)
+		fmt.Fprintf(&buf, "%s:%s\n", kv.K.Pretty(testkeys.Comparer.FormatKey), v)
+	}
+	require.NoError(t, iter.Close())
+	rangeDelIter, err := r.NewRawRangeDelIter(sstable.NoTransforms)
+	require.NoError(t, err)
+	require.NotNil(t, rangeDelIter)
+	s, err := rangeDelIter.First()
+	require.NoError(t, err)
+	fmt.Fprintf(&buf, "%s\n", s)
+	rangeDelIter.Close()
+	rangeKeyIter, err := r.NewRawRangeKeyIter(sstable.NoTransforms)
+	require.NoError(t, err)
+	require.NotNil(t, rangeKeyIter)
+	s, err = rangeKeyIter.First()
+	require.NoError(t, err)
+	fmt.Fprintf(&buf, "%s\n", s)
+	rangeKeyIter.Close()
+	require.Equal(t, fmt.Sprintf(`k099#%d,MERGE:m
+k100#%d,SET:b
+k100#%d,SET:a
+k101#%d,DEL:
+k102-k104:{(#%d,RANGEDEL) (#%d,RANGEDEL)}
+k105-k106:{(#%d,RANGEKEYSET,@1,rk)}
+`, since+5, since+1, since, since+2, since+4, since+3, since+6), buf.String())
+
+	_, err = src.ExportSpan(context.Background(), span, ExportSpanOptions{
+		FS: mem, Dir: "export", ReferenceSharedFiles: true, ReferenceExternalFiles: true,
+	})
+	require.Error(t, err)
+	_, err = src.ExportSpan(context.Background(), KeyRange{}, ExportSpanOptions{
+		FS: mem, Dir: "export", ReferenceSharedFiles: true,
+	})
+	require.Error(t, err)
+
+	// The DB's filesystem is used by default. The span excludes the merge key,
+	// whose visible state cannot be exported.
+	span = KeyRange{Start: []byte("k000"), End: []byte("k090")}
+	require.NoError(t, mem.MkdirAll("src/export", 0755))
+	exported, err = src.ExportSpan(context.Background(), span, ExportSpanOptions{Dir: "src/export"})
+	require.NoError(t, err)
+	require.Len(t, exported.Paths, 1)
+
+	// The sstables written by a failed export are removed.
+	require.NoError(t, mem.MkdirAll("failed", 0755))
+	_, err = src.ExportSpan(context.Background(), span, ExportSpanOptions{
+		FS:             &exportCreateErrorFS{FS: mem, name: "export-000002.sst"},
+		Dir:            "failed",
+		TargetFileSize: 2048,
+	})
+	require.Error(t, err)
+	ls, err := mem.List("failed")
+	require.NoError(t, err)
+	require.Empty(t, ls)
+}
+
+// exportCreateErrorFS fails to create the file with the given name.
+type exportCreateErrorFS struct {
+	vfs.FS
+	name string
+}
+
+func (fs *exportCreateErrorFS) Create(
+	name string, category vfs.DiskWriteCategory,
+) (vfs.File, error) {
+	if fs.PathBase(name) == fs.name {
+		return nil, errors.New("injected error")
+	}
+	return fs.FS.Create(name, category)
+}
+
+func TestExportSpanShared(t *testing.T) {
+	storage := remote.NewInMem()
+	open := func(creatorID uint64) *DB {
+		opts := &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatNewest}
+		opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+			"": storage,
+		})
+		opts.Experimental.CreateOnShared = remote.CreateOnSharedAll
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		require.NoError(t, d.SetCreatorID(creatorID))
+		return d
+	}
+	src, dst := open(1), open(2)
+	defer func() {
+		require.NoError(t, src.Close())
+		require.NoError(t, dst.Close())
+	}()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, src.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v"), nil))
+	}
+	require.NoError(t, src.Flush())
+	require.NoError(t, src.Compact([]byte("k"), []byte("l"), false))
+	// The deletion above the shared sstables is exported with them.
+	require.NoError(t, src.Delete([]byte("k050"), nil))
+
+	span := KeyRange{Start: []byte("k"), End: []byte("l")}
+	mem := dst.opts.FS
+	require.NoError(t, mem.MkdirAll("export", 0755))
+	exported, err := src.ExportSpan(context.Background(), span, ExportSpanOptions{
+		FS:                   mem,
+		Dir:                  "export",
+		ReferenceSharedFiles: true,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, exported.Shared)
+	require.Len(t, exported.Paths, 1)
+	_, err = dst.IngestAndExcise(exported.Paths, exported.Shared, nil /* external */, span, false)
+	require.NoError(t, err)
+	require.Equal(t, dumpDBContents(t, src, span), dumpDBContents(t, dst, span))
+	_, closer, err := dst.Get([]byte("k050"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Nil(t, closer)
+}
@@ -0,0 +1,1038 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/batchrepr"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/pebble/vfs/atomicfs"
+	"github.com/cockroachdb/pebble/wal"
+)
+
+// followerLeasePrefix is the prefix of the name of the lease files that
+// followers write into the directory of their primary.
+const followerLeasePrefix = "FOLLOWER-LEASE-"
+
+// followerCatchUpAttempts is the number of times a follower attempts to catch
+// up with its primary when a file disappears while it is being read.
+const followerCatchUpAttempts = 3
+
+// defaultFollowerLeaseTTL is the default value of FollowerCleaner.LeaseTTL.
+const defaultFollowerLeaseTTL = time.Minute
+
+// FollowerOptions configures a DB opened with OpenFollower.
+type FollowerOptions struct {
+	// CatchUpInterval is the period at which the follower catches up with its
+	// primary in the background. A value of zero disables background catch
+	// ups, in which case the follower only catches up when DB.CatchUp is
+	// called.
+	CatchUpInterval time.Duration
+
+	// LeaseName, if non-empty, is the name of the lease through which the
+	// follower pins the files it reads. The follower writes its lease into the
+	// primary's directory every time it catches up and removes it when it is
+	// closed; a primary configured with a FollowerCleaner defers the deletion
+	// of the files named by the leases of its followers. Every follower of a
+	// primary must use a distinct name.
+	LeaseName string
+}
+
+// OpenFollower opens a read-only DB that follows the primary DB whose files
+// live in the given directory, while the primary is running in another
+// process (or on another machine, with the directory on shared storage). The
+// follower does not lock the directory and never modifies the primary's
+// files.
+//
+// The follower serves consistent reads from the state of the primary as of
+// the last time it caught up: it replays the version edits appended to the
+// primary's MANIFEST and the batches appended to its WALs since then, stopping
+// at any record the primary is still writing. Writes that the primary has not
+// yet written to its WAL are not visible. Tables the primary creates on remote
+// storage are not visible to the follower.
+//
+// Unless the primary is configured with a FollowerCleaner and the follower
+// with a FollowerOptions.LeaseName, the primary may delete files the follower
+// is reading.
+func OpenFollower(dirname string, opts *Options, followerOpts FollowerOptions) (*DB, error) {
+	opts = opts.Clone()
+	opts.ReadOnly = true
+	if opts.WALFailover != nil {
+		return nil, errors.New("pebble: WAL failover is not supported by followers")
+	}
+	if opts.Experimental.CreateOnShared != remote.CreateOnSharedNone {
+		return nil, errors.New("pebble: shared storage is not supported by followers")
+	}
+	if strings.ContainsAny(followerOpts.LeaseName, `/\`) {
+		return nil, errors.Errorf("pebble: invalid follower lease name %q", followerOpts.LeaseName)
+	}
+	f := &followerState{opts: followerOpts}
+	if followerOpts.LeaseName != "" {
+		f.leasePath = opts.FS.PathJoin(dirname, followerLeasePrefix+followerOpts.LeaseName)
+	}
+	return open(dirname, opts, f)
+}
+
+// followerState holds the state of a DB opened with OpenFollower.
+type followerState struct {
+	opts       FollowerOptions
+	walDirname string
+	// leasePath is the path of the follower's lease, or "" if the follower
+	// does not write a lease.
+	leasePath string
+
+	// catchUpMu serializes the catch ups with the primary, which read the
+	// primary's files without holding DB.mu. The positions below are modified
+	// while holding both catchUpMu and DB.mu, and may be read while holding
+	// either.
+	catchUpMu sync.Mutex
+
+	// manifest is the position in the primary's MANIFEST up to which the
+	// version edits have been applied.
+	manifest struct {
+		fileNum base.DiskFileNum
+		offset  int64
+	}
+	// wal is the position in the primary's WALs up to which the batches have
+	// been replayed. The batches of the WAL are replayed into the mutable
+	// memtable, if any.
+	wal struct {
+		num    base.DiskFileNum
+		offset int64
+	}
+}
+
+// CatchUp applies the changes made by the primary since the follower last
+// caught up, so that they are visible to subsequently created iterators and
+// snapshots. It returns an error if the DB was not opened with OpenFollower.
+func (d *DB) CatchUp() error {
+	if d.follower == nil {
+		return errors.New("pebble: not a follower")
+	}
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	return d.followerCatchUp()
+}
+
+// FollowerLag returns the number of sequence numbers by which the follower
+// lags behind its primary, as of the changes the primary has written to its
+// MANIFEST and WALs. It reads the changes without applying them. It returns
+// an error if the DB was not opened with OpenFollower.
+func (d *DB) FollowerLag() (uint64, error) {
+	if d.follower == nil {
+		return 0, errors.New("pebble: not a follower")
+	}
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	f := d.follower
+	d.mu.Lock()
+	visible := d.mu.versions.visibleSeqNum.Load()
+	manifestPos, walPos := f.manifest, f.wal
+	d.mu.Unlock()
+	seqNum := visible
+
+	manifestFileNum, err := d.readCurrentManifestNum()
+	if err != nil {
+		return 0, err
+	}
+	offset := manifestPos.offset
+	if manifestFileNum != manifestPos.fileNum {
+		offset = 0
+	}
+	edits, _, err := readManifestTail(d.opts.FS, d.dirname, manifestFileNum, offset)
+	if err != nil {
+		return 0, err
+	}
+	for _, ve := range edits {
+		seqNum = max(seqNum, ve.LastSeqNum+1)
+	}
+
+	wals, err := wal.Scan(wal.Dir{FS: d.opts.FS, Dirname: f.walDirname})
+	if err != nil {
+		return 0, err
+	}
+	for _, ll := range wals {
+		num := base.DiskFileNum(ll.Num)
+		if num < walPos.num {
+			continue
+		}
+		offset := walPos.offset
+		if num != walPos.num {
+			offset = 0
+		}
+		walSeqNum, err := readWALTailSeqNum(ll, offset)
+		if err != nil && !oserror.IsNotExist(err) {
+			return 0, err
+		}
+		seqNum = max(seqNum, walSeqNum)
+	}
+	return seqNum - visible, nil
+}
+
+// runFollowerLoop catches up with the primary every CatchUpInterval, until the
+// DB is closed.
+func (d *DB) runFollowerLoop() {
+	ticker := time.NewTicker(d.follower.opts.CatchUpInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+		}
+		if err := d.followerCatchUp(); err != nil && !errors.Is(err, ErrClosed) {
+			d.opts.Logger.Errorf("pebble: follower failed to catch up: %v", err)
+		}
+	}
+}
+
+// followerCatchUp catches up with the primary, retrying if a file disappears
+// while it is being read: the primary may flush a WAL and delete it, or delete
+// a table it just compacted, after the follower read a manifest that
+// references it. It returns ErrClosed if the DB is closed.
+//
+// d.mu must not be held when calling this.
+func (d *DB) followerCatchUp() error {
+	f := d.follower
+	f.catchUpMu.Lock()
+	defer f.catchUpMu.Unlock()
+	if d.closed.Load() != nil {
+		return ErrClosed
+	}
+	var err error
+	for i := 0; i < followerCatchUpAttempts; i++ {
+		if err = d.followerCatchUpOnce(); err == nil || !oserror.IsNotExist(err) {
+			break
+		}
+	}
+	return err
+}
+
+// followerCatchUpOnce applies the version edits and replays the batches
+// appended to the primary's MANIFEST and WALs since the last catch up, and
+// installs the resulting read state. The primary's files are read without
+// holding d.mu, which is only acquired to install the changes.
+//
+// f.catchUpMu must be held when calling this.
+func (d *DB) followerCatchUpOnce() error {
+	f := d.follower
+
+	manifestFileNum, err := d.readCurrentManifestNum()
+	if err != nil {
+		return err
+	}
+	// If the primary rotated its manifest, the new manifest starts with a
+	// snapshot of the LSM, which is read in full and compared with the current
+	// version. The positions of the follower are only modified by catch ups,
+	// so they may be read without holding d.mu.
+	snapshot := manifestFileNum != f.manifest.fileNum
+	offset := f.manifest.offset
+	if snapshot {
+		offset = 0
+	}
+	edits, offset, err := readManifestTail(d.opts.FS, d.dirname, manifestFileNum, offset)
+	if err != nil {
+		return err
+	}
+	// List the directory and the WALs after reading the manifest, so that the
+	// provider knows about all the tables the edits reference and the WALs
+	// include the oldest one the primary has not flushed.
+	ls, err := d.opts.FS.List(d.dirname)
+	if err != nil {
+		return err
+	}
+	d.objProvider.AddLocalObjects(ls)
+	wals, err := wal.Scan(wal.Dir{FS: d.opts.FS, Dirname: f.walDirname})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	jobID := d.newJobIDLocked()
+	err = d.followerApplyEditsLocked(manifestFileNum, offset, edits, snapshot)
+	walNum, walOffset := f.wal.num, f.wal.offset
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var tails []*followerWALTail
+	for _, ll := range wals {
+		num := base.DiskFileNum(ll.Num)
+		if num < walNum {
+			continue
+		}
+		offset := walOffset
+		if num != walNum {
+			offset = 0
+		}
+		t, err := readFollowerWALTail(ll, offset)
+		if err != nil {
+			return err
+		}
+		tails = append(tails, t)
+	}
+
+	d.mu.Lock()
+	err = d.followerReplayLocked(jobID, tails)
+	var pinned []string
+	if err == nil && f.leasePath != "" {
+		pinned = d.followerPinnedFilesLocked(wals)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return f.writeLease(d.opts.FS, d.timeNow(), pinned)
+}
+
+// followerApplyEditsLocked applies the given version edits, read from the
+// primary's MANIFEST up to the given offset, and drops the memtables whose
+// contents the primary has flushed.
+//
+// d.mu and f.catchUpMu must be held when calling this.
+func (d *DB) followerApplyEditsLocked(
+	manifestFileNum base.DiskFileNum, offset int64, edits []*versionEdit, snapshot bool,
+) error {
+	f := d.follower
+	vs := d.mu.versions
+	ve, err := vs.followerEditLocked(edits, snapshot)
+	if err != nil {
+		return err
+	}
+	for _, nf := range ve.NewFiles {
+		if _, err := d.objProvider.Lookup(fileTypeTable, nf.Meta.FileBacking.DiskFileNum); err != nil {
+			return err
+		}
+	}
+	if len(ve.NewFiles) > 0 || len(ve.DeletedFiles) > 0 || ve.MinUnflushedLogNum != 0 {
+		if err := vs.applyFollowerEditLocked(ve); err != nil {
+			return err
+		}
+	}
+	if ve.LastSeqNum+1 > vs.logSeqNum.Load() {
+		vs.logSeqNum.Store(ve.LastSeqNum + 1)
+	}
+	if ve.NextFileNum > vs.nextFileNum {
+		vs.nextFileNum = ve.NextFileNum
+	}
+	vs.manifestFileNum = manifestFileNum
+	f.manifest.fileNum, f.manifest.offset = manifestFileNum, offset
+
+	// Drop the memtables whose contents the primary has flushed, and start
+	// replaying the oldest WAL that has not been flushed if the follower lags
+	// behind it.
+	var n int
+	for n < len(d.mu.mem.queue) && d.mu.mem.queue[n].logNum < vs.minUnflushedLogNum {
+		d.mu.mem.queue[n].readerUnrefLocked(true)
+		n++
+	}
+	d.mu.mem.queue = d.mu.mem.queue[n:]
+	if f.wal.num < vs.minUnflushedLogNum {
+		f.wal.num, f.wal.offset = vs.minUnflushedLogNum, 0
+		d.mu.mem.mutable = nil
+	}
+	return nil
+}
+
+// followerReplayLocked replays the batches read from the tails of the primary's
+// WALs and installs the resulting read state.
+//
+// d.mu and f.catchUpMu must be held when calling this.
+func (d *DB) followerReplayLocked(jobID JobID, tails []*followerWALTail) error {
+	f := d.follower
+	vs := d.mu.versions
+	for _, t := range tails {
+		if t.num != f.wal.num {
+			// The batches of a WAL must not be replayed into the memtable of the
+			// previous one, which is dropped once the primary flushes it.
+			f.wal.num, f.wal.offset = t.num, 0
+			d.mu.mem.mutable = nil
+		}
+		_, maxSeqNum, err := d.replayWAL(jobID, nil /* ve */, t.num, &followerWALTailReader{t: t}, false /* strictWALTail */)
+		if err != nil {
+			return err
+		}
+		f.wal.offset = t.end
+		if maxSeqNum > vs.logSeqNum.Load() {
+			vs.logSeqNum.Store(maxSeqNum)
+		}
+	}
+	vs.visibleSeqNum.Store(vs.logSeqNum.Load())
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	return nil
+}
+
+// readCurrentManifestNum returns the file number of the primary's current
+// MANIFEST.
+func (d *DB) readCurrentManifestNum() (base.DiskFileNum, error) {
+	filename, err := atomicfs.ReadMarker(d.opts.FS, d.dirname, manifestMarkerName)
+	if err != nil {
+		return 0, err
+	}
+	fileType, fileNum, ok := base.ParseFilename(d.opts.FS, filename)
+	if !ok || fileType != fileTypeManifest {
+		return 0, base.CorruptionErrorf("pebble: MANIFEST name %q is malformed", errors.Safe(filename))
+	}
+	return fileNum, nil
+}
+
+// followerPinnedFilesLocked returns the names of the primary's files that the
+// follower may read: the local tables of all the versions and memtables in
+// use, the segments of the given WALs that are being replayed and the current
+// MANIFEST.
+//
+// d.mu must be held when calling this.
+func (d *DB) followerPinnedFilesLocked(wals wal.Logs) []string {
+	tables := make(map[base.DiskFileNum]struct{})
+	d.mu.versions.addLiveFileNums(tables)
+	logs := map[base.DiskFileNum]struct{}{d.follower.wal.num: {}}
+	for _, entry := range d.mu.mem.queue {
+		logs[entry.logNum] = struct{}{}
+		if f, ok := entry.flushable.(*ingestedFlushable); ok {
+			for _, file := range f.files {
+				tables[file.FileBacking.DiskFileNum] = struct{}{}
+			}
+		}
+	}
+	pinned := make([]string, 0, len(tables)+len(logs)+1)
+	for n := range tables {
+		if objstorage.IsLocalTable(d.objProvider, n) {
+			pinned = append(pinned, base.MakeFilename(fileTypeTable, n))
+		}
+	}
+	for _, ll := range wals {
+		if _, ok := logs[base.DiskFileNum(ll.Num)]; !ok {
+			continue
+		}
+		for i := 0; i < ll.NumSegments(); i++ {
+			fs, path := ll.SegmentLocation(i)
+			pinned = append(pinned, fs.PathBase(path))
+		}
+	}
+	pinned = append(pinned, base.MakeFilename(fileTypeManifest, d.follower.manifest.fileNum))
+	slices.Sort(pinned)
+	return pinned
+}
+
+// forgetObsoleteFilesLocked clears the lists of obsolete files of a follower.
+// The files belong to the primary, which is responsible for deleting them; the
+// follower only evicts the tables from the table cache.
+//
+// d.mu must be held when calling this. The function will release and
+// re-acquire the mutex.
+func (d *DB) forgetObsoleteFilesLocked() {
+	obsoleteTables := d.mu.versions.obsoleteTables
+	d.mu.versions.obsoleteTables = nil
+	for _, tbl := range obsoleteTables {
+		delete(d.mu.versions.zombieTables, tbl.FileNum)
+	}
+	d.mu.versions.obsoleteManifests = nil
+	d.mu.versions.obsoleteOptions = nil
+	d.mu.versions.updateObsoleteTableMetricsLocked()
+
+	d.mu.Unlock()
+	defer d.mu.Lock()
+	for _, tbl := range obsoleteTables {
+		d.tableCache.evict(tbl.FileNum)
+	}
+}
+
+// ignoreObsoleteBackings is used in place of versionSet.addObsolete for the
+// flushables of a follower.
+func ignoreObsoleteBackings([]*fileBacking) {}
+
+// initFollower initializes the version set of a follower with an empty
+// version. The version set is populated when the follower catches up with the
+// primary's manifest.
+func (vs *versionSet) initFollower(
+	dirname string,
+	provider objstorage.Provider,
+	opts *Options,
+	marker *atomicfs.Marker,
+	getFormatMajorVersion func() FormatMajorVersion,
+	mu *sync.Mutex,
+) {
+	vs.init(dirname, provider, opts, marker, getFormatMajorVersion, mu)
+	newVersion := &version{}
+	vs.append(newVersion)
+	vs.picker = newCompactionPicker(newVersion, &vs.virtualBackings, vs.opts, nil)
+}
+
+// followerEditLocked combines the version edits read from the primary's
+// manifest into a single version edit to apply to the current version. If
+// snapshot is set, the edits are those of an entire manifest, and the returned
+// edit transforms the current version into the version they describe.
+//
+// The new tables of the returned edit share the backings of the tables of the
+// current version with the same file numbers, as they would in the primary.
+// MinUnflushedLogNum, NextFileNum and LastSeqNum are set to the last values
+// found in the edits, if any.
+//
+// DB.mu must be held when calling this method.
+func (vs *versionSet) followerEditLocked(
+	edits []*versionEdit, snapshot bool,
+) (*versionEdit, error) {
+	curr := vs.currentVersion()
+	var bve bulkVersionEdit
+	bve.AddedByFileNum = make(map[base.FileNum]*fileMetadata)
+	// currFiles maps the file numbers of the tables of each level of the
+	// current version to their metadata.
+	var currFiles [numLevels]map[base.FileNum]*fileMetadata
+	// physicalBackings holds the backings of the physical tables of the current
+	// version and of those added by the edits.
+	physicalBackings := make(map[base.DiskFileNum]*fileBacking)
+	for level := range curr.Levels {
+		currFiles[level] = make(map[base.FileNum]*fileMetadata)
+		iter := curr.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			currFiles[level][f.FileNum] = f
+			if !snapshot {
+				bve.AddedByFileNum[f.FileNum] = f
+			}
+			if !f.Virtual {
+				physicalBackings[f.FileBacking.DiskFileNum] = f.FileBacking
+			}
+		}
+	}
+	existingBacking := func(n base.DiskFileNum) *fileBacking {
+		if b, ok := physicalBackings[n]; ok {
+			return b
+		}
+		if b, ok := vs.virtualBackings.Get(n); ok {
+			return b
+		}
+		return nil
+	}
+
+	result := &versionEdit{DeletedFiles: make(map[deletedFileEntry]*fileMetadata)}
+	for _, ve := range edits {
+		if ve.ComparerName != "" && ve.ComparerName != vs.cmp.Name {
+			return nil, errors.Errorf("pebble: manifest file for DB %q: "+
+				"comparer name from file %q != comparer name from Options %q",
+				vs.dirname, errors.Safe(ve.ComparerName), errors.Safe(vs.cmp.Name))
+		}
+		for i, b := range ve.CreatedBackingTables {
+			if existing := existingBacking(b.DiskFileNum); existing != nil {
+				// A physical table of the current version became virtual.
+				ve.CreatedBackingTables[i] = existing
+			}
+		}
+		for i := range ve.NewFiles {
+			nf := &ve.NewFiles[i]
+			if !nf.Meta.Virtual {
+				n := nf.Meta.FileBacking.DiskFileNum
+				if existing, ok := physicalBackings[n]; ok {
+					nf.Meta.FileBacking = existing
+				} else {
+					physicalBackings[n] = nf.Meta.FileBacking
+				}
+				continue
+			}
+			if _, ok := bve.AddedFileBacking[nf.BackingFileNum]; ok {
+				continue
+			}
+			if slices.ContainsFunc(ve.CreatedBackingTables, func(b *fileBacking) bool {
+				return b.DiskFileNum == nf.BackingFileNum
+			}) {
+				continue
+			}
+			// The virtual table shares the backing of a table of the current
+			// version.
+			if existing := existingBacking(nf.BackingFileNum); existing != nil {
+				nf.Meta.FileBacking = existing
+			}
+		}
+		if err := bve.Accumulate(ve); err != nil {
+			return nil, err
+		}
+		if ve.MinUnflushedLogNum != 0 {
+			result.MinUnflushedLogNum = ve.MinUnflushedLogNum
+		}
+		if ve.NextFileNum != 0 {
+			result.NextFileNum = ve.NextFileNum
+		}
+		if ve.LastSeqNum != 0 {
+			result.LastSeqNum = ve.LastSeqNum
+		}
+	}
+	if result.MinUnflushedLogNum != 0 && result.MinUnflushedLogNum <= vs.minUnflushedLogNum {
+		result.MinUnflushedLogNum = 0
+	}
+
+	for level := range bve.Added {
+		for fileNum, m := range bve.Added[level] {
+			if _, ok := currFiles[level][fileNum]; ok && snapshot {
+				continue
+			}
+			result.NewFiles = append(result.NewFiles, newFileEntry{Level: level, Meta: m})
+		}
+		if snapshot {
+			for fileNum, m := range currFiles[level] {
+				if _, ok := bve.Added[level][fileNum]; !ok {
+					result.DeletedFiles[deletedFileEntry{Level: level, FileNum: fileNum}] = m
+				}
+			}
+			continue
+		}
+		for fileNum, m := range bve.Deleted[level] {
+			result.DeletedFiles[deletedFileEntry{Level: level, FileNum: fileNum}] = m
+		}
+	}
+	for _, b := range bve.AddedFileBacking {
+		if _, ok := vs.virtualBackings.Get(b.DiskFileNum); !ok {
+			result.CreatedBackingTables = append(result.CreatedBackingTables, b)
+		}
+	}
+	return result, nil
+}
+
+// applyFollowerEditLocked applies a version edit built by followerEditLocked to
+// the current version and installs the new version, as logAndApply does on
+// the primary, without logging the edit.
+//
+// DB.mu must be held when calling this method.
+func (vs *versionSet) applyFollowerEditLocked(ve *versionEdit) error {
+	var b bulkVersionEdit
+	if err := b.Accumulate(ve); err != nil {
+		return err
+	}
+	newVersion, err := b.Apply(
+		vs.currentVersion(), vs.cmp, vs.opts.FlushSplitBytes, vs.opts.Experimental.ReadCompactionRate,
+	)
+	if err != nil {
+		return err
+	}
+	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
+
+	zombieBackings, removedVirtualBackings, liveSizeDelta :=
+		getZombiesAndUpdateVirtualBackings(ve, &vs.virtualBackings, vs.provider)
+	for _, b := range zombieBackings {
+		vs.zombieTables[b.backing.DiskFileNum] = tableInfo{
+			fileInfo: fileInfo{
+				FileNum:  b.backing.DiskFileNum,
+				FileSize: b.backing.Size,
+			},
+			isLocal: b.isLocal,
+		}
+	}
+	var obsoleteVirtualBackings []*fileBacking
+	for _, b := range removedVirtualBackings {
+		if b.backing.Unref() == 0 {
+			obsoleteVirtualBackings = append(obsoleteVirtualBackings, b.backing)
+		}
+	}
+	vs.addObsoleteLocked(obsoleteVirtualBackings)
+
+	vs.append(newVersion)
+	if ve.MinUnflushedLogNum != 0 {
+		vs.minUnflushedLogNum = ve.MinUnflushedLogNum
+	}
+	vs.updateLevelMetricsLocked(newVersion)
+	vs.updateLiveSizeMetricsLocked(liveSizeDelta)
+	vs.picker = newCompactionPicker(newVersion, &vs.virtualBackings, vs.opts, nil)
+	return nil
+}
+
+// readManifestTail reads the version edits of the given manifest that start at
+// or after offset, stopping at the first record that has not been written in
+// full. It returns the offset that follows the last edit read.
+func readManifestTail(
+	fs vfs.FS, dirname string, fileNum base.DiskFileNum, offset int64,
+) (edits []*versionEdit, _ int64, err error) {
+	file, err := fs.Open(base.MakeFilepath(fs, dirname, fileTypeManifest, fileNum))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	rr, err := record.NewReaderAt(io.NewSectionReader(file, 0, math.MaxInt64), 0 /* logNum */, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	for {
+		r, err := rr.Next()
+		var buf []byte
+		if err == nil {
+			buf, err = io.ReadAll(r)
+		}
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			return edits, offset, nil
+		} else if err != nil {
+			return nil, 0, errors.Wrapf(err, "pebble: error when reading manifest file %s", fileNum)
+		}
+		ve := &versionEdit{}
+		if err := ve.Decode(bytes.NewReader(buf)); err != nil {
+			return nil, 0, err
+		}
+		edits = append(edits, ve)
+		offset = rr.Offset()
+	}
+}
+
+// readWALTailSeqNum returns the sequence number that follows the batches of
+// the given WAL that start at or after offset.
+func readWALTailSeqNum(ll wal.LogicalLog, offset int64) (uint64, error) {
+	fs, path := ll.SegmentLocation(ll.NumSegments() - 1)
+	rr, err := newFollowerWALReader(fs, path, base.DiskFileNum(ll.Num), offset)
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+	var seqNum uint64
+	for {
+		r, _, err := rr.NextRecord()
+		var buf []byte
+		if err == nil {
+			buf, err = io.ReadAll(r)
+		}
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			return seqNum, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if h, ok := batchrepr.ReadHeader(buf); ok {
+			seqNum = max(seqNum, h.SeqNum+uint64(h.Count))
+		}
+	}
+}
+
+// followerWALReader reads the records of a WAL of the primary, starting at a
+// given offset. A record that has not been written in full is treated as an
+// invalid record, which ends the replay of the WAL; the WAL is read again from
+// the offset of that record when the follower next catches up.
+type followerWALReader struct {
+	file vfs.File
+	path string
+	rr   *record.Reader
+	// start is the offset of the last record returned by NextRecord, and
+	// complete is whether that record has been read in full.
+	start    int64
+	complete bool
+}
+
+var _ wal.Reader = (*followerWALReader)(nil)
+
+func newFollowerWALReader(
+	fs vfs.FS, path string, logNum base.DiskFileNum, offset int64,
+) (*followerWALReader, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := record.NewReaderAt(io.NewSectionReader(file, 0, math.MaxInt64), logNum, offset)
+	if err != nil {
+		return nil, errors.CombineErrors(err, file.Close())
+	}
+	return &followerWALReader{file: file, path: path, rr: rr, start: offset, complete: true}, nil
+}
+
+// NextRecord implements wal.Reader.
+func (r *followerWALReader) NextRecord() (io.Reader, wal.Offset, error) {
+	if r.complete {
+		r.start = r.rr.Offset()
+	}
+	r.complete = false
+	rec, err := r.rr.Next()
+	if err != nil {
+		return nil, wal.Offset{}, err
+	}
+	return followerWALRecord{r: r, rec: rec}, wal.Offset{PhysicalFile: r.path, Physical: r.start}, nil
+}
+
+// Close implements wal.Reader.
+func (r *followerWALReader) Close() error {
+	return r.file.Close()
+}
+
+// offset returns the offset up to which the WAL has been replayed.
+func (r *followerWALReader) offset() int64 {
+	if r.complete {
+		return r.rr.Offset()
+	}
+	return r.start
+}
+
+// followerWALTail holds the records appended to a WAL of the primary since
+// the follower last replayed it, which are read without holding DB.mu. A WAL
+// is bounded by the size of a memtable of the primary.
+type followerWALTail struct {
+	num  base.DiskFileNum
+	path string
+	// records holds the records read in full, and offsets the offset of each
+	// record in the WAL.
+	records [][]byte
+	offsets []int64
+	// end is the offset up to which the WAL has been read.
+	end int64
+}
+
+// readFollowerWALTail reads the records of the given WAL that start at or
+// after offset, stopping at any record the primary is still writing.
+func readFollowerWALTail(ll wal.LogicalLog, offset int64) (*followerWALTail, error) {
+	fs, path := ll.SegmentLocation(ll.NumSegments() - 1)
+	rr, err := newFollowerWALReader(fs, path, base.DiskFileNum(ll.Num), offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Close()
+	t := &followerWALTail{num: base.DiskFileNum(ll.Num), path: path}
+	for {
+		r, off, err := rr.NextRecord()
+		var buf []byte
+		if err == nil {
+			buf, err = io.ReadAll(r)
+		}
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		t.records = append(t.records, buf)
+		t.offsets = append(t.offsets, off.Physical)
+	}
+	t.end = rr.offset()
+	return t, nil
+}
+
+// followerWALTailReader is a wal.Reader over the records of a followerWALTail.
+type followerWALTailReader struct {
+	t *followerWALTail
+	i int
+}
+
+var _ wal.Reader = (*followerWALTailReader)(nil)
+
+// NextRecord implements wal.Reader.
+func (r *followerWALTailReader) NextRecord() (io.Reader, wal.Offset, error) {
+	if r.i == len(r.t.records) {
+		return nil, wal.Offset{}, io.EOF
+	}
+	i := r.i
+	r.i++
+	return bytes.NewReader(r.t.records[i]), wal.Offset{PhysicalFile: r.t.path, Physical: r.t.offsets[i]}, nil
+}
+
+// Close implements wal.Reader.
+func (r *followerWALTailReader) Close() error {
+	return nil
+}
+
+type followerWALRecord struct {
+	r   *followerWALReader
+	rec io.Reader
+}
+
+func (x followerWALRecord) Read(p []byte) (int, error) {
+	n, err := x.rec.Read(p)
+	if err == io.EOF {
+		x.r.complete = true
+	}
+	return n, err
+}
+
+// writeLease atomically replaces the follower's lease, if any, by one renewed
+// at the given time that pins the given files.
+func (f *followerState) writeLease(fs vfs.FS, renewed time.Time, pinned []string) error {
+	if f.leasePath == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(strconv.FormatInt(renewed.UnixNano(), 10))
+	buf.WriteByte('\n')
+	for _, name := range pinned {
+		buf.WriteString(name)
+		buf.WriteByte('\n')
+	}
+	tmpPath := f.leasePath + ".tmp"
+	file, err := fs.Create(tmpPath, vfs.WriteCategoryUnspecified)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return errors.CombineErrors(err, file.Close())
+	}
+	if err := file.Sync(); err != nil {
+		return errors.CombineErrors(err, file.Close())
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, f.leasePath)
+}
+
+// removeLease removes the follower's lease, if any.
+func (f *followerState) removeLease(fs vfs.FS) error {
+	if f.leasePath == "" {
+		return nil
+	}
+	if err := fs.Remove(f.leasePath); err != nil && !oserror.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// followerLease is a lease read by a FollowerCleaner.
+type followerLease struct {
+	renewed time.Time
+	pinned  map[string]struct{}
+}
+
+// parseFollowerLease parses the contents of a lease written by
+// followerState.writeLease.
+func parseFollowerLease(data []byte) (followerLease, bool) {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	nanos, err := strconv.ParseInt(lines[0], 10, 64)
+	if err != nil {
+		return followerLease{}, false
+	}
+	l := followerLease{
+		renewed: time.Unix(0, nanos),
+		pinned:  make(map[string]struct{}, len(lines)-1),
+	}
+	for _, name := range lines[1:] {
+		l.pinned[name] = struct{}{}
+	}
+	return l, true
+}
+
+// FollowerCleaner is a Cleaner for a primary DB whose files are read by
+// followers (see OpenFollower). It defers cleaning the tables, WALs and
+// manifests that followers may still read, as indicated by the leases they
+// write into the primary's directory (see FollowerOptions.LeaseName).
+//
+// A file that the primary asks to clean at time t is cleaned once every lease
+// renewed within the last LeaseTTL was renewed after t and does not name the
+// file. The clocks of the primary and its followers are assumed to be
+// synchronized. Deferred files are cleaned by subsequent calls to Clean; those
+// still deferred when the primary is closed are cleaned after it is reopened.
+//
+// A FollowerCleaner must not be copied after first use.
+type FollowerCleaner struct {
+	// Dirname is the primary's data directory, in which followers write their
+	// leases.
+	Dirname string
+	// LeaseTTL is the duration after which a lease that has not been renewed
+	// no longer pins any file. Defaults to one minute.
+	LeaseTTL time.Duration
+	// Cleaner cleans the files once they are no longer pinned. Defaults to
+	// DeleteCleaner.
+	Cleaner Cleaner
+
+	mu struct {
+		sync.Mutex
+		deferred []deferredClean
+	}
+}
+
+// deferredClean is a file whose cleaning was deferred by a FollowerCleaner.
+type deferredClean struct {
+	fs       vfs.FS
+	fileType base.FileType
+	path     string
+	// cleaned is the time at which the primary asked to clean the file.
+	cleaned time.Time
+}
+
+var _ Cleaner = (*FollowerCleaner)(nil)
+
+// Clean implements Cleaner. It also cleans the previously deferred files that
+// are no longer pinned.
+func (c *FollowerCleaner) Clean(fs vfs.FS, fileType base.FileType, path string) error {
+	cleaner := c.Cleaner
+	if cleaner == nil {
+		cleaner = DeleteCleaner{}
+	}
+	switch fileType {
+	case fileTypeTable, fileTypeLog, fileTypeManifest:
+	default:
+		return cleaner.Clean(fs, fileType, path)
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.deferred = append(c.mu.deferred, deferredClean{
+		fs:       fs,
+		fileType: fileType,
+		path:     path,
+		cleaned:  now,
+	})
+	leases, err := c.readLeases(fs, now)
+	if err != nil {
+		return err
+	}
+	remaining := c.mu.deferred[:0]
+	for _, f := range c.mu.deferred {
+		name := f.fs.PathBase(f.path)
+		if slices.ContainsFunc(leases, func(l followerLease) bool {
+			_, ok := l.pinned[name]
+			return ok || !l.renewed.After(f.cleaned)
+		}) {
+			remaining = append(remaining, f)
+			continue
+		}
+		if cleanErr := cleaner.Clean(f.fs, f.fileType, f.path); cleanErr != nil && !oserror.IsNotExist(cleanErr) {
+			err = errors.CombineErrors(err, cleanErr)
+		}
+	}
+	c.mu.deferred = remaining
+	return err
+}
+
+// readLeases returns the leases in Dirname that were renewed within the last
+// LeaseTTL. Leases that cannot be parsed are ignored.
+func (c *FollowerCleaner) readLeases(fs vfs.FS, now time.Time) ([]followerLease, error) {
+	ttl := c.LeaseTTL
+	if ttl <= 0 {
+		ttl = defaultFollowerLeaseTTL
+	}
+	ls, err := fs.List(c.Dirname)
+	if err != nil {
+		return nil, err
+	}
+	var leases []followerLease
+	for _, name := range ls {
+		if !strings.HasPrefix(name, followerLeasePrefix) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		file, err := fs.Open(fs.PathJoin(c.Dirname, name))
+		if oserror.IsNotExist(err) {
+			// The follower was closed.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(file)
+		err = errors.CombineErrors(err, file.Close())
+		if err != nil {
+			return nil, err
+		}
+		if l, ok := parseFollowerLease(data); ok && now.Sub(l.renewed) <= ttl {
+			leases = append(leases, l)
+		}
+	}
+	return leases, nil
+}
+
+// String implements fmt.Stringer.
+func (c *FollowerCleaner) String() string {
+	return "follower"
+}
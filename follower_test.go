@@ -0,0 +1,191 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollower(t *testing.T) {
+	mem := vfs.NewMem()
+	cleaner := &FollowerCleaner{Dirname: ""}
+	opts := &Options{
+		FS:                          mem,
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+		Cleaner:                     cleaner,
+	}
+	primary, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+
+	set := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, primary.Set([]byte(fmt.Sprintf("%s%03d", prefix, i)), []byte(prefix), nil))
+		}
+	}
+	// One flushed table, and keys that are only in the WAL.
+	set("a", 10)
+	require.NoError(t, primary.Flush())
+	set("b", 10)
+
+	follower, err := OpenFollower("", &Options{FS: mem}, FollowerOptions{LeaseName: "f1"})
+	require.NoError(t, err)
+	followerClosed := false
+	defer func() {
+		if !followerClosed {
+			require.NoError(t, follower.Close())
+		}
+	}()
+
+	// scan returns the number of keys with each prefix in the follower.
+	scan := func() string {
+		counts := make(map[byte]int)
+		var prefixes []byte
+		for _, kv := range strings.Fields(dumpDBContents(t, follower, KeyRange{})) {
+			// Each key is set to its prefix.
+			require.Equal(t, kv[:1], kv[strings.IndexByte(kv, '=')+1:])
+			if counts[kv[0]] == 0 {
+				prefixes = append(prefixes, kv[0])
+			}
+			counts[kv[0]]++
+		}
+		var buf strings.Builder
+		for _, p := range prefixes {
+			fmt.Fprintf(&buf, "%c:%d ", p, counts[p])
+		}
+		return strings.TrimSpace(buf.String())
+	}
+	lag := func() uint64 {
+		n, err := follower.FollowerLag()
+		require.NoError(t, err)
+		return n
+	}
+	require.Equal(t, "a:10 b:10", scan())
+	require.Equal(t, uint64(0), lag())
+
+	// Writes to the primary are visible once the follower catches up.
+	set("c", 5)
+	require.Equal(t, uint64(5), lag())
+	require.Equal(t, "a:10 b:10", scan())
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "a:10 b:10 c:5", scan())
+	require.Equal(t, uint64(0), lag())
+
+	// The follower picks up flushes and compactions, and the WALs that follow
+	// them.
+	require.NoError(t, primary.Flush())
+	set("d", 3)
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "a:10 b:10 c:5 d:3", scan())
+	require.NoError(t, primary.Compact([]byte("a"), []byte("e"), false))
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "a:10 b:10 c:5 d:3", scan())
+	require.Equal(t, primary.Metrics().Levels[numLevels-1].NumFiles,
+		follower.Metrics().Levels[numLevels-1].NumFiles)
+
+	// The lease of the follower pins the tables it reads: the tables the
+	// compaction below makes obsolete are not deleted until the follower
+	// catches up.
+	tables := func(d *DB) []string {
+		sstables, err := d.SSTables()
+		require.NoError(t, err)
+		var names []string
+		for _, l := range sstables {
+			for _, info := range l {
+				names = append(names, base.MakeFilename(fileTypeTable, info.BackingSSTNum))
+			}
+		}
+		return names
+	}
+	pinned := tables(follower)
+	require.NotEmpty(t, pinned)
+	set("e", 3)
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.Compact([]byte("a"), []byte("f"), false))
+	primary.cleanupManager.Wait()
+	for _, name := range pinned {
+		_, err := mem.Stat(name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, follower.CatchUp())
+	require.Equal(t, "a:10 b:10 c:5 d:3 e:3", scan())
+	require.NotEqual(t, pinned, tables(follower))
+
+	// Once the follower is closed, its lease no longer pins any file.
+	require.NoError(t, follower.Close())
+	followerClosed = true
+	set("a", 3)
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.Compact([]byte("a"), []byte("f"), false))
+	primary.cleanupManager.Wait()
+	for _, name := range pinned {
+		_, err := mem.Stat(name)
+		require.True(t, oserror.IsNotExist(err), "%s: %v", name, err)
+	}
+
+	_, err = OpenFollower("", &Options{FS: mem}, FollowerOptions{LeaseName: "a/b"})
+	require.Error(t, err)
+}
+
+// TestFollowerCatchUpReadsWithoutMutex tests that a follower reads the
+// primary's WALs without holding DB.mu while catching up.
+func TestFollowerCatchUpReadsWithoutMutex(t *testing.T) {
+	mem := vfs.NewMem()
+	primary, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+	require.NoError(t, primary.Set([]byte("a"), []byte("a"), nil))
+
+	fs := &walOpenBlockingFS{FS: mem, opened: make(chan struct{}), unblock: make(chan struct{})}
+	follower, err := OpenFollower("", &Options{FS: fs}, FollowerOptions{})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, follower.Close()) }()
+
+	require.NoError(t, primary.Set([]byte("b"), []byte("b"), nil))
+	fs.block.Store(true)
+	errCh := make(chan error, 1)
+	go func() { errCh <- follower.CatchUp() }()
+	<-fs.opened
+	// The catch up is blocked reading the WAL; the follower still serves
+	// metrics and reads of the state as of the previous catch up.
+	_ = follower.Metrics()
+	_, closer, err := follower.Get([]byte("b"))
+	require.ErrorIs(t, err, ErrNotFound)
+	if closer != nil {
+		require.NoError(t, closer.Close())
+	}
+	close(fs.unblock)
+	require.NoError(t, <-errCh)
+	v, closer, err := follower.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), v)
+	require.NoError(t, closer.Close())
+}
+
+// walOpenBlockingFS blocks the first opening of a WAL after block is set until
+// unblock is closed.
+type walOpenBlockingFS struct {
+	vfs.FS
+	block   atomic.Bool
+	opened  chan struct{}
+	unblock chan struct{}
+}
+
+func (fs *walOpenBlockingFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	if strings.HasSuffix(name, ".log") && fs.block.CompareAndSwap(true, false) {
+		close(fs.opened)
+		<-fs.unblock
+	}
+	return fs.FS.Open(name, opts...)
+}
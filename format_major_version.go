@@ -185,6 +185,17 @@ const (
 	// fields in the Manifest and thus requires a format major version.
 	FormatSyntheticPrefixSuffix
 
+	// FormatNamedSnapshots is a format major version that adds support for
+	// named snapshots, which persist across restarts. The named snapshots are
+	// recorded in new, backward-incompatible fields in the Manifest, and
+	// therefore require a format major version.
+	FormatNamedSnapshots
+
+	// FormatWALCompression is a format major version that adds support for
+	// compressed WAL records (see Options.WALCompression). WALs containing
+	// compressed records cannot be read by earlier versions.
+	FormatWALCompression
+
 	// TODO(msbutler): add major version for synthetic suffixes
 
 	// -- Add new versions here --
@@ -222,7 +233,8 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 	switch v {
 	case FormatDefault, FormatFlushableIngest, FormatPrePebblev1MarkedCompacted:
 		return sstable.TableFormatPebblev3
-	case FormatDeleteSizedAndObsolete, FormatVirtualSSTables, FormatSyntheticPrefixSuffix:
+	case FormatDeleteSizedAndObsolete, FormatVirtualSSTables, FormatSyntheticPrefixSuffix,
+		FormatNamedSnapshots, FormatWALCompression:
 		return sstable.TableFormatPebblev4
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -234,7 +246,8 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 func (v FormatMajorVersion) MinTableFormat() sstable.TableFormat {
 	switch v {
 	case FormatDefault, FormatFlushableIngest, FormatPrePebblev1MarkedCompacted,
-		FormatDeleteSizedAndObsolete, FormatVirtualSSTables, FormatSyntheticPrefixSuffix:
+		FormatDeleteSizedAndObsolete, FormatVirtualSSTables, FormatSyntheticPrefixSuffix,
+		FormatNamedSnapshots, FormatWALCompression:
 		return sstable.TableFormatPebblev1
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -271,6 +284,12 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 	FormatSyntheticPrefixSuffix: func(d *DB) error {
 		return d.finalizeFormatVersUpgrade(FormatSyntheticPrefixSuffix)
 	},
+	FormatNamedSnapshots: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatNamedSnapshots)
+	},
+	FormatWALCompression: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatWALCompression)
+	},
 }
 
 const formatVersionMarkerName = `format-version`
@@ -0,0 +1,227 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// historySample records that every key written after time was assigned a
+// sequence number of at least seqNum. Samples taken by the DB record the
+// visible sequence number at that time, so that seqNum is also the sequence
+// number of a snapshot of the DB state at that time.
+type historySample struct {
+	time   time.Time
+	seqNum uint64
+}
+
+func (h *HistoryRetentionPolicy) enabled() bool {
+	return h.Duration > 0 || h.SeqNums > 0
+}
+
+// initHistoryLocked seeds the mapping from wall time to sequence numbers when
+// opening the DB. Every table bounds the sequence numbers of the keys written
+// after it was created, which provides a coarse mapping for the period before
+// the DB was opened.
+//
+// d.mu must be held when calling this.
+func (d *DB) initHistoryLocked() {
+	if !d.opts.Experimental.HistoryRetention.enabled() {
+		return
+	}
+	var samples []historySample
+	v := d.mu.versions.currentVersion()
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.CreationTime != 0 {
+				samples = append(samples, historySample{
+					time:   time.Unix(f.CreationTime, 0),
+					seqNum: f.LargestSeqNum + 1,
+				})
+			}
+		}
+	}
+	slices.SortFunc(samples, func(a, b historySample) int {
+		return a.time.Compare(b.time)
+	})
+	// A sample also bounds the keys written after any later sample, so the
+	// sequence numbers of the samples can be made non-decreasing.
+	for i := 1; i < len(samples); i++ {
+		samples[i].seqNum = max(samples[i].seqNum, samples[i-1].seqNum)
+	}
+	d.mu.history.samples = samples
+	d.recordHistorySampleLocked()
+}
+
+// runHistoryLoop records the visible sequence number every
+// HistoryRetention.SampleInterval, until the DB is closed.
+func (d *DB) runHistoryLoop() {
+	ticker := time.NewTicker(d.opts.Experimental.HistoryRetention.SampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		if d.closed.Load() == nil {
+			d.recordHistorySampleLocked()
+		}
+		d.mu.Unlock()
+	}
+}
+
+// recordHistorySampleLocked records the visible sequence number at the
+// current time, and discards the samples that precede the history retention
+// window.
+//
+// d.mu must be held when calling this.
+func (d *DB) recordHistorySampleLocked() {
+	s := historySample{
+		time:   d.timeNow(),
+		seqNum: d.mu.versions.visibleSeqNum.Load(),
+	}
+	samples := d.mu.history.samples
+	// The sample is only recorded if something was written since the last
+	// one, which otherwise still describes the current state. Samples must
+	// also be ordered by time, which the wall clock does not guarantee.
+	if n := len(samples); n == 0 || (samples[n-1].seqNum < s.seqNum && samples[n-1].time.Before(s.time)) {
+		samples = append(samples, s)
+	}
+	d.mu.history.samples = samples
+
+	// Keep the latest sample at or before the retention boundary, and the
+	// latest sample at or before the start of the retention window, which
+	// determines the boundary.
+	r := d.historyRetentionSeqNumLocked()
+	keep := sort.Search(len(samples), func(i int) bool {
+		return samples[i].seqNum > r
+	}) - 1
+	if h := &d.opts.Experimental.HistoryRetention; h.Duration > 0 {
+		start := s.time.Add(-h.Duration)
+		keep = min(keep, sort.Search(len(samples), func(i int) bool {
+			return samples[i].time.After(start)
+		})-1)
+	}
+	if keep > 0 {
+		d.mu.history.samples = slices.Delete(samples, 0, keep)
+	}
+}
+
+// seqNumAtLocked returns the sequence number of the DB state at the given
+// time, according to the latest sample at or before it. It returns false if
+// there is no such sample.
+//
+// d.mu must be held when calling this.
+func (d *DB) seqNumAtLocked(t time.Time) (uint64, bool) {
+	samples := d.mu.history.samples
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].time.After(t)
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return samples[i-1].seqNum, true
+}
+
+// historyRetentionSeqNumLocked returns the sequence number from which every
+// version is retained by compactions, or zero if history retention is
+// disabled.
+//
+// d.mu must be held when calling this.
+func (d *DB) historyRetentionSeqNumLocked() uint64 {
+	h := &d.opts.Experimental.HistoryRetention
+	if !h.enabled() {
+		return 0
+	}
+	visible := d.mu.versions.visibleSeqNum.Load()
+	r := visible
+	if h.SeqNums > 0 {
+		r = visible - min(visible, h.SeqNums)
+	}
+	if h.Duration > 0 {
+		// If no sample is old enough, the sequence numbers written within the
+		// window are unknown, and all history is retained.
+		seqNum, _ := d.seqNumAtLocked(d.timeNow().Add(-h.Duration))
+		r = min(r, seqNum)
+	}
+	return max(r, 1)
+}
+
+// earliestRetainedSeqNumLocked returns the earliest sequence number whose
+// state must be preserved by compactions, due to either an open snapshot or
+// history retention.
+//
+// d.mu must be held when calling this.
+func (d *DB) earliestRetainedSeqNumLocked() uint64 {
+	e := d.mu.snapshots.earliest()
+	if r := d.historyRetentionSeqNumLocked(); r != 0 {
+		e = min(e, r)
+	}
+	return e
+}
+
+// insertSnapshot returns the snapshot sequence numbers with seqNum added, in
+// ascending order.
+func insertSnapshot(snapshots []uint64, seqNum uint64) []uint64 {
+	i, found := slices.BinarySearch(snapshots, seqNum)
+	if found {
+		return snapshots
+	}
+	return slices.Insert(snapshots, i, seqNum)
+}
+
+// SeqNumAt returns the sequence number of the DB state at the given time, for
+// use with NewSnapshotAt. The state is that of the latest recorded sample at
+// or before t, so it may precede t by up to HistoryRetention.SampleInterval
+// (or more, for times before the DB was opened). An error is returned if the
+// state at t is not retained.
+func (d *DB) SeqNumAt(t time.Time) (uint64, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if !d.opts.Experimental.HistoryRetention.enabled() {
+		return 0, errors.New("pebble: history retention is disabled")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !t.Before(d.timeNow()) {
+		return d.mu.versions.visibleSeqNum.Load(), nil
+	}
+	seqNum, ok := d.seqNumAtLocked(t)
+	if !ok || seqNum < d.historyRetentionSeqNumLocked() {
+		return 0, errors.Errorf("pebble: history at %s is not retained", t)
+	}
+	return seqNum, nil
+}
+
+// NewSnapshotAt returns a point-in-time view of the DB state at the given
+// sequence number, which must be within the history retention window (see
+// Options.Experimental.HistoryRetention). The returned Snapshot keeps the
+// state at seqNum readable after the window moves past it, until it is
+// closed.
+func (d *DB) NewSnapshotAt(seqNum uint64) (*Snapshot, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if visible := d.mu.versions.visibleSeqNum.Load(); seqNum > visible {
+		return nil, errors.Errorf("pebble: sequence number %d is not visible (visible sequence number: %d)",
+			seqNum, visible)
+	}
+	if r := d.historyRetentionSeqNumLocked(); r == 0 || seqNum < r {
+		return nil, errors.Errorf("pebble: history at sequence number %d is not retained", seqNum)
+	}
+	s := &Snapshot{db: d, seqNum: seqNum}
+	d.mu.snapshots.insertSorted(s)
+	return s, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRetention(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.HistoryRetention = HistoryRetentionPolicy{
+		Duration: 10 * time.Minute,
+		// Samples are recorded explicitly by the test.
+		SampleInterval: time.Hour,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	now := time.Now()
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return now }
+	d.mu.Unlock()
+	advance := func(dur time.Duration) time.Time {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		now = now.Add(dur)
+		d.recordHistorySampleLocked()
+		return now
+	}
+	get := func(r Reader, key string) string {
+		v, closer, err := r.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	readAt := func(seqNum uint64, key string) string {
+		s, err := d.NewSnapshotAt(seqNum)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		return get(s, key)
+	}
+	numEntries := func() uint64 {
+		tables, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		var n uint64
+		for _, level := range tables {
+			for _, info := range level {
+				n += info.Properties.NumEntries
+			}
+		}
+		return n
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	t1 := advance(time.Minute)
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	t2 := advance(time.Minute)
+	require.NoError(t, d.Set([]byte("a"), []byte("3"), nil))
+	advance(time.Minute)
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), true))
+
+	// Every version is within the retention window, and survives the
+	// compaction.
+	require.Equal(t, uint64(3), numEntries())
+	seq1, err := d.SeqNumAt(t1)
+	require.NoError(t, err)
+	seq2, err := d.SeqNumAt(t2)
+	require.NoError(t, err)
+	require.Less(t, seq1, seq2)
+	require.Equal(t, "1", readAt(seq1, "a"))
+	require.Equal(t, "2", readAt(seq2, "a"))
+	require.Equal(t, "3", get(d, "a"))
+	_, err = d.NewSnapshotAt(d.mu.versions.visibleSeqNum.Load() + 1)
+	require.Error(t, err)
+
+	// Once the window moves past t1, the state at t1 is no longer retained and
+	// compactions collapse the versions that only it observed.
+	advance(9 * time.Minute)
+	_, err = d.SeqNumAt(t1)
+	require.Error(t, err)
+	_, err = d.NewSnapshotAt(seq1)
+	require.Error(t, err)
+	require.Equal(t, "2", readAt(seq2, "a"))
+	require.NoError(t, d.Set([]byte("a"), []byte("4"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), true))
+	require.Equal(t, uint64(3), numEntries())
+	require.Equal(t, "2", readAt(seq2, "a"))
+}
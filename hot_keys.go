@@ -0,0 +1,466 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"container/heap"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hotKeysReportCount is the maximum number of keys, and of key prefixes,
+// reported by an EventListener.HotKeysDetected event or by Metrics.HotKeys.
+const hotKeysReportCount = 10
+
+// HotKeyStats holds the sampled access statistics of a key or key range.
+type HotKeyStats struct {
+	// Reads and Writes are the estimated numbers of reads and writes over the
+	// measured period: the sampled counts multiplied by the sample rate.
+	Reads, Writes uint64
+	// Error bounds the overestimation of Reads+Writes. A key that enters the
+	// sketch after another key is evicted inherits the count of the evicted
+	// key as its error.
+	Error uint64
+	// ReadRate and WriteRate are the estimated numbers of reads and writes per
+	// second over the measured period.
+	ReadRate, WriteRate float64
+}
+
+// HotKey describes a frequently accessed key. See DB.HotKeys.
+type HotKey struct {
+	Key []byte
+	HotKeyStats
+}
+
+// HotRange describes a frequently accessed range of keys: the keys sharing a
+// prefix, as determined by Comparer.Split. See DB.HotRanges.
+type HotRange struct {
+	// Start is the prefix, and End is the immediate successor of the prefix.
+	Start, End []byte
+	HotKeyStats
+}
+
+// HotKeys returns up to n of the most frequently read and written keys, in
+// decreasing order of frequency, as estimated from samples of the reads and
+// writes over the last one to two windows. It returns nil if hot key tracking
+// is disabled (see Options.Experimental.HotKeys).
+func (d *DB) HotKeys(n int) []HotKey {
+	if d.hotKeys == nil {
+		return nil
+	}
+	return d.hotKeys.hotKeys(n)
+}
+
+// HotRanges returns up to n of the most frequently read and written key
+// prefixes, as determined by Comparer.Split, in decreasing order of frequency.
+// For an MVCC keyspace, a range holds the versions of a key. See HotKeys.
+func (d *DB) HotRanges(n int) []HotRange {
+	if d.hotKeys == nil {
+		return nil
+	}
+	return d.hotKeys.hotRanges(n)
+}
+
+// hotKeyTracker samples reads and writes into heavy-hitters sketches. Rates
+// are measured over two consecutive windows: samples are recorded in the
+// current window, and the previous window is dropped when the current one
+// ends.
+type hotKeyTracker struct {
+	opts     HotKeyOptions
+	comparer *Comparer
+	timeNow  func() time.Time
+	// onReport is invoked with the keys and key prefixes of a window whose
+	// rates reach ReportThreshold, when the window ends. It is invoked
+	// asynchronously, in order, by a goroutine that delivers the pending
+	// reports, so that reads and commits do not wait on the event listener.
+	onReport func(HotKeysInfo)
+	// reports tracks the goroutine delivering reports, if any.
+	reports sync.WaitGroup
+
+	// reads and writes count the reads and written keys, so that every
+	// SampleRate-th one is sampled.
+	reads, writes atomic.Uint64
+
+	mu struct {
+		sync.Mutex
+		windowStart time.Time
+		// cur and prev are the sketches of the current and previous windows.
+		cur, prev hotKeyWindow
+		// pending holds the reports not yet delivered to onReport, and
+		// reporting is set while a goroutine is delivering them.
+		pending   []HotKeysInfo
+		reporting bool
+	}
+}
+
+// hotKeyWindow holds the sketches of a window.
+type hotKeyWindow struct {
+	keys, prefixes hotKeySketch
+	// duration is the length of a window that has ended.
+	duration time.Duration
+}
+
+func newHotKeyTracker(
+	opts HotKeyOptions, comparer *Comparer, timeNow func() time.Time, onReport func(HotKeysInfo),
+) *hotKeyTracker {
+	t := &hotKeyTracker{
+		opts:     opts,
+		comparer: comparer,
+		timeNow:  timeNow,
+		onReport: onReport,
+	}
+	t.mu.windowStart = timeNow()
+	t.mu.cur = t.newWindow()
+	t.mu.prev = t.newWindow()
+	return t
+}
+
+func (t *hotKeyTracker) newWindow() hotKeyWindow {
+	return hotKeyWindow{
+		keys:     makeHotKeySketch(t.opts.Capacity),
+		prefixes: makeHotKeySketch(t.opts.Capacity),
+	}
+}
+
+// sampleRead records a read of the key, if it is sampled.
+func (t *hotKeyTracker) sampleRead(key []byte) {
+	if t.reads.Add(1)%uint64(t.opts.SampleRate) != 0 {
+		return
+	}
+	t.record(key, false /* write */)
+}
+
+// sampleBatch records the sampled keys written by a committed batch.
+func (t *hotKeyTracker) sampleBatch(b *Batch) {
+	count := uint64(b.Count())
+	if count == 0 {
+		return
+	}
+	rate := uint64(t.opts.SampleRate)
+	n := t.writes.Add(count)
+	// The batch holds the written keys numbered (n-count, n]. Sample those
+	// numbered by a multiple of the sample rate.
+	next := (n - count + rate) / rate * rate
+	if next > n {
+		return
+	}
+	r := b.Reader()
+	for i := n - count + 1; next <= n; {
+		kind, ukey, _, ok, err := r.Next()
+		if !ok || err != nil {
+			return
+		}
+		if kind == InternalKeyKindLogData {
+			continue
+		}
+		if i == next {
+			t.record(ukey, true /* write */)
+			next += rate
+		}
+		i++
+	}
+}
+
+func (t *hotKeyTracker) record(key []byte, write bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maybeEndWindowLocked(t.timeNow())
+	t.mu.cur.keys.add(key, write)
+	t.mu.cur.prefixes.add(key[:t.comparer.Split(key)], write)
+}
+
+// maybeEndWindowLocked ends the current window if it is over, and queues the
+// info to report about the ended window, if any. t.mu must be held.
+func (t *hotKeyTracker) maybeEndWindowLocked(now time.Time) {
+	elapsed := now.Sub(t.mu.windowStart)
+	if elapsed < t.opts.Window {
+		return
+	}
+	if t.opts.ReportThreshold > 0 {
+		if report := t.reportLocked(elapsed); report != nil {
+			t.queueReportLocked(report)
+		}
+	}
+	t.mu.prev, t.mu.cur = t.mu.cur, t.mu.prev
+	t.mu.prev.duration = elapsed
+	if elapsed >= 2*t.opts.Window {
+		// The window ended long ago, so its samples are stale.
+		t.mu.prev.keys.reset()
+		t.mu.prev.prefixes.reset()
+		t.mu.prev.duration = 0
+	}
+	t.mu.cur.keys.reset()
+	t.mu.cur.prefixes.reset()
+	t.mu.windowStart = now
+}
+
+// queueReportLocked queues a report for delivery to onReport, starting a
+// goroutine to deliver the pending reports if there is none. t.mu must be
+// held.
+func (t *hotKeyTracker) queueReportLocked(report *HotKeysInfo) {
+	t.mu.pending = append(t.mu.pending, *report)
+	if t.mu.reporting {
+		return
+	}
+	t.mu.reporting = true
+	t.reports.Add(1)
+	go t.deliverReports()
+}
+
+// deliverReports delivers the pending reports to onReport, until there are
+// none left.
+func (t *hotKeyTracker) deliverReports() {
+	defer t.reports.Done()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.mu.pending) > 0 {
+		report := t.mu.pending[0]
+		t.mu.pending[0] = HotKeysInfo{}
+		t.mu.pending = t.mu.pending[1:]
+		t.mu.Unlock()
+		t.onReport(report)
+		t.mu.Lock()
+	}
+	t.mu.pending = nil
+	t.mu.reporting = false
+}
+
+// waitReports waits until the queued reports have been delivered.
+func (t *hotKeyTracker) waitReports() {
+	t.reports.Wait()
+}
+
+// reportLocked returns the info to report about the current window, which
+// lasted for the given duration, or nil if no key reached the threshold.
+func (t *hotKeyTracker) reportLocked(duration time.Duration) *HotKeysInfo {
+	threshold := uint64(t.opts.ReportThreshold * duration.Seconds() / float64(t.opts.SampleRate))
+	info := &HotKeysInfo{Window: duration}
+	for _, e := range t.mu.cur.keys.top(hotKeysReportCount) {
+		if e.reads+e.writes < threshold {
+			break
+		}
+		info.Keys = append(info.Keys, HotKey{
+			Key:         []byte(e.key),
+			HotKeyStats: e.stats(t.opts.SampleRate, duration),
+		})
+	}
+	for _, e := range t.mu.cur.prefixes.top(hotKeysReportCount) {
+		if e.reads+e.writes < threshold {
+			break
+		}
+		info.Ranges = append(info.Ranges, t.hotRange(&e, duration))
+	}
+	if len(info.Keys) == 0 && len(info.Ranges) == 0 {
+		return nil
+	}
+	return info
+}
+
+func (t *hotKeyTracker) hotKeys(n int) []HotKey {
+	entries, period := t.top(n, false /* prefixes */)
+	keys := make([]HotKey, len(entries))
+	for i := range entries {
+		keys[i] = HotKey{
+			Key:         []byte(entries[i].key),
+			HotKeyStats: entries[i].stats(t.opts.SampleRate, period),
+		}
+	}
+	return keys
+}
+
+func (t *hotKeyTracker) hotRanges(n int) []HotRange {
+	entries, period := t.top(n, true /* prefixes */)
+	ranges := make([]HotRange, len(entries))
+	for i := range entries {
+		ranges[i] = t.hotRange(&entries[i], period)
+	}
+	return ranges
+}
+
+// metrics populates the hot key metrics with the most frequent keys and key
+// prefixes.
+func (t *hotKeyTracker) metrics(m *Metrics) {
+	if t == nil {
+		return
+	}
+	m.HotKeys.Keys = t.hotKeys(hotKeysReportCount)
+	m.HotKeys.Ranges = t.hotRanges(hotKeysReportCount)
+}
+
+// top returns up to n of the most frequent keys or key prefixes over the
+// previous and current windows, and the period they cover.
+func (t *hotKeyTracker) top(n int, prefixes bool) ([]hotKeyEntry, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.timeNow()
+	t.maybeEndWindowLocked(now)
+	return t.topLocked(n, prefixes, now)
+}
+
+func (t *hotKeyTracker) topLocked(
+	n int, prefixes bool, now time.Time,
+) ([]hotKeyEntry, time.Duration) {
+	prev, cur := &t.mu.prev.keys, &t.mu.cur.keys
+	if prefixes {
+		prev, cur = &t.mu.prev.prefixes, &t.mu.cur.prefixes
+	}
+	combined := make(map[string]hotKeyEntry, len(prev.entries)+len(cur.entries))
+	for _, s := range []*hotKeySketch{prev, cur} {
+		for k, e := range s.entries {
+			c := combined[k]
+			c.key = k
+			c.reads += e.reads
+			c.writes += e.writes
+			c.err += e.err
+			combined[k] = c
+		}
+	}
+	entries := make([]hotKeyEntry, 0, len(combined))
+	for _, e := range combined {
+		entries = append(entries, e)
+	}
+	sortHotKeyEntries(entries)
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries, t.mu.prev.duration + now.Sub(t.mu.windowStart)
+}
+
+func (t *hotKeyTracker) hotRange(e *hotKeyEntry, period time.Duration) HotRange {
+	start := []byte(e.key)
+	return HotRange{
+		Start:       start,
+		End:         t.comparer.ImmediateSuccessor(nil, start),
+		HotKeyStats: e.stats(t.opts.SampleRate, period),
+	}
+}
+
+// hotKeySketch is a Space-Saving heavy-hitters sketch: it tracks up to
+// capacity keys, and a key that is not tracked replaces the least frequent
+// tracked key, inheriting its count as error.
+type hotKeySketch struct {
+	capacity int
+	entries  map[string]*hotKeyEntry
+	// heap orders the entries by increasing count.
+	heap hotKeyHeap
+}
+
+// hotKeyEntry is a key tracked by a hotKeySketch, with its sampled counts.
+type hotKeyEntry struct {
+	key           string
+	reads, writes uint64
+	err           uint64
+	// index is the position of the entry in the heap.
+	index int
+}
+
+func (e *hotKeyEntry) count() uint64 {
+	return e.reads + e.writes + e.err
+}
+
+func (e *hotKeyEntry) stats(sampleRate int, period time.Duration) HotKeyStats {
+	s := HotKeyStats{
+		Reads:  e.reads * uint64(sampleRate),
+		Writes: e.writes * uint64(sampleRate),
+		Error:  e.err * uint64(sampleRate),
+	}
+	if secs := period.Seconds(); secs > 0 {
+		s.ReadRate = float64(s.Reads) / secs
+		s.WriteRate = float64(s.Writes) / secs
+	}
+	return s
+}
+
+func makeHotKeySketch(capacity int) hotKeySketch {
+	return hotKeySketch{
+		capacity: capacity,
+		entries:  make(map[string]*hotKeyEntry, capacity),
+	}
+}
+
+func (s *hotKeySketch) reset() {
+	clear(s.entries)
+	s.heap = s.heap[:0]
+}
+
+func (s *hotKeySketch) add(key []byte, write bool) {
+	e, ok := s.entries[string(key)]
+	if !ok {
+		if len(s.heap) < s.capacity {
+			e = &hotKeyEntry{}
+			heap.Push(&s.heap, e)
+		} else {
+			// Replace the least frequent key.
+			e = s.heap[0]
+			delete(s.entries, e.key)
+			*e = hotKeyEntry{err: e.count(), index: e.index}
+		}
+		e.key = string(key)
+		s.entries[e.key] = e
+	}
+	if write {
+		e.writes++
+	} else {
+		e.reads++
+	}
+	heap.Fix(&s.heap, e.index)
+}
+
+// top returns up to n of the most frequent keys of the sketch.
+func (s *hotKeySketch) top(n int) []hotKeyEntry {
+	entries := make([]hotKeyEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	sortHotKeyEntries(entries)
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// sortHotKeyEntries sorts entries by decreasing count, and then by key.
+func sortHotKeyEntries(entries []hotKeyEntry) {
+	slices.SortFunc(entries, func(a, b hotKeyEntry) int {
+		if ac, bc := a.count(), b.count(); ac != bc {
+			if ac > bc {
+				return -1
+			}
+			return +1
+		}
+		if a.key < b.key {
+			return -1
+		} else if a.key > b.key {
+			return +1
+		}
+		return 0
+	})
+}
+
+// hotKeyHeap is a min-heap of hotKeyEntries ordered by count.
+type hotKeyHeap []*hotKeyEntry
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count() < h[j].count() }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x any) {
+	e := x.(*hotKeyEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *hotKeyHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotKeySketch(t *testing.T) {
+	s := makeHotKeySketch(4)
+	for i := 0; i < 1000; i++ {
+		if i%3 == 0 {
+			s.add([]byte("hot"), i%2 == 0)
+		} else {
+			s.add([]byte(fmt.Sprintf("cold%d", i)), false)
+		}
+	}
+	require.Len(t, s.entries, 4)
+	top := s.top(1)
+	require.Equal(t, "hot", top[0].key)
+	// The counts of a tracked key are lower bounds, and its count including
+	// the error is an upper bound.
+	require.LessOrEqual(t, top[0].reads+top[0].writes, uint64(334))
+	require.GreaterOrEqual(t, top[0].count(), uint64(334))
+	require.Equal(t, uint64(167), top[0].writes)
+	for _, e := range s.heap {
+		require.Equal(t, e, s.entries[e.key])
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	var reports []HotKeysInfo
+	opts := &Options{
+		FS:       vfs.NewMem(),
+		Comparer: testkeys.Comparer,
+		EventListener: &EventListener{
+			HotKeysDetected: func(info HotKeysInfo) { reports = append(reports, info) },
+		},
+	}
+	opts.Experimental.HotKeys = HotKeyOptions{
+		SampleRate:      1,
+		Capacity:        8,
+		Window:          time.Minute,
+		ReportThreshold: 1,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	now := time.Unix(0, 0)
+	d.timeNow = func() time.Time { return now }
+	d.hotKeys.mu.windowStart = now
+
+	// The versions of "hot" are written, and "hot" is read by gets and seeks.
+	for i := 1; i <= 60; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("hot@%d", i)), nil, nil))
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("cold%d", i)), nil, nil))
+	}
+	for i := 0; i < 30; i++ {
+		_, _, err := d.Get([]byte("hot"))
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+	iter, err := d.NewIter(nil)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.True(t, iter.SeekGE([]byte("hot")))
+	}
+	require.NoError(t, iter.Close())
+
+	now = now.Add(30 * time.Second)
+	ranges := d.HotRanges(1)
+	require.Equal(t, []HotRange{{
+		Start: []byte("hot"),
+		End:   testkeys.Comparer.ImmediateSuccessor(nil, []byte("hot")),
+		HotKeyStats: HotKeyStats{
+			Reads:     60,
+			Writes:    60,
+			ReadRate:  2,
+			WriteRate: 2,
+		},
+	}}, ranges)
+	keys := d.HotKeys(1)
+	require.Equal(t, "hot", string(keys[0].Key))
+	require.Equal(t, uint64(60), keys[0].Reads)
+	require.Zero(t, keys[0].Writes)
+
+	m := d.Metrics()
+	require.Equal(t, ranges, m.HotKeys.Ranges[:1])
+	require.Equal(t, keys, m.HotKeys.Keys[:1])
+	require.Contains(t, m.String(), `Hot range: ["hot", "hot\x00")  reads: 2.0/s  writes: 2.0/s`)
+
+	// The end of the window reports the keys and ranges that reached the
+	// threshold, asynchronously.
+	require.Empty(t, reports)
+	now = now.Add(30 * time.Second)
+	require.NoError(t, d.Set([]byte("hot@61"), nil, nil))
+	d.hotKeys.waitReports()
+	require.Len(t, reports, 1)
+	require.Equal(t, time.Minute, reports[0].Window)
+	require.Len(t, reports[0].Keys, 1)
+	require.Equal(t, "hot", string(reports[0].Ranges[0].Start))
+	require.Contains(t, reports[0].String(), `range ["hot", "hot\x00") (1.0 reads/s, 1.0 writes/s)`)
+
+	// The previous window is still included in the rates, until it is over.
+	now = now.Add(30 * time.Second)
+	ranges = d.HotRanges(1)
+	require.Equal(t, uint64(61), ranges[0].Writes)
+	now = now.Add(2 * time.Minute)
+	require.Empty(t, d.HotRanges(1))
+}
+
+func TestHotKeysSampling(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.Nil(t, d.HotKeys(10))
+	require.NoError(t, d.Close())
+
+	opts = &Options{FS: vfs.NewMem()}
+	opts.Experimental.HotKeys.SampleRate = 4
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	// One in every four written keys is sampled, across batches.
+	for i := 0; i < 10; i++ {
+		b := d.NewBatch()
+		for j := 0; j < 3; j++ {
+			require.NoError(t, b.Set([]byte(fmt.Sprintf("k%02d", i*3+j)), nil, nil))
+		}
+		require.NoError(t, b.LogData([]byte("data"), nil))
+		require.NoError(t, b.Commit(nil))
+	}
+	var sampled []string
+	for _, k := range d.HotKeys(100) {
+		require.Equal(t, uint64(4), k.Writes)
+		sampled = append(sampled, string(k.Key))
+	}
+	require.Equal(t, []string{"k03", "k07", "k11", "k15", "k19", "k23", "k27"}, sampled)
+}
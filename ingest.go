@@ -416,6 +416,25 @@ type ingestExternalMeta struct {
 	usedExistingBacking bool
 }
 
+// bounds returns the bounds of the loaded files, and of the excise span if
+// valid.
+func (r *ingestLoadResult) bounds(exciseSpan KeyRange) []base.UserKeyBounds {
+	bounds := make([]base.UserKeyBounds, 0, r.fileCount()+1)
+	for i := range r.local {
+		bounds = append(bounds, r.local[i].UserKeyBounds())
+	}
+	for i := range r.shared {
+		bounds = append(bounds, r.shared[i].UserKeyBounds())
+	}
+	for i := range r.external {
+		bounds = append(bounds, r.external[i].UserKeyBounds())
+	}
+	if exciseSpan.Valid() {
+		bounds = append(bounds, exciseSpan.UserKeyBounds())
+	}
+	return bounds
+}
+
 func (r *ingestLoadResult) fileCount() int {
 	return len(r.local) + len(r.shared) + len(r.external)
 }
@@ -586,12 +605,25 @@ func ingestCleanup(objProvider objstorage.Provider, meta []ingestLocalMeta) erro
 // ingestLinkLocal creates new objects which are backed by either hardlinks to or
 // copies of the ingested files.
 func ingestLinkLocal(
-	jobID JobID, opts *Options, objProvider objstorage.Provider, localMetas []ingestLocalMeta,
+	jobID JobID,
+	opts *Options,
+	objProvider objstorage.Provider,
+	diskIO *diskIOThrottler,
+	localMetas []ingestLocalMeta,
 ) error {
 	for i := range localMetas {
+		createOpts := objstorage.CreateOptions{PreferSharedStorage: true}
+		if diskIO != nil {
+			// Charge the reads of the local file against the disk I/O budget if
+			// it is copied rather than hard linked.
+			size := int64(localMetas[i].Size)
+			createOpts.BeforeCopy = func() error {
+				return diskIO.waitRead(diskIOClassIngest, size, nil /* cancelled */)
+			}
+		}
 		objMeta, err := objProvider.LinkOrCopyFromLocal(
 			context.TODO(), opts.FS, localMetas[i].path, fileTypeTable, localMetas[i].FileBacking.DiskFileNum,
-			objstorage.CreateOptions{PreferSharedStorage: true},
+			createOpts,
 		)
 		if err != nil {
 			if err2 := ingestCleanup(objProvider, localMetas[:i]); err2 != nil {
@@ -1431,7 +1463,7 @@ func (d *DB) ingest(
 	// (e.g. because the files reside on a different filesystem), ingestLinkLocal
 	// will fall back to copying, and if that fails we undo our work and return an
 	// error.
-	if err := ingestLinkLocal(jobID, d.opts, d.objProvider, loadResult.local); err != nil {
+	if err := ingestLinkLocal(jobID, d.opts, d.objProvider, d.diskIO, loadResult.local); err != nil {
 		return IngestOperationStats{}, err
 	}
 
@@ -1459,6 +1491,9 @@ func (d *DB) ingest(
 	prepare := func(seqNum uint64) {
 		// Note that d.commit.mu is held by commitPipeline when calling prepare.
 
+		// Ingested sstables cannot be shipped to replicas.
+		d.failReplicationStreams(ErrReplicationUnsupported)
+
 		// Determine the set of bounds we care about for the purpose of checking
 		// for overlap among the flushables. If there's an excise span, we need
 		// to check for overlap with its bounds as well.
@@ -1712,6 +1747,9 @@ func (d *DB) ingest(
 	} else {
 		info.GlobalSeqNum = loadResult.external[0].SmallestSeqNum
 	}
+	if err == nil {
+		d.watches.ingested(loadResult.bounds(exciseSpan), info.GlobalSeqNum)
+	}
 	var stats IngestOperationStats
 	if ve != nil {
 		info.Tables = make([]struct {
@@ -334,7 +334,7 @@ func TestIngestLink(t *testing.T) {
 				opts.FS.Remove(meta[i].path)
 			}
 
-			err = ingestLinkLocal(0 /* jobID */, opts, objProvider, meta)
+			err = ingestLinkLocal(0 /* jobID */, opts, objProvider, nil /* diskIO */, meta)
 			if i < count {
 				if err == nil {
 					t.Fatalf("expected error, but found success")
@@ -401,7 +401,7 @@ func TestIngestLinkFallback(t *testing.T) {
 
 	meta := &fileMetadata{FileNum: 1}
 	meta.InitPhysicalBacking()
-	err = ingestLinkLocal(0, opts, objProvider, []ingestLocalMeta{{fileMetadata: meta, path: "source"}})
+	err = ingestLinkLocal(0, opts, objProvider, nil /* diskIO */, []ingestLocalMeta{{fileMetadata: meta, path: "source"}})
 	require.NoError(t, err)
 
 	dest, err := mem.Open("000001.sst")
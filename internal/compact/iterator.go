@@ -258,6 +258,12 @@ type IterConfig struct {
 	// numbers define the snapshot stripes.
 	Snapshots Snapshots
 
+	// HistoryRetentionSeqNum, if non-zero, is the sequence number from which
+	// all versions are retained. The state of the DB at any sequence number at
+	// or above HistoryRetentionSeqNum is preserved, as if there were a snapshot
+	// at HistoryRetentionSeqNum and at every larger sequence number.
+	HistoryRetentionSeqNum uint64
+
 	TombstoneElision TombstoneElision
 	RangeKeyElision  TombstoneElision
 
@@ -306,6 +312,14 @@ func NewIter(
 	rangeDelIter, rangeKeyIter keyspan.FragmentIterator,
 ) *Iter {
 	cfg.ensureDefaults()
+	if r := cfg.HistoryRetentionSeqNum; r != 0 {
+		// The retention boundary acts as a snapshot, so that the state at r is
+		// preserved. Keys at or above r are placed in their own stripes by
+		// snapshotIndexAndSeqNum.
+		if idx := cfg.Snapshots.Index(r - 1); idx == len(cfg.Snapshots) || cfg.Snapshots[idx] != r {
+			cfg.Snapshots = slices.Insert(slices.Clone(cfg.Snapshots), idx, r)
+		}
+	}
 	i := &Iter{
 		cmp: cfg.Comparer.Compare,
 		cfg: cfg,
@@ -365,7 +379,7 @@ func (i *Iter) First() (*base.InternalKey, []byte) {
 		if i.err != nil {
 			return nil, nil
 		}
-		i.curSnapshotIdx, i.curSnapshotSeqNum = i.cfg.Snapshots.IndexAndSeqNum(i.iterKV.SeqNum())
+		i.curSnapshotIdx, i.curSnapshotSeqNum = i.snapshotIndexAndSeqNum(i.iterKV.SeqNum())
 	}
 	i.pos = iterPosNext
 	i.iterStripeChange = newStripeNewKey
@@ -713,7 +727,7 @@ func (i *Iter) nextInStripeHelper() stripeChangeType {
 		//    of these keys, we consider the new key a `newStripeNewKey` to
 		//    reflect that it's the beginning of a new stream of point keys.
 		if i.key.IsExclusiveSentinel() || !i.cfg.Comparer.Equal(i.key.UserKey, kv.K.UserKey) {
-			i.curSnapshotIdx, i.curSnapshotSeqNum = i.cfg.Snapshots.IndexAndSeqNum(kv.SeqNum())
+			i.curSnapshotIdx, i.curSnapshotSeqNum = i.snapshotIndexAndSeqNum(kv.SeqNum())
 			return newStripeNewKey
 		}
 
@@ -734,7 +748,7 @@ func (i *Iter) nextInStripeHelper() stripeChangeType {
 			panic(errors.AssertionFailedf("pebble: invariant violation: %s and %s out of order", prevKey, kv.K))
 		}
 
-		i.curSnapshotIdx, i.curSnapshotSeqNum = i.cfg.Snapshots.IndexAndSeqNum(kv.SeqNum())
+		i.curSnapshotIdx, i.curSnapshotSeqNum = i.snapshotIndexAndSeqNum(kv.SeqNum())
 		switch kv.Kind() {
 		case base.InternalKeyKindRangeKeySet, base.InternalKeyKindRangeKeyUnset, base.InternalKeyKindRangeKeyDelete,
 			base.InternalKeyKindRangeDelete:
@@ -1373,7 +1387,7 @@ func (i *Iter) TombstonesUpTo(key []byte) []keyspan.Span {
 		currentIdx := -1
 		keys := make([]keyspan.Key, 0, min(len(span.Keys), len(i.cfg.Snapshots)+1))
 		for _, k := range span.Keys {
-			idx := i.cfg.Snapshots.Index(k.SeqNum())
+			idx, _ := i.snapshotIndexAndSeqNum(k.SeqNum())
 			if currentIdx == idx {
 				continue
 			}
@@ -1455,6 +1469,12 @@ func (i *Iter) RangeKeysUpTo(key []byte) []keyspan.Span {
 		dst.Keys = dst.Keys[:0]
 		x, y := len(i.cfg.Snapshots)-1, 0
 		usedLen := 0
+		// Range keys within the history retention window are each in their own
+		// stripe, and are never coalesced.
+		for r := i.cfg.HistoryRetentionSeqNum; r != 0 && y < len(s.Keys) && s.Keys[y].SeqNum() >= r; y++ {
+			dst.Keys = append(dst.Keys, s.Keys[y])
+			usedLen++
+		}
 		for x >= 0 {
 			start := y
 			for y < len(s.Keys) && !base.Visible(s.Keys[y].SeqNum(), i.cfg.Snapshots[x], base.InternalKeySeqNumMax) {
@@ -1497,6 +1517,17 @@ func (i *Iter) FirstRangeKeyStart() []byte {
 	return i.rangeKeys[0].Start
 }
 
+// snapshotIndexAndSeqNum returns the index of the snapshot stripe containing
+// the given sequence number, and the sequence number of the snapshot that
+// bounds the stripe. Every sequence number within the history retention window
+// has its own stripe, bounded by a snapshot at the next sequence number.
+func (i *Iter) snapshotIndexAndSeqNum(seq uint64) (int, uint64) {
+	if r := i.cfg.HistoryRetentionSeqNum; r != 0 && seq >= r {
+		return len(i.cfg.Snapshots) + 1 + int(seq-r), seq + 1
+	}
+	return i.cfg.Snapshots.IndexAndSeqNum(seq)
+}
+
 // maybeZeroSeqnum attempts to set the seqnum for the current key to 0. Doing
 // so improves compression and enables an optimization during forward iteration
 // to skip some key comparisons. The seqnum for an entry can be zeroed if the
@@ -53,6 +53,7 @@ func TestCompactionIter(t *testing.T) {
 	var snapshots Snapshots
 	var elideTombstones bool
 	var allowZeroSeqnum bool
+	var historyRetentionSeqNum uint64
 
 	var ineffectualSingleDeleteKeys []string
 	var invariantViolationSingleDeleteKeys []string
@@ -75,12 +76,13 @@ func TestCompactionIter(t *testing.T) {
 			elision = ElideTombstonesOutsideOf(nil)
 		}
 		cfg := IterConfig{
-			Comparer:         base.DefaultComparer,
-			Merge:            merge,
-			Snapshots:        snapshots,
-			TombstoneElision: elision,
-			RangeKeyElision:  elision,
-			AllowZeroSeqNum:  allowZeroSeqnum,
+			Comparer:               base.DefaultComparer,
+			Merge:                  merge,
+			Snapshots:              snapshots,
+			HistoryRetentionSeqNum: historyRetentionSeqNum,
+			TombstoneElision:       elision,
+			RangeKeyElision:        elision,
+			AllowZeroSeqNum:        allowZeroSeqnum,
 			IneffectualSingleDeleteCallback: func(userKey []byte) {
 				ineffectualSingleDeleteKeys = append(ineffectualSingleDeleteKeys, string(userKey))
 			},
@@ -151,6 +153,7 @@ func TestCompactionIter(t *testing.T) {
 				snapshots = snapshots[:0]
 				elideTombstones = false
 				allowZeroSeqnum = false
+				historyRetentionSeqNum = 0
 				printSnapshotPinned := false
 				printMissizedDels := false
 				printForceObsolete := false
@@ -176,6 +179,12 @@ func TestCompactionIter(t *testing.T) {
 						if err != nil {
 							return err.Error()
 						}
+					case "history-retention":
+						seqNum, err := strconv.ParseUint(arg.Vals[0], 10, 64)
+						if err != nil {
+							return err.Error()
+						}
+						historyRetentionSeqNum = seqNum
 					case "print-snapshot-pinned":
 						printSnapshotPinned = true
 					case "print-missized-dels":
@@ -159,6 +159,11 @@ type FileMetadata struct {
 	// that returns a user key (eg. Next, Prev, SeekGE, SeekLT, etc).
 	AllowedSeeks atomic.Int64
 
+	// ReadHeat is a decaying count of the sampled reads of the table. It is
+	// incremented by read sampling in pebble.Iterator, and halved by every
+	// pass of the tiering policy.
+	ReadHeat atomic.Int64
+
 	// statsValid indicates if stats have been loaded for the table. The
 	// TableStats structure is populated only if valid is true.
 	statsValid atomic.Bool
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -57,6 +58,8 @@ const (
 	tagNewFile5            = 104 // Range keys.
 	tagCreatedBackingTable = 105
 	tagRemovedBackingTable = 106
+	tagCreatedSnapshot     = 107
+	tagDeletedSnapshot     = 108
 
 	// The custom tags sub-format used by tagNewFile4 and above. All tags less
 	// than customTagNonSafeIgnoreMask are safe to ignore and their format must be
@@ -88,6 +91,16 @@ type NewFileEntry struct {
 	BackingFileNum base.DiskFileNum
 }
 
+// NamedSnapshot is a snapshot that is recorded in the manifest, and so
+// persists across restarts.
+type NamedSnapshot struct {
+	Name   string
+	SeqNum uint64
+	// CreationTime is the time the snapshot was created, in seconds since the
+	// epoch.
+	CreationTime int64
+}
+
 // VersionEdit holds the state for an edit to a Version along with other
 // on-disk state (log numbers, next file number, and the last sequence number).
 type VersionEdit struct {
@@ -152,6 +165,11 @@ type VersionEdit struct {
 	// and RemovedBackingTables. A file must be present in RemovedBackingTables
 	// in exactly one version edit.
 	RemovedBackingTables []base.DiskFileNum
+	// CreatedSnapshots are named snapshots to add. A name must not be reused
+	// until the snapshot with that name is deleted.
+	CreatedSnapshots []NamedSnapshot
+	// DeletedSnapshots are the names of named snapshots to delete.
+	DeletedSnapshots []string
 }
 
 // Decode decodes an edit from the specified reader.
@@ -233,6 +251,30 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				Size:        size,
 			}
 			v.CreatedBackingTables = append(v.CreatedBackingTables, fileBacking)
+		case tagCreatedSnapshot:
+			name, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			seqNum, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			creationTime, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			v.CreatedSnapshots = append(v.CreatedSnapshots, NamedSnapshot{
+				Name:         string(name),
+				SeqNum:       seqNum,
+				CreationTime: int64(creationTime),
+			})
+		case tagDeletedSnapshot:
+			name, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			v.DeletedSnapshots = append(v.DeletedSnapshots, string(name))
 		case tagDeletedFile:
 			level, err := d.readLevel()
 			if err != nil {
@@ -524,6 +566,16 @@ func (v *VersionEdit) string(verbose bool, fmtKey base.FormatKey) string {
 	for _, n := range v.RemovedBackingTables {
 		fmt.Fprintf(&buf, "  del-backing:   %s\n", n)
 	}
+	for _, s := range v.CreatedSnapshots {
+		fmt.Fprintf(&buf, "  add-snapshot:  %s #%d", s.Name, s.SeqNum)
+		if s.CreationTime != 0 {
+			fmt.Fprintf(&buf, " (%s)", time.Unix(s.CreationTime, 0).UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintln(&buf)
+	}
+	for _, name := range v.DeletedSnapshots {
+		fmt.Fprintf(&buf, "  del-snapshot:  %s\n", name)
+	}
 	return buf.String()
 }
 
@@ -593,6 +645,32 @@ func ParseVersionEditDebug(s string) (_ *VersionEdit, err error) {
 			n := p.DiskFileNum()
 			ve.RemovedBackingTables = append(ve.RemovedBackingTables, n)
 
+		case "add-snapshot":
+			// The creation time, if any, follows the sequence number in
+			// parentheses.
+			var creationTime int64
+			if i := strings.LastIndexByte(value, '('); i >= 0 {
+				t, err := time.Parse(time.RFC3339, strings.TrimSuffix(strings.TrimSpace(value[i+1:]), ")"))
+				if err != nil {
+					return nil, errors.Wrapf(err, "malformed add-snapshot line %q", l)
+				}
+				creationTime = t.Unix()
+				p = makeDebugParser(value[:i])
+			}
+			name := p.Next()
+			seqNum, err := strconv.ParseUint(strings.TrimPrefix(p.Next(), "#"), 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "malformed add-snapshot line %q", l)
+			}
+			ve.CreatedSnapshots = append(ve.CreatedSnapshots, NamedSnapshot{
+				Name:         name,
+				SeqNum:       seqNum,
+				CreationTime: creationTime,
+			})
+
+		case "del-snapshot":
+			ve.DeletedSnapshots = append(ve.DeletedSnapshots, p.Next())
+
 		default:
 			return nil, errors.Errorf("field %q not implemented", field)
 		}
@@ -629,6 +707,16 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(uint64(fileBacking.DiskFileNum))
 		e.writeUvarint(fileBacking.Size)
 	}
+	for _, s := range v.CreatedSnapshots {
+		e.writeUvarint(tagCreatedSnapshot)
+		e.writeString(s.Name)
+		e.writeUvarint(s.SeqNum)
+		e.writeUvarint(uint64(s.CreationTime))
+	}
+	for _, name := range v.DeletedSnapshots {
+		e.writeUvarint(tagDeletedSnapshot)
+		e.writeString(name)
+	}
 	// RocksDB requires LastSeqNum to be encoded for the first MANIFEST entry,
 	// even though its value is zero. We detect this by encoding LastSeqNum when
 	// ComparerName is set.
@@ -218,6 +218,11 @@ func TestVersionEditRoundTrip(t *testing.T) {
 			LastSeqNum:           55,
 			RemovedBackingTables: []base.DiskFileNum{10, 11},
 			CreatedBackingTables: []*FileBacking{m5.FileBacking, m6.FileBacking},
+			CreatedSnapshots: []NamedSnapshot{
+				{Name: "backup", SeqNum: 50, CreationTime: 1700000000},
+				{Name: "audit", SeqNum: 52},
+			},
+			DeletedSnapshots: []string{"old"},
 			DeletedFiles: map[DeletedFileEntry]*FileMetadata{
 				{
 					Level:   3,
@@ -526,6 +531,13 @@ func TestParseVersionEditDebugRoundTrip(t *testing.T) {
 				`  add-table:     L2 000002:[a#0,SET-z#0,DEL] seqnums:[0-0] points:[a#0,SET-z#0,DEL] size:2`,
 			}, "\n"),
 		},
+		{
+			input: strings.Join([]string{
+				`  add-snapshot:  backup #50 (2023-11-14T22:13:20Z)`,
+				`  add-snapshot:  audit #52`,
+				`  del-snapshot:  old`,
+			}, "\n"),
+		},
 	}
 	for _, tc := range testCases {
 		t.Run("", func(t *testing.T) {
@@ -301,6 +301,10 @@ type Iterator struct {
 	batchJustRefreshed bool
 	// batchOnlyIter is set to true for Batch.NewBatchOnlyIter.
 	batchOnlyIter bool
+
+	// hotKeys, if non-nil, samples the keys of seeks to track frequently read
+	// keys.
+	hotKeys *hotKeyTracker
 	// Used in some tests to disable the random disabling of seek optimizations.
 	forceEnableSeekOpt bool
 	// Set to true if NextPrefix is not currently permitted. Defaults to false
@@ -825,18 +829,30 @@ func (i *Iterator) sampleRead() {
 	if mi == nil {
 		return
 	}
+	containsKey := func(f *manifest.FileMetadata) bool {
+		if i.pos == iterPosNext || i.pos == iterPosCurForward ||
+			i.pos == iterPosCurForwardPaused {
+			return i.cmp(f.SmallestPointKey.UserKey, i.key) <= 0
+		} else if i.pos == iterPosPrev || i.pos == iterPosCurReverse ||
+			i.pos == iterPosCurReversePaused {
+			return i.cmp(f.LargestPointKey.UserKey, i.key) >= 0
+		}
+		return false
+	}
+	if i.readState.db.opts.Experimental.Tiering.Interval > 0 {
+		// Heat up every table containing the key. The tiering policy uses the
+		// heat to decide which tables to keep on local disk.
+		mi.ForEachLevelIter(func(li *levelIter) bool {
+			if f := li.iterFile; f != nil && containsKey(f) {
+				f.ReadHeat.Add(1)
+			}
+			return false
+		})
+	}
 	if len(mi.levels) > 1 {
 		mi.ForEachLevelIter(func(li *levelIter) bool {
 			l := manifest.LevelToInt(li.level)
 			if f := li.iterFile; f != nil {
-				var containsKey bool
-				if i.pos == iterPosNext || i.pos == iterPosCurForward ||
-					i.pos == iterPosCurForwardPaused {
-					containsKey = i.cmp(f.SmallestPointKey.UserKey, i.key) <= 0
-				} else if i.pos == iterPosPrev || i.pos == iterPosCurReverse ||
-					i.pos == iterPosCurReversePaused {
-					containsKey = i.cmp(f.LargestPointKey.UserKey, i.key) >= 0
-				}
 				// Do nothing if the current key is not contained in f's
 				// bounds. We could seek the LevelIterator at this level
 				// to find the right file, but the performance impacts of
@@ -844,7 +860,7 @@ func (i *Iterator) sampleRead() {
 				// of read sampling in the first place. See the discussion
 				// at:
 				// https://github.com/cockroachdb/pebble/pull/1041#issuecomment-763226492
-				if containsKey {
+				if containsKey(f) {
 					numOverlappingLevels++
 					if numOverlappingLevels >= 2 {
 						// Terminate the loop early if at least 2 overlapping levels are found.
@@ -1250,6 +1266,9 @@ func (i *Iterator) SeekGE(key []byte) bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace [key, limit).
 func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
+	if i.hotKeys != nil {
+		i.hotKeys.sampleRead(key)
+	}
 	if i.rangeKey != nil {
 		// NB: Check Valid() before clearing requiresReposition.
 		i.rangeKey.prevPosHadRangeKey = i.rangeKey.hasRangeKey && i.Valid()
@@ -1399,6 +1418,9 @@ func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
 // ImmediateSuccessor method. For example, a SeekPrefixGE("a@9") call with the
 // prefix "a" will truncate range key bounds to [a,ImmediateSuccessor(a)].
 func (i *Iterator) SeekPrefixGE(key []byte) bool {
+	if i.hotKeys != nil {
+		i.hotKeys.sampleRead(key)
+	}
 	if i.rangeKey != nil {
 		// NB: Check Valid() before clearing requiresReposition.
 		i.rangeKey.prevPosHadRangeKey = i.rangeKey.hasRangeKey && i.Valid()
@@ -1526,6 +1548,9 @@ func (i *Iterator) SeekLT(key []byte) bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace up to limit.
 func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
+	if i.hotKeys != nil {
+		i.hotKeys.sampleRead(key)
+	}
 	if i.rangeKey != nil {
 		// NB: Check Valid() before clearing requiresReposition.
 		i.rangeKey.prevPosHadRangeKey = i.rangeKey.hasRangeKey && i.Valid()
@@ -2824,6 +2849,7 @@ func (i *Iterator) CloneWithContext(ctx context.Context, opts CloneOptions) (*It
 		newIters:            i.newIters,
 		newIterRangeKey:     i.newIterRangeKey,
 		seqNum:              i.seqNum,
+		hotKeys:             i.hotKeys,
 	}
 	dbi.processBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
 
@@ -0,0 +1,421 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// MergeOperator defines a Merger from functions that merge whole values,
+// leaving the buffering of the operands to the Merger.
+//
+// When reading a key, or when a compaction reaches the value set on a key,
+// the operands of the key are merged with its existing value by FullMerge. A
+// compaction may also merge a run of operands without the existing value: the
+// operands are then combined by PartialMerge, or, if the operator does not
+// support partial merges, stacked into a single operand that FullMerge
+// receives as the original operands once the existing value is available.
+type MergeOperator struct {
+	// Name is the name of the Merger.
+	Name string
+
+	// FullMerge applies the operands, ordered from oldest to newest, to the
+	// existing value of the key. The existing value is the value set on the
+	// key, or the oldest operand of the key if it has no value. FullMerge may
+	// return delete to indicate that the key has no value (see
+	// DeletableValueMerger).
+	FullMerge func(key, existing []byte, operands [][]byte) (value []byte, delete bool, err error)
+
+	// PartialMerge, if set, combines two consecutive operands into a single
+	// one. PartialMerge is also used to combine the existing value with the
+	// operands, after which FullMerge is called with no operands, so the
+	// values and operands of the operator must have the same representation.
+	//
+	// If PartialMerge is nil, the operands merged without the existing value
+	// are stacked instead, and the operands must not start with a zero byte.
+	PartialMerge func(key, older, newer []byte) ([]byte, error)
+}
+
+// stackedOperandsPrefix is the prefix of the operand that stacks the operands
+// of an operator without partial merges. It is followed by the operands,
+// ordered from oldest to newest, each prefixed by its uvarint length.
+const stackedOperandsPrefix = 0
+
+// Merger returns a Merger that merges values with the operator.
+func (op *MergeOperator) Merger() *Merger {
+	return &Merger{
+		Name: op.Name,
+		Merge: func(key, value []byte) (ValueMerger, error) {
+			m := &operatorValueMerger{op: op, key: slices.Clone(key)}
+			if err := m.add(value); err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	}
+}
+
+// operatorValueMerger implements DeletableValueMerger for a MergeOperator.
+type operatorValueMerger struct {
+	op  *MergeOperator
+	key []byte
+	// operands are the operands of an operator without partial merges, in the
+	// order in which they were added, and reversed if they are added by
+	// MergeOlder. Stacked operands are expanded by DeletableFinish. For an
+	// operator with partial merges, operands is the single combined operand.
+	operands [][]byte
+	older    bool
+}
+
+var _ DeletableValueMerger = (*operatorValueMerger)(nil)
+
+func (m *operatorValueMerger) add(value []byte) error {
+	if m.op.PartialMerge == nil {
+		m.operands = append(m.operands, slices.Clone(value))
+		return nil
+	}
+	if len(m.operands) == 0 {
+		m.operands = append(m.operands, slices.Clone(value))
+		return nil
+	}
+	var err error
+	if m.older {
+		m.operands[0], err = m.op.PartialMerge(m.key, value, m.operands[0])
+	} else {
+		m.operands[0], err = m.op.PartialMerge(m.key, m.operands[0], value)
+	}
+	return err
+}
+
+// MergeNewer implements ValueMerger.
+func (m *operatorValueMerger) MergeNewer(value []byte) error {
+	return m.add(value)
+}
+
+// MergeOlder implements ValueMerger.
+func (m *operatorValueMerger) MergeOlder(value []byte) error {
+	m.older = true
+	return m.add(value)
+}
+
+// Finish implements ValueMerger.
+func (m *operatorValueMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	value, _, closer, err := m.DeletableFinish(includesBase)
+	return value, closer, err
+}
+
+// DeletableFinish implements DeletableValueMerger.
+func (m *operatorValueMerger) DeletableFinish(
+	includesBase bool,
+) (value []byte, delete bool, closer io.Closer, err error) {
+	if m.older {
+		slices.Reverse(m.operands)
+	}
+	if m.op.PartialMerge == nil {
+		// Expand the stacked operands, which are only known to be in order
+		// once all the operands have been added.
+		operands := make([][]byte, 0, len(m.operands))
+		for _, o := range m.operands {
+			if len(o) == 0 || o[0] != stackedOperandsPrefix {
+				operands = append(operands, o)
+				continue
+			}
+			stacked, err := decodeStackedOperands(o)
+			if err != nil {
+				return nil, false, nil, err
+			}
+			operands = append(operands, stacked...)
+		}
+		m.operands = operands
+	}
+	if !includesBase {
+		if m.op.PartialMerge != nil {
+			return m.operands[0], false, nil, nil
+		}
+		if len(m.operands) == 1 {
+			return m.operands[0], false, nil, nil
+		}
+		return encodeStackedOperands(m.operands), false, nil, nil
+	}
+	value, delete, err = m.op.FullMerge(m.key, m.operands[0], m.operands[1:])
+	return value, delete, nil, err
+}
+
+func encodeStackedOperands(operands [][]byte) []byte {
+	buf := []byte{stackedOperandsPrefix}
+	for _, o := range operands {
+		buf = binary.AppendUvarint(buf, uint64(len(o)))
+		buf = append(buf, o...)
+	}
+	return buf
+}
+
+func decodeStackedOperands(value []byte) ([][]byte, error) {
+	operands, err := decodeLengthPrefixed(value[1:])
+	if err != nil {
+		return nil, errors.Wrap(err, "pebble: invalid stacked merge operands")
+	}
+	return operands, nil
+}
+
+// decodeLengthPrefixed decodes a sequence of byte slices each prefixed by its
+// uvarint length.
+func decodeLengthPrefixed(buf []byte) ([][]byte, error) {
+	var res [][]byte
+	for len(buf) > 0 {
+		n, l := binary.Uvarint(buf)
+		if l <= 0 || uint64(len(buf)-l) < n {
+			return nil, errors.New("truncated element")
+		}
+		res = append(res, slices.Clone(buf[l:l+int(n)]))
+		buf = buf[l+int(n):]
+	}
+	return res, nil
+}
+
+var mergerRegistry = struct {
+	sync.Mutex
+	m map[string]*Merger
+}{m: make(map[string]*Merger)}
+
+// RegisterMerger registers a Merger under its name, so that it can be
+// returned by LookupMerger and found by Options.Parse. It panics if a
+// different Merger is registered under the same name.
+func RegisterMerger(m *Merger) {
+	mergerRegistry.Lock()
+	defer mergerRegistry.Unlock()
+	if existing, ok := mergerRegistry.m[m.Name]; ok && existing != m {
+		panic(fmt.Sprintf("pebble: merger %q already registered", m.Name))
+	}
+	mergerRegistry.m[m.Name] = m
+}
+
+// appendWithCapMergerPrefix is the prefix of the names of the Mergers returned
+// by AppendWithCapMerger, which is followed by the cap.
+const appendWithCapMergerPrefix = "pebble.append_with_cap."
+
+// LookupMerger returns the Merger registered under the given name, or nil.
+// The mergers of this package, including those returned by AppendWithCapMerger,
+// are always found.
+func LookupMerger(name string) *Merger {
+	mergerRegistry.Lock()
+	m := mergerRegistry.m[name]
+	mergerRegistry.Unlock()
+	if m != nil {
+		return m
+	}
+	if s, ok := strings.CutPrefix(name, appendWithCapMergerPrefix); ok {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && strconv.Itoa(n) == s {
+			return AppendWithCapMerger(n)
+		}
+	}
+	return nil
+}
+
+// EncodeInt64 encodes an int64 as a value or operand of Int64AddMerger,
+// Int64MaxMerger and Int64MinMerger.
+func EncodeInt64(v int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(v))
+}
+
+// DecodeInt64 decodes a value encoded by EncodeInt64.
+func DecodeInt64(value []byte) (int64, error) {
+	if len(value) != 8 {
+		return 0, errors.Errorf("pebble: invalid int64 value of length %d", len(value))
+	}
+	return int64(binary.LittleEndian.Uint64(value)), nil
+}
+
+func int64MergeOperator(name string, combine func(a, b int64) int64) *MergeOperator {
+	return &MergeOperator{
+		Name: name,
+		FullMerge: func(key, existing []byte, operands [][]byte) ([]byte, bool, error) {
+			// The operands were combined by PartialMerge.
+			if _, err := DecodeInt64(existing); err != nil {
+				return nil, false, err
+			}
+			return existing, false, nil
+		},
+		PartialMerge: func(key, older, newer []byte) ([]byte, error) {
+			a, err := DecodeInt64(older)
+			if err != nil {
+				return nil, err
+			}
+			b, err := DecodeInt64(newer)
+			if err != nil {
+				return nil, err
+			}
+			return EncodeInt64(combine(a, b)), nil
+		},
+	}
+}
+
+// Int64AddMerger adds int64s encoded by EncodeInt64. The addition wraps around
+// on overflow.
+var Int64AddMerger = int64MergeOperator("pebble.int64_add", func(a, b int64) int64 {
+	return a + b
+}).Merger()
+
+// Int64MaxMerger keeps the maximum of int64s encoded by EncodeInt64.
+var Int64MaxMerger = int64MergeOperator("pebble.int64_max", func(a, b int64) int64 {
+	return max(a, b)
+}).Merger()
+
+// Int64MinMerger keeps the minimum of int64s encoded by EncodeInt64.
+var Int64MinMerger = int64MergeOperator("pebble.int64_min", func(a, b int64) int64 {
+	return min(a, b)
+}).Merger()
+
+// EncodeList encodes a list of elements as a value or operand of
+// SetUnionMerger or of the Mergers returned by AppendWithCapMerger.
+func EncodeList(elems ...[]byte) []byte {
+	var buf []byte
+	for _, e := range elems {
+		buf = binary.AppendUvarint(buf, uint64(len(e)))
+		buf = append(buf, e...)
+	}
+	return buf
+}
+
+// DecodeList decodes a value encoded by EncodeList.
+func DecodeList(value []byte) ([][]byte, error) {
+	elems, err := decodeLengthPrefixed(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "pebble: invalid list value")
+	}
+	return elems, nil
+}
+
+// SetUnionMerger merges sets of members encoded by EncodeList into their
+// union, whose members are sorted in lexicographic order. A key whose set is
+// empty is deleted.
+var SetUnionMerger = (&MergeOperator{
+	Name: "pebble.set_union",
+	FullMerge: func(key, existing []byte, operands [][]byte) ([]byte, bool, error) {
+		// The operands were combined by PartialMerge, which leaves the existing
+		// value as is if there are none.
+		members, err := DecodeList(existing)
+		if err != nil {
+			return nil, false, err
+		}
+		return unionSets(members), len(members) == 0, nil
+	},
+	PartialMerge: func(key, older, newer []byte) ([]byte, error) {
+		a, err := DecodeList(older)
+		if err != nil {
+			return nil, err
+		}
+		b, err := DecodeList(newer)
+		if err != nil {
+			return nil, err
+		}
+		return unionSets(append(a, b...)), nil
+	},
+}).Merger()
+
+// unionSets returns the encoding of the sorted, distinct members.
+func unionSets(members [][]byte) []byte {
+	slices.SortFunc(members, bytes.Compare)
+	return EncodeList(slices.CompactFunc(members, bytes.Equal)...)
+}
+
+// AppendWithCapMerger returns a Merger that appends lists of elements encoded
+// by EncodeList, keeping only the newest n elements.
+func AppendWithCapMerger(n int) *Merger {
+	if n <= 0 {
+		panic(errors.AssertionFailedf("pebble: invalid append cap %d", n))
+	}
+	return (&MergeOperator{
+		Name: appendWithCapMergerPrefix + strconv.Itoa(n),
+		FullMerge: func(key, existing []byte, operands [][]byte) ([]byte, bool, error) {
+			// The operands were combined by PartialMerge.
+			if _, err := DecodeList(existing); err != nil {
+				return nil, false, err
+			}
+			return existing, false, nil
+		},
+		PartialMerge: func(key, older, newer []byte) ([]byte, error) {
+			a, err := DecodeList(older)
+			if err != nil {
+				return nil, err
+			}
+			b, err := DecodeList(newer)
+			if err != nil {
+				return nil, err
+			}
+			elems := append(a, b...)
+			if len(elems) > n {
+				elems = elems[len(elems)-n:]
+			}
+			return EncodeList(elems...), nil
+		},
+	}).Merger()
+}
+
+// JSONMergePatchMerger applies JSON merge patches (RFC 7396) to a JSON value.
+// The operands are merge patches, applied to the value set on the key, or to
+// an empty document if the key has no value. As the value set on a key cannot
+// be told apart from the oldest patch of a key without a value, the value is
+// itself applied as a patch, which drops its null members. Merge patches
+// cannot be combined without the document, so the operator has no partial
+// merges.
+var JSONMergePatchMerger = (&MergeOperator{
+	Name: "pebble.json_merge_patch",
+	FullMerge: func(key, existing []byte, operands [][]byte) ([]byte, bool, error) {
+		var base any
+		if err := json.Unmarshal(existing, &base); err != nil {
+			return nil, false, errors.Wrap(err, "pebble: invalid JSON value")
+		}
+		doc := applyJSONMergePatch(nil, base)
+		for _, o := range operands {
+			var patch any
+			if err := json.Unmarshal(o, &patch); err != nil {
+				return nil, false, errors.Wrap(err, "pebble: invalid JSON merge patch")
+			}
+			doc = applyJSONMergePatch(doc, patch)
+		}
+		value, err := json.Marshal(doc)
+		return value, false, err
+	},
+}).Merger()
+
+// applyJSONMergePatch applies a merge patch to a document, as defined by RFC
+// 7396.
+func applyJSONMergePatch(doc, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]any)
+	if !ok {
+		d = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = applyJSONMergePatch(d[k], v)
+		}
+	}
+	return d
+}
+
+func init() {
+	for _, m := range []*Merger{
+		DefaultMerger, Int64AddMerger, Int64MaxMerger, Int64MinMerger, SetUnionMerger, JSONMergePatchMerger,
+	} {
+		RegisterMerger(m)
+	}
+}
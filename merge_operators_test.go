@@ -0,0 +1,207 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// mergeAll merges the values, ordered from oldest to newest, with MergeNewer
+// or MergeOlder.
+func mergeAll(t *testing.T, m *Merger, includesBase, older bool, values ...[]byte) ([]byte, bool) {
+	var vm ValueMerger
+	var err error
+	if older {
+		vm, err = m.Merge([]byte("k"), values[len(values)-1])
+		require.NoError(t, err)
+		for i := len(values) - 2; i >= 0; i-- {
+			require.NoError(t, vm.MergeOlder(values[i]))
+		}
+	} else {
+		vm, err = m.Merge([]byte("k"), values[0])
+		require.NoError(t, err)
+		for _, v := range values[1:] {
+			require.NoError(t, vm.MergeNewer(v))
+		}
+	}
+	value, needDelete, closer, err := finishValueMerger(vm, includesBase)
+	require.NoError(t, err)
+	require.Nil(t, closer)
+	return value, needDelete
+}
+
+func TestMergeOperators(t *testing.T) {
+	list := func(elems ...string) []byte {
+		var b [][]byte
+		for _, e := range elems {
+			b = append(b, []byte(e))
+		}
+		return EncodeList(b...)
+	}
+	testCases := []struct {
+		merger   *Merger
+		values   [][]byte
+		expected []byte
+		deleted  bool
+	}{
+		{
+			merger:   Int64AddMerger,
+			values:   [][]byte{EncodeInt64(1), EncodeInt64(-5), EncodeInt64(10), EncodeInt64(3)},
+			expected: EncodeInt64(9),
+		},
+		{
+			merger:   Int64MaxMerger,
+			values:   [][]byte{EncodeInt64(1), EncodeInt64(-5), EncodeInt64(10), EncodeInt64(3)},
+			expected: EncodeInt64(10),
+		},
+		{
+			merger:   Int64MinMerger,
+			values:   [][]byte{EncodeInt64(1), EncodeInt64(-5), EncodeInt64(10), EncodeInt64(3)},
+			expected: EncodeInt64(-5),
+		},
+		{
+			merger:   SetUnionMerger,
+			values:   [][]byte{list("c", "a"), list("b", "a"), list(), list("d", "c")},
+			expected: list("a", "b", "c", "d"),
+		},
+		{
+			merger:  SetUnionMerger,
+			values:  [][]byte{list(), list()},
+			deleted: true,
+		},
+		{
+			merger:   AppendWithCapMerger(3),
+			values:   [][]byte{list("a", "b"), list("c"), list("d", "e"), list("f")},
+			expected: list("d", "e", "f"),
+		},
+		{
+			merger: JSONMergePatchMerger,
+			values: [][]byte{
+				[]byte(`{"a":1,"b":{"c":2,"d":3},"e":[1,2]}`),
+				[]byte(`{"b":null}`),
+				[]byte(`{"b":{"x":1},"e":null}`),
+				[]byte(`{"a":{"y":null,"z":2}}`),
+			},
+			expected: []byte(`{"a":{"z":2},"b":{"x":1}}`),
+		},
+		{
+			// The null members of the oldest patch of a key without a value are
+			// removals, as they are when applied to an empty document.
+			merger: JSONMergePatchMerger,
+			values: [][]byte{
+				[]byte(`{"a":null,"b":{"c":null,"d":1}}`),
+				[]byte(`{"e":2}`),
+			},
+			expected: []byte(`{"b":{"d":1},"e":2}`),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.merger.Name, func(t *testing.T) {
+			for _, older := range []bool{false, true} {
+				value, deleted := mergeAll(t, tc.merger, true /* includesBase */, older, tc.values...)
+				require.Equal(t, tc.deleted, deleted)
+				if !tc.deleted {
+					require.Equal(t, tc.expected, value)
+				}
+			}
+			// The operands merged without the base, whether partially merged or
+			// stacked, merge with the base into the same value.
+			for split := 1; split < len(tc.values); split++ {
+				for _, older := range []bool{false, true} {
+					partial, deleted := mergeAll(t, tc.merger, false /* includesBase */, older, tc.values[split:]...)
+					require.False(t, deleted)
+					values := append(append([][]byte(nil), tc.values[:split]...), partial)
+					value, deleted := mergeAll(t, tc.merger, true /* includesBase */, !older, values...)
+					require.Equal(t, tc.deleted, deleted)
+					if !tc.deleted {
+						require.Equal(t, tc.expected, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMergeOperatorsInvalid(t *testing.T) {
+	vm, err := Int64AddMerger.Merge([]byte("k"), EncodeInt64(1))
+	require.NoError(t, err)
+	require.Error(t, vm.MergeNewer([]byte("x")))
+
+	vm, err = JSONMergePatchMerger.Merge([]byte("k"), []byte(`{"a":`))
+	require.NoError(t, err)
+	_, _, err = vm.Finish(true /* includesBase */)
+	require.Error(t, err)
+
+	_, err = DecodeList([]byte{5, 'a'})
+	require.Error(t, err)
+}
+
+func TestMergeOperatorsCompaction(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		Merger:                      JSONMergePatchMerger,
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	require.NoError(t, d.Set([]byte("k"), []byte(`{"a":1,"b":2}`), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	// The patches are stacked by the flush and by the compaction of L0, in
+	// which the value of the key is not present.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.Merge([]byte("k"), []byte(fmt.Sprintf(`{"c%d":%d}`, i, i)), nil))
+		require.NoError(t, d.Merge([]byte("k"), []byte(`{"b":null}`), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Merge([]byte("k"), []byte(`{"a":{"x":1}}`), nil))
+	const expected = `{"a":{"x":1},"c0":0,"c1":1,"c2":2}`
+	require.Equal(t, expected, get("k"))
+	require.NoError(t, d.Flush())
+	require.Equal(t, expected, get("k"))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	require.Equal(t, expected, get("k"))
+
+	// A key whose set of members is empty is deleted.
+	d2, err := Open("", &Options{FS: vfs.NewMem(), Merger: SetUnionMerger})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d2.Close()) }()
+	require.NoError(t, d2.Merge([]byte("s"), EncodeList(), nil))
+	_, _, err = d2.Get([]byte("s"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, d2.Merge([]byte("s"), EncodeList([]byte("m")), nil))
+	v, closer, err := d2.Get([]byte("s"))
+	require.NoError(t, err)
+	require.Equal(t, EncodeList([]byte("m")), v)
+	require.NoError(t, closer.Close())
+}
+
+func TestLookupMerger(t *testing.T) {
+	require.Equal(t, Int64AddMerger, LookupMerger("pebble.int64_add"))
+	require.Equal(t, DefaultMerger, LookupMerger(DefaultMerger.Name))
+	require.Equal(t, "pebble.append_with_cap.10", LookupMerger("pebble.append_with_cap.10").Name)
+	require.Nil(t, LookupMerger("pebble.append_with_cap.0"))
+	require.Nil(t, LookupMerger("pebble.append_with_cap.010"))
+	require.Nil(t, LookupMerger("unknown"))
+	require.Panics(t, func() { RegisterMerger(&Merger{Name: Int64AddMerger.Name}) })
+
+	opts := &Options{Merger: SetUnionMerger}
+	var parsed Options
+	require.NoError(t, parsed.Parse(opts.EnsureDefaults().String(), nil))
+	require.Equal(t, SetUnionMerger, parsed.Merger)
+}
@@ -178,6 +178,11 @@ type Metrics struct {
 		// Duration records the cumulative duration of all compactions since the
 		// database was opened.
 		Duration time.Duration
+		// FIFODroppedTables and FIFODroppedBytes are the number and total size of
+		// tables deleted by delete-only compactions because they exceeded the
+		// limits of FIFO compaction. See CompactionStyleFIFO.
+		FIFODroppedTables int64
+		FIFODroppedBytes  uint64
 	}
 
 	Ingest struct {
@@ -185,6 +190,78 @@ type Metrics struct {
 		Count uint64
 	}
 
+	// Tiering contains metrics about the tables migrated between local disk
+	// and shared storage by Options.Experimental.Tiering. The bytes per tier
+	// are reported by Table.Local and Table.Remote.
+	Tiering struct {
+		// The number and total size of tables demoted to shared storage.
+		TablesDemoted int64
+		BytesDemoted  uint64
+		// The number and total size of tables promoted to local disk.
+		TablesPromoted int64
+		BytesPromoted  uint64
+	}
+
+	// DiskIO contains metrics about the disk I/O budget. All fields are zero
+	// if no Options.DiskIOBudget is configured.
+	DiskIO struct {
+		// The current read and write rates of the budget, in bytes per second.
+		// These may differ from the configured rates if the budget is
+		// auto-tuned.
+		ReadBytesPerSec  uint64
+		WriteBytesPerSec uint64
+		// ThrottledDuration is the cumulative time spent waiting for disk I/O
+		// budget, per category of background work.
+		ThrottledDuration struct {
+			Flush      time.Duration
+			Compaction time.Duration
+			Download   time.Duration
+			Ingest     time.Duration
+		}
+	}
+
+	// CompactionScheduler contains metrics about the compaction slots of the
+	// Options.CompactionScheduler. All fields are zero if no scheduler is
+	// configured.
+	CompactionScheduler struct {
+		// Slots is the number of slots of the scheduler, and Running the
+		// number of slots in use across all the DBs that share it.
+		Slots   int
+		Running int
+		// WaitCount is the number of times the DB had to wait for a slot, and
+		// WaitDuration the cumulative time it spent waiting.
+		WaitCount    int64
+		WaitDuration time.Duration
+	}
+
+	// WriteAdmission contains metrics about the admission control of writes.
+	// All fields are zero unless Options.Experimental.WriteAdmission is
+	// configured.
+	WriteAdmission struct {
+		// BytesPerSec is the current rate at which writes are admitted, or
+		// zero if writes are not throttled.
+		BytesPerSec uint64
+		// Queued is the number of writes currently waiting for admission.
+		Queued int64
+		// The cumulative number and size of admitted writes.
+		AdmittedCount uint64
+		AdmittedBytes uint64
+		// ThrottledCount is the cumulative number of writes that waited for
+		// admission, and ThrottledDuration the cumulative time they spent
+		// waiting.
+		ThrottledCount    uint64
+		ThrottledDuration time.Duration
+	}
+
+	// HotKeys contains the most frequently read and written keys and key
+	// prefixes, in decreasing order of frequency, as estimated by the sampled
+	// hot key tracking. It is empty unless Options.Experimental.HotKeys is
+	// configured. See DB.HotKeys and DB.HotRanges.
+	HotKeys struct {
+		Keys   []HotKey
+		Ranges []HotRange
+	}
+
 	Flush struct {
 		// The total number of flushes.
 		Count           int64
@@ -247,6 +324,9 @@ type Metrics struct {
 		// sstables during flushes or compactions that would've been elided if
 		// it weren't for open snapshots.
 		PinnedSize uint64
+		// Named describes the named snapshots, including the keys pinned by
+		// each of them.
+		Named []NamedSnapshotInfo
 	}
 
 	Table struct {
@@ -274,6 +354,13 @@ type Metrics struct {
 			// ZombieSize is the number of bytes in zombie tables.
 			ZombieSize uint64
 		}
+
+		// Remote file sizes.
+		Remote struct {
+			// LiveSize is the number of bytes in live tables stored on shared
+			// or external storage.
+			LiveSize uint64
+		}
 	}
 
 	TableCache CacheMetrics
@@ -561,6 +648,13 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 			m.WAL.Failover.PrimaryWriteDuration.String(), m.WAL.Failover.SecondaryWriteDuration.String())
 	}
 
+	if lw := &m.LogWriter.LogWriterMetrics; lw.UncompressedBytes > 0 {
+		w.Printf("WAL compression: in: %s  out: %s (ratio %.2f)\n",
+			humanize.Bytes.Int64(lw.UncompressedBytes),
+			humanize.Bytes.Int64(lw.CompressedBytes),
+			redact.Safe(lw.CompressionRatio()))
+	}
+
 	w.Printf("Flushes: %d\n", redact.Safe(m.Flush.Count))
 
 	w.Printf("Compactions: %d  estimated debt: %s  in progress: %d (%s)\n",
@@ -577,6 +671,11 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(m.Compact.ReadCount),
 		redact.Safe(m.Compact.RewriteCount),
 		redact.Safe(m.Compact.MultiLevelCount))
+	if m.Compact.FIFODroppedTables > 0 {
+		w.Printf("             fifo dropped: %d (%s)\n",
+			redact.Safe(m.Compact.FIFODroppedTables),
+			humanize.Bytes.Uint64(m.Compact.FIFODroppedBytes))
+	}
 
 	w.Printf("MemTables: %d (%s)  zombie: %d (%s)\n",
 		redact.Safe(m.MemTable.Count),
@@ -627,6 +726,39 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(m.Flush.AsIngestCount),
 		humanize.Bytes.Uint64(m.Flush.AsIngestBytes),
 		redact.Safe(m.Flush.AsIngestTableCount))
+	if m.DiskIO.ReadBytesPerSec > 0 || m.DiskIO.WriteBytesPerSec > 0 {
+		w.Printf("Disk I/O budget: read %s/s  write %s/s  throttled: flush %s  compaction %s  download %s  ingest %s\n",
+			humanize.Bytes.Uint64(m.DiskIO.ReadBytesPerSec),
+			humanize.Bytes.Uint64(m.DiskIO.WriteBytesPerSec),
+			redact.Safe(m.DiskIO.ThrottledDuration.Flush),
+			redact.Safe(m.DiskIO.ThrottledDuration.Compaction),
+			redact.Safe(m.DiskIO.ThrottledDuration.Download),
+			redact.Safe(m.DiskIO.ThrottledDuration.Ingest))
+	}
+	if m.CompactionScheduler.Slots > 0 {
+		w.Printf("Compaction slots: %d/%d in use  waits: %d (%s)\n",
+			redact.Safe(m.CompactionScheduler.Running),
+			redact.Safe(m.CompactionScheduler.Slots),
+			redact.Safe(m.CompactionScheduler.WaitCount),
+			redact.Safe(m.CompactionScheduler.WaitDuration))
+	}
+	if m.WriteAdmission.AdmittedCount > 0 {
+		w.Printf("Write admission: rate %s/s  queued %d  admitted %d (%s)  throttled %d (%s)\n",
+			humanize.Bytes.Uint64(m.WriteAdmission.BytesPerSec),
+			redact.Safe(m.WriteAdmission.Queued),
+			redact.Safe(m.WriteAdmission.AdmittedCount),
+			humanize.Bytes.Uint64(m.WriteAdmission.AdmittedBytes),
+			redact.Safe(m.WriteAdmission.ThrottledCount),
+			redact.Safe(m.WriteAdmission.ThrottledDuration))
+	}
+	for _, k := range m.HotKeys.Keys {
+		w.Printf("Hot key: %q  reads: %.1f/s  writes: %.1f/s\n",
+			k.Key, redact.Safe(k.ReadRate), redact.Safe(k.WriteRate))
+	}
+	for _, r := range m.HotKeys.Ranges {
+		w.Printf("Hot range: [%q, %q)  reads: %.1f/s  writes: %.1f/s\n",
+			r.Start, r.End, redact.Safe(r.ReadRate), redact.Safe(r.WriteRate))
+	}
 }
 
 func hitRate(hits, misses int64) float64 {
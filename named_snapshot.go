@@ -0,0 +1,252 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// NamedSnapshotInfo describes a named snapshot.
+type NamedSnapshotInfo struct {
+	Name string
+	// SeqNum is the sequence number of the snapshot.
+	SeqNum uint64
+	// CreationTime is the time the snapshot was created.
+	CreationTime time.Time
+	// PinnedKeys and PinnedSize are a running tally of the keys, and of the size
+	// of the keys and values, written to sstables by flushes and compactions
+	// since the DB was opened that would've been elided if it weren't for this
+	// snapshot (or another snapshot at the same sequence number).
+	PinnedKeys uint64
+	PinnedSize uint64
+}
+
+// namedSnapshot is a named snapshot that is recorded in the manifest.
+type namedSnapshot struct {
+	manifest.NamedSnapshot
+	// snapshot is linked into the DB's snapshot list, so that flushes and
+	// compactions preserve the view of the database at the snapshot's sequence
+	// number.
+	snapshot *Snapshot
+	// pending is set while the snapshot is being created, until it's recorded
+	// in the manifest. A pending snapshot cannot be opened or deleted. It's
+	// protected by DB.mu.
+	pending bool
+	// pinnedKeys and pinnedSize are protected by DB.mu.
+	pinnedKeys uint64
+	pinnedSize uint64
+}
+
+// CreateNamedSnapshot creates a snapshot of the current DB state that is
+// recorded in the MANIFEST, and so survives restarts until it's deleted with
+// DeleteNamedSnapshot. Like a Snapshot, it prevents flushes and compactions
+// from deleting the sequence numbers it references. Use OpenNamedSnapshot to
+// read from it.
+//
+// Creating a named snapshot syncs the WAL, so that the writes visible to it
+// survive a crash. Named snapshots require FormatNamedSnapshots.
+func (d *DB) CreateNamedSnapshot(name string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if name == "" {
+		return errors.New("pebble: named snapshot requires a name")
+	}
+	if v := d.FormatMajorVersion(); v < FormatNamedSnapshots {
+		return errors.Newf("pebble: named snapshots require at least format major version %d (current: %d)",
+			FormatNamedSnapshots, v)
+	}
+
+	// Link the snapshot into the snapshot list before anything else, so that
+	// no flush or compaction deletes the sequence numbers it references. The
+	// name is reserved by a pending snapshot until it's recorded in the
+	// manifest.
+	d.mu.Lock()
+	if _, ok := d.mu.snapshots.named[name]; ok {
+		d.mu.Unlock()
+		return errors.Errorf("pebble: named snapshot %q already exists", name)
+	}
+	ns := &namedSnapshot{
+		NamedSnapshot: manifest.NamedSnapshot{
+			Name:         name,
+			SeqNum:       d.mu.versions.visibleSeqNum.Load(),
+			CreationTime: d.timeNow().Unix(),
+		},
+		pending: true,
+	}
+	ns.snapshot = &Snapshot{db: d, seqNum: ns.SeqNum}
+	d.mu.snapshots.pushBack(ns.snapshot)
+	d.mu.snapshots.named[name] = ns
+	d.mu.Unlock()
+
+	// Every batch visible to the snapshot has been written to the WAL. Sync it,
+	// so that the snapshot never references writes lost in a crash.
+	var err error
+	if !d.opts.DisableWAL {
+		err = d.LogData(nil, Sync)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed.Load() != nil {
+		// The snapshot was unlinked by Close.
+		return ErrClosed
+	}
+	if err == nil {
+		jobID := d.newJobIDLocked()
+		d.mu.versions.logLock()
+		err = d.mu.versions.logAndApply(jobID, &manifest.VersionEdit{
+			CreatedSnapshots: []manifest.NamedSnapshot{ns.NamedSnapshot},
+		}, map[int]*LevelMetrics{}, false /* forceRotation */, func() []compactionInfo {
+			return d.getInProgressCompactionInfoLocked(nil)
+		})
+	}
+	if err != nil {
+		delete(d.mu.snapshots.named, name)
+		return errors.CombineErrors(err, ns.snapshot.closeLocked())
+	}
+	ns.pending = false
+	return nil
+}
+
+// DeleteNamedSnapshot deletes the named snapshot, allowing compactions to
+// reclaim the space it pins. Snapshots returned by OpenNamedSnapshot remain
+// usable until they're closed.
+func (d *DB) DeleteNamedSnapshot(name string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ns, err := d.namedSnapshotLocked(name)
+	if err != nil {
+		return err
+	}
+	jobID := d.newJobIDLocked()
+	d.mu.versions.logLock()
+	if err := d.mu.versions.logAndApply(jobID, &manifest.VersionEdit{
+		DeletedSnapshots: []string{name},
+	}, map[int]*LevelMetrics{}, false /* forceRotation */, func() []compactionInfo {
+		return d.getInProgressCompactionInfoLocked(nil)
+	}); err != nil {
+		return err
+	}
+	delete(d.mu.snapshots.named, name)
+	return ns.snapshot.closeLocked()
+}
+
+// OpenNamedSnapshot returns a Snapshot that reads the DB state captured by the
+// named snapshot. The caller must call Snapshot.Close when the snapshot is no
+// longer needed.
+func (d *DB) OpenNamedSnapshot(name string) (*Snapshot, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ns, err := d.namedSnapshotLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{db: d, seqNum: ns.SeqNum}
+	// Keep the snapshot list in sequence number order.
+	d.mu.snapshots.insertAfter(ns.snapshot, s)
+	return s, nil
+}
+
+// namedSnapshotLocked returns the named snapshot with the given name. It
+// returns an error if there is none, or if it's still being created.
+//
+// d.mu must be held when calling this.
+func (d *DB) namedSnapshotLocked(name string) (*namedSnapshot, error) {
+	ns, ok := d.mu.snapshots.named[name]
+	if !ok {
+		return nil, errors.Errorf("pebble: named snapshot %q not found", name)
+	}
+	if ns.pending {
+		return nil, errors.Errorf("pebble: named snapshot %q is being created", name)
+	}
+	return ns, nil
+}
+
+// NamedSnapshots returns the named snapshots, sorted by name.
+func (d *DB) NamedSnapshots() []NamedSnapshotInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.namedSnapshotsLocked()
+}
+
+func (d *DB) namedSnapshotsLocked() []NamedSnapshotInfo {
+	if len(d.mu.snapshots.named) == 0 {
+		return nil
+	}
+	infos := make([]NamedSnapshotInfo, 0, len(d.mu.snapshots.named))
+	for _, ns := range d.mu.snapshots.named {
+		if ns.pending {
+			continue
+		}
+		infos = append(infos, NamedSnapshotInfo{
+			Name:         ns.Name,
+			SeqNum:       ns.SeqNum,
+			CreationTime: time.Unix(ns.CreationTime, 0),
+			PinnedKeys:   ns.pinnedKeys,
+			PinnedSize:   ns.pinnedSize,
+		})
+	}
+	slices.SortFunc(infos, func(a, b NamedSnapshotInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos
+}
+
+// loadNamedSnapshotsLocked links the named snapshots recorded in the manifest
+// into the snapshot list. It's called when opening the DB, before the WALs are
+// replayed.
+func (d *DB) loadNamedSnapshotsLocked() {
+	d.mu.snapshots.named = make(map[string]*namedSnapshot)
+	for _, s := range d.mu.versions.namedSnapshotsSorted() {
+		ns := &namedSnapshot{
+			NamedSnapshot: s,
+			snapshot:      &Snapshot{db: d, seqNum: s.SeqNum},
+		}
+		d.mu.snapshots.pushBack(ns.snapshot)
+		d.mu.snapshots.named[s.Name] = ns
+	}
+}
+
+// releaseNamedSnapshotsLocked unlinks the named snapshots from the snapshot
+// list when closing the DB. They remain recorded in the manifest.
+func (d *DB) releaseNamedSnapshotsLocked() {
+	for _, ns := range d.mu.snapshots.named {
+		d.mu.snapshots.remove(ns.snapshot)
+		ns.snapshot.db = nil
+	}
+	d.mu.snapshots.named = nil
+}
+
+// updateNamedSnapshotPinnedLocked adds the snapshot-pinned keys written by a
+// flush or compaction to the named snapshots they were pinned by.
+func (d *DB) updateNamedSnapshotPinnedLocked(stats *compactStats) {
+	if len(stats.pinnedBySnapshot) == 0 {
+		return
+	}
+	for _, ns := range d.mu.snapshots.named {
+		if p, ok := stats.pinnedBySnapshot[ns.SeqNum]; ok {
+			ns.pinnedKeys += p.keys
+			ns.pinnedSize += p.size
+		}
+	}
+}
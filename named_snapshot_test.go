@@ -0,0 +1,183 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamedSnapshots(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: FormatNewest,
+		// Rotate the manifest on every edit, so that the named snapshots must be
+		// carried over to the new manifests.
+		MaxManifestFileSize: 1,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	get := func(r Reader, key string) string {
+		v, closer, err := r.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("1"), nil))
+	require.NoError(t, d.CreateNamedSnapshot("backup"))
+	require.Error(t, d.CreateNamedSnapshot("backup"))
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.NoError(t, d.CreateNamedSnapshot("audit"))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// The named snapshots survive a restart, and the compactions that follow
+	// preserve the state they captured.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	infos := d.NamedSnapshots()
+	require.Len(t, infos, 2)
+	require.Equal(t, "audit", infos[0].Name)
+	require.Equal(t, "backup", infos[1].Name)
+	require.Less(t, infos[1].SeqNum, infos[0].SeqNum)
+	require.False(t, infos[0].CreationTime.IsZero())
+
+	require.NoError(t, d.Set([]byte("a"), []byte("3"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), true))
+	s, err := d.OpenNamedSnapshot("backup")
+	require.NoError(t, err)
+	require.Equal(t, "1", get(s, "a"))
+	require.Equal(t, "1", get(s, "b"))
+	require.NoError(t, s.Close())
+	s, err = d.OpenNamedSnapshot("audit")
+	require.NoError(t, err)
+	require.Equal(t, "2", get(s, "a"))
+	require.Equal(t, "<not found>", get(s, "b"))
+	require.Equal(t, "3", get(d, "a"))
+
+	// The keys pinned by each named snapshot are reported.
+	m := d.Metrics()
+	// The open Snapshot of "audit" is counted along with the named snapshots.
+	require.Equal(t, 3, m.Snapshots.Count)
+	require.Len(t, m.Snapshots.Named, 2)
+	for _, info := range m.Snapshots.Named {
+		require.Equal(t, uint64(2), info.PinnedKeys, info.Name)
+		require.Greater(t, info.PinnedSize, uint64(0))
+	}
+
+	// Deleting a named snapshot lets compactions reclaim the keys it pinned.
+	// An open Snapshot of it remains usable.
+	require.NoError(t, d.DeleteNamedSnapshot("audit"))
+	require.Error(t, d.DeleteNamedSnapshot("audit"))
+	_, err = d.OpenNamedSnapshot("audit")
+	require.Error(t, err)
+	require.Equal(t, "2", get(s, "a"))
+	require.NoError(t, s.Close())
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	infos = d.NamedSnapshots()
+	require.Len(t, infos, 1)
+	require.Equal(t, "backup", infos[0].Name)
+	require.NoError(t, d.DeleteNamedSnapshot("backup"))
+	require.NoError(t, d.Set([]byte("a"), []byte("4"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), true))
+	m = d.Metrics()
+	require.Zero(t, m.Snapshots.Count)
+	require.Empty(t, m.Snapshots.Named)
+	stats, err := d.EstimateRangeStats([]byte("a"), []byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.PointKeys.Estimate)
+	require.NoError(t, d.Close())
+}
+
+func TestNamedSnapshotsFormatMajorVersion(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatSyntheticPrefixSuffix})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.Error(t, d.CreateNamedSnapshot("backup"))
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatNamedSnapshots))
+	require.NoError(t, d.CreateNamedSnapshot("backup"))
+	require.Len(t, d.NamedSnapshots(), 1)
+}
+
+// TestNamedSnapshotPending tests that a named snapshot cannot be opened or
+// deleted while it's being created.
+func TestNamedSnapshotPending(t *testing.T) {
+	fs := &walSyncBlockingFS{FS: vfs.NewMem(), synced: make(chan struct{}), unblock: make(chan struct{})}
+	d, err := Open("", &Options{FS: fs, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+
+	fs.block.Store(true)
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.CreateNamedSnapshot("backup") }()
+	// The creation is blocked syncing the WAL.
+	<-fs.synced
+	require.Error(t, d.DeleteNamedSnapshot("backup"))
+	_, err = d.OpenNamedSnapshot("backup")
+	require.Error(t, err)
+	require.Error(t, d.CreateNamedSnapshot("backup"))
+	require.Empty(t, d.NamedSnapshots())
+	close(fs.unblock)
+	require.NoError(t, <-errCh)
+
+	require.Len(t, d.NamedSnapshots(), 1)
+	require.NoError(t, d.DeleteNamedSnapshot("backup"))
+	require.Empty(t, d.NamedSnapshots())
+}
+
+// walSyncBlockingFS blocks the first sync of a WAL after block is set until
+// unblock is closed.
+type walSyncBlockingFS struct {
+	vfs.FS
+	block   atomic.Bool
+	synced  chan struct{}
+	unblock chan struct{}
+}
+
+func (fs *walSyncBlockingFS) Create(name string, category vfs.DiskWriteCategory) (vfs.File, error) {
+	f, err := fs.FS.Create(name, category)
+	if err != nil || !strings.HasSuffix(name, ".log") {
+		return f, err
+	}
+	return &walSyncBlockingFile{File: f, fs: fs}, nil
+}
+
+type walSyncBlockingFile struct {
+	vfs.File
+	fs *walSyncBlockingFS
+}
+
+func (f *walSyncBlockingFile) maybeBlock() {
+	if f.fs.block.CompareAndSwap(true, false) {
+		close(f.fs.synced)
+		<-f.fs.unblock
+	}
+}
+
+func (f *walSyncBlockingFile) Sync() error {
+	f.maybeBlock()
+	return f.File.Sync()
+}
+
+func (f *walSyncBlockingFile) SyncData() error {
+	f.maybeBlock()
+	return f.File.SyncData()
+}
@@ -206,6 +206,12 @@ type CreateOptions struct {
 	// WriteCategory is used for the object when it is created on local storage
 	// to collect aggregated write metrics for each write source.
 	WriteCategory vfs.DiskWriteCategory
+
+	// BeforeCopy, if set, is called by LinkOrCopyFromLocal before the contents
+	// of the local file are copied, i.e. when the object is not created as a
+	// hard link. It may block, e.g. to throttle the copy. If it returns an
+	// error, the object is not created and the error is returned.
+	BeforeCopy func() error
 }
 
 // Provider is a singleton object used to access and manage objects.
@@ -272,6 +278,12 @@ type Provider interface {
 	// List returns the objects currently known to the provider. Does not perform any I/O.
 	List() []ObjectMetadata
 
+	// AddLocalObjects registers the local objects in the given listing of the
+	// provider's directory that are not yet known to the provider. It is used
+	// to pick up the objects created by another process sharing the directory,
+	// such as the primary of a read-only follower. Does not perform any I/O.
+	AddLocalObjects(listing []string)
+
 	// SetCreatorID sets the CreatorID which is needed in order to use shared
 	// objects. Remote object usage is disabled until this method is called the
 	// first time. Once set, the Creator ID is persisted and cannot change.
@@ -348,7 +348,7 @@ func (p *provider) LinkOrCopyFromLocal(
 			BytesPerSync:  p.st.BytesPerSync,
 		})
 		dstPath := p.vfsPath(dstFileType, dstFileNum)
-		if err := vfs.LinkOrCopy(fs, srcFilePath, dstPath); err != nil {
+		if err := vfs.LinkOrCopyWithCopyHook(fs, srcFilePath, dstPath, opts.BeforeCopy); err != nil {
 			return objstorage.ObjectMetadata{}, err
 		}
 
@@ -360,6 +360,11 @@ func (p *provider) LinkOrCopyFromLocal(
 		return meta, nil
 	}
 	// Create the object and copy the data.
+	if opts.BeforeCopy != nil {
+		if err := opts.BeforeCopy(); err != nil {
+			return objstorage.ObjectMetadata{}, err
+		}
+	}
 	w, meta, err := p.Create(ctx, dstFileType, dstFileNum, opts)
 	if err != nil {
 		return objstorage.ObjectMetadata{}, err
@@ -447,6 +452,13 @@ func (p *provider) List() []objstorage.ObjectMetadata {
 	return res
 }
 
+// AddLocalObjects is part of the objstorage.Provider interface.
+func (p *provider) AddLocalObjects(listing []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.vfsAddObjectsLocked(listing)
+}
+
 // Metrics is part of the objstorage.Provider interface.
 func (p *provider) Metrics() sharedcache.Metrics {
 	if p.remote.cache != nil {
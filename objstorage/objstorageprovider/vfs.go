@@ -71,17 +71,28 @@ func (p *provider) vfsInit() error {
 		}
 	}
 
+	p.vfsAddObjectsLocked(listing)
+	return nil
+}
+
+// vfsAddObjectsLocked adds the local objects in the given listing that are
+// not yet known.
+//
+// p.mu must be held when calling this, unless the provider is being opened.
+func (p *provider) vfsAddObjectsLocked(listing []string) {
 	for _, filename := range listing {
 		fileType, fileNum, ok := base.ParseFilename(p.st.FS, filename)
-		if ok && fileType == base.FileTypeTable {
-			o := objstorage.ObjectMetadata{
-				FileType:    fileType,
-				DiskFileNum: fileNum,
-			}
-			p.mu.knownObjects[o.DiskFileNum] = o
+		if !ok || fileType != base.FileTypeTable {
+			continue
+		}
+		if _, ok := p.mu.knownObjects[fileNum]; ok {
+			continue
+		}
+		p.mu.knownObjects[fileNum] = objstorage.ObjectMetadata{
+			FileType:    fileType,
+			DiskFileNum: fileNum,
 		}
 	}
-	return nil
 }
 
 func (p *provider) vfsSync() error {
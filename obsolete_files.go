@@ -475,6 +475,10 @@ func (d *DB) deleteObsoleteFiles(jobID JobID) {
 	if d.mu.disableFileDeletions > 0 {
 		return
 	}
+	if d.follower != nil {
+		d.forgetObsoleteFilesLocked()
+		return
+	}
 	_, noRecycle := d.opts.Cleaner.(base.NeedsFileContents)
 
 	// NB: d.mu.versions.minUnflushedLogNum is the log number of the earliest
@@ -74,6 +74,13 @@ func TableCacheSize(maxOpenFiles int) int {
 
 // Open opens a DB whose files live in the given directory.
 func Open(dirname string, opts *Options) (db *DB, err error) {
+	return open(dirname, opts, nil /* follower */)
+}
+
+// open opens a DB whose files live in the given directory. If follower is
+// non-nil, the DB is opened as a follower of the primary that owns the
+// directory (see OpenFollower).
+func open(dirname string, opts *Options, follower *followerState) (db *DB, err error) {
 	// Make a copy of the options so that we don't mutate the passed in options.
 	opts = opts.Clone()
 	opts = opts.EnsureDefaults()
@@ -85,6 +92,10 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 	} else {
 		opts.Logger = opts.LoggerAndTracer
 	}
+	if opts.DiskIOBudget != nil {
+		// Feed disk slowness events to the budget so that it may auto-tune.
+		opts.AddEventListener(EventListener{DiskSlow: opts.DiskIOBudget.ReportDiskSlow})
+	}
 
 	// In all error cases, we return db = nil; this is used by various
 	// deferred cleanups.
@@ -102,7 +113,9 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 
 	// Lock the database directory.
 	var fileLock *Lock
-	if opts.Lock != nil {
+	if follower != nil {
+		// The primary holds the lock on the directory.
+	} else if opts.Lock != nil {
 		// The caller already acquired the database lock. Ensure that the
 		// directory matches.
 		if err := opts.Lock.pathMatches(dirname); err != nil {
@@ -119,7 +132,7 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		}
 	}
 	defer func() {
-		if db == nil {
+		if db == nil && fileLock != nil {
 			fileLock.Close()
 		}
 	}()
@@ -218,9 +231,13 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		dataDir:             dataDir,
 		closed:              new(atomic.Value),
 		closedCh:            make(chan struct{}),
+		follower:            follower,
 	}
 	d.mu.versions = &versionSet{}
 	d.diskAvailBytes.Store(math.MaxUint64)
+	d.diskIO = newDiskIOThrottler(opts.DiskIOBudget, d.closedCh)
+	d.writeBuffer = opts.WriteBufferManager.register(d)
+	d.compactionSlots = opts.CompactionScheduler.register(d)
 
 	defer func() {
 		// If an error or panic occurs during open, attempt to release the manually
@@ -233,6 +250,8 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 			// the tableCache, then the tableCache will also release its
 			// reference to the cache.
 			opts.Cache.Unref()
+			d.writeBuffer.unregister()
+			d.compactionSlots.unregister()
 
 			if d.tableCache != nil {
 				_ = d.tableCache.close()
@@ -262,7 +281,9 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		visibleSeqNum: &d.mu.versions.visibleSeqNum,
 		apply:         d.commitApply,
 		write:         d.commitWrite,
+		published:     d.published,
 	})
+	d.watches.cmp = d.cmp
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
 	if d.mu.mem.nextSize > initialMemTableSize {
@@ -282,6 +303,28 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 
 	d.timeNow = time.Now
 	d.openedAt = d.timeNow()
+	if opts.Experimental.HotKeys.SampleRate > 0 {
+		d.hotKeys = newHotKeyTracker(opts.Experimental.HotKeys, opts.Comparer,
+			func() time.Time { return d.timeNow() }, opts.EventListener.HotKeysDetected)
+	}
+	if opts.Experimental.WriteAdmission.enabled() && !opts.ReadOnly {
+		d.writeAdmission = newWriteAdmissionController(opts.Experimental.WriteAdmission,
+			func() time.Time { return d.timeNow() })
+	}
+
+	if follower != nil {
+		// Pin the files of the primary before reading its manifest, so that none
+		// of the files it references are deleted before the lease is renewed.
+		follower.walDirname = walDirname
+		if err := follower.writeLease(opts.FS, d.openedAt, nil /* pinned */); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if db == nil {
+				_ = follower.removeLease(opts.FS)
+			}
+		}()
+	}
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -322,8 +365,12 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		if opts.ErrorIfExists {
 			return nil, errors.Wrapf(ErrDBAlreadyExists, "dirname=%q", dirname)
 		}
-		// Load the version set.
-		if err := d.mu.versions.load(
+		// Load the version set. The version set of a follower is instead
+		// populated when it catches up with the primary's manifest below.
+		if follower != nil {
+			d.mu.versions.initFollower(
+				dirname, d.objProvider, opts, manifestMarker, d.FormatMajorVersion, &d.mu.Mutex)
+		} else if err := d.mu.versions.load(
 			dirname, d.objProvider, opts, manifestFileNum, manifestMarker, d.FormatMajorVersion, &d.mu.Mutex); err != nil {
 			return nil, err
 		}
@@ -336,6 +383,8 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		}
 	}
 
+	d.loadNamedSnapshotsLocked()
+
 	// In read-only mode, we replay directly into the mutable memtable but never
 	// flush it. We need to delay creation of the memtable until we know the
 	// sequence number of the first batch that will be inserted.
@@ -359,9 +408,13 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		MinSyncInterval:      opts.WALMinSyncInterval,
 		FsyncLatency:         d.mu.log.metrics.fsyncLatency,
 		QueueSemChan:         d.commit.logSyncQSem,
+		Compression:          d.walCompression,
 		Logger:               opts.Logger,
 		EventListener:        walEventListenerAdaptor{l: opts.EventListener},
 	}
+	if opts.WALArchive != nil && !opts.ReadOnly {
+		walOpts.Archive = d.archiveWAL
+	}
 	if opts.WALFailover != nil {
 		walOpts.Secondary = opts.WALFailover.Secondary
 		walOpts.FailoverOptions = opts.WALFailover.FailoverOptions
@@ -460,10 +513,11 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		}
 	}
 
-	// Replay any newer log files than the ones named in the manifest.
+	// Replay any newer log files than the ones named in the manifest. A
+	// follower replays them when it catches up with the primary below.
 	var replayWALs wal.Logs
 	for i, w := range wals {
-		if base.DiskFileNum(w.Num) >= d.mu.versions.minUnflushedLogNum {
+		if follower == nil && base.DiskFileNum(w.Num) >= d.mu.versions.minUnflushedLogNum {
 			replayWALs = wals[i:]
 			break
 		}
@@ -478,7 +532,7 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		// 20.1 do not guarantee that closed WALs end cleanly. But the earliest
 		// compatible Pebble format is newer and guarantees a clean EOF.
 		strictWALTail := i < len(replayWALs)-1
-		flush, maxSeqNum, err := d.replayWAL(jobID, &ve, lf, strictWALTail)
+		flush, maxSeqNum, err := d.replayWAL(jobID, &ve, base.DiskFileNum(lf.Num), lf.OpenForRead(), strictWALTail)
 		if err != nil {
 			return nil, err
 		}
@@ -488,6 +542,7 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 		}
 	}
 	d.mu.versions.visibleSeqNum.Store(d.mu.versions.logSeqNum.Load())
+	d.initHistoryLocked()
 
 	if !d.opts.ReadOnly {
 		// Create an empty .log file.
@@ -524,6 +579,15 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
 
+	if follower != nil {
+		d.mu.Unlock()
+		err := d.followerCatchUp()
+		d.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if !d.opts.ReadOnly {
 		// If the Options specify a format major version higher than the
 		// loaded database's, upgrade it. If this is a new database, this
@@ -593,6 +657,22 @@ func Open(dirname string, opts *Options) (db *DB, err error) {
 
 	d.maybeScheduleFlush()
 	d.maybeScheduleCompaction()
+	d.writeBuffer.markOpened()
+	if d.opts.Experimental.Tiering.Interval > 0 && !d.opts.ReadOnly {
+		go d.runTieringLoop()
+	}
+	if follower != nil && follower.opts.CatchUpInterval > 0 {
+		go d.runFollowerLoop()
+	}
+	if d.opts.Experimental.HistoryRetention.enabled() {
+		go d.runHistoryLoop()
+	}
+	if d.writeAdmission != nil {
+		go d.runWriteAdmissionLoop()
+	}
+	if d.opts.WALArchive != nil && !d.opts.ReadOnly {
+		go d.runWALTimestampLoop()
+	}
 
 	// Note: this is a no-op if invariants are disabled or race is enabled.
 	//
@@ -736,7 +816,8 @@ func GetVersion(dir string, fs vfs.FS) (string, error) {
 	return version, nil
 }
 
-// replayWAL replays the edits in the specified WAL. If the DB is in read
+// replayWAL replays the edits read from rr, a reader of the WAL with the
+// given number, and closes rr. If the DB is in read
 // only mode, then the WALs are replayed into memtables and not flushed. If
 // the DB is not in read only mode, then the contents of the WAL are
 // guaranteed to be flushed. Note that this flushing is very important for
@@ -756,9 +837,8 @@ func GetVersion(dir string, fs vfs.FS) (string, error) {
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
 func (d *DB) replayWAL(
-	jobID JobID, ve *versionEdit, ll wal.LogicalLog, strictWALTail bool,
+	jobID JobID, ve *versionEdit, logNum base.DiskFileNum, rr wal.Reader, strictWALTail bool,
 ) (toFlush flushableList, maxSeqNum uint64, err error) {
-	rr := ll.OpenForRead()
 	defer rr.Close()
 	var (
 		b               Batch
@@ -808,7 +888,7 @@ func (d *DB) replayWAL(
 		if mem != nil {
 			return
 		}
-		mem, entry = d.newMemTable(base.DiskFileNum(ll.Num), seqNum)
+		mem, entry = d.newMemTable(logNum, seqNum)
 		if d.opts.ReadOnly {
 			d.mu.mem.mutable = mem
 			d.mu.mem.queue = append(d.mu.mem.queue, entry)
@@ -835,7 +915,7 @@ func (d *DB) replayWAL(
 	}
 	defer func() {
 		if err != nil {
-			err = errors.WithDetailf(err, "replaying wal %d, offset %d", ll.Num, offset)
+			err = errors.WithDetailf(err, "replaying wal %d, offset %d", logNum, offset)
 		}
 	}()
 
@@ -860,7 +940,7 @@ func (d *DB) replayWAL(
 
 		if buf.Len() < batchrepr.HeaderLen {
 			return nil, 0, base.CorruptionErrorf("pebble: corrupt wal %s (offset %s)",
-				errors.Safe(base.DiskFileNum(ll.Num)), offset)
+				errors.Safe(logNum), offset)
 		}
 
 		if d.opts.ErrorIfNotPristine {
@@ -946,7 +1026,7 @@ func (d *DB) replayWAL(
 					panic("pebble: couldn't load all files in WAL entry.")
 				}
 
-				entry, err = d.newIngestedFlushableEntry(meta, seqNum, base.DiskFileNum(ll.Num), KeyRange{})
+				entry, err = d.newIngestedFlushableEntry(meta, seqNum, logNum, KeyRange{})
 				if err != nil {
 					return nil, 0, err
 				}
@@ -1005,7 +1085,7 @@ func (d *DB) replayWAL(
 			if err != nil {
 				return nil, 0, err
 			}
-			entry := d.newFlushableEntry(b.flushable, base.DiskFileNum(ll.Num), b.SeqNum())
+			entry := d.newFlushableEntry(b.flushable, logNum, b.SeqNum())
 			// Disable memory accounting by adding a reader ref that will never be
 			// removed.
 			entry.readerRefs.Add(1)
@@ -1047,8 +1127,12 @@ func (d *DB) replayWAL(
 		buf.Reset()
 	}
 
-	d.opts.Logger.Infof("[JOB %d] WAL %s stopped reading at offset: %d; replayed %d keys in %d batches",
-		jobID, base.DiskFileNum(ll.Num).String(), offset, keysReplayed, batchesReplayed)
+	if d.follower == nil || batchesReplayed > 0 {
+		// A follower replays the tail of the WAL every time it catches up, which
+		// is usually empty.
+		d.opts.Logger.Infof("[JOB %d] WAL %s stopped reading at offset: %d; replayed %d keys in %d batches",
+			jobID, logNum.String(), offset, keysReplayed, batchesReplayed)
+	}
 	flushMem()
 
 	// mem is nil here.
@@ -325,7 +325,7 @@ func TestNewDBFilenames(t *testing.T) {
 			"LOCK",
 			"MANIFEST-000001",
 			"OPTIONS-000003",
-			"marker.format-version.000004.017",
+			"marker.format-version.000006.019",
 			"marker.manifest.000001.MANIFEST-000001",
 		},
 	}
@@ -861,6 +861,57 @@ func TestOpenWALReplay2(t *testing.T) {
 	}
 }
 
+// TestOpenWALReplayCompressed tests that the records of a WAL written with
+// Options.WALCompression are compressed once the format major version allows
+// it, and are decompressed when the WAL is replayed.
+func TestOpenWALReplayCompressed(t *testing.T) {
+	value := bytes.Repeat([]byte("compressible "), 1<<10)
+	for _, fmv := range []FormatMajorVersion{FormatWALCompression - 1, FormatWALCompression} {
+		for _, compression := range []Compression{SnappyCompression, ZstdCompression} {
+			t.Run(fmt.Sprintf("fmv=%s,compression=%s", fmv, compression), func(t *testing.T) {
+				mem := vfs.NewMem()
+				opts := &Options{
+					FS:                 mem,
+					FormatMajorVersion: fmv,
+					WALCompression:     compression,
+				}
+				d, err := Open("", opts)
+				require.NoError(t, err)
+				for i := 0; i < 10; i++ {
+					require.NoError(t, d.Set([]byte(fmt.Sprint(i)), value, nil))
+				}
+				// Records are only compressed at FormatWALCompression.
+				m := d.Metrics()
+				compressed := m.WAL.Size < uint64(len(value))
+				require.Equal(t, fmv >= FormatWALCompression, compressed, "WAL size: %d", m.WAL.Size)
+				require.NoError(t, d.Close())
+
+				// The compressed records are decompressed when replaying the WAL.
+				d, err = Open("", opts)
+				require.NoError(t, err)
+				for i := 0; i < 10; i++ {
+					v, closer, err := d.Get([]byte(fmt.Sprint(i)))
+					require.NoError(t, err)
+					require.Equal(t, value, v)
+					require.NoError(t, closer.Close())
+				}
+				// Rotating the WAL accumulates the metrics of its writer.
+				require.NoError(t, d.Set([]byte("a"), value, nil))
+				require.NoError(t, d.Flush())
+				m = d.Metrics()
+				if compressed {
+					require.Greater(t, m.LogWriter.UncompressedBytes, int64(len(value)))
+					require.Greater(t, m.LogWriter.CompressionRatio(), 10.0)
+					require.Contains(t, m.String(), "WAL compression: ")
+				} else {
+					require.Zero(t, m.LogWriter.UncompressedBytes)
+				}
+				require.NoError(t, d.Close())
+			})
+		}
+	}
+}
+
 // TestTwoWALReplayCorrupt tests WAL-replay behavior when the first of the two
 // WALs is corrupted with an sstable checksum error. Replay must stop at the
 // first WAL because otherwise we may violate point-in-time recovery
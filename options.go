@@ -271,6 +271,21 @@ type scanInternalOptions struct {
 	// are exposed. If false, only one internal key per user key is exposed.
 	includeObsoleteKeys bool
 
+	// includeObsoletePoints specifies whether the points that sstables mark as
+	// obsolete, which are only visible to readers at older snapshots, are
+	// exposed. Only meaningful with includeObsoleteKeys.
+	includeObsoletePoints bool
+
+	// includeRangeKeySeqNums specifies whether the range keys passed to
+	// visitRangeKey keep their sequence numbers. If false, their sequence
+	// numbers are zeroed.
+	includeRangeKeySeqNums bool
+
+	// includeShadowedRangeDels specifies whether visitRangeDel is called for
+	// every range deletion of a fragment, from the newest to the oldest. If
+	// false, it is only called for the newest one.
+	includeShadowedRangeDels bool
+
 	// rateLimitFunc is used to limit the amount of bytes read per second.
 	rateLimitFunc func(key *InternalKey, value LazyValue) error
 }
@@ -364,6 +379,16 @@ type WriteOptions struct {
 	//
 	// The default value is true.
 	Sync bool
+
+	// Priority is the priority of the write for admission control (see
+	// Options.Experimental.WriteAdmission). While writes are throttled,
+	// waiting writes are admitted in priority order.
+	Priority WritePriority
+
+	// Tenant identifies the tenant on whose behalf the write is performed. While
+	// writes are throttled, waiting writes of the same priority are admitted so
+	// that every tenant is granted a fair share of the admitted bytes.
+	Tenant string
 }
 
 // Sync specifies the default write options for writes which synchronize to
@@ -379,6 +404,24 @@ func (o *WriteOptions) GetSync() bool {
 	return o == nil || o.Sync
 }
 
+// GetPriority returns the Priority value or WritePriorityNormal if the
+// receiver is nil.
+func (o *WriteOptions) GetPriority() WritePriority {
+	if o == nil {
+		return WritePriorityNormal
+	}
+	return o.Priority
+}
+
+// GetTenant returns the Tenant value or the empty string if the receiver is
+// nil.
+func (o *WriteOptions) GetTenant() string {
+	if o == nil {
+		return ""
+	}
+	return o.Tenant
+}
+
 // LevelOptions holds the optional per-level parameters.
 type LevelOptions struct {
 	// BlockRestartInterval is the number of keys between restart points
@@ -465,6 +508,231 @@ func (o *LevelOptions) EnsureDefaults() *LevelOptions {
 	return o
 }
 
+// CompactionStyle selects the strategy used to pick automatic compactions.
+type CompactionStyle int8
+
+const (
+	// CompactionStyleLeveled is the default compaction style. Each level is
+	// targeted to be LevelMultiplier times larger than the level above it, and
+	// compactions are picked by scoring levels against their target sizes.
+	CompactionStyleLeveled CompactionStyle = iota
+	// CompactionStyleUniversal is a size-tiered compaction style that trades
+	// read amplification and space amplification for lower write
+	// amplification. The LSM is viewed as a sequence of sorted runs: every L0
+	// sublevel and every non-empty level below L0 is a sorted run. Compactions
+	// merge adjacent sorted runs of similar size. See
+	// UniversalCompactionOptions.
+	CompactionStyleUniversal
+	// CompactionStyleFIFO never rewrites data. Flushes and ingestions
+	// accumulate tables, and the oldest tables are deleted wholesale once the
+	// total size or the age of the data exceeds the configured limits. It is
+	// intended for time-ordered data that is only ever dropped from the old
+	// end; deletions and snapshots are not honored when dropping tables. See
+	// FIFOCompactionOptions.
+	CompactionStyleFIFO
+)
+
+// String implements fmt.Stringer.
+func (s CompactionStyle) String() string {
+	switch s {
+	case CompactionStyleLeveled:
+		return "leveled"
+	case CompactionStyleUniversal:
+		return "universal"
+	case CompactionStyleFIFO:
+		return "fifo"
+	default:
+		return fmt.Sprintf("CompactionStyle(%d)", int8(s))
+	}
+}
+
+// UniversalCompactionOptions configures compaction picking when
+// Options.CompactionStyle is CompactionStyleUniversal.
+type UniversalCompactionOptions struct {
+	// MaxSizeAmplificationPercent is the maximum permitted size of all sorted
+	// runs other than the oldest, expressed as a percentage of the size of the
+	// oldest sorted run. Exceeding it triggers a compaction of all sorted runs
+	// into the bottommost level.
+	//
+	// The default value is 200.
+	MaxSizeAmplificationPercent int
+
+	// MaxSortedRuns is the number of sorted runs above which the newest sorted
+	// runs are merged to bound read amplification.
+	//
+	// The default value is L0CompactionThreshold + numLevels - 1.
+	MaxSortedRuns int
+
+	// SizeRatioPercent is the slack used when accumulating sorted runs of
+	// similar size. Starting from the newest run, the next older run is
+	// included in a compaction if its size is at most (100 + SizeRatioPercent)
+	// percent of the total size of the runs accumulated so far.
+	//
+	// The default value is 1.
+	SizeRatioPercent int
+
+	// MinMergeWidth is the minimum number of sorted runs merged by a compaction
+	// triggered by the size ratio.
+	//
+	// The default value is 2.
+	MinMergeWidth int
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized.
+func (o *UniversalCompactionOptions) EnsureDefaults(l0CompactionThreshold int) {
+	if o.MaxSizeAmplificationPercent <= 0 {
+		o.MaxSizeAmplificationPercent = 200
+	}
+	if o.MaxSortedRuns <= 0 {
+		o.MaxSortedRuns = l0CompactionThreshold + numLevels - 1
+	}
+	if o.SizeRatioPercent <= 0 {
+		o.SizeRatioPercent = 1
+	}
+	if o.MinMergeWidth < 2 {
+		o.MinMergeWidth = 2
+	}
+}
+
+// FIFOCompactionOptions configures compaction picking when
+// Options.CompactionStyle is CompactionStyleFIFO. At least one of MaxTotalSize
+// and TTL must be set.
+type FIFOCompactionOptions struct {
+	// MaxTotalSize is the maximum total size of all tables in the DB. Once
+	// exceeded, the oldest tables are deleted until the total size falls below
+	// it. A value of zero disables the size limit.
+	MaxTotalSize int64
+
+	// TTL is the maximum age of a table, measured from its creation time. Tables
+	// older than TTL are deleted, oldest first. Expiry is evaluated whenever
+	// compactions are scheduled, e.g. after a flush. A value of zero disables
+	// the age limit.
+	TTL time.Duration
+
+	// IntraL0FileThreshold, if positive, is the number of L0 tables above which
+	// overlapping L0 tables are merged by an intra-L0 compaction in order to
+	// bound the number of files and L0 read amplification.
+	IntraL0FileThreshold int
+}
+
+// TieringPolicy configures the migration of tables between local disk and
+// remote storage according to how frequently they are read. The read heat of a
+// table is the number of reads of the table sampled by iterators (see
+// Experimental.ReadSamplingMultiplier), halved at every tiering pass so that
+// it decays over time.
+//
+// At every pass, local tables that are at least DemoteMinAge old and whose
+// heat is at most DemoteMaxHeat are copied to shared storage, and tables on
+// shared storage whose heat is at least PromoteMinHeat are copied back to
+// local disk. Each migration replaces the table in the LSM by an identical
+// table backed by the copy. Tables that were ingested from other DBs, external
+// tables (see DB.Download) and virtual tables sharing their backing with
+// other tables are never migrated.
+type TieringPolicy struct {
+	// Interval is the period between tiering passes. A value of zero disables
+	// tiering.
+	Interval time.Duration
+
+	// DemoteMinAge is the minimum age of a local table, measured from its
+	// creation time, before it may be demoted to shared storage. Demotion
+	// requires Experimental.CreateOnShared to be set.
+	DemoteMinAge time.Duration
+
+	// DemoteMaxHeat is the read heat at or below which a local table is
+	// demoted.
+	DemoteMaxHeat int64
+
+	// PromoteMinHeat is the read heat at or above which a table on shared
+	// storage is promoted to local disk. It must be greater than DemoteMaxHeat.
+	// A value of zero disables promotion.
+	PromoteMinHeat int64
+
+	// MaxConcurrentMigrations is the maximum number of tables migrated
+	// concurrently. Migrations also count towards MaxConcurrentCompactions.
+	// Defaults to 1.
+	MaxConcurrentMigrations int
+}
+
+// HotKeyOptions configures the sampled tracking of frequently read and written
+// keys. One in every SampleRate reads (gets and iterator seeks), and one in
+// every SampleRate keys written by committed batches, is recorded in bounded
+// heavy-hitters sketches over keys and over key prefixes (as determined by
+// Comparer.Split). See DB.HotKeys and DB.HotRanges.
+type HotKeyOptions struct {
+	// SampleRate is the number of reads, and of written keys, per sample. A
+	// value of zero disables tracking.
+	SampleRate int
+
+	// Capacity is the number of keys, and of key prefixes, tracked. Keys whose
+	// frequency is below the frequency of the least frequent tracked key are
+	// evicted. Defaults to 128.
+	Capacity int
+
+	// Window is the period over which rates are measured. The rates reported
+	// cover the previous window and the current, partial one. Defaults to one
+	// minute.
+	Window time.Duration
+
+	// ReportThreshold is the rate, in reads and writes per second, at or above
+	// which the keys and key prefixes of a window are reported through
+	// EventListener.HotKeysDetected when the window ends. A value of zero
+	// disables the event.
+	ReportThreshold float64
+}
+
+// HistoryRetentionPolicy configures the retention of past versions of keys,
+// so that the DB can be read as of any sequence number within a recent window
+// (see DB.NewSnapshotAt). Compactions preserve the state of the DB at every
+// sequence number within the window, as if there were a snapshot at each of
+// them. The window is the union of the most recent SeqNums sequence numbers
+// and of the sequence numbers written within the last Duration.
+//
+// The DB periodically records the visible sequence number along with the
+// wall time, which maps times to sequence numbers for DB.SeqNumAt and for
+// Duration. The mapping is not persisted; when the DB is opened, it is seeded
+// from the creation times and sequence numbers of the tables in the LSM.
+type HistoryRetentionPolicy struct {
+	// Duration is the period of wall time for which history is retained. A
+	// value of zero retains no history by duration.
+	Duration time.Duration
+
+	// SeqNums is the number of most recent sequence numbers for which history
+	// is retained. A value of zero retains no history by sequence number.
+	SeqNums uint64
+
+	// SampleInterval is the period at which the visible sequence number is
+	// recorded. It bounds the precision of DB.SeqNumAt. Defaults to one
+	// second.
+	SampleInterval time.Duration
+}
+
+// WriteAdmissionOptions configures the admission control of writes. While the
+// LSM is overloaded, because L0 has too many sublevels or the compaction debt
+// is too large, the bytes of the batches applied to the DB are metered by a
+// token bucket. Its rate is derived from the observed flush throughput, and is
+// reduced in proportion to the overload, which replaces the latency cliff of
+// the write stalls (see L0StopWritesThreshold and
+// MemTableStopWritesThreshold, which still apply) with a gradual slowdown.
+type WriteAdmissionOptions struct {
+	// L0SublevelThreshold is the number of L0 sublevels above which writes are
+	// throttled. A value of zero disables throttling based on L0 sublevels.
+	L0SublevelThreshold int
+
+	// CompactionDebtThreshold is the estimated compaction debt, in bytes,
+	// above which writes are throttled. A value of zero disables throttling
+	// based on compaction debt.
+	CompactionDebtThreshold uint64
+
+	// MinBytesPerSec is the lowest rate at which writes are admitted while
+	// throttled. Defaults to 1 MB/s.
+	MinBytesPerSec int64
+
+	// Interval is the period at which the health of the LSM is sampled and the
+	// rate of admission adjusted. Defaults to 250ms.
+	Interval time.Duration
+}
+
 // Options holds the optional parameters for configuring pebble. These options
 // apply to the DB at large; per-query options are defined by the IterOptions
 // and WriteOptions types.
@@ -488,6 +756,11 @@ type Options struct {
 	// The default cleaner uses the DeleteCleaner.
 	Cleaner Cleaner
 
+	// CompactionStyle selects the strategy used to pick automatic compactions.
+	//
+	// The default value is CompactionStyleLeveled.
+	CompactionStyle CompactionStyle
+
 	// Comparer defines a total ordering over the space of []byte keys: a 'less
 	// than' relationship. The same comparison algorithm must be used for reads
 	// and writes over the lifetime of the DB.
@@ -495,6 +768,23 @@ type Options struct {
 	// The default value uses the same ordering as bytes.Compare.
 	Comparer *Comparer
 
+	// DiskIOBudget, if non-nil, limits the disk bandwidth consumed by flushes,
+	// compactions, downloads and ingestion copies. The budget may be shared
+	// between multiple DBs that use the same device. See DiskIOBudget.
+	//
+	// The default value is nil, which imposes no limit.
+	DiskIOBudget *DiskIOBudget
+
+	// WriteBufferManager, if non-nil, enforces a memory budget for the
+	// memtables of all the DBs that share it, by flushing memtables across DBs
+	// as the budget is approached. See WriteBufferManager.
+	WriteBufferManager *WriteBufferManager
+
+	// CompactionScheduler, if non-nil, limits the number of automatic
+	// compactions running concurrently across all the DBs that share it, in
+	// addition to MaxConcurrentCompactions. See CompactionScheduler.
+	CompactionScheduler *CompactionScheduler
+
 	// DebugCheck is invoked, if non-nil, whenever a new version is being
 	// installed. Typically, this is set to pebble.DebugCheckLevels in tests
 	// or tools only, to check invariants over all the data in the database.
@@ -625,6 +915,30 @@ type Options struct {
 		// compaction will never get triggered.
 		MultiLevelCompactionHeuristic MultiLevelHeuristic
 
+		// CompactionPicker, if set, picks automatic compactions in place of the
+		// built-in picker for the configured CompactionStyle. Candidates it
+		// returns are validated before being scheduled; see CompactionPicker.
+		// Elision-only, rewrite and read-triggered compactions continue to be
+		// picked by the built-in picker.
+		CompactionPicker CompactionPicker
+
+		// MaxSubcompactions is the maximum number of disjoint key ranges a
+		// single compaction may be split into, each processed by its own
+		// goroutine. A compaction is only split while fewer than
+		// MaxConcurrentCompactions compactions are running, into at most one
+		// more range than the number of idle compaction slots, and only if each
+		// range holds at least a target file size worth of input. Values <= 1
+		// disable subcompactions.
+		MaxSubcompactions int
+
+		// CompactionExecutor, if set, runs eligible compactions outside of the
+		// DB, typically on a worker process sharing the DB's remote storage.
+		// Only compactions into the bottommost level whose inputs are all
+		// physical tables on shared storage are offloaded; see CompactionJob.
+		// The outputs are validated and installed by the DB as if the
+		// compaction had run locally.
+		CompactionExecutor CompactionExecutor
+
 		// MaxWriterConcurrency is used to indicate the maximum number of
 		// compression workers the compression queue is allowed to use. If
 		// MaxWriterConcurrency > 0, then the Writer will use parallelism, to
@@ -706,6 +1020,22 @@ type Options struct {
 		// on shared storage in bytes. If it is 0, no cache is used.
 		SecondaryCacheSizeBytes int64
 
+		// Tiering configures the migration of tables between local disk and
+		// shared storage according to their read heat. See TieringPolicy.
+		Tiering TieringPolicy
+
+		// HotKeys configures the sampled tracking of frequently read and written
+		// keys. See HotKeyOptions.
+		HotKeys HotKeyOptions
+
+		// HistoryRetention configures the retention of past versions of keys
+		// for reads at past sequence numbers. See HistoryRetentionPolicy.
+		HistoryRetention HistoryRetentionPolicy
+
+		// WriteAdmission configures the throttling of writes while the LSM is
+		// overloaded. See WriteAdmissionOptions.
+		WriteAdmission WriteAdmissionOptions
+
 		// NB: DO NOT crash on SingleDeleteInvariantViolationCallback or
 		// IneffectualSingleDeleteCallback, since these can be false positives
 		// even if SingleDel has been used correctly.
@@ -991,6 +1321,14 @@ type Options struct {
 	// and pebble will panic otherwise.
 	TableCache *TableCache
 
+	// FIFOCompaction configures compaction picking when CompactionStyle is
+	// CompactionStyleFIFO. It is ignored otherwise.
+	FIFOCompaction FIFOCompactionOptions
+
+	// UniversalCompaction configures compaction picking when CompactionStyle is
+	// CompactionStyleUniversal. It is ignored otherwise.
+	UniversalCompaction UniversalCompactionOptions
+
 	// BlockPropertyCollectors is a list of BlockPropertyCollector creation
 	// functions. A new BlockPropertyCollector is created for each sstable
 	// built and lives for the lifetime of writing that table.
@@ -1007,6 +1345,14 @@ type Options struct {
 	// default behaviour in RocksDB.
 	WALBytesPerSync int
 
+	// WALCompression is the algorithm used to compress WAL records, which can
+	// reduce the WAL write bandwidth for large, compressible batches. Only
+	// SnappyCompression and ZstdCompression enable compression; the default
+	// leaves WAL records uncompressed. Compression is only used once the DB's
+	// format major version is at least FormatWALCompression, since WALs
+	// containing compressed records cannot be read by earlier versions.
+	WALCompression Compression
+
 	// WALDir specifies the directory to store write-ahead logs (WALs) in. If
 	// empty (the default), WALs will be stored in the same directory as sstables
 	// (i.e. the directory passed to pebble.Open).
@@ -1019,6 +1365,11 @@ type Options struct {
 	// unavailability.
 	WALFailover *WALFailoverOptions
 
+	// WALArchive may be set to upload every closed WAL to remote storage,
+	// from which a checkpoint of the DB can be brought forward to a later
+	// point in time (see Restore). WALArchive requires the WAL to be enabled.
+	WALArchive *WALArchiveOptions
+
 	// WALRecoveryDirs is a list of additional directories that should be
 	// scanned for the existence of additional write-ahead logs. WALRecoveryDirs
 	// is expected to be used when starting Pebble with a new WALDir or a new
@@ -1230,6 +1581,24 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.NumPrevManifest <= 0 {
 		o.NumPrevManifest = 1
 	}
+	if o.Experimental.Tiering.MaxConcurrentMigrations <= 0 {
+		o.Experimental.Tiering.MaxConcurrentMigrations = 1
+	}
+	if o.Experimental.HotKeys.Capacity <= 0 {
+		o.Experimental.HotKeys.Capacity = 128
+	}
+	if o.Experimental.HotKeys.Window <= 0 {
+		o.Experimental.HotKeys.Window = time.Minute
+	}
+	if o.Experimental.HistoryRetention.SampleInterval <= 0 {
+		o.Experimental.HistoryRetention.SampleInterval = time.Second
+	}
+	if o.Experimental.WriteAdmission.MinBytesPerSec <= 0 {
+		o.Experimental.WriteAdmission.MinBytesPerSec = 1 << 20 // 1 MB/s
+	}
+	if o.Experimental.WriteAdmission.Interval <= 0 {
+		o.Experimental.WriteAdmission.Interval = 250 * time.Millisecond
+	}
 
 	if o.FormatMajorVersion == FormatDefault {
 		o.FormatMajorVersion = FormatMinSupported
@@ -1247,6 +1616,14 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.WALFailover != nil {
 		o.WALFailover.FailoverOptions.EnsureDefaults()
 	}
+	if o.WALArchive != nil {
+		o.WALArchive.EnsureDefaults()
+	}
+	if o.CompactionStyle == CompactionStyleUniversal {
+		// The default number of sorted runs depends on L0CompactionThreshold,
+		// which may be changed after the defaults of another style are set.
+		o.UniversalCompaction.EnsureDefaults(o.L0CompactionThreshold)
+	}
 	if o.Experimental.LevelMultiplier <= 0 {
 		o.Experimental.LevelMultiplier = defaultLevelMultiplier
 	}
@@ -1353,6 +1730,9 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
+	if o.CompactionStyle != CompactionStyleLeveled {
+		fmt.Fprintf(&buf, "  compaction_style=%s\n", o.CompactionStyle)
+	}
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
 	if o.Experimental.DisableIngestAsFlushable != nil && o.Experimental.DisableIngestAsFlushable() {
@@ -1374,6 +1754,9 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  max_concurrent_downloads=%d\n", o.MaxConcurrentDownloads())
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	if o.Experimental.MaxSubcompactions > 1 {
+		fmt.Fprintf(&buf, "  max_subcompactions=%d\n", o.Experimental.MaxSubcompactions)
+	}
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.TargetByteDeletionRate)
@@ -1390,10 +1773,51 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  validate_on_ingest=%t\n", o.Experimental.ValidateOnIngest)
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
 	fmt.Fprintf(&buf, "  wal_bytes_per_sync=%d\n", o.WALBytesPerSync)
+	if o.WALCompression == SnappyCompression || o.WALCompression == ZstdCompression {
+		fmt.Fprintf(&buf, "  wal_compression=%s\n", o.WALCompression)
+	}
 	fmt.Fprintf(&buf, "  max_writer_concurrency=%d\n", o.Experimental.MaxWriterConcurrency)
 	fmt.Fprintf(&buf, "  force_writer_parallelism=%t\n", o.Experimental.ForceWriterParallelism)
 	fmt.Fprintf(&buf, "  secondary_cache_size_bytes=%d\n", o.Experimental.SecondaryCacheSizeBytes)
 	fmt.Fprintf(&buf, "  create_on_shared=%d\n", o.Experimental.CreateOnShared)
+	if o.CompactionStyle == CompactionStyleUniversal {
+		u := &o.UniversalCompaction
+		fmt.Fprintf(&buf, "  universal_max_size_amplification_percent=%d\n", u.MaxSizeAmplificationPercent)
+		fmt.Fprintf(&buf, "  universal_max_sorted_runs=%d\n", u.MaxSortedRuns)
+		fmt.Fprintf(&buf, "  universal_min_merge_width=%d\n", u.MinMergeWidth)
+		fmt.Fprintf(&buf, "  universal_size_ratio_percent=%d\n", u.SizeRatioPercent)
+	}
+	if o.CompactionStyle == CompactionStyleFIFO {
+		f := &o.FIFOCompaction
+		fmt.Fprintf(&buf, "  fifo_intra_l0_file_threshold=%d\n", f.IntraL0FileThreshold)
+		fmt.Fprintf(&buf, "  fifo_max_total_size=%d\n", f.MaxTotalSize)
+		fmt.Fprintf(&buf, "  fifo_ttl=%s\n", f.TTL)
+	}
+	if t := &o.Experimental.Tiering; t.Interval > 0 {
+		fmt.Fprintf(&buf, "  tiering_interval=%s\n", t.Interval)
+		fmt.Fprintf(&buf, "  tiering_demote_min_age=%s\n", t.DemoteMinAge)
+		fmt.Fprintf(&buf, "  tiering_demote_max_heat=%d\n", t.DemoteMaxHeat)
+		fmt.Fprintf(&buf, "  tiering_promote_min_heat=%d\n", t.PromoteMinHeat)
+		fmt.Fprintf(&buf, "  tiering_max_concurrent_migrations=%d\n", t.MaxConcurrentMigrations)
+	}
+	if h := &o.Experimental.HotKeys; h.SampleRate > 0 {
+		fmt.Fprintf(&buf, "  hot_keys_sample_rate=%d\n", h.SampleRate)
+		fmt.Fprintf(&buf, "  hot_keys_capacity=%d\n", h.Capacity)
+		fmt.Fprintf(&buf, "  hot_keys_window=%s\n", h.Window)
+		fmt.Fprintf(&buf, "  hot_keys_report_threshold=%s\n",
+			strconv.FormatFloat(h.ReportThreshold, 'g', -1, 64))
+	}
+	if h := &o.Experimental.HistoryRetention; h.enabled() {
+		fmt.Fprintf(&buf, "  history_retention_duration=%s\n", h.Duration)
+		fmt.Fprintf(&buf, "  history_retention_seq_nums=%d\n", h.SeqNums)
+		fmt.Fprintf(&buf, "  history_retention_sample_interval=%s\n", h.SampleInterval)
+	}
+	if w := &o.Experimental.WriteAdmission; w.enabled() {
+		fmt.Fprintf(&buf, "  write_admission_l0_sublevel_threshold=%d\n", w.L0SublevelThreshold)
+		fmt.Fprintf(&buf, "  write_admission_compaction_debt_threshold=%d\n", w.CompactionDebtThreshold)
+		fmt.Fprintf(&buf, "  write_admission_min_bytes_per_sec=%d\n", w.MinBytesPerSec)
+		fmt.Fprintf(&buf, "  write_admission_interval=%s\n", w.Interval)
+	}
 
 	// Private options.
 	//
@@ -1567,6 +1991,17 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "compaction_debt_concurrency":
 				o.Experimental.CompactionDebtConcurrency, err = strconv.ParseUint(value, 10, 64)
+			case "compaction_style":
+				switch value {
+				case "leveled":
+					o.CompactionStyle = CompactionStyleLeveled
+				case "universal":
+					o.CompactionStyle = CompactionStyleUniversal
+				case "fifo":
+					o.CompactionStyle = CompactionStyleFIFO
+				default:
+					err = errors.Newf("unrecognized compaction style: %s", value)
+				}
 			case "delete_range_flush_delay":
 				// NB: This is a deprecated serialization of the
 				// `flush_delay_delete_range`.
@@ -1585,6 +2020,12 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.private.disableLazyCombinedIteration, err = strconv.ParseBool(value)
 			case "disable_wal":
 				o.DisableWAL, err = strconv.ParseBool(value)
+			case "fifo_intra_l0_file_threshold":
+				o.FIFOCompaction.IntraL0FileThreshold, err = strconv.Atoi(value)
+			case "fifo_max_total_size":
+				o.FIFOCompaction.MaxTotalSize, err = strconv.ParseInt(value, 10, 64)
+			case "fifo_ttl":
+				o.FIFOCompaction.TTL, err = time.ParseDuration(value)
 			case "flush_delay_delete_range":
 				o.FlushDelayDeleteRange, err = time.ParseDuration(value)
 			case "flush_delay_range_key":
@@ -1604,6 +2045,20 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				if err == nil {
 					o.FormatMajorVersion = FormatMajorVersion(v)
 				}
+			case "history_retention_duration":
+				o.Experimental.HistoryRetention.Duration, err = time.ParseDuration(value)
+			case "history_retention_sample_interval":
+				o.Experimental.HistoryRetention.SampleInterval, err = time.ParseDuration(value)
+			case "history_retention_seq_nums":
+				o.Experimental.HistoryRetention.SeqNums, err = strconv.ParseUint(value, 10, 64)
+			case "hot_keys_capacity":
+				o.Experimental.HotKeys.Capacity, err = strconv.Atoi(value)
+			case "hot_keys_report_threshold":
+				o.Experimental.HotKeys.ReportThreshold, err = strconv.ParseFloat(value, 64)
+			case "hot_keys_sample_rate":
+				o.Experimental.HotKeys.SampleRate, err = strconv.Atoi(value)
+			case "hot_keys_window":
+				o.Experimental.HotKeys.Window, err = time.ParseDuration(value)
 			case "l0_compaction_concurrency":
 				o.Experimental.L0CompactionConcurrency, err = strconv.Atoi(value)
 			case "l0_compaction_file_threshold":
@@ -1638,6 +2093,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "max_subcompactions":
+				o.Experimental.MaxSubcompactions, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.ParseUint(value, 10, 64)
 			case "mem_table_stop_writes_threshold":
@@ -1693,6 +2150,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				default:
 					if hooks != nil && hooks.NewMerger != nil {
 						o.Merger, err = hooks.NewMerger(value)
+					} else if m := LookupMerger(value); m != nil {
+						o.Merger = m
 					}
 				}
 			case "read_compaction_rate":
@@ -1710,12 +2169,47 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "table_property_collectors":
 				// No longer implemented; ignore.
+			case "tiering_demote_max_heat":
+				o.Experimental.Tiering.DemoteMaxHeat, err = strconv.ParseInt(value, 10, 64)
+			case "tiering_demote_min_age":
+				o.Experimental.Tiering.DemoteMinAge, err = time.ParseDuration(value)
+			case "tiering_interval":
+				o.Experimental.Tiering.Interval, err = time.ParseDuration(value)
+			case "tiering_max_concurrent_migrations":
+				o.Experimental.Tiering.MaxConcurrentMigrations, err = strconv.Atoi(value)
+			case "tiering_promote_min_heat":
+				o.Experimental.Tiering.PromoteMinHeat, err = strconv.ParseInt(value, 10, 64)
+			case "universal_max_size_amplification_percent":
+				o.UniversalCompaction.MaxSizeAmplificationPercent, err = strconv.Atoi(value)
+			case "universal_max_sorted_runs":
+				o.UniversalCompaction.MaxSortedRuns, err = strconv.Atoi(value)
+			case "universal_min_merge_width":
+				o.UniversalCompaction.MinMergeWidth, err = strconv.Atoi(value)
+			case "universal_size_ratio_percent":
+				o.UniversalCompaction.SizeRatioPercent, err = strconv.Atoi(value)
 			case "validate_on_ingest":
 				o.Experimental.ValidateOnIngest, err = strconv.ParseBool(value)
 			case "wal_dir":
 				o.WALDir = value
+			case "write_admission_compaction_debt_threshold":
+				o.Experimental.WriteAdmission.CompactionDebtThreshold, err = strconv.ParseUint(value, 10, 64)
+			case "write_admission_interval":
+				o.Experimental.WriteAdmission.Interval, err = time.ParseDuration(value)
+			case "write_admission_l0_sublevel_threshold":
+				o.Experimental.WriteAdmission.L0SublevelThreshold, err = strconv.Atoi(value)
+			case "write_admission_min_bytes_per_sec":
+				o.Experimental.WriteAdmission.MinBytesPerSec, err = strconv.ParseInt(value, 10, 64)
 			case "wal_bytes_per_sync":
 				o.WALBytesPerSync, err = strconv.Atoi(value)
+			case "wal_compression":
+				switch value {
+				case "Snappy":
+					o.WALCompression = SnappyCompression
+				case "ZSTD":
+					o.WALCompression = ZstdCompression
+				default:
+					err = errors.Newf("unrecognized WAL compression: %s", value)
+				}
 			case "max_writer_concurrency":
 				o.Experimental.MaxWriterConcurrency, err = strconv.Atoi(value)
 			case "force_writer_parallelism":
@@ -1901,6 +2395,15 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "L0CompactionConcurrency (%d) must be >= 1\n",
 			o.Experimental.L0CompactionConcurrency)
 	}
+	switch o.CompactionStyle {
+	case CompactionStyleLeveled, CompactionStyleUniversal:
+	case CompactionStyleFIFO:
+		if o.FIFOCompaction.MaxTotalSize <= 0 && o.FIFOCompaction.TTL <= 0 {
+			fmt.Fprintf(&buf, "FIFOCompaction requires MaxTotalSize or TTL to be set\n")
+		}
+	default:
+		fmt.Fprintf(&buf, "CompactionStyle (%s) is not supported\n", o.CompactionStyle)
+	}
 	if o.L0StopWritesThreshold < o.L0CompactionThreshold {
 		fmt.Fprintf(&buf, "L0StopWritesThreshold (%d) must be >= L0CompactionThreshold (%d)\n",
 			o.L0StopWritesThreshold, o.L0CompactionThreshold)
@@ -1922,6 +2425,18 @@ func (o *Options) Validate() error {
 			o.FormatMajorVersion, FormatMinForSharedObjects)
 
 	}
+	if t := &o.Experimental.Tiering; t.Interval > 0 && t.PromoteMinHeat > 0 && t.PromoteMinHeat <= t.DemoteMaxHeat {
+		fmt.Fprintf(&buf, "Tiering.PromoteMinHeat (%d) must be > Tiering.DemoteMaxHeat (%d)\n",
+			t.PromoteMinHeat, t.DemoteMaxHeat)
+	}
+	if o.WALArchive != nil {
+		if o.WALArchive.Storage == nil {
+			fmt.Fprintf(&buf, "WALArchive.Storage must be set\n")
+		}
+		if o.DisableWAL {
+			fmt.Fprintf(&buf, "WALArchive requires the WAL to be enabled\n")
+		}
+	}
 	if o.TableCache != nil && o.Cache != o.TableCache.cache {
 		fmt.Fprintf(&buf, "underlying cache in the TableCache and the Cache dont match\n")
 	}
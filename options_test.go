@@ -280,15 +280,37 @@ func TestOptionsParse(t *testing.T) {
 			opts.FlushDelayRangeKey = 11 * time.Second
 			opts.Experimental.LevelMultiplier = 5
 			opts.TargetByteDeletionRate = 200
+			opts.WALCompression = ZstdCompression
 			opts.WALFailover = &WALFailoverOptions{
 				Secondary: wal.Dir{Dirname: "wal_secondary", FS: vfs.Default},
 			}
 			opts.Experimental.ReadCompactionRate = 300
 			opts.Experimental.ReadSamplingMultiplier = 400
 			opts.Experimental.TableCacheShards = 500
+			opts.Experimental.MaxSubcompactions = 4
 			opts.Experimental.MaxWriterConcurrency = 1
 			opts.Experimental.ForceWriterParallelism = true
 			opts.Experimental.SecondaryCacheSizeBytes = 1024
+			opts.Experimental.Tiering = TieringPolicy{
+				Interval:                time.Minute,
+				DemoteMinAge:            time.Hour,
+				DemoteMaxHeat:           2,
+				PromoteMinHeat:          10,
+				MaxConcurrentMigrations: 3,
+			}
+			opts.Experimental.HistoryRetention = HistoryRetentionPolicy{
+				Duration:       10 * time.Minute,
+				SeqNums:        1000,
+				SampleInterval: 5 * time.Second,
+			}
+			opts.Experimental.WriteAdmission = WriteAdmissionOptions{
+				L0SublevelThreshold:     10,
+				CompactionDebtThreshold: 1 << 30,
+				MinBytesPerSec:          2 << 20,
+				Interval:                time.Second,
+			}
+			opts.CompactionStyle = CompactionStyleUniversal
+			opts.UniversalCompaction.MaxSortedRuns = 12
 			opts.EnsureDefaults()
 			str := opts.String()
 
@@ -0,0 +1,376 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// RangeStatEstimate is an estimate of a statistic of a key range, along with
+// bounds on the statistic.
+type RangeStatEstimate struct {
+	// Estimate is the estimated value of the statistic.
+	Estimate uint64
+	// Lower and Upper bound the value of the statistic. The bounds are exact
+	// for the keys of memtables and of sstables contained within the range, and
+	// assume the keys of an sstable that partially overlaps the range may lie
+	// anywhere within the sstable. The properties of virtual sstables are
+	// extrapolated from those of their backing sstables, so the bounds are only
+	// estimates for virtual sstables.
+	Lower, Upper uint64
+}
+
+// addExact adds a value known exactly to the estimate.
+func (e *RangeStatEstimate) addExact(v uint64) {
+	e.Estimate += v
+	e.Lower += v
+	e.Upper += v
+}
+
+// addFraction adds the estimated fraction of a value to the estimate. Any part
+// of the value, from none to all of it, may lie within the range.
+func (e *RangeStatEstimate) addFraction(v uint64, fraction float64) {
+	e.Estimate += uint64(float64(v) * fraction)
+	e.Upper += v
+}
+
+// RangeStats holds estimates of the statistics of the keys within a key range.
+// See DB.EstimateRangeStats.
+type RangeStats struct {
+	// PointKeys is the number of point keys, including point deletion
+	// tombstones and the older versions of keys that have not yet been
+	// compacted away.
+	PointKeys RangeStatEstimate
+	// PointDeletions is the number of point deletion tombstones (DEL, SINGLEDEL
+	// and DELSIZED keys) within PointKeys.
+	PointDeletions RangeStatEstimate
+	// KeyBytes and ValueBytes are the uncompressed sizes of the keys and values
+	// of the point keys and range deletions. The size of a key includes its
+	// 8-byte trailer.
+	KeyBytes   RangeStatEstimate
+	ValueBytes RangeStatEstimate
+	// DiskBytes is the estimated size of the sstables within the range, at
+	// data-block granularity.
+	DiskBytes uint64
+	// MemtablePointKeys is the number of point keys within PointKeys that are
+	// in memtables.
+	MemtablePointKeys uint64
+	// Tables is the number of sstables overlapping the range, and
+	// PartialTables is the number of those that extend beyond the range and
+	// whose statistics are interpolated from the index blocks.
+	Tables        int
+	PartialTables int
+
+	// RangeDeletions is the number of range deletion tombstones overlapping the
+	// range, counting each fragment of a tombstone separately.
+	RangeDeletions uint64
+	// RangeDeletionCoverageBytes is the estimated size of the sstables within
+	// the parts of the range covered by range deletion tombstones. It
+	// includes the sstables containing the tombstones, and data that is newer
+	// than the tombstones.
+	RangeDeletionCoverageBytes uint64
+	// RangeKeys is the number of range keys (RANGEKEYSET, RANGEKEYUNSET and
+	// RANGEKEYDEL keys) overlapping the range, counting each fragment of a
+	// range key separately.
+	RangeKeys uint64
+	// RangeKeyCoverageBytes is the estimated size of the sstables within the
+	// parts of the range covered by range keys.
+	RangeKeyCoverageBytes uint64
+
+	// PointDeletionsBytesEstimate and RangeDeletionsBytesEstimate are the
+	// estimated disk space that compacting the point and range deletions of
+	// the sstables within the range may reclaim. They are taken from the table
+	// stats of the sstables (see TableStats), and are interpolated for the
+	// sstables that extend beyond the range: the point deletion estimate from
+	// the table's data blocks overlapping the range, and the range deletion
+	// estimate from the table's range deletions overlapping the range.
+	// Deletions in memtables are not included.
+	PointDeletionsBytesEstimate RangeStatEstimate
+	RangeDeletionsBytesEstimate RangeStatEstimate
+	// TablesWithoutStats is the number of sstables within Tables whose table
+	// stats have not been loaded yet, and which are therefore excluded from
+	// PointDeletionsBytesEstimate and RangeDeletionsBytesEstimate.
+	TablesWithoutStats int
+}
+
+// AvgKeySize returns the estimated average size of the keys of the range's
+// point keys and range deletions, or 0 if the range has no keys.
+func (s *RangeStats) AvgKeySize() float64 {
+	n := s.PointKeys.Estimate + s.RangeDeletions
+	if n == 0 {
+		return 0
+	}
+	return float64(s.KeyBytes.Estimate) / float64(n)
+}
+
+// AvgValueSize returns the estimated average size of the values of the
+// range's point keys that are not deletion tombstones, or 0 if the range has
+// no such keys.
+func (s *RangeStats) AvgValueSize() float64 {
+	if s.PointKeys.Estimate <= s.PointDeletions.Estimate {
+		return 0
+	}
+	return float64(s.ValueBytes.Estimate) / float64(s.PointKeys.Estimate-s.PointDeletions.Estimate)
+}
+
+// EstimateRangeStats returns estimates of the statistics of the keys within
+// the range [start, end): the number of point keys and tombstones, the sizes
+// of keys and values, and the range deletions and range keys overlapping the
+// range along with the amount of data they cover.
+//
+// The statistics of sstables contained within the range are taken from their
+// table properties. The statistics of sstables that extend beyond the range
+// are interpolated from the fraction of their data blocks that overlap the
+// range, which is determined from their index blocks. The point keys of
+// memtables, and the range deletions and range keys of both memtables and
+// sstables, are counted exactly. The estimates of the disk space reclaimable
+// by deletions are taken from the table stats of the sstables, once they have
+// been loaded. The statistics count internal keys: keys
+// shadowed by newer keys or deleted by tombstones are counted until
+// compactions drop them.
+func (d *DB) EstimateRangeStats(start, end []byte) (RangeStats, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.Comparer.Compare(start, end) >= 0 {
+		return RangeStats{}, errors.New("invalid key-range specified (start >= end)")
+	}
+
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	e := rangeStatsEstimator{
+		cmp:    d.opts.Comparer.Compare,
+		bounds: base.UserKeyBoundsEndExclusive(start, end),
+	}
+	for _, mem := range readState.memtables {
+		if err := e.addFlushable(mem.flushable); err != nil {
+			return RangeStats{}, err
+		}
+	}
+	for level, files := range readState.current.Levels {
+		iter := files.Iter()
+		if level > 0 {
+			overlaps := readState.current.Overlaps(level, e.bounds)
+			iter = overlaps.Iter()
+		}
+		for file := iter.First(); file != nil; file = iter.Next() {
+			if !file.Overlaps(e.cmp, &e.bounds) {
+				continue
+			}
+			err := d.tableCache.withCommonReader(file, func(r sstable.CommonReader) error {
+				return e.addTable(file, r)
+			})
+			if err != nil {
+				return RangeStats{}, err
+			}
+		}
+	}
+
+	// Estimate the size of the data covered by the union of the range
+	// deletions, and by the union of the range keys.
+	for _, c := range []struct {
+		spans []keyspan.Span
+		bytes *uint64
+	}{
+		{spans: e.rangeDels, bytes: &e.stats.RangeDeletionCoverageBytes},
+		{spans: e.rangeKeys, bytes: &e.stats.RangeKeyCoverageBytes},
+	} {
+		for _, s := range mergeSpanBounds(e.cmp, c.spans) {
+			size, err := d.EstimateDiskUsage(s.Start, s.End)
+			if err != nil {
+				return RangeStats{}, err
+			}
+			*c.bytes += size
+		}
+	}
+	return e.stats, nil
+}
+
+// rangeStatsEstimator accumulates the statistics of the keys within a range.
+type rangeStatsEstimator struct {
+	cmp    base.Compare
+	bounds base.UserKeyBounds
+	stats  RangeStats
+	// rangeDels and rangeKeys are the bounds of the range deletions and range
+	// keys overlapping the range, truncated to the range.
+	rangeDels []keyspan.Span
+	rangeKeys []keyspan.Span
+}
+
+// addFlushable counts the keys of a memtable, or of another flushable, within
+// the range.
+func (e *rangeStatsEstimator) addFlushable(f flushable) error {
+	iter := f.newIter(&IterOptions{LowerBound: e.bounds.Start, UpperBound: e.bounds.End.Key})
+	for kv := iter.SeekGE(e.bounds.Start, base.SeekGEFlagsNone); kv != nil; kv = iter.Next() {
+		if e.cmp(kv.K.UserKey, e.bounds.End.Key) >= 0 {
+			break
+		}
+		e.stats.PointKeys.addExact(1)
+		e.stats.MemtablePointKeys++
+		switch kv.Kind() {
+		case InternalKeyKindDelete, InternalKeyKindSingleDelete, InternalKeyKindDeleteSized:
+			e.stats.PointDeletions.addExact(1)
+		}
+		e.stats.KeyBytes.addExact(uint64(kv.K.Size()))
+		e.stats.ValueBytes.addExact(uint64(kv.V.Len()))
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if err := e.addSpans(f.newRangeDelIter(nil), true /* rangeDels */, true /* rawSizes */); err != nil {
+		return err
+	}
+	if f.containsRangeKeys() {
+		return e.addSpans(f.newRangeKeyIter(nil), false /* rangeDels */, true /* rawSizes */)
+	}
+	return nil
+}
+
+// addTable adds the statistics of an sstable overlapping the range.
+func (e *rangeStatsEstimator) addTable(file *fileMetadata, r sstable.CommonReader) error {
+	e.stats.Tables++
+	props := r.CommonProperties()
+	pointKeys := props.NumEntries - props.NumRangeDeletions
+	pointDels := props.NumDeletions - props.NumRangeDeletions
+	// The table stats are loaded asynchronously. Once they are, their entry
+	// counts match the table properties, and they additionally estimate the
+	// disk space reclaimable by the table's deletions.
+	statsValid := file.StatsValid()
+	if statsValid {
+		pointKeys = file.Stats.NumEntries - props.NumRangeDeletions
+		pointDels = file.Stats.NumDeletions - props.NumRangeDeletions
+	} else {
+		e.stats.TablesWithoutStats++
+	}
+	contained := file.ContainedWithinSpan(e.cmp, e.bounds.Start, e.bounds.End.Key)
+	if contained {
+		e.stats.PointKeys.addExact(pointKeys)
+		e.stats.PointDeletions.addExact(pointDels)
+		e.stats.KeyBytes.addExact(props.RawKeySize)
+		e.stats.ValueBytes.addExact(props.RawValueSize)
+		e.stats.DiskBytes += file.Size
+		if statsValid {
+			e.stats.PointDeletionsBytesEstimate.addExact(file.Stats.PointDeletionsBytesEstimate)
+			e.stats.RangeDeletionsBytesEstimate.addExact(file.Stats.RangeDeletionsBytesEstimate)
+		}
+	} else {
+		e.stats.PartialTables++
+		// The estimated disk usage covers the data blocks overlapping the
+		// range, and the interpolated share of the value blocks.
+		size, err := r.EstimateDiskUsage(e.bounds.Start, e.bounds.End.Key)
+		if err != nil {
+			return err
+		}
+		dataSize := file.Size
+		if pr, ok := r.(*sstable.Reader); ok {
+			dataSize = pr.Properties.DataSize + pr.Properties.ValueBlocksSize
+		}
+		fraction := 1.0
+		if size < dataSize {
+			fraction = float64(size) / float64(dataSize)
+		}
+		e.stats.PointKeys.addFraction(pointKeys, fraction)
+		e.stats.PointDeletions.addFraction(pointDels, fraction)
+		e.stats.KeyBytes.addFraction(props.RawKeySize, fraction)
+		e.stats.ValueBytes.addFraction(props.RawValueSize, fraction)
+		e.stats.DiskBytes += min(size, file.Size)
+		if statsValid {
+			e.stats.PointDeletionsBytesEstimate.addFraction(file.Stats.PointDeletionsBytesEstimate, fraction)
+		}
+	}
+
+	if props.NumRangeDeletions > 0 {
+		iter, err := r.NewRawRangeDelIter(file.IterTransforms())
+		if err != nil {
+			return err
+		}
+		n := e.stats.RangeDeletions
+		if err := e.addSpans(iter, true /* rangeDels */, false /* rawSizes */); err != nil {
+			return err
+		}
+		// The data blocks of a table say little about where its range
+		// deletions lie, so the range deletion estimate of a partially
+		// overlapping table is interpolated from the share of its range
+		// deletion fragments that overlap the range instead.
+		if statsValid && !contained {
+			fraction := min(1, float64(e.stats.RangeDeletions-n)/float64(props.NumRangeDeletions))
+			e.stats.RangeDeletionsBytesEstimate.addFraction(file.Stats.RangeDeletionsBytesEstimate, fraction)
+		}
+	}
+	if file.HasRangeKeys {
+		iter, err := r.NewRawRangeKeyIter(file.IterTransforms())
+		if err != nil {
+			return err
+		}
+		return e.addSpans(iter, false /* rangeDels */, false /* rawSizes */)
+	}
+	return nil
+}
+
+// addSpans counts the range deletions or range keys of a fragment iterator
+// overlapping the range, and records the bounds of the overlap. If rawSizes is
+// set, the sizes of the range deletions are added to the key and value sizes;
+// the sizes of the range deletions of sstables are included in their table
+// properties instead. It closes the iterator, which may be nil.
+func (e *rangeStatsEstimator) addSpans(
+	iter keyspan.FragmentIterator, rangeDels, rawSizes bool,
+) error {
+	if iter == nil {
+		return nil
+	}
+	defer iter.Close()
+	s, err := iter.SeekGE(e.bounds.Start)
+	for ; s != nil; s, err = iter.Next() {
+		if e.cmp(s.Start, e.bounds.End.Key) >= 0 {
+			break
+		}
+		if s.Empty() {
+			continue
+		}
+		bounds := keyspan.Span{Start: s.Start, End: s.End}
+		if e.cmp(bounds.Start, e.bounds.Start) < 0 {
+			bounds.Start = e.bounds.Start
+		}
+		if e.cmp(bounds.End, e.bounds.End.Key) > 0 {
+			bounds.End = e.bounds.End.Key
+		}
+		bounds.Start, bounds.End = slices.Clone(bounds.Start), slices.Clone(bounds.End)
+		if rangeDels {
+			e.stats.RangeDeletions += uint64(len(s.Keys))
+			if rawSizes {
+				e.stats.KeyBytes.addExact(uint64(len(s.Keys)) * uint64(len(s.Start)+base.InternalTrailerLen))
+				e.stats.ValueBytes.addExact(uint64(len(s.Keys)) * uint64(len(s.End)))
+			}
+			e.rangeDels = append(e.rangeDels, bounds)
+		} else {
+			e.stats.RangeKeys += uint64(len(s.Keys))
+			e.rangeKeys = append(e.rangeKeys, bounds)
+		}
+	}
+	return err
+}
+
+// mergeSpanBounds returns the union of the bounds of the spans, as a sorted
+// slice of disjoint spans.
+func mergeSpanBounds(cmp base.Compare, spans []keyspan.Span) []keyspan.Span {
+	slices.SortFunc(spans, func(a, b keyspan.Span) int { return cmp(a.Start, b.Start) })
+	var merged []keyspan.Span
+	for _, s := range spans {
+		if n := len(merged); n > 0 && cmp(s.Start, merged[n-1].End) <= 0 {
+			if cmp(s.End, merged[n-1].End) > 0 {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateRangeStats(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatNewest}
+	opts.Levels = []LevelOptions{{TargetFileSize: 16 << 10}}
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%04d", i)) }
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		v := make([]byte, 100)
+		rng.Read(v)
+		require.NoError(t, d.Set(key(i), v, nil))
+	}
+	require.NoError(t, d.Compact([]byte("k"), []byte("l"), false))
+	require.Greater(t, d.Metrics().Levels[6].NumFiles, int64(2))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Delete(key(i*10), nil))
+	}
+
+	// The range contains all the sstables, so the statistics are exact.
+	stats, err := d.EstimateRangeStats([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, RangeStatEstimate{Estimate: 1100, Lower: 1100, Upper: 1100}, stats.PointKeys)
+	require.Equal(t, RangeStatEstimate{Estimate: 100, Lower: 100, Upper: 100}, stats.PointDeletions)
+	require.Equal(t, uint64(100), stats.MemtablePointKeys)
+	require.Equal(t, uint64(1100*(5+8)), stats.KeyBytes.Estimate)
+	require.Equal(t, uint64(1000*100), stats.ValueBytes.Estimate)
+	require.Equal(t, 100.0, stats.AvgValueSize())
+	require.Zero(t, stats.PartialTables)
+	require.Equal(t, int(d.Metrics().Levels[6].NumFiles), stats.Tables)
+	total := stats.DiskBytes
+
+	// The range partially overlaps sstables, whose statistics are
+	// interpolated.
+	stats, err = d.EstimateRangeStats(key(250), key(750))
+	require.NoError(t, err)
+	require.Greater(t, stats.PartialTables, 0)
+	for _, e := range []struct {
+		estimate RangeStatEstimate
+		actual   uint64
+	}{
+		{estimate: stats.PointKeys, actual: 550},
+		{estimate: stats.PointDeletions, actual: 50},
+		{estimate: stats.ValueBytes, actual: 500 * 100},
+	} {
+		require.LessOrEqual(t, e.estimate.Lower, e.actual)
+		require.GreaterOrEqual(t, e.estimate.Upper, e.actual)
+		require.InEpsilon(t, e.actual, e.estimate.Estimate, 0.2)
+	}
+	require.InEpsilon(t, total/2, stats.DiskBytes, 0.2)
+
+	// Once flushed and their table stats loaded, the point deletions are
+	// estimated to reclaim the space of the values they delete.
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	stats, err = d.EstimateRangeStats([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Zero(t, stats.TablesWithoutStats)
+	require.Equal(t, stats.PointDeletionsBytesEstimate.Lower, stats.PointDeletionsBytesEstimate.Upper)
+	require.Greater(t, stats.PointDeletionsBytesEstimate.Estimate, uint64(100*100))
+	require.Zero(t, stats.RangeDeletionsBytesEstimate.Estimate)
+
+	// Range deletions and range keys are counted exactly, along with the data
+	// they cover.
+	require.NoError(t, d.DeleteRange(key(100), key(200), nil))
+	require.NoError(t, d.RangeKeySet(key(150), key(350), []byte("@1"), nil, nil))
+	require.NoError(t, d.Flush())
+	stats, err = d.EstimateRangeStats(key(0), key(300))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.RangeDeletions)
+	require.Equal(t, uint64(1), stats.RangeKeys)
+	require.InEpsilon(t, total/10, stats.RangeDeletionCoverageBytes, 0.5)
+	require.InEpsilon(t, total*15/100, stats.RangeKeyCoverageBytes, 0.5)
+	require.Zero(t, stats.MemtablePointKeys)
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	stats, err = d.EstimateRangeStats(key(0), key(300))
+	require.NoError(t, err)
+	require.Greater(t, stats.RangeDeletionsBytesEstimate.Estimate, uint64(100*100))
+
+	_, err = d.EstimateRangeStats(key(2), key(1))
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package record
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/golang/snappy"
+)
+
+// Compression is the compression algorithm applied to the records written by
+// a LogWriter (see LogWriterConfig.Compression).
+//
+// A compressed record is written using the recyclable chunk format, with the
+// first chunk (or the only chunk) of the record using one of the compressed
+// chunk types. Its payload is:
+//
+//	+----------------+------------------------------+--- ... ---+
+//	| Algorithm (1B) | Uncompressed length (varint) | Data      |
+//	+----------------+------------------------------+--- ... ---+
+//
+// Records are only compressed if doing so saves at least 1/8 of their size;
+// other records are written uncompressed, so that a log may contain a mix of
+// compressed and uncompressed records. Readers decompress records
+// transparently.
+type Compression uint8
+
+// The values of Compression are part of the wire format and should not be
+// changed.
+const (
+	NoCompression Compression = iota
+	SnappyCompression
+	ZstdCompression
+)
+
+// String implements fmt.Stringer.
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "NoCompression"
+	case SnappyCompression:
+		return "Snappy"
+	case ZstdCompression:
+		return "ZSTD"
+	default:
+		return "Unknown"
+	}
+}
+
+// minCompressedRecordSize is the size below which records are not compressed.
+const minCompressedRecordSize = 64
+
+// compressRecord compresses p with the given algorithm, appending the encoded
+// payload of a compressed record to buf[:0]. It returns false if the record
+// should be written uncompressed, because it is too small or does not
+// compress well.
+func compressRecord(c Compression, buf, p []byte) ([]byte, bool) {
+	if c == NoCompression || len(p) < minCompressedRecordSize {
+		return buf, false
+	}
+	buf = append(buf[:0], byte(c))
+	buf = binary.AppendUvarint(buf, uint64(len(p)))
+	switch c {
+	case SnappyCompression:
+		prefixLen := len(buf)
+		n := snappy.MaxEncodedLen(len(p))
+		if cap(buf) < prefixLen+n {
+			buf = append(make([]byte, 0, prefixLen+n), buf...)
+		}
+		encoded := snappy.Encode(buf[prefixLen:prefixLen+n], p)
+		buf = buf[:prefixLen+len(encoded)]
+	case ZstdCompression:
+		buf = encodeZstd(buf, p)
+	default:
+		return buf, false
+	}
+	if len(buf) > len(p)-len(p)/8 {
+		return buf, false
+	}
+	return buf, true
+}
+
+// decompressRecord decodes the payload of a compressed record, reusing the
+// capacity of buf if it is sufficient.
+func decompressRecord(buf, payload []byte) ([]byte, error) {
+	if len(payload) < 1 {
+		return nil, base.CorruptionErrorf("pebble/record: empty compressed record")
+	}
+	c := Compression(payload[0])
+	n, k := binary.Uvarint(payload[1:])
+	if k <= 0 || n > 1<<31 {
+		return nil, base.CorruptionErrorf("pebble/record: invalid compressed record length")
+	}
+	data := payload[1+k:]
+	if uint64(cap(buf)) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	var err error
+	var decoded []byte
+	switch c {
+	case SnappyCompression:
+		decoded, err = snappy.Decode(buf, data)
+	case ZstdCompression:
+		decoded, err = decodeZstd(buf, data)
+	default:
+		return nil, base.CorruptionErrorf("pebble/record: unknown compression algorithm %d", errors.Safe(c))
+	}
+	if err != nil {
+		return nil, base.MarkCorruptionError(err)
+	}
+	if uint64(len(decoded)) != n {
+		return nil, base.CorruptionErrorf("pebble/record: decompressed record length %d, expected %d",
+			errors.Safe(len(decoded)), errors.Safe(n))
+	}
+	return decoded, nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package record
+
+import (
+	"sync"
+
+	"github.com/DataDog/zstd"
+)
+
+// zstdCtxPool holds the compression contexts reused across records.
+var zstdCtxPool = sync.Pool{
+	New: func() interface{} {
+		return zstd.NewCtx()
+	},
+}
+
+// decodeZstd decompresses src with the Zstandard algorithm. The destination
+// buffer must already be sufficiently sized, otherwise decodeZstd may error.
+func decodeZstd(dst, src []byte) ([]byte, error) {
+	n, err := zstd.DecompressInto(dst, src)
+	// NB: zstd.DecompressInto may return n < 0 if err != nil.
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+// encodeZstd compresses b with the Zstandard algorithm at default compression
+// level (level 3), appending the result to buf.
+func encodeZstd(buf []byte, b []byte) []byte {
+	ctx := zstdCtxPool.Get().(zstd.Ctx)
+	defer zstdCtxPool.Put(ctx)
+	// CompressLevel writes to the start of its destination, so it is given the
+	// spare capacity of buf, grown to the worst case compressed size.
+	n := len(buf)
+	bound := zstd.CompressBound(len(b))
+	if cap(buf) < n+bound {
+		buf = append(make([]byte, 0, n+bound), buf...)
+	}
+	encoded, err := ctx.CompressLevel(buf[n:n+bound], b, 3)
+	if err != nil {
+		// The destination is large enough, so compression cannot fail. Should
+		// it, the record is written uncompressed as it does not shrink.
+		return append(buf, b...)
+	}
+	return buf[:n+len(encoded)]
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build !cgo
+// +build !cgo
+
+package record
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDecoderPool and zstdEncoderPool hold the decoders and encoders reused
+// across records. They are only used through DecodeAll and EncodeAll, which
+// do not start goroutines when the concurrency is 1, so they need not be
+// closed.
+var zstdDecoderPool = sync.Pool{
+	New: func() interface{} {
+		decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return decoder
+	},
+}
+
+var zstdEncoderPool = sync.Pool{
+	New: func() interface{} {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	},
+}
+
+// decodeZstd decompresses src with the Zstandard algorithm. The destination
+// buffer must already be sufficiently sized, otherwise decodeZstd may error.
+func decodeZstd(dst, src []byte) ([]byte, error) {
+	decoder := zstdDecoderPool.Get().(*zstd.Decoder)
+	defer zstdDecoderPool.Put(decoder)
+	return decoder.DecodeAll(src, dst[:0])
+}
+
+// encodeZstd compresses b with the Zstandard algorithm at default compression
+// level (level 3), appending the result to buf.
+func encodeZstd(buf []byte, b []byte) []byte {
+	encoder := zstdEncoderPool.Get().(*zstd.Encoder)
+	defer zstdEncoderPool.Put(encoder)
+	return encoder.EncodeAll(b, buf)
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestCompressedRecords(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 3*blockSize)
+	_, _ = rng.Read(random)
+	records := [][]byte{
+		// Too small to be compressed.
+		[]byte("a"),
+		// Compressible, in a single chunk.
+		bytes.Repeat([]byte("hello "), 100),
+		// Compressible, spanning multiple blocks even once compressed.
+		bytes.Repeat([]byte(fmt.Sprint(rng.Uint64())), 1<<16),
+		// Incompressible, written uncompressed.
+		random,
+		nil,
+	}
+
+	for _, c := range []Compression{NoCompression, SnappyCompression, ZstdCompression} {
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewLogWriter(&buf, base.DiskFileNum(7), LogWriterConfig{
+				Compression: func() Compression { return c },
+			})
+			var written int64
+			for _, rec := range records {
+				_, err := w.WriteRecord(rec)
+				require.NoError(t, err)
+				written += int64(len(rec))
+			}
+			require.NoError(t, w.Close())
+
+			// The second record is a single chunk, following the single chunk of
+			// the first one.
+			chunkType := buf.Bytes()[recyclableHeaderSize+1+6]
+			m := w.Metrics()
+			if c == NoCompression {
+				require.Equal(t, byte(recyclableFullChunkType), chunkType)
+				require.Zero(t, m.UncompressedBytes)
+				require.Equal(t, 1.0, m.CompressionRatio())
+			} else {
+				require.Equal(t, byte(recyclableCompressedFullChunkType), chunkType)
+				require.Equal(t, written, m.UncompressedBytes)
+				require.Greater(t, m.CompressionRatio(), 2.0)
+				require.Less(t, int64(buf.Len()), written/2)
+			}
+
+			r := NewReader(bytes.NewReader(buf.Bytes()), base.DiskFileNum(7))
+			for i, rec := range records {
+				rr, err := r.Next()
+				require.NoError(t, err, "record %d", i)
+				got, err := io.ReadAll(rr)
+				require.NoError(t, err, "record %d", i)
+				require.Equal(t, len(rec), len(got), "record %d", i)
+				require.True(t, bytes.Equal(rec, got), "record %d", i)
+			}
+			_, err := r.Next()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestCompressedRecordTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewLogWriter(&buf, base.DiskFileNum(1), LogWriterConfig{
+		Compression: func() Compression { return SnappyCompression },
+	})
+	rng := rand.New(rand.NewSource(1))
+	rec := make([]byte, 4*blockSize)
+	for i := range rec {
+		rec[i] = byte('a' + rng.Intn(4))
+	}
+	_, err := w.WriteRecord(rec)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Greater(t, buf.Len(), blockSize)
+
+	// A compressed record whose tail is missing is reported like any other
+	// truncated record.
+	r := NewReader(bytes.NewReader(buf.Bytes()[:blockSize]), base.DiskFileNum(1))
+	_, err = r.Next()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.True(t, IsInvalidRecord(err))
+}
+
+func TestDecompressRecordCorrupt(t *testing.T) {
+	p := bytes.Repeat([]byte("abcd"), 100)
+	for _, c := range []Compression{SnappyCompression, ZstdCompression} {
+		payload, ok := compressRecord(c, nil, p)
+		require.True(t, ok)
+		got, err := decompressRecord(nil, payload)
+		require.NoError(t, err)
+		require.Equal(t, p, got)
+
+		for _, corrupt := range [][]byte{
+			nil,
+			{byte(c)},
+			append([]byte{99}, payload[1:]...),
+			payload[:len(payload)-2],
+		} {
+			_, err := decompressRecord(nil, corrupt)
+			require.Error(t, err)
+			require.True(t, errors.Is(err, base.ErrCorruption), "%v", err)
+		}
+	}
+}
@@ -471,6 +471,16 @@ type LogWriter struct {
 	pendingSyncsBackingIndex pendingSyncsWithHighestSyncIndex
 
 	pendingSyncForSyncQueueBacking pendingSyncForSyncQueue
+
+	// compression returns the algorithm used to compress records, and
+	// compressionBuf is the buffer holding the current compressed record.
+	// External synchronisation provided by commitPipeline.mu.
+	compression    func() Compression
+	compressionBuf []byte
+	// uncompressedBytes and compressedBytes are the sizes of the records
+	// written when compression is enabled, before and after compression.
+	uncompressedBytes atomic.Int64
+	compressedBytes   atomic.Int64
 }
 
 // LogWriterConfig is a struct used for configuring new LogWriters
@@ -495,6 +505,12 @@ type LogWriterConfig struct {
 	// package) precede the lower layer locks (in the record package). These
 	// callbacks are serialized since they are invoked from the flushLoop.
 	ExternalSyncQueueCallback ExternalSyncQueueCallback
+
+	// Compression, if non-nil, returns the algorithm used to compress the
+	// next record. Records are written uncompressed when compression does not
+	// reduce their size significantly. Logs containing compressed records
+	// cannot be read by versions of Pebble that do not support them.
+	Compression func() Compression
 }
 
 // ExternalSyncQueueCallback is to be run when a PendingSync has been
@@ -534,6 +550,7 @@ func NewLogWriter(
 		afterFunc: func(d time.Duration, f func()) syncTimer {
 			return time.AfterFunc(d, f)
 		},
+		compression: logWriterConfig.Compression,
 	}
 	m := &LogWriterMetrics{}
 	if logWriterConfig.ExternalSyncQueueCallback != nil {
@@ -919,12 +936,22 @@ func (w *LogWriter) SyncRecordGeneralized(p []byte, ps PendingSync) (logSize int
 		return -1, w.err
 	}
 
+	compressed := false
+	if c := w.getCompression(); c != NoCompression {
+		w.uncompressedBytes.Add(int64(len(p)))
+		w.compressionBuf, compressed = compressRecord(c, w.compressionBuf, p)
+		if compressed {
+			p = w.compressionBuf
+		}
+		w.compressedBytes.Add(int64(len(p)))
+	}
+
 	// The `i == 0` condition ensures we handle empty records. Such records can
 	// possibly be generated for VersionEdits stored in the MANIFEST. While the
 	// MANIFEST is currently written using Writer, it is good to support the same
 	// semantics with LogWriter.
 	for i := 0; i == 0 || len(p) > 0; i++ {
-		p = w.emitFragment(i, p)
+		p = w.emitFragment(i, p, compressed)
 	}
 
 	if ps.syncRequested() {
@@ -946,6 +973,13 @@ func (w *LogWriter) SyncRecordGeneralized(p []byte, ps PendingSync) (logSize int
 	return offset, nil
 }
 
+func (w *LogWriter) getCompression() Compression {
+	if w.compression == nil {
+		return NoCompression
+	}
+	return w.compression()
+}
+
 // Size returns the current size of the file.
 // External synchronisation provided by commitPipeline.mu.
 func (w *LogWriter) Size() int64 {
@@ -964,20 +998,24 @@ func (w *LogWriter) emitEOFTrailer() {
 	b.written.Store(i + int32(recyclableHeaderSize))
 }
 
-func (w *LogWriter) emitFragment(n int, p []byte) (remainingP []byte) {
+func (w *LogWriter) emitFragment(n int, p []byte, compressed bool) (remainingP []byte) {
 	b := w.block
 	i := b.written.Load()
 	first := n == 0
 	last := blockSize-i-recyclableHeaderSize >= int32(len(p))
 
 	if last {
-		if first {
+		if first && compressed {
+			b.buf[i+6] = recyclableCompressedFullChunkType
+		} else if first {
 			b.buf[i+6] = recyclableFullChunkType
 		} else {
 			b.buf[i+6] = recyclableLastChunkType
 		}
 	} else {
-		if first {
+		if first && compressed {
+			b.buf[i+6] = recyclableCompressedFirstChunkType
+		} else if first {
 			b.buf[i+6] = recyclableFirstChunkType
 		} else {
 			b.buf[i+6] = recyclableMiddleChunkType
@@ -1009,6 +1047,8 @@ func (w *LogWriter) Metrics() LogWriterMetrics {
 	w.flusher.Lock()
 	defer w.flusher.Unlock()
 	m := *w.flusher.metrics
+	m.UncompressedBytes = w.uncompressedBytes.Load()
+	m.CompressedBytes = w.compressedBytes.Load()
 	return m
 }
 
@@ -1017,6 +1057,21 @@ type LogWriterMetrics struct {
 	WriteThroughput  base.ThroughputMetric
 	PendingBufferLen base.GaugeSampleMetric
 	SyncQueueLen     base.GaugeSampleMetric
+	// UncompressedBytes and CompressedBytes are the total size of the records
+	// written with compression enabled, before and after compression. Records
+	// that were written uncompressed count towards both.
+	UncompressedBytes int64
+	CompressedBytes   int64
+}
+
+// CompressionRatio returns the ratio of the size of the records written with
+// compression enabled before compression to their size after compression, or
+// 1 if no such records were written.
+func (m *LogWriterMetrics) CompressionRatio() float64 {
+	if m.CompressedBytes == 0 {
+		return 1
+	}
+	return float64(m.UncompressedBytes) / float64(m.CompressedBytes)
 }
 
 // Merge merges metrics from x. Requires that x is non-nil.
@@ -1024,5 +1079,7 @@ func (m *LogWriterMetrics) Merge(x *LogWriterMetrics) error {
 	m.WriteThroughput.Merge(x.WriteThroughput)
 	m.PendingBufferLen.Merge(x.PendingBufferLen)
 	m.SyncQueueLen.Merge(x.SyncQueueLen)
+	m.UncompressedBytes += x.UncompressedBytes
+	m.CompressedBytes += x.CompressedBytes
 	return nil
 }
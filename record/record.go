@@ -94,6 +94,13 @@
 // (i.e. full, first, middle, last). The CRC is computed over the type, log
 // number, and payload.
 //
+// Records written by a LogWriter configured with a Compression may be
+// compressed. The first chunk of a compressed record uses one of 2 additional
+// "recyclable compressed" chunk types (full or first) instead of the
+// recyclable full or first chunk types; its subsequent chunks use the
+// recyclable middle and last chunk types. The payload of a compressed record
+// is described by Compression.
+//
 // The wire format allows for limited recovery in the face of data corruption:
 // on a format error (such as a checksum mismatch), the reader moves to the
 // next block and looks for the next full or first chunk.
@@ -124,6 +131,9 @@ const (
 	recyclableFirstChunkType  = 6
 	recyclableMiddleChunkType = 7
 	recyclableLastChunkType   = 8
+
+	recyclableCompressedFullChunkType  = 9
+	recyclableCompressedFirstChunkType = 10
 )
 
 const (
@@ -178,8 +188,17 @@ type Reader struct {
 	recovering bool
 	// last is whether the current chunk is the last chunk of the record.
 	last bool
+	// compressed is whether the current chunk is the first chunk of a
+	// compressed record.
+	compressed bool
 	// err is any accumulated error.
 	err error
+	// compressedBuf and decompressedBuf are the buffers used to read and
+	// decompress compressed records. decompressedBuf[decompressedOff:] is the
+	// unread portion of the current record, if it is compressed.
+	compressedBuf   []byte
+	decompressedBuf []byte
+	decompressedOff int
 	// buf is the buffer.
 	buf [blockSize]byte
 }
@@ -195,6 +214,40 @@ func NewReader(r io.Reader, logNum base.DiskFileNum) *Reader {
 	}
 }
 
+// NewReaderAt returns a new reader that starts reading at the given offset of
+// r, which must be the offset of a record as returned by Offset. It is used to
+// resume reading a file that is still being written after having reached its
+// tail: a partially written final record results in an error satisfying
+// IsInvalidRecord, and reading can be resumed later at the offset of that
+// record.
+func NewReaderAt(r io.ReadSeeker, logNum base.DiskFileNum, offset int64) (*Reader, error) {
+	if _, err := r.Seek(offset&^blockSizeMask, io.SeekStart); err != nil {
+		return nil, err
+	}
+	rr := NewReader(r, logNum)
+	if offset == 0 {
+		return rr, nil
+	}
+	c := int(offset & blockSizeMask)
+	if c == 0 {
+		// Pretend that the previous block was read in full, so that the next
+		// call to Next reads the block at offset.
+		rr.blockNum = offset/blockSize - 1
+		rr.begin, rr.end, rr.n = blockSize, blockSize, blockSize
+		return rr, nil
+	}
+	n, err := io.ReadFull(r, rr.buf[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if n < c {
+		return nil, errors.Errorf("pebble/record: offset %d is past the end of the file", offset)
+	}
+	rr.blockNum = offset / blockSize
+	rr.begin, rr.end, rr.n = c, c, n
+	return rr, nil
+}
+
 // nextChunk sets r.buf[r.i:r.j] to hold the next chunk's payload, reading the
 // next block into the buffer if necessary.
 func (r *Reader) nextChunk(wantFirst bool) error {
@@ -224,7 +277,8 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 			}
 
 			headerSize := legacyHeaderSize
-			if chunkType >= recyclableFullChunkType && chunkType <= recyclableLastChunkType {
+			compressed := false
+			if chunkType >= recyclableFullChunkType && chunkType <= recyclableCompressedFirstChunkType {
 				headerSize = recyclableHeaderSize
 				if r.end+headerSize > r.n {
 					return ErrInvalidChunk
@@ -242,7 +296,14 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 					return ErrInvalidChunk
 				}
 
-				chunkType -= (recyclableFullChunkType - 1)
+				switch chunkType {
+				case recyclableCompressedFullChunkType:
+					chunkType, compressed = fullChunkType, true
+				case recyclableCompressedFirstChunkType:
+					chunkType, compressed = firstChunkType, true
+				default:
+					chunkType -= (recyclableFullChunkType - 1)
+				}
 			}
 
 			r.begin = r.end + headerSize
@@ -268,6 +329,7 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 				}
 			}
 			r.last = chunkType == fullChunkType || chunkType == lastChunkType
+			r.compressed = compressed
 			r.recovering = false
 			return nil
 		}
@@ -305,9 +367,34 @@ func (r *Reader) Next() (io.Reader, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
+	if r.compressed {
+		return r.decompress()
+	}
 	return singleReader{r, r.seq}, nil
 }
 
+// decompress reads the rest of the current record, which is compressed, and
+// returns a reader for its decompressed contents.
+func (r *Reader) decompress() (io.Reader, error) {
+	r.compressedBuf = r.compressedBuf[:0]
+	for {
+		r.compressedBuf = append(r.compressedBuf, r.buf[r.begin:r.end]...)
+		r.begin = r.end
+		if r.last {
+			break
+		}
+		if r.err = r.nextChunk(false); r.err != nil {
+			return nil, r.err
+		}
+	}
+	r.decompressedBuf, r.err = decompressRecord(r.decompressedBuf, r.compressedBuf)
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.decompressedOff = 0
+	return decompressedReader{r, r.seq}, nil
+}
+
 // Offset returns the current offset within the file. If called immediately
 // before a call to Next(), Offset() will return the record offset.
 func (r *Reader) Offset() int64 {
@@ -409,6 +496,24 @@ func (x singleReader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+type decompressedReader struct {
+	r   *Reader
+	seq int
+}
+
+func (x decompressedReader) Read(p []byte) (int, error) {
+	r := x.r
+	if r.seq != x.seq {
+		return 0, errors.New("pebble/record: stale reader")
+	}
+	if r.decompressedOff == len(r.decompressedBuf) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.decompressedBuf[r.decompressedOff:])
+	r.decompressedOff += n
+	return n, nil
+}
+
 // Writer writes records to an underlying io.Writer.
 type Writer struct {
 	// w is the underlying writer.
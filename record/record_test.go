@@ -1062,3 +1062,46 @@ func BenchmarkRecordWrite(b *testing.B) {
 		})
 	}
 }
+
+func TestReaderAtTail(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var want []string
+	for i := 0; i < 50; i++ {
+		s := big(fmt.Sprintf("record %d ", i), 100+i*1500)
+		want = append(want, s)
+		_, err := w.WriteRecord([]byte(s))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// Read a file that grows in small increments, resuming at the offset of
+	// the first record that could not be read in full.
+	var got []string
+	var offset int64
+	for n := 0; n <= buf.Len(); n = min(n+777, buf.Len()) {
+		r, err := NewReaderAt(bytes.NewReader(buf.Bytes()[:n]), 0 /* logNum */, offset)
+		require.NoError(t, err)
+		require.Equal(t, offset, r.Offset())
+		for {
+			rec, err := r.Next()
+			if err == nil {
+				var b []byte
+				if b, err = io.ReadAll(rec); err == nil {
+					got = append(got, string(b))
+					offset = r.Offset()
+					continue
+				}
+			}
+			require.True(t, err == io.EOF || IsInvalidRecord(err), "%v", err)
+			break
+		}
+		if n == buf.Len() {
+			break
+		}
+	}
+	require.Equal(t, len(want), len(got))
+	for i := range want {
+		require.Equal(t, want[i], got[i], "record %d", i)
+	}
+}
@@ -0,0 +1,794 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/compact"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// Remote compactions offload compactions into the bottommost level to a
+// CompactionWorker, typically running in a separate process that shares the
+// DB's remote storage. The DB describes the compaction as a CompactionJob,
+// referencing its input tables through their remote object backings. The
+// worker attaches the inputs to its own objstorage.Provider, writes the
+// outputs to shared storage and describes them in a CompactionJobResult. The
+// DB validates the outputs, attaches them to its own provider and installs
+// them through the same version edit a local compaction would have produced.
+//
+// Objects on shared storage are reference counted by each provider they are
+// attached to. The DB holds references to the inputs for the duration of the
+// job, and the worker holds references to the outputs until they are released
+// by CompactionExecutor.Release, after the DB attached them.
+
+// CompactionJob is a serializable description of a compaction run by a
+// CompactionWorker. Only compactions into the bottommost level are offloaded,
+// so every tombstone that is not required by a snapshot is elided and
+// sequence numbers are zeroed where possible.
+type CompactionJob struct {
+	// Inputs holds the input tables of each level participating in the
+	// compaction, from the start level to the output level.
+	Inputs []CompactionJobLevel
+	// OutputLevel is the level the outputs are written to.
+	OutputLevel int
+	// Smallest and Largest are the bounds of the compaction's inputs. Outputs
+	// never extend beyond them.
+	Smallest, Largest InternalKey
+	// Snapshots holds the sequence numbers of the DB's open snapshots, in
+	// increasing order.
+	Snapshots []uint64
+	// HistoryRetentionSeqNum is the sequence number from which every version
+	// is preserved, or zero if the DB does not retain history.
+	HistoryRetentionSeqNum uint64
+	// TableFormat is the format of the output tables.
+	TableFormat sstable.TableFormat
+	// TargetFileSize is the target size of each output table.
+	TargetFileSize uint64
+	// ComparerName and MergerName identify the Comparer and Merger of the DB,
+	// which must match the ones configured on the worker.
+	ComparerName, MergerName string
+}
+
+// CompactionJobLevel describes the input tables of a level of a
+// CompactionJob. The tables of L0 are ordered by sequence number, and the
+// tables of other levels are ordered by key.
+type CompactionJobLevel struct {
+	Level  int
+	Tables []CompactionJobTable
+}
+
+// CompactionJobTable describes a physical table on shared storage.
+type CompactionJobTable struct {
+	// FileNum is the table's file number within the DB or worker that
+	// described it. It is only meaningful to that DB or worker.
+	FileNum FileNum
+	// Backing is the encoded objstorage.RemoteObjectBacking of the table.
+	Backing []byte
+	Size    uint64
+	// SubLevel is the L0 sublevel of an input table in L0.
+	SubLevel int
+
+	SmallestSeqNum, LargestSeqNum uint64
+
+	HasPointKeys                      bool
+	SmallestPointKey, LargestPointKey InternalKey
+	HasRangeKeys                      bool
+	SmallestRangeKey, LargestRangeKey InternalKey
+}
+
+// Encode serializes the job.
+func (j *CompactionJob) Encode() ([]byte, error) {
+	return json.Marshal(j)
+}
+
+// DecodeCompactionJob deserializes a job serialized by CompactionJob.Encode.
+func DecodeCompactionJob(buf []byte) (*CompactionJob, error) {
+	j := &CompactionJob{}
+	if err := json.Unmarshal(buf, j); err != nil {
+		return nil, errors.Wrap(err, "pebble: invalid compaction job")
+	}
+	return j, nil
+}
+
+// CompactionJobResult describes the outputs of a CompactionJob, in key order.
+type CompactionJobResult struct {
+	Outputs []CompactionJobTable
+}
+
+// Encode serializes the result.
+func (r *CompactionJobResult) Encode() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// DecodeCompactionJobResult deserializes a result serialized by
+// CompactionJobResult.Encode.
+func DecodeCompactionJobResult(buf []byte) (*CompactionJobResult, error) {
+	r := &CompactionJobResult{}
+	if err := json.Unmarshal(buf, r); err != nil {
+		return nil, errors.Wrap(err, "pebble: invalid compaction job result")
+	}
+	return r, nil
+}
+
+// CompactionExecutor runs CompactionJobs on behalf of a DB. It is configured
+// through Options.Experimental.CompactionExecutor. Implementations typically
+// send the encoded job to a CompactionWorker in another process.
+type CompactionExecutor interface {
+	// Execute runs the job and returns its result. The outputs described by
+	// the result must remain referenced by the worker until Release is
+	// called.
+	Execute(ctx context.Context, job *CompactionJob) (*CompactionJobResult, error)
+	// Release is called once the DB no longer needs the worker's references
+	// to the outputs of a job, either because the DB attached the outputs or
+	// because it discarded them.
+	Release(ctx context.Context, result *CompactionJobResult) error
+}
+
+// NewLocalCompactionExecutor returns a CompactionExecutor that runs jobs on
+// the given worker in the current process. Jobs and results are passed in
+// their encoded form, as they would be to a worker in another process. It is
+// intended for testing.
+func NewLocalCompactionExecutor(w *CompactionWorker) CompactionExecutor {
+	return localCompactionExecutor{w: w}
+}
+
+type localCompactionExecutor struct {
+	w *CompactionWorker
+}
+
+// Execute implements CompactionExecutor.
+func (e localCompactionExecutor) Execute(
+	ctx context.Context, job *CompactionJob,
+) (*CompactionJobResult, error) {
+	buf, err := job.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if job, err = DecodeCompactionJob(buf); err != nil {
+		return nil, err
+	}
+	result, err := e.w.Run(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = result.Encode(); err != nil {
+		return nil, err
+	}
+	return DecodeCompactionJobResult(buf)
+}
+
+// Release implements CompactionExecutor.
+func (e localCompactionExecutor) Release(ctx context.Context, result *CompactionJobResult) error {
+	return e.w.Release(ctx, result)
+}
+
+func newCompactionJobTable(m *fileMetadata) CompactionJobTable {
+	return CompactionJobTable{
+		FileNum:          m.FileNum,
+		Size:             m.Size,
+		SubLevel:         m.SubLevel,
+		SmallestSeqNum:   m.SmallestSeqNum,
+		LargestSeqNum:    m.LargestSeqNum,
+		HasPointKeys:     m.HasPointKeys,
+		SmallestPointKey: m.SmallestPointKey,
+		LargestPointKey:  m.LargestPointKey,
+		HasRangeKeys:     m.HasRangeKeys,
+		SmallestRangeKey: m.SmallestRangeKey,
+		LargestRangeKey:  m.LargestRangeKey,
+	}
+}
+
+// fileMetadata returns the metadata of the table, which is known by the given
+// file number.
+func (t *CompactionJobTable) fileMetadata(cmp Compare, fileNum base.DiskFileNum) *fileMetadata {
+	m := &fileMetadata{
+		FileNum:               base.PhysicalTableFileNum(fileNum),
+		Size:                  t.Size,
+		SubLevel:              t.SubLevel,
+		SmallestSeqNum:        t.SmallestSeqNum,
+		LargestSeqNum:         t.LargestSeqNum,
+		LargestSeqNumAbsolute: t.LargestSeqNum,
+	}
+	if t.HasPointKeys {
+		m.ExtendPointKeyBounds(cmp, t.SmallestPointKey, t.LargestPointKey)
+	}
+	if t.HasRangeKeys {
+		m.ExtendRangeKeyBounds(cmp, t.SmallestRangeKey, t.LargestRangeKey)
+	}
+	m.InitPhysicalBacking()
+	return m
+}
+
+// canOffloadCompaction returns true if the compaction may be run by the
+// CompactionExecutor: it is a default compaction into the bottommost level,
+// and all its inputs are physical tables on shared storage.
+func (d *DB) canOffloadCompaction(c *compaction) bool {
+	if c.kind != compactionKindDefault || len(c.flushing) > 0 ||
+		c.outputLevel.level != numLevels-1 || !c.allowZeroSeqNum() {
+		return false
+	}
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.Virtual {
+				return false
+			}
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+			if err != nil || !objMeta.IsShared() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newCompactionJob returns the description of the compaction. The returned
+// handles keep the remote objects of the inputs alive, and must be closed
+// once the job completes.
+func (d *DB) newCompactionJob(
+	c *compaction, snapshots compact.Snapshots, formatVers FormatMajorVersion,
+) (_ *CompactionJob, handles []objstorage.RemoteObjectBackingHandle, retErr error) {
+	defer func() {
+		if retErr != nil {
+			for _, h := range handles {
+				h.Close()
+			}
+			handles = nil
+		}
+	}()
+	job := &CompactionJob{
+		OutputLevel:    c.outputLevel.level,
+		Smallest:       c.smallest,
+		Largest:        c.largest,
+		Snapshots:      snapshots,
+		TableFormat:    d.compactionTableFormat(formatVers),
+		TargetFileSize: c.maxOutputFileSize,
+		ComparerName:   d.opts.Comparer.Name,
+		MergerName:     d.opts.Merger.Name,
+
+		HistoryRetentionSeqNum: c.historyRetentionSeqNum,
+	}
+	for _, cl := range c.inputs {
+		level := CompactionJobLevel{Level: cl.level}
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+			if err != nil {
+				return nil, handles, err
+			}
+			h, err := d.objProvider.RemoteObjectBacking(&objMeta)
+			if err != nil {
+				return nil, handles, err
+			}
+			handles = append(handles, h)
+			backing, err := h.Get()
+			if err != nil {
+				return nil, handles, err
+			}
+			t := newCompactionJobTable(f)
+			t.Backing = backing
+			level.Tables = append(level.Tables, t)
+		}
+		job.Inputs = append(job.Inputs, level)
+	}
+	return job, handles, nil
+}
+
+// remoteCompactionCancelPollInterval is the interval at which the context of
+// a remote compaction job polls the compaction for cancellation.
+const remoteCompactionCancelPollInterval = 100 * time.Millisecond
+
+// compactionContext returns a context that is cancelled when the DB is closed
+// or when the compaction is cancelled. The returned CancelFunc must be called
+// once the context is no longer in use.
+func (d *DB) compactionContext(c *compaction) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(remoteCompactionCancelPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.closedCh:
+				cancel()
+				return
+			case <-ticker.C:
+				if c.cancelled() {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// runRemoteCompaction runs the compaction through the CompactionExecutor and
+// installs its outputs. The returned versionEdit holds the new tables.
+//
+// d.mu must not be held when calling this method.
+func (d *DB) runRemoteCompaction(
+	jobID JobID,
+	c *compaction,
+	e CompactionExecutor,
+	snapshots compact.Snapshots,
+	formatVers FormatMajorVersion,
+) (ve *versionEdit, pendingOutputs []compactionOutput, stats compactStats, retErr error) {
+	c.remote = true
+	job, handles, err := d.newCompactionJob(c, snapshots, formatVers)
+	if err != nil {
+		return nil, nil, stats, err
+	}
+	defer func() {
+		for _, h := range handles {
+			h.Close()
+		}
+	}()
+
+	ctx, cancel := d.compactionContext(c)
+	result, err := e.Execute(ctx, job)
+	cancel()
+	if err != nil {
+		if c.cancelled() {
+			return nil, nil, stats, ErrCancelledCompaction
+		}
+		return nil, nil, stats, errors.Wrap(err, "pebble: remote compaction failed")
+	}
+	defer func() {
+		// The outputs are referenced by the DB once attached, so failing to
+		// release the worker's references only leaks them. The release is
+		// performed even if the compaction was cancelled.
+		if err := e.Release(context.Background(), result); err != nil {
+			d.opts.Logger.Errorf("pebble: failed to release remote compaction outputs: %v", err)
+		}
+	}()
+	if c.cancelled() {
+		return nil, nil, stats, ErrCancelledCompaction
+	}
+	ve, pendingOutputs, err = d.installCompactionJobResult(c, result)
+	if err != nil {
+		return nil, nil, stats, err
+	}
+	return ve, pendingOutputs, stats, nil
+}
+
+// installCompactionJobResult validates the outputs of a remote compaction and
+// attaches them to the DB's provider. The returned versionEdit holds the new
+// tables.
+func (d *DB) installCompactionJobResult(
+	c *compaction, result *CompactionJobResult,
+) (ve *versionEdit, pendingOutputs []compactionOutput, retErr error) {
+	ve = &versionEdit{
+		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
+	}
+	outputMetrics := c.initMetrics()
+	if len(result.Outputs) == 0 {
+		return ve, nil, nil
+	}
+
+	d.mu.Lock()
+	objs := make([]objstorage.RemoteObjectToAttach, len(result.Outputs))
+	for i := range objs {
+		objs[i] = objstorage.RemoteObjectToAttach{
+			FileNum:  d.mu.versions.getNextDiskFileNum(),
+			FileType: fileTypeTable,
+			Backing:  result.Outputs[i].Backing,
+		}
+	}
+	d.mu.Unlock()
+
+	inputLargestSeqNumAbsolute := c.inputLargestSeqNumAbsolute()
+	for i := range result.Outputs {
+		t := &result.Outputs[i]
+		meta := t.fileMetadata(d.cmp, objs[i].FileNum)
+		meta.CreationTime = d.timeNow().Unix()
+		meta.LargestSeqNumAbsolute = inputLargestSeqNumAbsolute
+		if err := d.validateRemoteCompactionOutput(c, ve, meta); err != nil {
+			return nil, nil, errors.Wrap(err, "pebble: invalid remote compaction output")
+		}
+		ve.NewFiles = append(ve.NewFiles, newFileEntry{
+			Level: c.outputLevel.level,
+			Meta:  meta,
+		})
+	}
+
+	objMetas, err := d.objProvider.AttachRemoteObjects(objs)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			for _, o := range objs {
+				_ = d.objProvider.Remove(fileTypeTable, o.FileNum)
+			}
+		}
+	}()
+	for i, objMeta := range objMetas {
+		meta := ve.NewFiles[i].Meta
+		size, err := d.objProvider.Size(objMeta)
+		if err != nil {
+			return nil, nil, err
+		}
+		if uint64(size) != meta.Size {
+			return nil, nil, errors.Errorf("pebble: remote compaction output %s has size %d, expected %d",
+				meta.FileNum, size, meta.Size)
+		}
+		pendingOutputs = append(pendingOutputs, compactionOutput{
+			meta:    meta.PhysicalMeta().FileMetadata,
+			isLocal: false,
+		})
+		c.bytesWritten += int64(meta.Size)
+		outputMetrics.TablesCompacted++
+		outputMetrics.BytesCompacted += meta.Size
+		outputMetrics.Size += int64(meta.Size)
+		outputMetrics.NumFiles++
+	}
+	return ve, pendingOutputs, nil
+}
+
+// validateRemoteCompactionOutput checks that the output of a remote compaction
+// is a well-formed table whose sequence numbers lie within those of the
+// compaction's inputs, lies within the bounds of the inputs, and does not
+// overlap the preceding outputs in ve. Offloaded compactions zero sequence
+// numbers, so a smallest sequence number of zero is permitted.
+func (d *DB) validateRemoteCompactionOutput(
+	c *compaction, ve *versionEdit, meta *fileMetadata,
+) error {
+	smallestSeqNum, largestSeqNum := uint64(math.MaxUint64), uint64(0)
+	for _, cl := range c.inputs {
+		cl.files.Each(func(m *manifest.FileMetadata) {
+			smallestSeqNum = min(smallestSeqNum, m.SmallestSeqNum)
+			largestSeqNum = max(largestSeqNum, m.LargestSeqNum)
+		})
+	}
+	if meta.LargestSeqNum > largestSeqNum ||
+		meta.SmallestSeqNum < smallestSeqNum && meta.SmallestSeqNum != 0 {
+		return errors.Errorf("table %s sequence numbers %d-%d exceed the compaction's sequence numbers %d-%d",
+			meta.FileNum, meta.SmallestSeqNum, meta.LargestSeqNum, smallestSeqNum, largestSeqNum)
+	}
+	if err := meta.Validate(d.cmp, d.opts.Comparer.FormatKey); err != nil {
+		return err
+	}
+	if d.cmp(meta.Smallest.UserKey, c.smallest.UserKey) < 0 ||
+		d.cmp(meta.Largest.UserKey, c.largest.UserKey) > 0 {
+		return errors.Errorf("table %s bounds %s-%s exceed the compaction bounds %s-%s", meta.FileNum,
+			meta.Smallest.Pretty(d.opts.Comparer.FormatKey), meta.Largest.Pretty(d.opts.Comparer.FormatKey),
+			c.smallest.Pretty(d.opts.Comparer.FormatKey), c.largest.Pretty(d.opts.Comparer.FormatKey))
+	}
+	if n := len(ve.NewFiles); n > 0 {
+		prev := ve.NewFiles[n-1].Meta
+		if v := d.cmp(prev.Largest.UserKey, meta.Smallest.UserKey); v > 0 || v == 0 && !prev.Largest.IsExclusiveSentinel() {
+			return errors.Errorf("table %s overlaps the preceding table %s", meta.FileNum, prev.FileNum)
+		}
+	}
+	return nil
+}
+
+// CompactionWorker runs CompactionJobs. A worker shares the remote storage of
+// the DBs whose compactions it runs, and has its own directory holding the
+// catalog of the remote objects it references.
+type CompactionWorker struct {
+	opts        *Options
+	provider    objstorage.Provider
+	tableCache  *tableCacheContainer
+	nextFileNum atomic.Uint64
+}
+
+// OpenCompactionWorker opens a worker in the given directory. The options must
+// configure the same Comparer, Merger and Experimental.RemoteStorage as the
+// DBs whose compactions the worker runs. The creator ID identifies the objects
+// created by the worker on shared storage, and must be unique among the
+// worker and the DBs sharing the storage.
+func OpenCompactionWorker(
+	dirname string, creatorID objstorage.CreatorID, opts *Options,
+) (_ *CompactionWorker, retErr error) {
+	opts = opts.Clone().EnsureDefaults()
+	if opts.Experimental.RemoteStorage == nil {
+		return nil, errors.New("pebble: compaction worker requires remote storage")
+	}
+	if err := opts.FS.MkdirAll(dirname, 0755); err != nil {
+		return nil, err
+	}
+	ls, err := opts.FS.List(dirname)
+	if err != nil {
+		return nil, err
+	}
+	providerSettings := objstorageprovider.Settings{
+		Logger:              opts.Logger,
+		FS:                  opts.FS,
+		FSDirName:           dirname,
+		FSDirInitialListing: ls,
+		FSCleaner:           opts.Cleaner,
+		NoSyncOnClose:       opts.NoSyncOnClose,
+		BytesPerSync:        opts.BytesPerSync,
+	}
+	providerSettings.Remote.StorageFactory = opts.Experimental.RemoteStorage
+	providerSettings.Remote.CreateOnShared = remote.CreateOnSharedAll
+	providerSettings.Remote.CreateOnSharedLocator = opts.Experimental.CreateOnSharedLocator
+	provider, err := objstorageprovider.Open(providerSettings)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = provider.Close()
+		}
+	}()
+	if err := provider.SetCreatorID(creatorID); err != nil {
+		return nil, err
+	}
+
+	if opts.Cache == nil {
+		opts.Cache = cache.New(cacheDefaultSize)
+	} else {
+		opts.Cache.Ref()
+	}
+	w := &CompactionWorker{opts: opts, provider: provider}
+	w.tableCache = newTableCacheContainer(nil, opts.Cache.NewID(), provider, opts,
+		TableCacheSize(opts.MaxOpenFiles), &sstable.CategoryStatsCollector{})
+	// Objects left behind by a previous incarnation of the worker remain in
+	// its catalog until they are released.
+	for _, meta := range provider.List() {
+		if uint64(meta.DiskFileNum) > w.nextFileNum.Load() {
+			w.nextFileNum.Store(uint64(meta.DiskFileNum))
+		}
+	}
+	return w, nil
+}
+
+// Close closes the worker. Outputs that were not released remain referenced
+// by the worker's catalog.
+func (w *CompactionWorker) Close() error {
+	err := w.tableCache.close()
+	err = firstError(err, w.provider.Close())
+	w.opts.Cache.Unref()
+	return err
+}
+
+func (w *CompactionWorker) nextDiskFileNum() base.DiskFileNum {
+	return base.DiskFileNum(w.nextFileNum.Add(1))
+}
+
+// Run runs the job, returning the description of its outputs. The outputs
+// remain referenced by the worker until they are released with Release.
+func (w *CompactionWorker) Run(
+	ctx context.Context, job *CompactionJob,
+) (_ *CompactionJobResult, retErr error) {
+	if job.ComparerName != w.opts.Comparer.Name {
+		return nil, errors.Errorf("pebble: compaction job comparer %q does not match %q",
+			job.ComparerName, w.opts.Comparer.Name)
+	}
+	if job.MergerName != w.opts.Merger.Name {
+		return nil, errors.Errorf("pebble: compaction job merger %q does not match %q",
+			job.MergerName, w.opts.Merger.Name)
+	}
+	if len(job.Inputs) < 2 {
+		return nil, errors.Errorf("pebble: compaction job has %d input levels", len(job.Inputs))
+	}
+
+	c, inputs, err := w.newCompaction(job)
+	defer func() {
+		if c != nil {
+			c.unrefInputBackings()
+		}
+		for _, fileNum := range inputs {
+			w.tableCache.evict(fileNum)
+			retErr = firstError(retErr, w.provider.Remove(fileTypeTable, fileNum))
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	c.bufferPool.Init(12)
+	defer c.bufferPool.Release()
+	pointIter, rangeDelIter, rangeKeyIter, err := c.newInputIters(
+		w.tableCache.newIters, tableNewRangeKeyIter(ctx, w.tableCache.newIters))
+	if err != nil {
+		return nil, err
+	}
+	cfg := compact.IterConfig{
+		Comparer:         c.comparer,
+		Merge:            w.opts.Merger.Merge,
+		TombstoneElision: c.delElision,
+		RangeKeyElision:  c.rangeKeyElision,
+		Snapshots:        job.Snapshots,
+		AllowZeroSeqNum:  true,
+
+		HistoryRetentionSeqNum: job.HistoryRetentionSeqNum,
+	}
+	iter := compact.NewIter(cfg, pointIter, rangeDelIter, rangeKeyIter)
+
+	result := &CompactionJobResult{}
+	var createdFiles []base.DiskFileNum
+	defer func() {
+		retErr = firstError(retErr, iter.Close())
+		for _, closer := range c.closers {
+			retErr = firstError(retErr, closer.Close())
+		}
+		if retErr != nil {
+			for _, fileNum := range createdFiles {
+				_ = w.provider.Remove(fileTypeTable, fileNum)
+			}
+		}
+	}()
+
+	writerOpts := w.opts.MakeWriterOptions(job.OutputLevel, job.TableFormat)
+	newOutput := func() (*sstable.Writer, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fileNum := w.nextDiskFileNum()
+		writable, objMeta, err := w.provider.Create(ctx, fileTypeTable, fileNum, objstorage.CreateOptions{
+			PreferSharedStorage: true,
+			WriteCategory:       "pebble-compaction",
+		})
+		if err != nil {
+			return nil, err
+		}
+		createdFiles = append(createdFiles, fileNum)
+		if !objMeta.IsShared() {
+			writable.Abort()
+			return nil, errors.Errorf("pebble: compaction output %s not created on shared storage", fileNum)
+		}
+		return sstable.NewWriter(writable, writerOpts), nil
+	}
+	finishOutput := func(writerMeta *sstable.WriterMetadata, _ []byte) error {
+		meta := &fileMetadata{
+			FileNum:        base.PhysicalTableFileNum(createdFiles[len(createdFiles)-1]),
+			Size:           writerMeta.Size,
+			SmallestSeqNum: writerMeta.SmallestSeqNum,
+			LargestSeqNum:  writerMeta.LargestSeqNum,
+		}
+		if writerMeta.HasPointKeys {
+			meta.ExtendPointKeyBounds(c.cmp, writerMeta.SmallestPoint, writerMeta.LargestPoint)
+		}
+		if writerMeta.HasRangeDelKeys {
+			meta.ExtendPointKeyBounds(c.cmp, writerMeta.SmallestRangeDel, writerMeta.LargestRangeDel)
+		}
+		if writerMeta.HasRangeKeys {
+			meta.ExtendRangeKeyBounds(c.cmp, writerMeta.SmallestRangeKey, writerMeta.LargestRangeKey)
+		}
+		result.Outputs = append(result.Outputs, newCompactionJobTable(meta))
+		return nil
+	}
+	// Outputs of the bottommost level have no grandparents to limit them, so
+	// no split limit is needed.
+	err = c.writeOutputs(iter, compactionOutputs{
+		newOutput:    newOutput,
+		finishOutput: finishOutput,
+		checkProgress: func([]byte) error {
+			return ctx.Err()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.provider.Sync(); err != nil {
+		return nil, err
+	}
+	for i := range result.Outputs {
+		t := &result.Outputs[i]
+		objMeta, err := w.provider.Lookup(fileTypeTable, base.PhysicalTableDiskFileNum(t.FileNum))
+		if err != nil {
+			return nil, err
+		}
+		h, err := w.provider.RemoteObjectBacking(&objMeta)
+		if err != nil {
+			return nil, err
+		}
+		t.Backing, err = h.Get()
+		h.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// newCompaction attaches the inputs of the job to the worker's provider and
+// returns the corresponding compaction. The returned file numbers of the
+// attached inputs must be removed once the compaction completes, even if an
+// error is returned.
+func (w *CompactionWorker) newCompaction(
+	job *CompactionJob,
+) (*compaction, []base.DiskFileNum, error) {
+	var objs []objstorage.RemoteObjectToAttach
+	for _, l := range job.Inputs {
+		for _, t := range l.Tables {
+			objs = append(objs, objstorage.RemoteObjectToAttach{
+				FileNum:  w.nextDiskFileNum(),
+				FileType: fileTypeTable,
+				Backing:  t.Backing,
+			})
+		}
+	}
+	if _, err := w.provider.AttachRemoteObjects(objs); err != nil {
+		return nil, nil, err
+	}
+	inputs := make([]base.DiskFileNum, len(objs))
+	for i := range objs {
+		inputs[i] = objs[i].FileNum
+	}
+
+	cmp := w.opts.Comparer.Compare
+	c := &compaction{
+		kind:              compactionKindDefault,
+		cmp:               cmp,
+		equal:             w.opts.Comparer.Equal,
+		comparer:          w.opts.Comparer,
+		formatKey:         w.opts.Comparer.FormatKey,
+		logger:            w.opts.Logger,
+		smallest:          job.Smallest,
+		largest:           job.Largest,
+		maxOutputFileSize: job.TargetFileSize,
+		delElision:        compact.ElideTombstonesOutsideOf(nil),
+		rangeKeyElision:   compact.ElideTombstonesOutsideOf(nil),
+	}
+	c.inputs = make([]compactionLevel, len(job.Inputs))
+	i := 0
+	for j, l := range job.Inputs {
+		files := make([]*fileMetadata, len(l.Tables))
+		for k := range l.Tables {
+			files[k] = l.Tables[k].fileMetadata(cmp, inputs[i])
+			// The table cache only opens tables with referenced backings; the
+			// worker does not maintain a version holding the references, so
+			// the compaction holds them until it completes (see
+			// unrefInputBackings).
+			files[k].FileBacking.Ref()
+			i++
+		}
+		c.inputs[j].level = l.Level
+		if l.Level == 0 {
+			c.inputs[j].files = manifest.NewLevelSliceSeqSorted(files)
+		} else {
+			c.inputs[j].files = manifest.NewLevelSliceKeySorted(cmp, files)
+		}
+	}
+	c.startLevel = &c.inputs[0]
+	c.outputLevel = &c.inputs[len(c.inputs)-1]
+	for j := 1; j < len(c.inputs)-1; j++ {
+		c.extraLevels = append(c.extraLevels, &c.inputs[j])
+	}
+	if c.startLevel.level == 0 {
+		c.startLevel.l0SublevelInfo = generateSublevelInfo(cmp, c.startLevel.files)
+	}
+	return c, inputs, nil
+}
+
+// unrefInputBackings releases the references to the backings of the inputs of
+// a compaction returned by CompactionWorker.newCompaction.
+func (c *compaction) unrefInputBackings() {
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			f.FileBacking.Unref()
+		}
+	}
+}
+
+// Release releases the worker's references to the outputs of a job.
+func (w *CompactionWorker) Release(ctx context.Context, result *CompactionJobResult) error {
+	var err error
+	for _, t := range result.Outputs {
+		err = firstError(err, w.provider.Remove(fileTypeTable, base.PhysicalTableDiskFileNum(t.FileNum)))
+	}
+	return firstError(err, w.provider.Sync())
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// testCompactionExecutor wraps a CompactionExecutor, counting the jobs it
+// executes and optionally tampering with their results.
+type testCompactionExecutor struct {
+	CompactionExecutor
+	executed int
+	tamper   func(*CompactionJobResult)
+}
+
+func (e *testCompactionExecutor) Execute(
+	ctx context.Context, job *CompactionJob,
+) (*CompactionJobResult, error) {
+	e.executed++
+	result, err := e.CompactionExecutor.Execute(ctx, job)
+	if err == nil && e.tamper != nil {
+		e.tamper(result)
+	}
+	return result, err
+}
+
+func TestRemoteCompaction(t *testing.T) {
+	storage := remote.NewInMem()
+	newOpts := func() *Options {
+		opts := &Options{
+			FS:                          vfs.NewMem(),
+			FormatMajorVersion:          FormatNewest,
+			DisableAutomaticCompactions: true,
+		}
+		opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+			"": storage,
+		})
+		opts.Experimental.CreateOnShared = remote.CreateOnSharedAll
+		opts.EnsureDefaults()
+		for i := range opts.Levels {
+			opts.Levels[i].TargetFileSize = 4 << 10
+		}
+		return opts
+	}
+
+	worker, err := OpenCompactionWorker("worker", 100, newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, worker.Close()) }()
+
+	// run writes the same data to a DB, returning the DB's contents at the
+	// latest sequence number and at a snapshot. If e is non-nil, the DB's
+	// compactions are offloaded to it.
+	creatorID := 1
+	run := func(e *testCompactionExecutor) (latest, snapshot string, remoteCount int) {
+		opts := newOpts()
+		opts.EventListener = &EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				for _, a := range info.Annotations {
+					if a == "remote" {
+						remoteCount++
+					}
+				}
+			},
+		}
+		if e != nil {
+			opts.Experimental.CompactionExecutor = e
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		require.NoError(t, d.SetCreatorID(uint64(creatorID)))
+		creatorID++
+
+		latest, snapshot = runCompactionTestWorkload(t, d)
+		return latest, snapshot, remoteCount
+	}
+
+	latest, snapshot, remoteCount := run(nil)
+	require.Zero(t, remoteCount)
+
+	e := &testCompactionExecutor{CompactionExecutor: NewLocalCompactionExecutor(worker)}
+	remoteLatest, remoteSnapshot, remoteCount := run(e)
+	require.Equal(t, 2, e.executed)
+	require.Equal(t, 2, remoteCount)
+	require.Equal(t, latest, remoteLatest)
+	require.Equal(t, snapshot, remoteSnapshot)
+	// The worker released its references to the inputs and outputs.
+	require.Empty(t, worker.provider.List())
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			tamper func(*CompactionJobResult)
+			err    string
+		}{
+			// The outputs of a worker may not have sequence numbers outside
+			// those of the compaction's inputs.
+			"largest-seqnum": {
+				tamper: func(r *CompactionJobResult) { r.Outputs[0].LargestSeqNum = 1 << 40 },
+				err:    "exceed the compaction's sequence numbers",
+			},
+			"smallest-seqnum": {
+				tamper: func(r *CompactionJobResult) { r.Outputs[0].SmallestSeqNum = 1 },
+				err:    "exceed the compaction's sequence numbers",
+			},
+			"order": {
+				tamper: func(r *CompactionJobResult) { r.Outputs[0], r.Outputs[1] = r.Outputs[1], r.Outputs[0] },
+				err:    "overlaps the preceding table",
+			},
+			"size": {
+				tamper: func(r *CompactionJobResult) { r.Outputs[0].Size++ },
+				err:    "has size",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				opts := newOpts()
+				opts.Experimental.CompactionExecutor = &testCompactionExecutor{
+					CompactionExecutor: NewLocalCompactionExecutor(worker),
+					tamper:             tc.tamper,
+				}
+				d, err := Open("", opts)
+				require.NoError(t, err)
+				defer func() { require.NoError(t, d.Close()) }()
+				require.NoError(t, d.SetCreatorID(uint64(creatorID)))
+				creatorID++
+
+				for i := 0; i < 1000; i++ {
+					require.NoError(t, d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(strings.Repeat("v", 64)), nil))
+				}
+				require.NoError(t, d.Flush())
+				before := d.DebugString()
+				err = d.Compact([]byte("key"), []byte("key99999"), false)
+				require.ErrorContains(t, err, "remote compaction")
+				require.ErrorContains(t, err, tc.err)
+				require.Empty(t, worker.provider.List())
+				// The inputs remain in place.
+				require.Equal(t, before, d.DebugString())
+			})
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		// The executor blocks until the job's context is cancelled.
+		started := make(chan struct{}, 1)
+		opts := newOpts()
+		opts.Experimental.CompactionExecutor = blockingCompactionExecutor{started: started}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		require.NoError(t, d.SetCreatorID(uint64(creatorID)))
+		creatorID++
+
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(strings.Repeat("v", 64)), nil))
+		}
+		require.NoError(t, d.Flush())
+		before := d.DebugString()
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		err = d.CompactWithOptions(ctx, []KeyRange{{Start: []byte("key"), End: []byte("key99999")}}, CompactOptions{})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, before, d.DebugString())
+	})
+}
+
+// blockingCompactionExecutor is a CompactionExecutor whose jobs run until
+// their context is cancelled.
+type blockingCompactionExecutor struct {
+	started chan struct{}
+}
+
+func (e blockingCompactionExecutor) Execute(
+	ctx context.Context, job *CompactionJob,
+) (*CompactionJobResult, error) {
+	e.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (e blockingCompactionExecutor) Release(context.Context, *CompactionJobResult) error {
+	return nil
+}
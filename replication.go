@@ -0,0 +1,471 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ErrReplicationOverflow is returned by a ReplicationStream whose buffered
+// batches exceeded ReplicationStreamOptions.MaxBufferedBytes because its
+// consumer fell behind. The replicas it fed must be bootstrapped again.
+var ErrReplicationOverflow = errors.New("pebble: replication stream overflowed")
+
+// ErrReplicationUnsupported is returned by a ReplicationStream when the DB
+// performs an operation that cannot be shipped to replicas, such as an
+// ingestion. The replicas it fed must be bootstrapped again.
+var ErrReplicationUnsupported = errors.New("pebble: operation cannot be replicated")
+
+// errReplicatedBatchContained is set as the replicatedErr of a batch applied
+// with DB.ApplyReplicated that the DB already contains.
+var errReplicatedBatchContained = errors.New("pebble: replicated batch already applied")
+
+// errReplicationStreamClosed is returned by a closed ReplicationStream.
+var errReplicationStreamClosed = errors.New("pebble: replication stream closed")
+
+// defaultReplicationMaxBufferedBytes is the default value of
+// ReplicationStreamOptions.MaxBufferedBytes.
+const defaultReplicationMaxBufferedBytes = 64 << 20
+
+// ReplicatedBatch is a batch committed by a primary DB, as shipped to its
+// replicas.
+type ReplicatedBatch struct {
+	// SeqNum is the sequence number the batch was given by the primary.
+	SeqNum uint64
+	// Repr is the representation of the batch (see Batch.Repr). It must not be
+	// modified.
+	Repr []byte
+}
+
+// ReplicationSource is a source of the batches committed by a primary DB, in
+// sequence number order. It is implemented by ReplicationStream on the
+// primary, and by the receiving end of a transport on a replica.
+type ReplicationSource interface {
+	// Recv returns the next batch, blocking until one is available, the
+	// context is done or the source fails.
+	Recv(ctx context.Context) (ReplicatedBatch, error)
+}
+
+// ReplicationSink is the sending end of a transport that ships the batches of
+// a ReplicationStream to a replica.
+type ReplicationSink interface {
+	// Send ships the batch. The batch's Repr must not be retained after Send
+	// returns.
+	Send(ctx context.Context, b ReplicatedBatch) error
+}
+
+// ReplicationStreamOptions configures a ReplicationStream.
+type ReplicationStreamOptions struct {
+	// MaxBufferedBytes is the size of the batches the stream buffers for its
+	// consumer, beyond which the stream fails with ErrReplicationOverflow
+	// rather than slowing down the DB. Defaults to 64 MB.
+	MaxBufferedBytes int64
+}
+
+// NewReplicationStream returns a stream of the batches committed to the DB
+// from now on, in sequence number order, that can be applied to a replica with
+// DB.Replicate or DB.ApplyReplicated. A batch is returned by the stream once it
+// is visible and durable in the WAL (unless the WAL is disabled), so that a
+// replica never contains a batch the primary could lose in a crash.
+//
+// A replica is bootstrapped from a checkpoint (see ReplicationStream.Checkpoint)
+// or from sstables (see ReplicationStream.ExportSnapshot) made after the stream
+// is created; the batches of the stream that the replica already contains are
+// skipped when they are applied.
+//
+// The stream buffers the batches until they are received, and fails if its
+// consumer falls behind (see ReplicationStreamOptions.MaxBufferedBytes) or if
+// the DB ingests sstables. The stream must be closed.
+func (d *DB) NewReplicationStream(opts ReplicationStreamOptions) (*ReplicationStream, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if opts.MaxBufferedBytes <= 0 {
+		opts.MaxBufferedBytes = defaultReplicationMaxBufferedBytes
+	}
+	s := &ReplicationStream{
+		db:     d,
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+	}
+
+	d.commit.mu.Lock()
+	// Every batch from startSeqNum on is passed to publishReplicated, which
+	// acquires d.replication.mu and so observes the stream.
+	s.startSeqNum = d.commit.startWatchingLocked()
+	d.replication.mu.Lock()
+	d.replication.streams = append(d.replication.streams, s)
+	d.replication.mu.Unlock()
+	d.commit.mu.Unlock()
+
+	// Wait for the batches that were assigned sequence numbers before the
+	// stream was created to become visible, so that snapshots taken after the
+	// stream is created contain them.
+	d.commit.waitVisible(s.startSeqNum)
+	return s, nil
+}
+
+// publishReplicated adds a published batch to the replication streams. It is
+// invoked by the commit pipeline in sequence number order, once the batch is
+// visible. The representation of the batch is copied, as the batch may be
+// reused once committed.
+func (d *DB) publishReplicated(b *Batch) {
+	d.replication.mu.Lock()
+	defer d.replication.mu.Unlock()
+	// Ingested sstables fail the streams, and batches without sequence numbers
+	// (e.g. containing only LogData) have nothing to replicate.
+	if len(d.replication.streams) == 0 || b.ingestedSSTBatch || b.Count() == 0 {
+		return
+	}
+	var rb ReplicatedBatch
+	for _, s := range d.replication.streams {
+		if b.SeqNum() < s.startSeqNum {
+			continue
+		}
+		if rb.Repr == nil {
+			rb = ReplicatedBatch{SeqNum: b.SeqNum(), Repr: slices.Clone(b.Repr())}
+		}
+		s.push(rb)
+	}
+}
+
+// failReplicationStreams fails the replication streams with the given error.
+// They no longer buffer batches, but stay registered until they are closed.
+func (d *DB) failReplicationStreams(err error) {
+	d.replication.mu.Lock()
+	defer d.replication.mu.Unlock()
+	for _, s := range d.replication.streams {
+		s.fail(err)
+	}
+}
+
+// syncReplicationWAL syncs the WAL if the batches below seqNum may not be
+// durable yet, and ratchets d.replication.durableSeqNum.
+func (d *DB) syncReplicationWAL(seqNum uint64) error {
+	d.replication.syncMu.Lock()
+	defer d.replication.syncMu.Unlock()
+	if seqNum < d.replication.durableSeqNum.Load() {
+		return nil
+	}
+	if d.closed.Load() != nil {
+		return ErrClosed
+	}
+	// Committing a synced batch makes the batches written to the WAL before it
+	// durable too, i.e. those whose sequence numbers precede its own.
+	b := newBatch(d)
+	defer b.Close()
+	_ = b.LogData(nil, nil)
+	if err := d.Apply(b, Sync); err != nil {
+		return err
+	}
+	d.replication.durableSeqNum.Store(b.SeqNum())
+	return nil
+}
+
+// ReplicationStream is a stream of the batches committed to a primary DB. See
+// DB.NewReplicationStream.
+type ReplicationStream struct {
+	db   *DB
+	opts ReplicationStreamOptions
+	// startSeqNum is the sequence number of the first batch that may be
+	// returned by the stream.
+	startSeqNum uint64
+	// notify is signaled when a batch is buffered or the stream fails.
+	notify chan struct{}
+
+	mu struct {
+		sync.Mutex
+		queue         []ReplicatedBatch
+		bufferedBytes int64
+		// err is returned once the buffered batches have been received.
+		err error
+	}
+}
+
+var _ ReplicationSource = (*ReplicationStream)(nil)
+
+// StartSeqNum returns the sequence number of the first batch that may be
+// returned by the stream. All the batches with lower sequence numbers are
+// visible to snapshots and checkpoints of the DB made after the stream was
+// created.
+func (s *ReplicationStream) StartSeqNum() uint64 {
+	return s.startSeqNum
+}
+
+// push buffers the batch, unless the stream failed.
+func (s *ReplicationStream) push(b ReplicatedBatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.err != nil {
+		return
+	}
+	if s.mu.bufferedBytes+int64(len(b.Repr)) > s.opts.MaxBufferedBytes {
+		s.failLocked(ErrReplicationOverflow)
+		return
+	}
+	s.mu.queue = append(s.mu.queue, b)
+	s.mu.bufferedBytes += int64(len(b.Repr))
+	s.signal()
+}
+
+func (s *ReplicationStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failLocked(err)
+}
+
+func (s *ReplicationStream) failLocked(err error) {
+	if s.mu.err == nil {
+		s.mu.err = err
+		s.signal()
+	}
+}
+
+func (s *ReplicationStream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Recv implements ReplicationSource. Once the stream fails, Recv returns the
+// batches buffered before the failure, followed by the error.
+func (s *ReplicationStream) Recv(ctx context.Context) (ReplicatedBatch, error) {
+	d := s.db
+	for {
+		s.mu.Lock()
+		if len(s.mu.queue) > 0 {
+			b := s.mu.queue[0]
+			if !d.opts.DisableWAL && b.SeqNum >= d.replication.durableSeqNum.Load() {
+				// The batch is visible, but may not have been synced to the WAL.
+				s.mu.Unlock()
+				if err := d.syncReplicationWAL(b.SeqNum); err != nil {
+					return ReplicatedBatch{}, err
+				}
+				continue
+			}
+			s.mu.queue[0] = ReplicatedBatch{}
+			s.mu.queue = s.mu.queue[1:]
+			s.mu.bufferedBytes -= int64(len(b.Repr))
+			s.mu.Unlock()
+			return b, nil
+		}
+		err := s.mu.err
+		s.mu.Unlock()
+		if err != nil {
+			return ReplicatedBatch{}, err
+		}
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return ReplicatedBatch{}, ctx.Err()
+		}
+	}
+}
+
+// SendTo ships the batches of the stream to the sink until the context is
+// done, the stream fails or the sink returns an error.
+func (s *ReplicationStream) SendTo(ctx context.Context, sink ReplicationSink) error {
+	for {
+		b, err := s.Recv(ctx)
+		if err != nil {
+			return err
+		}
+		if err := sink.Send(ctx, b); err != nil {
+			return err
+		}
+	}
+}
+
+// Checkpoint creates a checkpoint of the DB in destDir (see DB.Checkpoint),
+// from which a replica fed by the stream can be opened.
+func (s *ReplicationStream) Checkpoint(destDir string, opts ...CheckpointOption) error {
+	return s.db.Checkpoint(destDir, opts...)
+}
+
+// ReplicationSnapshot is a set of sstables containing the state of a primary
+// DB, from which a replica is bootstrapped with DB.BootstrapReplica. See
+// ReplicationStream.ExportSnapshot.
+type ReplicationSnapshot struct {
+	// Paths are the paths of the sstables.
+	Paths []string
+	// SeqNum is the sequence number of the snapshot: the sstables contain all
+	// the batches with lower sequence numbers.
+	SeqNum uint64
+}
+
+// ExportSnapshot writes the visible state of a snapshot of the DB to sstables
+// in the given directory (see DB.ExportSpan), from which an empty replica fed
+// by the stream can be bootstrapped with DB.BootstrapReplica.
+func (s *ReplicationStream) ExportSnapshot(
+	ctx context.Context, fs vfs.FS, dir string,
+) (ReplicationSnapshot, error) {
+	exported, err := s.db.ExportSpan(ctx, KeyRange{}, ExportSpanOptions{FS: fs, Dir: dir})
+	if err != nil {
+		return ReplicationSnapshot{}, err
+	}
+	return ReplicationSnapshot{Paths: exported.Paths, SeqNum: exported.SeqNum}, nil
+}
+
+// Close stops the stream. The DB no longer buffers batches for it.
+func (s *ReplicationStream) Close() error {
+	d := s.db
+	d.replication.mu.Lock()
+	i := slices.Index(d.replication.streams, s)
+	if i >= 0 {
+		d.replication.streams = slices.Delete(d.replication.streams, i, i+1)
+	}
+	d.replication.mu.Unlock()
+	s.fail(errReplicationStreamClosed)
+	if i >= 0 {
+		d.commit.stopWatching()
+	}
+	return nil
+}
+
+// ApplyReplicated applies a batch committed by a primary DB with the given
+// sequence number, preserving it, so that snapshots and sequence numbers are
+// consistent between the primary and the replica. The batches of the primary
+// must be applied in sequence number order, and the replica must not be
+// written to otherwise.
+//
+// A batch whose sequence numbers precede those of the DB, because the DB was
+// bootstrapped from a checkpoint or snapshot that already contains it, is
+// skipped. ApplyReplicated returns an error if only part of the batch is
+// contained in the DB, or if the batch does not start at the DB's next
+// sequence number, which means that the batches in between were lost.
+func (d *DB) ApplyReplicated(batch *Batch, seqNum uint64, opts *WriteOptions) error {
+	if seqNum < base.SeqNumStart {
+		return errors.Errorf("pebble: invalid replicated batch seqnum %d", errors.Safe(seqNum))
+	}
+	// A batch that is rejected up front is neither admitted nor charged to
+	// write admission. Otherwise, the sequence numbers are checked again by the
+	// commit pipeline, as concurrent batches may advance the DB's, which
+	// rejects the batch through batch.replicatedErr.
+	d.commit.mu.Lock()
+	err := d.commit.checkReplicatedLocked(seqNum, uint64(batch.Count()))
+	d.commit.mu.Unlock()
+	if err == nil {
+		batch.replicatedSeqNum = seqNum
+		err = d.Apply(batch, opts)
+		batch.replicatedSeqNum = 0
+		if err == nil {
+			err = batch.replicatedErr
+			batch.replicatedErr = nil
+		}
+	}
+	if err == errReplicatedBatchContained {
+		return nil
+	}
+	return err
+}
+
+// Replicate applies the batches received from the source to the DB (see
+// DB.ApplyReplicated), until the context is done, the source fails or a batch
+// cannot be applied.
+func (d *DB) Replicate(ctx context.Context, src ReplicationSource, opts *WriteOptions) error {
+	b := d.NewBatch()
+	defer b.Close()
+	for {
+		rb, err := src.Recv(ctx)
+		if err != nil {
+			return err
+		}
+		b.Reset()
+		if err := b.SetRepr(rb.Repr); err != nil {
+			return err
+		}
+		if err := d.ApplyReplicated(b, rb.SeqNum, opts); err != nil {
+			return err
+		}
+	}
+}
+
+// BootstrapReplica ingests the sstables of a snapshot exported by
+// ReplicationStream.ExportSnapshot into the DB, which must be empty, and
+// ensures that the batches the snapshot contains are skipped when they are
+// applied with DB.ApplyReplicated.
+func (d *DB) BootstrapReplica(snap ReplicationSnapshot) error {
+	d.mu.Lock()
+	empty := d.mu.versions.logSeqNum.Load() == base.SeqNumStart
+	vers := d.mu.versions.currentVersion()
+	for level := range vers.Levels {
+		empty = empty && vers.Levels[level].Empty()
+	}
+	d.mu.Unlock()
+	if !empty {
+		return errors.New("pebble: cannot bootstrap a replica into a non-empty DB")
+	}
+	if len(snap.Paths) > 0 {
+		if err := d.Ingest(snap.Paths); err != nil {
+			return err
+		}
+	}
+	d.commit.ratchetSeqNum(snap.SeqNum)
+	return nil
+}
+
+// LoopbackReplicationTransport is a transport that ships the batches of a
+// ReplicationStream to a replica in the same process, as a ReplicationSink
+// and a ReplicationSource. The batches are copied, as they would be by a
+// network transport.
+type LoopbackReplicationTransport struct {
+	ch        chan ReplicatedBatch
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ ReplicationSink = (*LoopbackReplicationTransport)(nil)
+var _ ReplicationSource = (*LoopbackReplicationTransport)(nil)
+
+// NewLoopbackReplicationTransport returns a transport that buffers up to
+// capacity batches.
+func NewLoopbackReplicationTransport(capacity int) *LoopbackReplicationTransport {
+	return &LoopbackReplicationTransport{
+		ch:     make(chan ReplicatedBatch, capacity),
+		closed: make(chan struct{}),
+	}
+}
+
+// Send implements ReplicationSink.
+func (t *LoopbackReplicationTransport) Send(ctx context.Context, b ReplicatedBatch) error {
+	b.Repr = slices.Clone(b.Repr)
+	select {
+	case t.ch <- b:
+		return nil
+	case <-t.closed:
+		return errReplicationStreamClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv implements ReplicationSource.
+func (t *LoopbackReplicationTransport) Recv(ctx context.Context) (ReplicatedBatch, error) {
+	select {
+	case b := <-t.ch:
+		return b, nil
+	case <-t.closed:
+		return ReplicatedBatch{}, errReplicationStreamClosed
+	case <-ctx.Done():
+		return ReplicatedBatch{}, ctx.Err()
+	}
+}
+
+// Close closes the transport. Subsequent calls to Send and Recv return an
+// error.
+func (t *LoopbackReplicationTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
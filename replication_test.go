@@ -0,0 +1,300 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForSeqNum waits for the DB to make the given sequence number visible.
+func waitForSeqNum(t *testing.T, d *DB, seqNum uint64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for d.mu.versions.visibleSeqNum.Load() < seqNum {
+		require.True(t, time.Now().Before(deadline), "timed out waiting for seqnum %d", seqNum)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplicationCheckpoint(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest, Comparer: testkeys.Comparer}
+	primary, err := Open("primary", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+
+	require.NoError(t, primary.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, primary.Flush())
+	stream, err := primary.NewReplicationStream(ReplicationStreamOptions{})
+	require.NoError(t, err)
+	defer stream.Close()
+	require.Equal(t, primary.mu.versions.logSeqNum.Load(), stream.StartSeqNum())
+
+	// This batch is both in the checkpoint and in the stream, and is skipped
+	// by the replica.
+	require.NoError(t, primary.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, stream.Checkpoint("replica"))
+
+	replica, err := Open("replica", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, replica.Close()) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	transport := NewLoopbackReplicationTransport(4)
+	defer transport.Close()
+	sendErr := make(chan error, 1)
+	go func() { sendErr <- stream.SendTo(ctx, transport) }()
+	replicateErr := make(chan error, 1)
+	go func() { replicateErr <- replica.Replicate(ctx, transport, NoSync) }()
+
+	b := primary.NewBatch()
+	require.NoError(t, b.Delete([]byte("a"), nil))
+	require.NoError(t, b.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, b.RangeKeySet([]byte("d"), []byte("f"), []byte("@1"), []byte("rk"), nil))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, b.Close())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, primary.Set([]byte(fmt.Sprintf("e%03d", i)), []byte("v"), nil))
+	}
+
+	waitForSeqNum(t, replica, primary.mu.versions.visibleSeqNum.Load())
+	require.Equal(t, primary.mu.versions.logSeqNum.Load(), replica.mu.versions.logSeqNum.Load())
+	require.Equal(t, dumpDBContents(t, primary, KeyRange{}), dumpDBContents(t, replica, KeyRange{}))
+
+	cancel()
+	require.ErrorIs(t, <-sendErr, context.Canceled)
+	require.ErrorIs(t, <-replicateErr, context.Canceled)
+}
+
+func TestReplicationExportSnapshot(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest, Comparer: testkeys.Comparer}
+	opts.Levels = []LevelOptions{{TargetFileSize: 100}}
+	primary, err := Open("primary", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		v := make([]byte, 500)
+		rng.Read(v)
+		require.NoError(t, primary.Set([]byte(fmt.Sprintf("a%03d", i)), v, nil))
+	}
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.DeleteRange([]byte("a010"), []byte("a020"), nil))
+	require.NoError(t, primary.RangeKeySet([]byte("a030"), []byte("a040"), []byte("@2"), []byte("rk"), nil))
+	require.NoError(t, primary.Delete([]byte("a045"), nil))
+
+	stream, err := primary.NewReplicationStream(ReplicationStreamOptions{})
+	require.NoError(t, err)
+	defer stream.Close()
+	require.NoError(t, mem.MkdirAll("export", 0755))
+	snap, err := stream.ExportSnapshot(context.Background(), mem, "export")
+	require.NoError(t, err)
+	require.Greater(t, len(snap.Paths), 1)
+	require.Equal(t, stream.StartSeqNum(), snap.SeqNum)
+
+	replica, err := Open("replica", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, replica.Close()) }()
+	require.NoError(t, replica.BootstrapReplica(snap))
+	require.Equal(t, snap.SeqNum, replica.mu.versions.logSeqNum.Load())
+	// The replica is no longer empty.
+	require.ErrorContains(t, replica.BootstrapReplica(snap), "non-empty")
+	require.Equal(t, dumpDBContents(t, primary, KeyRange{}), dumpDBContents(t, replica, KeyRange{}))
+
+	require.NoError(t, primary.Set([]byte("b"), []byte("v"), nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, replica.Replicate(ctx, stream, nil), context.Canceled)
+	require.Equal(t, dumpDBContents(t, primary, KeyRange{}), dumpDBContents(t, replica, KeyRange{}))
+}
+
+func TestApplyReplicated(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	next := d.mu.versions.logSeqNum.Load()
+	apply := func(seqNum uint64, keys ...string) error {
+		b := d.NewBatch()
+		defer b.Close()
+		for _, k := range keys {
+			require.NoError(t, b.Set([]byte(k), []byte(k), nil))
+		}
+		return d.ApplyReplicated(b, seqNum, nil)
+	}
+	// A batch that follows a gap, e.g. because the transport lost a batch, is
+	// rejected.
+	require.ErrorContains(t, apply(next+10, "a", "b"), "follows a gap")
+	require.Equal(t, next, d.mu.versions.logSeqNum.Load())
+	// The sequence numbers of the primary are preserved.
+	require.NoError(t, apply(next, "a", "b"))
+	require.Equal(t, next+2, d.mu.versions.logSeqNum.Load())
+	require.Equal(t, next+2, d.mu.versions.visibleSeqNum.Load())
+	// A batch that was already applied is skipped.
+	require.NoError(t, apply(next, "c", "d"))
+	_, closer, err := d.Get([]byte("c"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Nil(t, closer)
+	// A batch that was partially applied is rejected.
+	require.Error(t, apply(next+1, "e", "f"))
+	require.NoError(t, apply(next+2, "e", "f"))
+	require.Equal(t, next+4, d.mu.versions.logSeqNum.Load())
+
+	// A rejected batch can be applied as a regular batch.
+	b := d.NewBatch()
+	defer b.Close()
+	require.NoError(t, b.Set([]byte("g"), []byte("g"), nil))
+	require.NoError(t, b.Set([]byte("h"), []byte("h"), nil))
+	require.Error(t, d.ApplyReplicated(b, next+3, nil))
+	require.NoError(t, d.Apply(b, nil))
+	require.Equal(t, next+6, d.mu.versions.logSeqNum.Load())
+
+	// Sequence numbers are checked against those assigned concurrently, rather
+	// than the ones observed when ApplyReplicated is called.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, d.Set([]byte("h"), []byte("h"), nil))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		// The batch is either applied, skipped or rejected.
+		_ = apply(d.mu.versions.logSeqNum.Load(), "i", "j")
+	}
+	wg.Wait()
+}
+
+func TestReplicationStreamFailure(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	ctx := context.Background()
+
+	// A stream whose consumer falls behind fails, once the batches it buffered
+	// are received.
+	stream, err := d.NewReplicationStream(ReplicationStreamOptions{MaxBufferedBytes: 100})
+	require.NoError(t, err)
+	defer stream.Close()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%d", i)), make([]byte, 20), nil))
+	}
+	var received int
+	for {
+		_, err := stream.Recv(ctx)
+		if err != nil {
+			require.ErrorIs(t, err, ErrReplicationOverflow)
+			break
+		}
+		received++
+	}
+	require.Greater(t, received, 0)
+	require.Less(t, received, 10)
+
+	// An ingestion fails the streams.
+	stream, err = d.NewReplicationStream(ReplicationStreamOptions{})
+	require.NoError(t, err)
+	f, err := mem.Create("ext", vfs.WriteCategoryUnspecified)
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+	require.NoError(t, w.Set([]byte("z"), []byte("z")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	_, err = stream.Recv(ctx)
+	require.ErrorIs(t, err, ErrReplicationUnsupported)
+	require.NoError(t, stream.Close())
+
+	// A closed stream returns an error.
+	stream, err = d.NewReplicationStream(ReplicationStreamOptions{})
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	require.NoError(t, d.Set([]byte("k"), nil, nil))
+	_, err = stream.Recv(ctx)
+	require.True(t, errors.Is(err, errReplicationStreamClosed))
+}
+
+func TestReplicationStreamDurable(t *testing.T) {
+	mem := vfs.NewStrictMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	stream, err := d.NewReplicationStream(ReplicationStreamOptions{})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	// The batches written without syncing the WAL are visible once committed,
+	// but are only received once they are durable.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.Commit(NoSync))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), NoSync))
+	seqNum := b.SeqNum()
+	require.NoError(t, b.Close())
+	for _, n := range []uint64{seqNum, seqNum + 2} {
+		rb, err := stream.Recv(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, n, rb.SeqNum)
+	}
+	require.Greater(t, d.replication.durableSeqNum.Load(), seqNum+2)
+
+	// Crash the DB. The batches that were received survive.
+	mem.SetIgnoreSyncs(true)
+	require.NoError(t, d.Close())
+	mem.ResetToSyncedState()
+	mem.SetIgnoreSyncs(false)
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.Equal(t, "a=1\nb=2\nc=3\n", dumpDBContents(t, d, KeyRange{}))
+}
+
+func TestApplyReplicatedAdmission(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.WriteAdmission.L0SublevelThreshold = 100
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	admitted := func() uint64 { return d.Metrics().WriteAdmission.AdmittedCount }
+
+	next := d.mu.versions.logSeqNum.Load()
+	newBatch := func() *Batch {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte("a"), []byte("a"), nil))
+		return b
+	}
+	b := newBatch()
+	require.NoError(t, d.ApplyReplicated(b, next, nil))
+	require.NoError(t, b.Close())
+	require.Equal(t, uint64(1), admitted())
+
+	// Batches rejected by ApplyReplicated are not charged to write admission,
+	// whether they are rejected before being admitted or by the commit
+	// pipeline.
+	b = newBatch()
+	defer b.Close()
+	require.NoError(t, d.ApplyReplicated(b, next, nil))
+	require.Error(t, d.ApplyReplicated(b, next+10, nil))
+	require.Equal(t, uint64(1), admitted())
+	b.replicatedSeqNum = next
+	require.NoError(t, d.Apply(b, nil))
+	require.Equal(t, errReplicatedBatchContained, b.replicatedErr)
+	require.Equal(t, uint64(1), admitted())
+}
@@ -772,14 +772,16 @@ func scanInternalImpl(
 		case InternalKeyKindRangeKeyDelete, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeySet:
 			if opts.visitRangeKey != nil {
 				span := iter.unsafeSpan()
-				// NB: The caller isn't interested in the sequence numbers of these
-				// range keys. Rather, the caller wants them to be in trailer order
-				// _after_ zeroing of sequence numbers. Copy span.Keys, sort it, and then
-				// call visitRangeKey.
+				// NB: The caller usually isn't interested in the sequence numbers of
+				// these range keys. Rather, the caller wants them to be in trailer
+				// order _after_ zeroing of sequence numbers. Copy span.Keys, sort it,
+				// and then call visitRangeKey.
 				keysCopy := make([]keyspan.Key, len(span.Keys))
 				for i := range span.Keys {
 					keysCopy[i] = span.Keys[i]
-					keysCopy[i].Trailer = base.MakeTrailer(0, span.Keys[i].Kind())
+					if !opts.includeRangeKeySeqNums {
+						keysCopy[i].Trailer = base.MakeTrailer(0, span.Keys[i].Kind())
+					}
 				}
 				keyspan.SortKeysByTrailer(&keysCopy)
 				if err := opts.visitRangeKey(span.Start, span.End, keysCopy); err != nil {
@@ -789,7 +791,13 @@ func scanInternalImpl(
 		case InternalKeyKindRangeDelete:
 			if opts.visitRangeDel != nil {
 				rangeDel := iter.unsafeRangeDel()
-				if err := opts.visitRangeDel(rangeDel.Start, rangeDel.End, rangeDel.LargestSeqNum()); err != nil {
+				if opts.includeShadowedRangeDels {
+					for _, k := range rangeDel.Keys {
+						if err := opts.visitRangeDel(rangeDel.Start, rangeDel.End, k.SeqNum()); err != nil {
+							return err
+						}
+					}
+				} else if err := opts.visitRangeDel(rangeDel.Start, rangeDel.End, rangeDel.LargestSeqNum()); err != nil {
 					return err
 				}
 			}
@@ -891,7 +899,9 @@ func (i *scanInternalIterator) constructPointIter(
 	mlevels = mlevels[:numMergingLevels]
 	levels = levels[:numLevelIters]
 	rangeDelLevels = rangeDelLevels[:numLevelIters]
-	i.opts.IterOptions.snapshotForHideObsoletePoints = i.seqNum
+	if !i.opts.includeObsoletePoints {
+		i.opts.IterOptions.snapshotForHideObsoletePoints = i.seqNum
+	}
 	i.opts.IterOptions.CategoryAndQoS = categoryAndQoS
 	addLevelIterForFiles := func(files manifest.LevelIterator, level manifest.Level) {
 		li := &levels[levelsIndex]
@@ -192,6 +192,36 @@ func (l *snapshotList) pushBack(s *Snapshot) {
 	s.list = l
 }
 
+// insertAfter links s into the list after prev, which must be in the list.
+func (l *snapshotList) insertAfter(prev, s *Snapshot) {
+	if s.list != nil || s.prev != nil || s.next != nil || prev.list != l {
+		panic("pebble: snapshot list is inconsistent")
+	}
+	s.prev = prev
+	s.next = prev.next
+	s.prev.next = s
+	s.next.prev = s
+	s.list = l
+}
+
+// insertSorted links s into the list after every snapshot with a sequence
+// number less than or equal to s.seqNum, keeping the list in sequence number
+// order.
+func (l *snapshotList) insertSorted(s *Snapshot) {
+	if s.list != nil || s.prev != nil || s.next != nil {
+		panic("pebble: snapshot list is inconsistent")
+	}
+	prev := l.root.prev
+	for prev != &l.root && prev.seqNum > s.seqNum {
+		prev = prev.prev
+	}
+	s.prev = prev
+	s.next = prev.next
+	s.prev.next = s
+	s.next.prev = s
+	s.list = l
+}
+
 func (l *snapshotList) remove(s *Snapshot) {
 	if s == &l.root {
 		panic("pebble: cannot remove snapshot list root node")
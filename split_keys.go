@@ -0,0 +1,104 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"slices"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// splitKeysSmallTableFraction is the fraction of the target chunk size below
+// which an sstable contained within the span is treated as a single block,
+// without reading its index.
+const splitKeysSmallTableFraction = 8
+
+// SuggestSplitKeys returns user keys that partition the span [start, end) into
+// chunks of approximately targetBytes of sstable data each. The returned keys
+// are sorted, and lie strictly between start and end: the chunks are [start,
+// keys[0]), [keys[0], keys[1]), ..., [keys[n-1], end). No keys are returned if
+// the span holds less than targetBytes of data.
+//
+// The sizes are estimated from the file metadata and the index blocks of the
+// sstables, as in EstimateDiskUsage, at data-block granularity. Keys that are
+// only in memtables are not accounted for. Each returned key is the prefix of
+// a key (as determined by Comparer.Split), so a split never falls between the
+// versions of an MVCC key.
+func (d *DB) SuggestSplitKeys(start, end []byte, targetBytes uint64) ([][]byte, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.Comparer.Compare(start, end) >= 0 {
+		return nil, errors.New("invalid key-range specified (start >= end)")
+	}
+	if targetBytes == 0 {
+		return nil, errors.New("pebble: target chunk size must be positive")
+	}
+	cmp := d.opts.Comparer.Compare
+
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	// Collect the boundaries of the data blocks of the sstables overlapping the
+	// span, across all levels. Small sstables contained within the span, and
+	// sstables whose keys are transformed, are treated as a single block.
+	bounds := base.UserKeyBoundsEndExclusive(start, end)
+	var boundaries []sstable.DataBlockBoundary
+	for level, files := range readState.current.Levels {
+		iter := files.Iter()
+		if level > 0 {
+			overlaps := readState.current.Overlaps(level, bounds)
+			iter = overlaps.Iter()
+		}
+		for file := iter.First(); file != nil; file = iter.Next() {
+			if !file.HasPointKeys || !file.Overlaps(cmp, &bounds) {
+				continue
+			}
+			contained := file.ContainedWithinSpan(cmp, start, end)
+			if (contained && file.Size <= targetBytes/splitKeysSmallTableFraction) ||
+				file.SyntheticPrefix.IsSet() || file.SyntheticSuffix.IsSet() {
+				boundaries = append(boundaries, sstable.DataBlockBoundary{
+					Key:  file.LargestPointKey.UserKey,
+					Size: file.Size,
+				})
+				continue
+			}
+			err := d.tableCache.withCommonReader(file, func(r sstable.CommonReader) error {
+				b, err := r.DataBlockBoundaries(start, end)
+				boundaries = append(boundaries, b...)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	slices.SortStableFunc(boundaries, func(a, b sstable.DataBlockBoundary) int {
+		return cmp(a.Key, b.Key)
+	})
+
+	// Cut a chunk once the accumulated size reaches the target, at the prefix
+	// of the boundary that reached it.
+	var splitKeys [][]byte
+	var size uint64
+	for _, b := range boundaries {
+		size += b.Size
+		if size < targetBytes {
+			continue
+		}
+		key := b.Key[:d.opts.Comparer.Split(b.Key)]
+		if cmp(key, start) <= 0 || cmp(key, end) >= 0 {
+			continue
+		}
+		if n := len(splitKeys); n > 0 && cmp(key, splitKeys[n-1]) <= 0 {
+			continue
+		}
+		splitKeys = append(splitKeys, slices.Clone(key))
+		size = 0
+	}
+	return splitKeys, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggestSplitKeys(t *testing.T) {
+	for _, indexBlockSize := range []int{0, 256} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			opts := &Options{
+				FS:                          vfs.NewMem(),
+				Comparer:                    testkeys.Comparer,
+				FormatMajorVersion:          FormatNewest,
+				DisableAutomaticCompactions: true,
+			}
+			opts.Levels = []LevelOptions{{TargetFileSize: 128 << 10, IndexBlockSize: indexBlockSize}}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			// Each key has several versions, and one key has enough versions to
+			// span many data blocks.
+			rng := rand.New(rand.NewSource(1))
+			b := d.NewBatch()
+			for i := 0; i < 1000; i++ {
+				versions := 5
+				if i == 500 {
+					versions = 500
+				}
+				for v := 1; v <= versions; v++ {
+					value := make([]byte, 200)
+					rng.Read(value)
+					require.NoError(t, b.Set([]byte(fmt.Sprintf("k%04d@%d", i, v)), value, nil))
+				}
+			}
+			require.NoError(t, b.Commit(nil))
+			require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+
+			start, end := []byte("k0100"), []byte("k0900")
+			total, err := d.EstimateDiskUsage(start, end)
+			require.NoError(t, err)
+			const target = 128 << 10
+			keys, err := d.SuggestSplitKeys(start, end, target)
+			require.NoError(t, err)
+			require.InDelta(t, total/target, len(keys), 3)
+
+			bounds := append(append([][]byte{start}, keys...), end)
+			for i := 1; i < len(bounds); i++ {
+				require.Less(t, testkeys.Comparer.Compare(bounds[i-1], bounds[i]), 0)
+				// The split keys have no suffix, so the versions of a key are never
+				// split across chunks.
+				require.Equal(t, len(bounds[i]), testkeys.Comparer.Split(bounds[i]))
+				if i < len(bounds)-1 {
+					size, err := d.EstimateDiskUsage(bounds[i-1], bounds[i])
+					require.NoError(t, err)
+					require.InEpsilon(t, target, size, 0.5)
+				}
+			}
+
+			keys, err = d.SuggestSplitKeys(start, end, total*2)
+			require.NoError(t, err)
+			require.Empty(t, keys)
+			_, err = d.SuggestSplitKeys(end, start, target)
+			require.Error(t, err)
+			_, err = d.SuggestSplitKeys(start, end, 0)
+			require.Error(t, err)
+		})
+	}
+}
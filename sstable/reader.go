@@ -1174,6 +1174,90 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 		endBH.Offset + endBH.Length + blockTrailerLen - startBH.Offset), nil
 }
 
+// DataBlockBoundary is the upper boundary of a data block of an sstable.
+type DataBlockBoundary struct {
+	// Key is the user key of the block's index entry: a separator that is
+	// greater than or equal to the keys of the block, and less than the keys
+	// of the next block.
+	Key []byte
+	// Size is the size of the block, including its trailer and the
+	// interpolated share of the value blocks.
+	Size uint64
+}
+
+// DataBlockBoundaries returns the boundaries of the data blocks that may
+// contain keys in the range [start, end], in order. Like EstimateDiskUsage, it
+// only reads the index blocks.
+func (r *Reader) DataBlockBoundaries(start, end []byte) ([]DataBlockBoundary, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	indexH, err := r.readIndex(context.Background(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer indexH.Release()
+
+	var boundaries []DataBlockBoundary
+	var alloc bytealloc.A
+	// addBlocks adds the data blocks of an index block from the block whose
+	// index entry is at kv, and returns true once it has added the block
+	// containing end.
+	addBlocks := func(iter *blockIter, kv *base.InternalKV) (bool, error) {
+		for ; kv != nil; kv = iter.Next() {
+			bh, err := decodeBlockHandleWithProperties(kv.InPlaceValue())
+			if err != nil {
+				return false, errCorruptIndexEntry(err)
+			}
+			size := bh.Length + blockTrailerLen
+			if r.Properties.DataSize > 0 {
+				size += uint64((float64(size) / float64(r.Properties.DataSize)) *
+					float64(r.Properties.ValueBlocksSize))
+			}
+			var key []byte
+			alloc, key = alloc.Copy(kv.K.UserKey)
+			boundaries = append(boundaries, DataBlockBoundary{Key: key, Size: size})
+			if r.Compare(kv.K.UserKey, end) >= 0 {
+				return true, nil
+			}
+		}
+		return false, iter.Error()
+	}
+
+	iter, err := newBlockIter(r.Compare, r.Split, indexH.Get(), NoTransforms)
+	if err != nil {
+		return nil, err
+	}
+	if r.Properties.IndexPartitions == 0 {
+		_, err := addBlocks(iter, iter.SeekGE(start, base.SeekGEFlagsNone))
+		return boundaries, err
+	}
+	topIter := iter
+	subIter := &blockIter{}
+	for kv := topIter.SeekGE(start, base.SeekGEFlagsNone); kv != nil; kv = topIter.Next() {
+		indexBH, err := decodeBlockHandleWithProperties(kv.InPlaceValue())
+		if err != nil {
+			return nil, errCorruptIndexEntry(err)
+		}
+		subIndex, err := r.readBlock(context.Background(), indexBH.BlockHandle,
+			nil /* transform */, nil /* readHandle */, nil /* stats */, nil /* iterStats */, nil /* buffer pool */)
+		if err != nil {
+			return nil, err
+		}
+		if err := subIter.init(r.Compare, r.Split, subIndex.Get(), NoTransforms); err != nil {
+			subIndex.Release()
+			return nil, err
+		}
+		done, err := addBlocks(subIter, subIter.SeekGE(start, base.SeekGEFlagsNone))
+		subIndex.Release()
+		*subIter = subIter.resetForReuse()
+		if done || err != nil {
+			return boundaries, err
+		}
+	}
+	return boundaries, topIter.Error()
+}
+
 // TableFormat returns the format version for the table.
 func (r *Reader) TableFormat() (TableFormat, error) {
 	if r.err != nil {
@@ -41,6 +41,8 @@ type CommonReader interface {
 
 	EstimateDiskUsage(start, end []byte) (uint64, error)
 
+	DataBlockBoundaries(start, end []byte) ([]DataBlockBoundary, error)
+
 	CommonProperties() *CommonProperties
 }
 
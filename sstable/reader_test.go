@@ -1699,6 +1699,35 @@ func TestReader_TableFormat(t *testing.T) {
 	}
 }
 
+func TestReaderDataBlockBoundaries(t *testing.T) {
+	provider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(vfs.NewMem(), ""))
+	require.NoError(t, err)
+	defer provider.Close()
+	key := func(i uint64) []byte { return binary.BigEndian.AppendUint64(nil, i) }
+	for _, indexBlockSize := range []int{math.MaxInt32, 100} {
+		r := buildTestTableWithProvider(t, provider, 1e4, 1000, indexBlockSize, DefaultCompression, nil)
+		start, end := key(100), key(5000)
+		boundaries, err := r.DataBlockBoundaries(start, end)
+		require.NoError(t, err)
+		require.Greater(t, len(boundaries), 2)
+		// The boundaries cover the same data blocks as EstimateDiskUsage.
+		var size uint64
+		for i, b := range boundaries {
+			size += b.Size
+			require.GreaterOrEqual(t, r.Compare(b.Key, start), 0)
+			if i < len(boundaries)-1 {
+				require.Less(t, r.Compare(b.Key, end), 0)
+			} else {
+				require.GreaterOrEqual(t, r.Compare(b.Key, end), 0)
+			}
+		}
+		expected, err := r.EstimateDiskUsage(start, end)
+		require.NoError(t, err)
+		require.Equal(t, expected, size)
+		require.NoError(t, r.Close())
+	}
+}
+
 func buildTestTableWithProvider(
 	t *testing.T,
 	provider objstorage.Provider,
@@ -244,6 +244,13 @@ func (v *VirtualReader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	return v.reader.EstimateDiskUsage(f, l)
 }
 
+// DataBlockBoundaries calls VirtualReader.reader.DataBlockBoundaries after
+// enforcing the virtual sstable bounds.
+func (v *VirtualReader) DataBlockBoundaries(start, end []byte) ([]DataBlockBoundary, error) {
+	_, f, l := v.vState.constrainBounds(start, end, true /* endInclusive */)
+	return v.reader.DataBlockBoundaries(f, l)
+}
+
 // CommonProperties implements the CommonReader interface.
 func (v *VirtualReader) CommonProperties() *CommonProperties {
 	return &v.Properties
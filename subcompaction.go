@@ -0,0 +1,303 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/compact"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// Subcompactions split a single compaction into disjoint key ranges that are
+// processed in parallel, each by its own compaction iterator and output
+// splitter. All versions of a user key fall within the same range, so
+// sequence number zeroing, snapshot striping and tombstone elision behave
+// exactly as they do for the compaction as a whole. Range deletions and range
+// keys straddling a boundary are truncated to the bounds of each range, so
+// the outputs of adjacent ranges never overlap; the outputs of all the ranges
+// are installed by a single version edit.
+
+// numSubcompactionsLocked returns the maximum number of subcompactions the
+// given compaction may be split into. The subcompactions beyond the first are
+// added to d.mu.compact.subcompactingCount, and use the slots of the
+// CompactionScheduler, if any, which are acquired without waiting; the caller
+// must release both once the subcompactions complete.
+//
+// d.mu must be held when calling this method.
+func (d *DB) numSubcompactionsLocked(c *compaction) int {
+	n := d.opts.Experimental.MaxSubcompactions
+	if n <= 1 || c.kind != compactionKindDefault || len(c.flushing) > 0 ||
+		c.outputLevel.level == 0 {
+		return 1
+	}
+	// Subcompactions make use of compaction slots that are otherwise idle. The
+	// count of running compactions includes c itself.
+	idle := d.opts.MaxConcurrentCompactions() - d.runningCompactionsLocked()
+	n = 1 + d.compactionSlots.tryAcquireExtra(max(0, min(n-1, idle)))
+	d.mu.compact.subcompactingCount += n - 1
+	return n
+}
+
+// runningCompactionsLocked returns the number of running compactions and
+// subcompactions, which MaxConcurrentCompactions bounds.
+//
+// d.mu must be held when calling this method.
+func (d *DB) runningCompactionsLocked() int {
+	return d.mu.compact.compactingCount + d.mu.compact.subcompactingCount
+}
+
+// subcompactionSplits returns the user keys at which the compaction's key range
+// should be partitioned to produce at most n subcompactions holding roughly
+// equal amounts of input data, or nil if the compaction should not be split.
+// Every subcompaction holds at least maxOutputFileSize bytes of input, and
+// begins at the smallest key of one of the compaction's input tables.
+func (c *compaction) subcompactionSplits(n int) [][]byte {
+	if n <= 1 {
+		return nil
+	}
+	var files []*fileMetadata
+	var total uint64
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, f)
+			total += f.Size
+		}
+	}
+	if c.maxOutputFileSize > 0 {
+		n = min(n, int(total/c.maxOutputFileSize))
+	}
+	if n <= 1 {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return c.cmp(files[i].Smallest.UserKey, files[j].Smallest.UserKey) < 0
+	})
+
+	var splits [][]byte
+	var size uint64
+	for _, f := range files {
+		if size >= total*uint64(len(splits)+1)/uint64(n) &&
+			c.cmp(f.Smallest.UserKey, c.smallest.UserKey) > 0 &&
+			(len(splits) == 0 || c.cmp(f.Smallest.UserKey, splits[len(splits)-1]) > 0) {
+			splits = append(splits, f.Smallest.UserKey)
+			if len(splits) == n-1 {
+				break
+			}
+		}
+		size += f.Size
+	}
+	return splits
+}
+
+// newSubcompaction returns a compaction processing the keys of c within
+// [lower, upper). A nil lower or upper bound leaves the range unbounded on that
+// side. The subcompaction's inputs are limited to the tables overlapping the
+// range.
+func (c *compaction) newSubcompaction(lower, upper []byte) *compaction {
+	sub := &compaction{
+		parent:            c,
+		kind:              c.kind,
+		cmp:               c.cmp,
+		equal:             c.equal,
+		comparer:          c.comparer,
+		formatKey:         c.formatKey,
+		logger:            c.logger,
+		version:           c.version,
+		beganAt:           c.beganAt,
+		maxOutputFileSize: c.maxOutputFileSize,
+		maxOverlapBytes:   c.maxOverlapBytes,
+		smallest:          c.smallest,
+		largest:           c.largest,
+		grandparents:      c.grandparents,
+		delElision:        c.delElision,
+		rangeKeyElision:   c.rangeKeyElision,
+		inputNewIters:     c.inputNewIters,
+
+		historyRetentionSeqNum: c.historyRetentionSeqNum,
+	}
+	overlaps := func(f *fileMetadata) bool {
+		return (upper == nil || c.cmp(f.Smallest.UserKey, upper) < 0) &&
+			(lower == nil || f.UserKeyBounds().End.IsUpperBoundFor(c.cmp, lower))
+	}
+	sub.inputs = make([]compactionLevel, len(c.inputs))
+	for i, cl := range c.inputs {
+		var files []*fileMetadata
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if overlaps(f) {
+				files = append(files, f)
+			}
+		}
+		sub.inputs[i].level = cl.level
+		if cl.level == 0 {
+			sub.inputs[i].files = manifest.NewLevelSliceSeqSorted(files)
+		} else {
+			sub.inputs[i].files = manifest.NewLevelSliceKeySorted(c.cmp, files)
+		}
+	}
+	sub.startLevel = &sub.inputs[0]
+	sub.outputLevel = &sub.inputs[len(sub.inputs)-1]
+	for i := 1; i < len(sub.inputs)-1; i++ {
+		sub.extraLevels = append(sub.extraLevels, &sub.inputs[i])
+	}
+	if c.startLevel.l0SublevelInfo != nil {
+		sub.startLevel.l0SublevelInfo = generateSublevelInfo(c.cmp, sub.startLevel.files)
+	}
+	return sub
+}
+
+// boundInputIters restricts the compaction's input iterators to the keys
+// within [lower, upper). Range deletions and range keys are truncated to the
+// bounds.
+func (c *compaction) boundInputIters(
+	pointIter internalIterator,
+	rangeDelIter, rangeKeyIter keyspan.FragmentIterator,
+	lower, upper []byte,
+) (internalIterator, keyspan.FragmentIterator, keyspan.FragmentIterator) {
+	pointIter = &subcompactionIter{
+		internalIterator: pointIter,
+		cmp:              c.cmp,
+		lower:            lower,
+		upper:            upper,
+	}
+	// Input spans never extend beyond the compaction's largest key, so it
+	// bounds the spans of the last subcompaction.
+	end := upper
+	if end == nil {
+		end = c.largest.UserKey
+	}
+	bounds := base.UserKeyBoundsEndExclusive(lower, end)
+	if rangeDelIter != nil {
+		rangeDelIter = keyspan.Truncate(c.cmp, rangeDelIter, bounds)
+	}
+	if rangeKeyIter != nil {
+		rangeKeyIter = keyspan.Truncate(c.cmp, rangeKeyIter, bounds)
+	}
+	return pointIter, rangeDelIter, rangeKeyIter
+}
+
+// subcompactionIter restricts the forward iteration performed by a compaction
+// iterator to the point keys within [lower, upper).
+type subcompactionIter struct {
+	internalIterator
+	cmp          Compare
+	lower, upper []byte
+}
+
+// First implements (base.InternalIterator).First.
+func (i *subcompactionIter) First() *base.InternalKV {
+	// Compaction sstable iterators do not support seeking. The subcompaction's
+	// inputs are limited to the tables overlapping its range, so only the
+	// tables straddling the lower bound are stepped through.
+	kv := i.internalIterator.First()
+	for i.lower != nil && kv != nil && i.cmp(kv.K.UserKey, i.lower) < 0 {
+		kv = i.internalIterator.Next()
+	}
+	return i.checkUpper(kv)
+}
+
+// Next implements (base.InternalIterator).Next.
+func (i *subcompactionIter) Next() *base.InternalKV {
+	return i.checkUpper(i.internalIterator.Next())
+}
+
+func (i *subcompactionIter) checkUpper(kv *base.InternalKV) *base.InternalKV {
+	if kv != nil && i.upper != nil && i.cmp(kv.K.UserKey, i.upper) >= 0 {
+		return nil
+	}
+	return kv
+}
+
+// runSubcompactions runs the compaction as len(splits)+1 subcompactions in
+// parallel, partitioning the compaction's key range at the given split keys.
+// The returned versionEdit holds the new tables of all subcompactions, in key
+// order.
+//
+// d.mu must not be held when calling this method.
+func (d *DB) runSubcompactions(
+	jobID JobID,
+	c *compaction,
+	snapshots compact.Snapshots,
+	formatVers FormatMajorVersion,
+	splits [][]byte,
+) (ve *versionEdit, pendingOutputs []compactionOutput, stats compactStats, retErr error) {
+	type result struct {
+		ve             *versionEdit
+		pendingOutputs []compactionOutput
+		stats          compactStats
+		err            error
+	}
+	subs := make([]*compaction, len(splits)+1)
+	c.subcompactions = len(subs)
+	results := make([]result, len(subs))
+	var wg sync.WaitGroup
+	for i := range subs {
+		var lower, upper []byte
+		if i > 0 {
+			lower = splits[i-1]
+		}
+		if i < len(splits) {
+			upper = splits[i]
+		}
+		subs[i] = c.newSubcompaction(lower, upper)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &results[i]
+			r.ve, r.pendingOutputs, r.stats, r.err = d.compactAndWrite(
+				jobID, subs[i], snapshots, formatVers, lower, upper)
+		}(i)
+	}
+	wg.Wait()
+
+	ve = &versionEdit{
+		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
+	}
+	outputMetrics := c.initMetrics()
+	for i, sub := range subs {
+		r := &results[i]
+		c.bytesWritten += sub.bytesWritten
+		if r.err != nil {
+			retErr = firstError(retErr, r.err)
+			continue
+		}
+		pendingOutputs = append(pendingOutputs, r.pendingOutputs...)
+		ve.NewFiles = append(ve.NewFiles, r.ve.NewFiles...)
+		stats.merge(&r.stats)
+
+		// The bytes read by the compaction were already accounted for by
+		// initMetrics; the inputs of adjacent subcompactions may overlap.
+		m := *sub.metrics[sub.outputLevel.level]
+		m.BytesIn, m.BytesRead = 0, 0
+		m.MultiLevel.BytesInTop, m.MultiLevel.BytesIn, m.MultiLevel.BytesRead = 0, 0, 0
+		outputMetrics.Add(&m)
+	}
+	if retErr == nil {
+		for i := 1; i < len(ve.NewFiles); i++ {
+			prev, meta := ve.NewFiles[i-1].Meta, ve.NewFiles[i].Meta
+			if !prev.Largest.IsExclusiveSentinel() && d.cmp(prev.Largest.UserKey, meta.Smallest.UserKey) >= 0 ||
+				d.cmp(prev.Largest.UserKey, meta.Smallest.UserKey) > 0 {
+				retErr = errors.Errorf("pebble: subcompaction outputs overlap: %s in %s and %s in %s",
+					prev.Largest.Pretty(c.formatKey), prev.FileNum,
+					meta.Smallest.Pretty(c.formatKey), meta.FileNum)
+				break
+			}
+		}
+	}
+	if retErr != nil {
+		// The failed subcompactions removed their own outputs.
+		for _, o := range pendingOutputs {
+			_ = d.objProvider.Remove(fileTypeTable, base.PhysicalTableDiskFileNum(o.meta.FileNum))
+		}
+		return nil, nil, stats, retErr
+	}
+	return ve, pendingOutputs, stats, nil
+}
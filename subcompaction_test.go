@@ -0,0 +1,147 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubcompactions(t *testing.T) {
+	// run writes the same data to a DB with the given maximum number of
+	// subcompactions, returning the DB's contents at the latest sequence
+	// number and at a snapshot, and the number of compactions that were split.
+	run := func(maxSubcompactions int) (latest, snapshot string, split int) {
+		opts := &Options{
+			FS:                          vfs.NewMem(),
+			FormatMajorVersion:          FormatNewest,
+			DisableAutomaticCompactions: true,
+			MaxConcurrentCompactions:    func() int { return 4 },
+			EventListener: &EventListener{
+				CompactionEnd: func(info CompactionInfo) {
+					for _, a := range info.Annotations {
+						if strings.HasPrefix(a, "subcompactions=") {
+							split++
+						}
+					}
+				},
+			},
+		}
+		opts.Experimental.MaxSubcompactions = maxSubcompactions
+		opts.EnsureDefaults()
+		for i := range opts.Levels {
+			opts.Levels[i].TargetFileSize = 4 << 10
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		latest, snapshot = runCompactionTestWorkload(t, d)
+		return latest, snapshot, split
+	}
+
+	latest, snapshot, split := run(0)
+	require.Zero(t, split)
+	subLatest, subSnapshot, split := run(4)
+	require.NotZero(t, split)
+	require.Equal(t, latest, subLatest)
+	require.Equal(t, snapshot, subSnapshot)
+}
+
+// runCompactionTestWorkload writes keys to the DB in two rounds and compacts
+// them into L6. It then overwrites some of the keys, and adds range deletions
+// and range keys that straddle many of the L6 tables, before compacting them
+// again. It returns the DB's contents at the latest sequence number and at a
+// snapshot that pins some of the overwritten and deleted keys, so that the
+// results of differently configured compactions can be compared.
+func runCompactionTestWorkload(t *testing.T, d *DB) (latest, snapshot string) {
+	rng := rand.New(rand.NewSource(1))
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	const numKeys = 2000
+	for round := 0; round < 2; round++ {
+		for i := round; i < numKeys; i += 2 {
+			v := make([]byte, 64)
+			rng.Read(v)
+			require.NoError(t, d.Set(key(i), v, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact(key(0), key(numKeys), false))
+
+	for i := 0; i < numKeys; i += 7 {
+		require.NoError(t, d.Set(key(i), []byte("overwritten"), nil))
+	}
+	require.NoError(t, d.Merge(key(3), []byte("merged"), nil))
+	require.NoError(t, d.RangeKeySet(key(100), key(1700), []byte("@5"), []byte("rk"), nil))
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	require.NoError(t, d.DeleteRange(key(300), key(1300), nil))
+	require.NoError(t, d.Set(key(500), []byte("resurrected"), nil))
+	require.NoError(t, d.RangeKeyUnset(key(900), key(1100), []byte("@5"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact(key(0), key(numKeys), false))
+	require.NoError(t, d.CheckLevels(nil))
+	return dumpDBContents(t, d, KeyRange{}), dumpDBContents(t, snap, KeyRange{})
+}
+
+func TestSubcompactionSplits(t *testing.T) {
+	var files []*fileMetadata
+	for i := 0; i < 8; i++ {
+		f := &fileMetadata{FileNum: base.FileNum(i + 1), Size: 100}
+		f.ExtendPointKeyBounds(DefaultComparer.Compare,
+			base.MakeInternalKey([]byte(fmt.Sprintf("%c", 'a'+2*i)), 1, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(fmt.Sprintf("%c", 'b'+2*i)), 1, InternalKeyKindSet))
+		f.InitPhysicalBacking()
+		files = append(files, f)
+	}
+	c := &compaction{
+		cmp:               DefaultComparer.Compare,
+		maxOutputFileSize: 100,
+		inputs: []compactionLevel{
+			{level: 5, files: manifest.NewLevelSliceKeySorted(DefaultComparer.Compare, files[:1])},
+			{level: 6, files: manifest.NewLevelSliceKeySorted(DefaultComparer.Compare, files)},
+		},
+	}
+	c.smallest, c.largest = files[0].Smallest, files[len(files)-1].Largest
+
+	require.Nil(t, c.subcompactionSplits(1))
+	require.Equal(t, "[e i m]", fmt.Sprintf("%s", c.subcompactionSplits(4)))
+	// Each subcompaction holds at least maxOutputFileSize bytes of input.
+	c.maxOutputFileSize = 400
+	require.Equal(t, "[i]", fmt.Sprintf("%s", c.subcompactionSplits(4)))
+}
+
+func TestNumSubcompactions(t *testing.T) {
+	opts := &Options{
+		FS:                       vfs.NewMem(),
+		MaxConcurrentCompactions: func() int { return 4 },
+	}
+	opts.Experimental.MaxSubcompactions = 3
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := &compaction{kind: compactionKindDefault, inputs: []compactionLevel{{level: 5}, {level: 6}}}
+	c.outputLevel = &c.inputs[1]
+
+	// Two compactions are running. The first splits into the idle slots, so
+	// the second cannot split.
+	d.mu.compact.compactingCount += 2
+	require.Equal(t, 3, d.numSubcompactionsLocked(c))
+	require.Equal(t, 2, d.mu.compact.subcompactingCount)
+	require.Equal(t, 4, d.runningCompactionsLocked())
+	require.Equal(t, 1, d.numSubcompactionsLocked(c))
+	d.mu.compact.compactingCount -= 2
+	d.mu.compact.subcompactingCount -= 2
+}
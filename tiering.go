@@ -0,0 +1,253 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+)
+
+// tieringDirection is the direction in which a table is migrated by the
+// tiering policy.
+type tieringDirection int8
+
+const (
+	tierNone tieringDirection = iota
+	// tierDemote migrates a table from local disk to shared storage.
+	tierDemote
+	// tierPromote migrates a table from shared storage to local disk.
+	tierPromote
+)
+
+func (d tieringDirection) String() string {
+	switch d {
+	case tierDemote:
+		return "demote"
+	case tierPromote:
+		return "promote"
+	default:
+		return "none"
+	}
+}
+
+// tieringMigration is a migration chosen by a pass of the tiering policy.
+type tieringMigration struct {
+	level int
+	file  *fileMetadata
+	dir   tieringDirection
+}
+
+// runTieringLoop runs a pass of the tiering policy every Tiering.Interval,
+// until the DB is closed.
+func (d *DB) runTieringLoop() {
+	ticker := time.NewTicker(d.opts.Experimental.Tiering.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		if d.closed.Load() == nil {
+			d.tieringPassLocked()
+			d.maybeScheduleCompaction()
+		}
+		d.mu.Unlock()
+	}
+}
+
+// tieringPassLocked decays the read heat of every table in the current
+// version and replaces the pending migrations with the tables that should
+// currently be demoted or promoted.
+//
+// d.mu must be held when calling this.
+func (d *DB) tieringPassLocked() {
+	d.mu.versions.logLock()
+	defer d.mu.versions.logUnlock()
+
+	now := d.timeNow()
+	vers := d.mu.versions.currentVersion()
+	d.mu.tiering.pending = d.mu.tiering.pending[:0]
+	for level := range vers.Levels {
+		iter := vers.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			heat := f.ReadHeat.Load()
+			// Halve the heat, rounding down so that it decays to zero.
+			f.ReadHeat.Add(-(heat + 1) / 2)
+			if dir := d.tieringDirectionLocked(f, heat, now); dir != tierNone {
+				d.mu.tiering.pending = append(d.mu.tiering.pending, tieringMigration{
+					level: level,
+					file:  f,
+					dir:   dir,
+				})
+			}
+		}
+	}
+}
+
+// tieringDirectionLocked returns the direction in which the given table of
+// the current version should be migrated, given its read heat.
+//
+// d.mu and the manifest lock must be held when calling this.
+func (d *DB) tieringDirectionLocked(f *fileMetadata, heat int64, now time.Time) tieringDirection {
+	t := &d.opts.Experimental.Tiering
+	// Tables ingested from other DBs are read with a synthetic sequence number
+	// which a copy would not preserve.
+	if f.IsCompacting() || f.SyntheticSeqNum() != 0 {
+		return tierNone
+	}
+	if f.Virtual {
+		if useCount, _ := d.mu.versions.virtualBackings.Usage(f.FileBacking.DiskFileNum); useCount > 1 {
+			return tierNone
+		}
+	}
+	objMeta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+	if err != nil || objMeta.IsExternal() {
+		return tierNone
+	}
+	if !objMeta.IsRemote() {
+		age := now.Sub(time.Unix(int64(f.CreationTime), 0))
+		if d.opts.Experimental.CreateOnShared != remote.CreateOnSharedNone &&
+			age >= t.DemoteMinAge && heat <= t.DemoteMaxHeat {
+			return tierDemote
+		}
+		return tierNone
+	}
+	if t.PromoteMinHeat > 0 && heat >= t.PromoteMinHeat {
+		return tierPromote
+	}
+	return tierNone
+}
+
+// tryScheduleTieringMigrations starts copy compactions for the pending
+// migrations, up to Tiering.MaxConcurrentMigrations and
+// MaxConcurrentCompactions.
+//
+// d.mu and the manifest lock must be held when calling this.
+func (d *DB) tryScheduleTieringMigrations(env compactionEnv, maxCompactions int) {
+	maxMigrations := d.opts.Experimental.Tiering.MaxConcurrentMigrations
+	vers := d.mu.versions.currentVersion()
+	for len(d.mu.tiering.pending) > 0 && d.mu.tiering.migrating < maxMigrations &&
+		d.runningCompactionsLocked() < maxCompactions {
+		m := d.mu.tiering.pending[0]
+		d.mu.tiering.pending = d.mu.tiering.pending[1:]
+		// The table may have been compacted away or moved since the pass that
+		// chose it.
+		if !vers.Contains(m.level, m.file) || m.file.IsCompacting() {
+			continue
+		}
+		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
+		pc := pickDownloadCompaction(
+			vers, d.opts, env, d.mu.versions.picker.getBaseLevel(), compactionKindCopy, m.level, m.file,
+		)
+		if pc == nil {
+			continue
+		}
+		c := newCompaction(pc, d.opts, d.timeNow(), d.objProvider)
+		c.tiering = m.dir
+		d.mu.compact.compactingCount++
+		d.mu.tiering.migrating++
+		d.addInProgressCompaction(c)
+		go d.compact(c, nil)
+	}
+}
+
+// runTieringCompaction copies the table of a copy compaction started by the
+// tiering policy to shared storage or to local disk, and updates ve to replace
+// the table with an identical one backed by the copy. A virtual table is given
+// a new backing.
+//
+// d.mu must be held when calling this method. The mutex will be released when
+// doing IO.
+func (d *DB) runTieringCompaction(
+	jobID JobID,
+	c *compaction,
+	inputMeta *fileMetadata,
+	objMeta objstorage.ObjectMetadata,
+	ve *versionEdit,
+) (pendingOutputs []compactionOutput, retErr error) {
+	ctx := context.TODO()
+
+	if (c.tiering == tierDemote) == objMeta.IsRemote() || objMeta.IsExternal() {
+		return nil, errors.AssertionFailedf("pebble: cannot %s table %s", c.tiering, inputMeta.FileNum)
+	}
+	newMeta := copyFileMetadata(c.cmp, inputMeta)
+	newMeta.FileNum = d.mu.versions.getNextFileNum()
+	if newMeta.Virtual {
+		newMeta.InitProviderBacking(base.DiskFileNum(newMeta.FileNum), inputMeta.FileBacking.Size)
+	} else {
+		newMeta.InitPhysicalBacking()
+	}
+	newMeta.ReadHeat.Store(inputMeta.ReadHeat.Load())
+
+	c.metrics = map[int]*LevelMetrics{
+		c.outputLevel.level: {
+			BytesIn:         inputMeta.FileBacking.Size,
+			BytesCompacted:  inputMeta.FileBacking.Size,
+			TablesCompacted: 1,
+		},
+	}
+
+	// Before dropping the db mutex, grab a ref to the current version. This
+	// prevents any concurrent excises from deleting the backing we copy.
+	vers := d.mu.versions.currentVersion()
+	vers.Ref()
+	defer vers.UnrefLocked()
+
+	d.mu.Unlock()
+	defer d.mu.Lock()
+
+	if c.tiering == tierDemote {
+		pendingOutputs = append(pendingOutputs, compactionOutput{meta: newMeta})
+		if _, err := d.objProvider.LinkOrCopyFromLocal(ctx, d.opts.FS,
+			d.objProvider.Path(objMeta), fileTypeTable, newMeta.FileBacking.DiskFileNum,
+			objstorage.CreateOptions{
+				PreferSharedStorage: true,
+				BeforeCopy: func() error {
+					return d.diskIO.waitRead(c.diskIOClass(), int64(inputMeta.FileBacking.Size), c.cancelled)
+				},
+			}); err != nil {
+			return pendingOutputs, err
+		}
+	} else {
+		src, err := d.objProvider.OpenForReading(
+			ctx, fileTypeTable, inputMeta.FileBacking.DiskFileNum, objstorage.OpenOptions{},
+		)
+		if err != nil {
+			return pendingOutputs, err
+		}
+		defer src.Close()
+		w, _, err := d.objProvider.Create(
+			ctx, fileTypeTable, newMeta.FileBacking.DiskFileNum, objstorage.CreateOptions{},
+		)
+		if err != nil {
+			return pendingOutputs, err
+		}
+		w = d.diskIO.writable(w, c.diskIOClass(), c.cancelled)
+		pendingOutputs = append(pendingOutputs, compactionOutput{meta: newMeta, isLocal: true})
+		if err := objstorage.Copy(ctx, src, w, 0, uint64(src.Size())); err != nil {
+			w.Abort()
+			return pendingOutputs, err
+		}
+		if err := w.Finish(); err != nil {
+			return pendingOutputs, err
+		}
+	}
+	ve.NewFiles[0].Meta = newMeta
+	if newMeta.Virtual {
+		ve.CreatedBackingTables = []*fileBacking{newMeta.FileBacking}
+	}
+
+	if err := d.objProvider.Sync(); err != nil {
+		return pendingOutputs, err
+	}
+	return pendingOutputs, nil
+}
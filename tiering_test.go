@@ -0,0 +1,140 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiering(t *testing.T) {
+	var reasons []string
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				reasons = append(reasons, info.Reason)
+			},
+		},
+	}
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": remote.NewInMem(),
+	})
+	opts.Experimental.CreateOnShared = remote.CreateOnSharedLower
+	// Only the reads of the test are sampled.
+	opts.Experimental.ReadSamplingMultiplier = -1
+	// The passes are run by the test rather than by the background loop.
+	opts.Experimental.Tiering = TieringPolicy{
+		Interval:       time.Hour,
+		DemoteMaxHeat:  0,
+		PromoteMinHeat: 10,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	// Write two tables to L0; "a" keys in the first and "b" keys in the
+	// second.
+	for _, prefix := range []string{"a", "b"} {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%03d", prefix, i)), []byte(strings.Repeat("v", 32)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	span := func(prefix string) KeyRange {
+		return KeyRange{Start: []byte(prefix), End: []byte(prefix + "\xff")}
+	}
+	// read returns the keys with the given prefix, without sampling them.
+	read := func(prefix string) string {
+		return dumpDBContents(t, d, span(prefix))
+	}
+	// sample reads the keys with the given prefix, sampling every read.
+	sample := func(prefix string) {
+		iter, err := d.NewIter(&IterOptions{
+			LowerBound: span(prefix).Start,
+			UpperBound: span(prefix).End,
+		})
+		require.NoError(t, err)
+		iter.readSampling.forceReadSampling = true
+		for valid := iter.First(); valid; valid = iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+	// pass runs a pass of the tiering policy and waits for its migrations.
+	pass := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.tieringPassLocked()
+		d.maybeScheduleCompaction()
+		for d.mu.tiering.migrating > 0 || len(d.mu.tiering.pending) > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	// tiers returns the tier of the tables in L0.
+	tiers := func() []string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var res []string
+		iter := d.mu.versions.currentVersion().Levels[0].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+			require.NoError(t, err)
+			if objMeta.IsShared() {
+				res = append(res, fmt.Sprintf("%s:shared", f.Smallest.UserKey[:1]))
+			} else {
+				res = append(res, fmt.Sprintf("%s:local", f.Smallest.UserKey[:1]))
+			}
+		}
+		return res
+	}
+
+	a, b := read("a"), read("b")
+	require.Equal(t, []string{"a:local", "b:local"}, tiers())
+	require.Zero(t, d.Metrics().Table.Remote.LiveSize)
+
+	// Only the "b" table is read, so the cold "a" table is demoted.
+	sample("b")
+	pass()
+	require.Equal(t, []string{"a:shared", "b:local"}, tiers())
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Tiering.TablesDemoted)
+	require.NotZero(t, m.Tiering.BytesDemoted)
+	require.Equal(t, m.Tiering.BytesDemoted, m.Table.Remote.LiveSize)
+	require.Equal(t, a, read("a"))
+	require.Equal(t, []string{"demote,copy"}, reasons)
+
+	// Reading the "a" table heats it up enough to be promoted back, while
+	// the decaying heat of the "b" table keeps it local.
+	sample("a")
+	pass()
+	require.Equal(t, []string{"a:local", "b:local"}, tiers())
+	m = d.Metrics()
+	require.Equal(t, int64(1), m.Tiering.TablesDemoted)
+	require.Equal(t, int64(1), m.Tiering.TablesPromoted)
+	require.Equal(t, m.Tiering.BytesDemoted, m.Tiering.BytesPromoted)
+	require.Zero(t, m.Table.Remote.LiveSize)
+	require.Equal(t, []string{"demote,copy", "promote,copy"}, reasons)
+	require.Equal(t, a, read("a"))
+	require.Equal(t, b, read("b"))
+	require.NoError(t, d.CheckLevels(nil))
+
+	// Without reads, the heat of both tables decays until they are demoted.
+	for i := 0; i < 10; i++ {
+		pass()
+	}
+	require.Equal(t, []string{"a:shared", "b:shared"}, tiers())
+	require.Equal(t, a, read("a"))
+	require.Equal(t, b, read("b"))
+}
@@ -53,19 +53,21 @@ type dbT struct {
 	exciseSpanFn    DBExciseSpanFn
 
 	// Flags.
-	comparerName  string
-	mergerName    string
-	fmtKey        keyFormatter
-	fmtValue      valueFormatter
-	start         key
-	end           key
-	count         int64
-	allLevels     bool
-	ioCount       int
-	ioParallelism int
-	ioSizes       string
-	verbose       bool
-	bypassPrompt  bool
+	comparerName    string
+	mergerName      string
+	fmtKey          keyFormatter
+	fmtValue        valueFormatter
+	start           key
+	end             key
+	count           int64
+	allLevels       bool
+	ioCount         int
+	ioParallelism   int
+	ioSizes         string
+	verbose         bool
+	bypassPrompt    bool
+	incrementalFrom string
+	verify          bool
 }
 
 func newDB(
@@ -108,6 +110,11 @@ database not be in use by another process.
 Creates a Pebble checkpoint in the specified destination directory. A checkpoint
 is a point-in-time snapshot of DB state. Requires that the specified
 database not be in use by another process.
+
+With --incremental-from, the sstables that are part of the given base checkpoint
+are referenced rather than linked or copied. With --verify, the checkpoint is
+verified to reference only sstables that are present in it or in its base
+checkpoints.
 `,
 		Args: cobra.ExactArgs(2),
 		Run:  d.runCheckpoint,
@@ -231,6 +238,11 @@ specified database.
 	d.Excise.Flags().BoolVar(
 		&d.bypassPrompt, "yes", false, "bypass prompt")
 
+	d.Checkpoint.Flags().StringVar(
+		&d.incrementalFrom, "incremental-from", "", "base checkpoint of an incremental checkpoint")
+	d.Checkpoint.Flags().BoolVar(
+		&d.verify, "verify", false, "verify the checkpoint once it is created")
+
 	d.IOBench.Flags().BoolVar(
 		&d.allLevels, "all-levels", false, "if set, benchmark all levels (default is only L5/L6)")
 	d.IOBench.Flags().IntVar(
@@ -401,7 +413,14 @@ func (d *dbT) runCheckpoint(cmd *cobra.Command, args []string) {
 	defer d.closeDB(stderr, db)
 	destDir := args[1]
 
-	if err := db.Checkpoint(destDir); err != nil {
+	var opts []pebble.CheckpointOption
+	if d.incrementalFrom != "" {
+		opts = append(opts, pebble.WithIncrementalFrom(d.incrementalFrom))
+	}
+	if d.verify {
+		opts = append(opts, pebble.WithVerification())
+	}
+	if err := db.Checkpoint(destDir, opts...); err != nil {
 		fmt.Fprintf(stderr, "%s\n", err)
 	}
 }
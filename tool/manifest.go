@@ -153,6 +153,7 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 			bve.AddedByFileNum = make(map[base.FileNum]*manifest.FileMetadata)
 			var comparer *base.Comparer
 			var editIdx int
+			snapshots := make(map[string]manifest.NamedSnapshot)
 			rr := record.NewReader(f, 0 /* logNum */)
 			for {
 				offset := rr.Offset()
@@ -172,6 +173,12 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 					fmt.Fprintf(stdout, "%s\n", err)
 					break
 				}
+				for _, name := range ve.DeletedSnapshots {
+					delete(snapshots, name)
+				}
+				for _, s := range ve.CreatedSnapshots {
+					snapshots[s.Name] = s
+				}
 
 				if comparer != nil && !anyOverlap(comparer.Compare, &ve, m.filterStart, m.filterEnd) {
 					continue
@@ -231,6 +238,15 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 					}
 					fmt.Fprintf(stdout, "\n")
 				}
+				for _, s := range ve.CreatedSnapshots {
+					empty = false
+					fmt.Fprintf(stdout, "  snapshot:      ")
+					formatNamedSnapshot(stdout, s)
+				}
+				for _, name := range ve.DeletedSnapshots {
+					empty = false
+					fmt.Fprintf(stdout, "  del-snapshot:  %s\n", name)
+				}
 				if empty {
 					// NB: An empty version edit can happen if we log a version edit with
 					// a zero field. RocksDB does this with a version edit that contains
@@ -251,10 +267,30 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 				}
 				m.printLevels(comparer.Compare, stdout, v)
 			}
+			if len(snapshots) > 0 {
+				fmt.Fprintf(stdout, "--- named snapshots ---\n")
+				names := make([]string, 0, len(snapshots))
+				for name := range snapshots {
+					names = append(names, name)
+				}
+				slices.Sort(names)
+				for _, name := range names {
+					fmt.Fprintf(stdout, "  ")
+					formatNamedSnapshot(stdout, snapshots[name])
+				}
+			}
 		}()
 	}
 }
 
+func formatNamedSnapshot(w io.Writer, s manifest.NamedSnapshot) {
+	fmt.Fprintf(w, "%s #%d", s.Name, s.SeqNum)
+	if s.CreationTime != 0 {
+		fmt.Fprintf(w, " (%s)", time.Unix(s.CreationTime, 0).UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "\n")
+}
+
 func anyOverlap(cmp base.Compare, ve *manifest.VersionEdit, start, end key) bool {
 	if start == nil && end == nil {
 		return true
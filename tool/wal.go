@@ -9,6 +9,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/internal/base"
@@ -22,8 +25,9 @@ import (
 // walT implements WAL-level tools, including both configuration state and the
 // commands themselves.
 type walT struct {
-	Root *cobra.Command
-	Dump *cobra.Command
+	Root    *cobra.Command
+	Dump    *cobra.Command
+	HotKeys *cobra.Command
 
 	opts     *pebble.Options
 	fmtKey   keyFormatter
@@ -32,6 +36,7 @@ type walT struct {
 	defaultComparer string
 	comparers       sstable.Comparers
 	verbose         bool
+	count           int
 }
 
 func newWAL(opts *pebble.Options, comparers sstable.Comparers, defaultComparer string) *walT {
@@ -57,13 +62,30 @@ Print the contents of the WAL files.
 		Run:  w.runDump,
 	}
 
-	w.Root.AddCommand(w.Dump)
+	w.HotKeys = &cobra.Command{
+		Use:   "hot-keys <wal-files>",
+		Short: "print the most frequently written keys",
+		Long: `
+Print the keys, and the key prefixes, most frequently written by the batches
+in the WAL files, along with the number of writes. This identifies the keys
+that were hot in recent writes, as the WAL files hold the writes that are not
+yet flushed.
+`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  w.runHotKeys,
+	}
+
+	w.Root.AddCommand(w.Dump, w.HotKeys)
 	w.Root.PersistentFlags().BoolVarP(&w.verbose, "verbose", "v", false, "verbose output")
 
 	w.Dump.Flags().Var(
 		&w.fmtKey, "key", "key formatter")
 	w.Dump.Flags().Var(
 		&w.fmtValue, "value", "value formatter")
+	w.HotKeys.Flags().Var(
+		&w.fmtKey, "key", "key formatter")
+	w.HotKeys.Flags().IntVar(
+		&w.count, "count", 10, "number of keys and of key prefixes to print")
 	return w
 }
 
@@ -170,3 +192,99 @@ func (w *walT) runDump(cmd *cobra.Command, args []string) {
 		}()
 	}
 }
+
+func (w *walT) runHotKeys(cmd *cobra.Command, args []string) {
+	stdout, stderr := cmd.OutOrStdout(), cmd.OutOrStderr()
+	w.fmtKey.setForComparer(w.defaultComparer, w.comparers)
+	split := base.DefaultComparer.Split
+	if c := w.comparers[w.defaultComparer]; c != nil {
+		split = c.Split
+	}
+
+	keys := make(map[string]int)
+	prefixes := make(map[string]int)
+	var writes, batches int
+	for _, arg := range args {
+		func() {
+			fileNum, _, ok := wal.ParseLogFilename(arg)
+			if !ok {
+				fileNum = 0
+			}
+			f, err := w.opts.FS.Open(arg)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s\n", err)
+				return
+			}
+			defer f.Close()
+
+			var buf bytes.Buffer
+			rr := record.NewReader(f, base.DiskFileNum(fileNum))
+			for {
+				r, err := rr.Next()
+				if err == nil {
+					buf.Reset()
+					_, err = io.Copy(&buf, r)
+				}
+				if err != nil {
+					// Zeroed and invalid chunks are expected at the end of a
+					// preallocated or recycled WAL, and are treated like EOF.
+					if err != io.EOF && err != record.ErrZeroedChunk && err != record.ErrInvalidChunk {
+						fmt.Fprintf(stderr, "%s: %s\n", arg, err)
+					}
+					return
+				}
+				var b pebble.Batch
+				if err := b.SetRepr(buf.Bytes()); err != nil {
+					fmt.Fprintf(stderr, "corrupt batch within log file %q: %v\n", arg, err)
+					return
+				}
+				batches++
+				for r := b.Reader(); ; {
+					kind, ukey, _, ok, err := r.Next()
+					if !ok {
+						if err != nil {
+							fmt.Fprintf(stderr, "corrupt batch within log file %q: %v\n", arg, err)
+						}
+						break
+					}
+					if kind == base.InternalKeyKindLogData || kind == base.InternalKeyKindIngestSST {
+						continue
+					}
+					writes++
+					keys[string(ukey)]++
+					prefixes[string(ukey[:split(ukey)])]++
+				}
+			}
+		}()
+	}
+
+	fmt.Fprintf(stdout, "%d writes in %d batches\n", writes, batches)
+	for _, c := range []struct {
+		name   string
+		counts map[string]int
+	}{
+		{name: "keys", counts: keys},
+		{name: "prefixes", counts: prefixes},
+	} {
+		sorted := make([]string, 0, len(c.counts))
+		for k := range c.counts {
+			sorted = append(sorted, k)
+		}
+		slices.SortFunc(sorted, func(a, b string) int {
+			if c.counts[a] != c.counts[b] {
+				return c.counts[b] - c.counts[a]
+			}
+			return strings.Compare(a, b)
+		})
+		if len(sorted) > w.count {
+			sorted = sorted[:w.count]
+		}
+		fmt.Fprintf(stdout, "hot %s:\n", c.name)
+		tw := tabwriter.NewWriter(stdout, 2, 1, 2, ' ', 0)
+		for _, k := range sorted {
+			fmt.Fprintf(tw, "  %s\t%d\t%.1f%%\n", w.fmtKey.fn([]byte(k)), c.counts[k],
+				100*float64(c.counts[k])/float64(writes))
+		}
+		tw.Flush()
+	}
+}
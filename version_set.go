@@ -5,8 +5,11 @@
 package pebble
 
 import (
+	"cmp"
 	"fmt"
 	"io"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -107,6 +110,11 @@ type versionSet struct {
 	// the next version.
 	virtualBackings manifest.VirtualBackings
 
+	// namedSnapshots contains the named snapshots recorded in the manifest,
+	// indexed by name. It is modified under DB.mu and the log lock, like
+	// virtualBackings.
+	namedSnapshots map[string]manifest.NamedSnapshot
+
 	// minUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	minUnflushedLogNum base.DiskFileNum
@@ -174,11 +182,11 @@ func (vs *versionSet) create(
 	vs.append(newVersion)
 	var err error
 
-	vs.picker = newCompactionPickerByScore(newVersion, &vs.virtualBackings, vs.opts, nil)
+	vs.picker = newCompactionPicker(newVersion, &vs.virtualBackings, vs.opts, nil)
 	// Note that a "snapshot" version edit is written to the manifest when it is
 	// created.
 	vs.manifestFileNum = vs.getNextDiskFileNum()
-	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum, nil /* virtualBackings */)
+	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum, nil /* virtualBackings */, nil /* namedSnapshots */)
 	if err == nil {
 		if err = vs.manifest.Flush(); err != nil {
 			vs.opts.Logger.Fatalf("MANIFEST flush failed: %v", err)
@@ -263,6 +271,7 @@ func (vs *versionSet) load(
 		if err := bve.Accumulate(&ve); err != nil {
 			return err
 		}
+		vs.applyNamedSnapshots(&ve)
 		if ve.MinUnflushedLogNum != 0 {
 			vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 		}
@@ -344,21 +353,23 @@ func (vs *versionSet) load(
 		files := newVersion.Levels[i].Slice()
 		l.Size = int64(files.SizeSum())
 	}
+	var liveSize liveSizeDelta
 	for _, l := range newVersion.Levels {
 		iter := l.Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
 			if !f.Virtual {
-				_, localSize := sizeIfLocal(f.FileBacking, vs.provider)
-				vs.metrics.Table.Local.LiveSize = uint64(int64(vs.metrics.Table.Local.LiveSize) + localSize)
+				_, size := sizeByLocation(f.FileBacking, vs.provider)
+				liveSize.add(size)
 			}
 		}
 	}
 	vs.virtualBackings.ForEach(func(backing *fileBacking) {
-		_, localSize := sizeIfLocal(backing, vs.provider)
-		vs.metrics.Table.Local.LiveSize = uint64(int64(vs.metrics.Table.Local.LiveSize) + localSize)
+		_, size := sizeByLocation(backing, vs.provider)
+		liveSize.add(size)
 	})
+	vs.updateLiveSizeMetricsLocked(liveSize)
 
-	vs.picker = newCompactionPickerByScore(newVersion, &vs.virtualBackings, vs.opts, nil)
+	vs.picker = newCompactionPicker(newVersion, &vs.virtualBackings, vs.opts, nil)
 	return nil
 }
 
@@ -526,6 +537,7 @@ func (vs *versionSet) logAndApply(
 	var newManifestFileNum base.DiskFileNum
 	var prevManifestFileSize uint64
 	var newManifestVirtualBackings []*fileBacking
+	var newManifestNamedSnapshots []manifest.NamedSnapshot
 	if requireRotation {
 		newManifestFileNum = vs.getNextDiskFileNum()
 		prevManifestFileSize = uint64(vs.manifest.Size())
@@ -534,7 +546,9 @@ func (vs *versionSet) logAndApply(
 		// the new manifest will contain the pre-apply version plus the last version
 		// edit.
 		newManifestVirtualBackings = vs.virtualBackings.Backings()
+		newManifestNamedSnapshots = vs.namedSnapshotsSorted()
 	}
+	vs.applyNamedSnapshots(ve)
 
 	// Grab certain values before releasing vs.mu, in case createManifest() needs
 	// to be called.
@@ -542,7 +556,7 @@ func (vs *versionSet) logAndApply(
 	nextFileNum := vs.nextFileNum
 
 	// Note: this call populates ve.RemovedBackingTables.
-	zombieBackings, removedVirtualBackings, localLiveSizeDelta :=
+	zombieBackings, removedVirtualBackings, liveSizeDelta :=
 		getZombiesAndUpdateVirtualBackings(ve, &vs.virtualBackings, vs.provider)
 
 	if err := func() error {
@@ -565,7 +579,7 @@ func (vs *versionSet) logAndApply(
 		}
 
 		if newManifestFileNum != 0 {
-			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum, newManifestVirtualBackings); err != nil {
+			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum, newManifestVirtualBackings, newManifestNamedSnapshots); err != nil {
 				vs.opts.EventListener.ManifestCreated(ManifestCreateInfo{
 					JobID:   int(jobID),
 					Path:    base.MakeFilepath(vs.fs, vs.dirname, fileTypeManifest, newManifestFileNum),
@@ -672,6 +686,21 @@ func (vs *versionSet) logAndApply(
 	for level, update := range metrics {
 		vs.metrics.Levels[level].Add(update)
 	}
+	vs.updateLevelMetricsLocked(newVersion)
+	vs.updateLiveSizeMetricsLocked(liveSizeDelta)
+
+	vs.picker = newCompactionPicker(newVersion, &vs.virtualBackings, vs.opts, inProgress)
+	if !vs.dynamicBaseLevel {
+		vs.picker.forceBaseLevel1()
+	}
+	return nil
+}
+
+// updateLevelMetricsLocked updates the per-level file counts and sizes to
+// those of the given version, which was just installed.
+//
+// DB.mu must be held when calling this method.
+func (vs *versionSet) updateLevelMetricsLocked(newVersion *version) {
 	for i := range vs.metrics.Levels {
 		l := &vs.metrics.Levels[i]
 		l.NumFiles = int64(newVersion.Levels[i].Len())
@@ -703,13 +732,6 @@ func (vs *versionSet) logAndApply(
 		}
 	}
 	vs.metrics.Levels[0].Sublevels = int32(len(newVersion.L0SublevelFiles))
-	vs.metrics.Table.Local.LiveSize = uint64(int64(vs.metrics.Table.Local.LiveSize) + localLiveSizeDelta)
-
-	vs.picker = newCompactionPickerByScore(newVersion, &vs.virtualBackings, vs.opts, inProgress)
-	if !vs.dynamicBaseLevel {
-		vs.picker.forceBaseLevel1()
-	}
-	return nil
 }
 
 type fileBackingInfo struct {
@@ -725,10 +747,10 @@ type fileBackingInfo struct {
 //   - removedVirtualBackings: the virtual backings that will be removed by the
 //     VersionEdit and which must be Unref()ed by the caller. These backings
 //     match ve.RemovedBackingTables.
-//   - localLiveSizeDelta: the delta in local live bytes.
+//   - liveSizeDelta: the delta in local and remote live bytes.
 func getZombiesAndUpdateVirtualBackings(
 	ve *versionEdit, virtualBackings *manifest.VirtualBackings, provider objstorage.Provider,
-) (zombieBackings, removedVirtualBackings []fileBackingInfo, localLiveSizeDelta int64) {
+) (zombieBackings, removedVirtualBackings []fileBackingInfo, liveSizeDelta liveSizeDelta) {
 	// First, deal with the physical tables.
 	//
 	// A physical backing has become unused if it is in DeletedFiles but not in
@@ -740,8 +762,8 @@ func getZombiesAndUpdateVirtualBackings(
 	for _, nf := range ve.NewFiles {
 		if !nf.Meta.Virtual {
 			stillUsed[nf.Meta.FileBacking.DiskFileNum] = struct{}{}
-			_, localFileDelta := sizeIfLocal(nf.Meta.FileBacking, provider)
-			localLiveSizeDelta += localFileDelta
+			_, fileDelta := sizeByLocation(nf.Meta.FileBacking, provider)
+			liveSizeDelta.add(fileDelta)
 		}
 	}
 	for _, b := range ve.CreatedBackingTables {
@@ -752,10 +774,10 @@ func getZombiesAndUpdateVirtualBackings(
 			// NB: this deleted file may also be in NewFiles or
 			// CreatedBackingTables, due to a file moving between levels, or
 			// becoming virtualized. In which case there is no change due to this
-			// file in the localLiveSizeDelta -- the subtraction below compensates
-			// for the addition.
-			isLocal, localFileDelta := sizeIfLocal(m.FileBacking, provider)
-			localLiveSizeDelta -= localFileDelta
+			// file in the liveSizeDelta -- the subtraction below compensates for
+			// the addition.
+			isLocal, fileDelta := sizeByLocation(m.FileBacking, provider)
+			liveSizeDelta.sub(fileDelta)
 			if _, ok := stillUsed[m.FileBacking.DiskFileNum]; !ok {
 				zombieBackings = append(zombieBackings, fileBackingInfo{
 					backing: m.FileBacking,
@@ -771,8 +793,8 @@ func getZombiesAndUpdateVirtualBackings(
 	// which works out.
 	for _, b := range ve.CreatedBackingTables {
 		virtualBackings.AddAndRef(b)
-		_, localFileDelta := sizeIfLocal(b, provider)
-		localLiveSizeDelta += localFileDelta
+		_, fileDelta := sizeByLocation(b, provider)
+		liveSizeDelta.add(fileDelta)
 	}
 	for _, nf := range ve.NewFiles {
 		if nf.Meta.Virtual {
@@ -790,8 +812,8 @@ func getZombiesAndUpdateVirtualBackings(
 		// to RemovedBackingTables (before the version edit is written to disk).
 		ve.RemovedBackingTables = make([]base.DiskFileNum, len(unused))
 		for i, b := range unused {
-			isLocal, localFileDelta := sizeIfLocal(b, provider)
-			localLiveSizeDelta -= localFileDelta
+			isLocal, fileDelta := sizeByLocation(b, provider)
+			liveSizeDelta.sub(fileDelta)
 			ve.RemovedBackingTables[i] = b.DiskFileNum
 			zombieBackings = append(zombieBackings, fileBackingInfo{
 				backing: b,
@@ -801,18 +823,50 @@ func getZombiesAndUpdateVirtualBackings(
 		}
 		removedVirtualBackings = zombieBackings[len(zombieBackings)-len(unused):]
 	}
-	return zombieBackings, removedVirtualBackings, localLiveSizeDelta
+	return zombieBackings, removedVirtualBackings, liveSizeDelta
+}
+
+// liveSizeDelta is a change in the number of bytes in live tables, split by
+// whether the tables are stored locally or on shared or external storage.
+type liveSizeDelta struct {
+	local  int64
+	remote int64
+}
+
+func (d *liveSizeDelta) add(o liveSizeDelta) {
+	d.local += o.local
+	d.remote += o.remote
 }
 
-// sizeIfLocal returns backing.Size if the backing is a local file, else 0.
-func sizeIfLocal(
+func (d *liveSizeDelta) sub(o liveSizeDelta) {
+	d.local -= o.local
+	d.remote -= o.remote
+}
+
+// sizeByLocation returns whether the backing is a local file, along with the
+// backing's size attributed to local or remote storage. A backing unknown to
+// the provider is attributed to neither.
+func sizeByLocation(
 	backing *fileBacking, provider objstorage.Provider,
-) (isLocal bool, localSize int64) {
-	isLocal = objstorage.IsLocalTable(provider, backing.DiskFileNum)
-	if isLocal {
-		return true, int64(backing.Size)
+) (isLocal bool, size liveSizeDelta) {
+	meta, err := provider.Lookup(base.FileTypeTable, backing.DiskFileNum)
+	switch {
+	case err != nil:
+		return false, liveSizeDelta{}
+	case meta.IsRemote():
+		return false, liveSizeDelta{remote: int64(backing.Size)}
+	default:
+		return true, liveSizeDelta{local: int64(backing.Size)}
 	}
-	return false, 0
+}
+
+// updateLiveSizeMetricsLocked applies the delta to the live table size
+// metrics.
+//
+// DB.mu must be held when calling this method.
+func (vs *versionSet) updateLiveSizeMetricsLocked(delta liveSizeDelta) {
+	vs.metrics.Table.Local.LiveSize = uint64(int64(vs.metrics.Table.Local.LiveSize) + delta.local)
+	vs.metrics.Table.Remote.LiveSize = uint64(int64(vs.metrics.Table.Remote.LiveSize) + delta.remote)
 }
 
 func (vs *versionSet) incrementCompactions(
@@ -861,6 +915,7 @@ func (vs *versionSet) createManifest(
 	fileNum, minUnflushedLogNum base.DiskFileNum,
 	nextFileNum uint64,
 	virtualBackings []*fileBacking,
+	namedSnapshots []manifest.NamedSnapshot,
 ) (err error) {
 	var (
 		filename     = base.MakeFilepath(vs.fs, dirname, fileTypeManifest, fileNum)
@@ -899,6 +954,7 @@ func (vs *versionSet) createManifest(
 	}
 
 	snapshot.CreatedBackingTables = virtualBackings
+	snapshot.CreatedSnapshots = namedSnapshots
 
 	// When creating a version snapshot for an existing DB, this snapshot VersionEdit will be
 	// immediately followed by another VersionEdit (being written in logAndApply()). That
@@ -933,6 +989,39 @@ func (vs *versionSet) createManifest(
 	return nil
 }
 
+// applyNamedSnapshots applies the named snapshots created and deleted by ve to
+// vs.namedSnapshots.
+func (vs *versionSet) applyNamedSnapshots(ve *versionEdit) {
+	for _, name := range ve.DeletedSnapshots {
+		delete(vs.namedSnapshots, name)
+	}
+	for _, s := range ve.CreatedSnapshots {
+		if vs.namedSnapshots == nil {
+			vs.namedSnapshots = make(map[string]manifest.NamedSnapshot)
+		}
+		vs.namedSnapshots[s.Name] = s
+	}
+}
+
+// namedSnapshotsSorted returns the named snapshots, in ascending sequence
+// number order.
+func (vs *versionSet) namedSnapshotsSorted() []manifest.NamedSnapshot {
+	if len(vs.namedSnapshots) == 0 {
+		return nil
+	}
+	snapshots := make([]manifest.NamedSnapshot, 0, len(vs.namedSnapshots))
+	for _, s := range vs.namedSnapshots {
+		snapshots = append(snapshots, s)
+	}
+	slices.SortFunc(snapshots, func(a, b manifest.NamedSnapshot) int {
+		if v := cmp.Compare(a.SeqNum, b.SeqNum); v != 0 {
+			return v
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return snapshots
+}
+
 func (vs *versionSet) markFileNumUsed(fileNum base.DiskFileNum) {
 	if vs.nextFileNum <= uint64(fileNum) {
 		vs.nextFileNum = uint64(fileNum + 1)
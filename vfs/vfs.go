@@ -385,6 +385,14 @@ func LimitedCopy(fs FS, oldname, newname string, maxBytes int64) error {
 // the hard link fails, LinkOrCopy falls back to copying the file (which may
 // also fail if oldname doesn't exist or newname already exists).
 func LinkOrCopy(fs FS, oldname, newname string) error {
+	return LinkOrCopyWithCopyHook(fs, oldname, newname, nil)
+}
+
+// LinkOrCopyWithCopyHook is like LinkOrCopy, but calls beforeCopy (if non-nil)
+// when creating the hard link failed, before falling back to copying the file.
+// If beforeCopy returns an error, the file is not copied and the error is
+// returned.
+func LinkOrCopyWithCopyHook(fs FS, oldname, newname string, beforeCopy func() error) error {
 	err := fs.Link(oldname, newname)
 	if err == nil {
 		return nil
@@ -399,6 +407,11 @@ func LinkOrCopy(fs FS, oldname, newname string) error {
 	if oserror.IsExist(err) || oserror.IsNotExist(err) || oserror.IsPermission(err) {
 		return err
 	}
+	if beforeCopy != nil {
+		if err := beforeCopy(); err != nil {
+			return err
+		}
+	}
 	return Copy(fs, oldname, newname)
 }
 
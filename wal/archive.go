@@ -0,0 +1,183 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package wal
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// archiveRetryInterval is the delay before a failed Options.Archive call is
+// retried.
+const archiveRetryInterval = time.Second
+
+// archiveCloseTimeout bounds how long archiver.close waits for the pending
+// WALs to be archived. It is a variable for testing.
+var archiveCloseTimeout = 10 * time.Second
+
+// noBound is passed to archiver.obsoleteBound when only the archival state
+// bounds the obsolete WALs.
+const noBound = NumWAL(math.MaxUint64)
+
+// archiver calls Options.Archive on each closed WAL from a background
+// goroutine, in increasing NumWAL order. A WAL is not returned by
+// Manager.Obsolete (and so is neither deleted nor recycled) until it, and
+// every WAL preceding it, has been archived.
+//
+// A nil *archiver is valid and archives nothing.
+type archiver struct {
+	archive func(LogicalLog) error
+	logger  base.Logger
+	// notify is signaled when a WAL is added to mu.pending.
+	notify chan struct{}
+	// closeCh is closed by close, after which the goroutine exits once
+	// mu.pending is drained or an attempt fails.
+	closeCh chan struct{}
+	// abandonCh is closed by close once archiveCloseTimeout has elapsed,
+	// after which the goroutine exits once the current attempt returns.
+	abandonCh chan struct{}
+	done      chan struct{}
+
+	mu struct {
+		sync.Mutex
+		// pending holds the closed WALs that are yet to be archived, in
+		// increasing NumWAL order.
+		pending []LogicalLog
+	}
+}
+
+// newArchiver returns an archiver running in the background, or nil if
+// o.Archive is unset.
+func newArchiver(o *Options) *archiver {
+	if o.Archive == nil {
+		return nil
+	}
+	a := &archiver{
+		archive:   o.Archive,
+		logger:    o.Logger,
+		notify:    make(chan struct{}, 1),
+		closeCh:   make(chan struct{}),
+		abandonCh: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// add queues a closed WAL for archival.
+func (a *archiver) add(ll LogicalLog) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.mu.pending = append(a.mu.pending, ll)
+	a.mu.Unlock()
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// obsoleteBound returns the NumWAL below which closed WALs may be treated as
+// obsolete: the smaller of minUnflushedNum and the first WAL that has not
+// been archived.
+func (a *archiver) obsoleteBound(minUnflushedNum NumWAL) NumWAL {
+	if a == nil {
+		return minUnflushedNum
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.mu.pending) > 0 {
+		return min(minUnflushedNum, a.mu.pending[0].Num)
+	}
+	return minUnflushedNum
+}
+
+// pendingCount returns the number of closed WALs that are yet to be archived.
+func (a *archiver) pendingCount() int {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.mu.pending)
+}
+
+// close archives the remaining pending WALs and stops the background
+// goroutine. A failure is not retried, and leaves the unarchived WALs in
+// place to be archived after the next Open. The same holds for the WALs that
+// are not archived within archiveCloseTimeout, since close is called while
+// the DB's mutexes are held: the goroutine then exits in the background once
+// its current attempt returns.
+func (a *archiver) close() {
+	if a == nil {
+		return
+	}
+	close(a.closeCh)
+	t := time.NewTimer(archiveCloseTimeout)
+	defer t.Stop()
+	select {
+	case <-a.done:
+	case <-t.C:
+		close(a.abandonCh)
+		a.logger.Errorf("pebble: WALs were not archived within %s of closing; they will be archived after the next Open",
+			archiveCloseTimeout)
+	}
+}
+
+func (a *archiver) run() {
+	defer close(a.done)
+	for {
+		a.mu.Lock()
+		var ll LogicalLog
+		n := len(a.mu.pending)
+		if n > 0 {
+			ll = a.mu.pending[0]
+		}
+		a.mu.Unlock()
+
+		if n == 0 {
+			select {
+			case <-a.notify:
+				continue
+			case <-a.closeCh:
+				return
+			}
+		}
+		if err := a.archive(ll); err != nil {
+			a.logger.Errorf("pebble: failed to archive WAL %s: %v", ll.Num, err)
+			select {
+			case <-time.After(archiveRetryInterval):
+				continue
+			case <-a.closeCh:
+				return
+			}
+		}
+		a.mu.Lock()
+		a.mu.pending = a.mu.pending[1:]
+		a.mu.Unlock()
+		select {
+		case <-a.abandonCh:
+			return
+		default:
+		}
+	}
+}
+
+// splitDeletableLogs splits logs, which are in increasing NumWAL order, into
+// those preceding bound and the rest.
+func splitDeletableLogs(logs []DeletableLog, bound NumWAL) (before, rest []DeletableLog) {
+	i := 0
+	for i < len(logs) && logs[i].NumWAL < bound {
+		i++
+	}
+	if i == len(logs) {
+		return logs, nil
+	}
+	return logs[:i:i], logs[i:]
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package wal
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandaloneManagerArchive(t *testing.T) {
+	fs := vfs.NewMem()
+	require.NoError(t, fs.MkdirAll("wal", 0755))
+
+	// block holds back archival until it is closed; failures makes the first
+	// attempts fail.
+	var mu sync.Mutex
+	var archived []NumWAL
+	block := make(chan struct{})
+	failures := 1
+	m, err := Init(Options{
+		Primary:              Dir{FS: fs, Dirname: "wal"},
+		MaxNumRecyclableLogs: 1,
+		PreallocateSize:      func() int { return 0 },
+		Logger:               base.DefaultLogger,
+		EventListener:        noopEventListener{},
+		Archive: func(ll LogicalLog) error {
+			<-block
+			mu.Lock()
+			defer mu.Unlock()
+			if failures > 0 {
+				failures--
+				return errors.New("injected")
+			}
+			require.Equal(t, 1, ll.NumSegments())
+			archived = append(archived, ll.Num)
+			return nil
+		},
+	}, nil /* initial logs */)
+	require.NoError(t, err)
+
+	for _, wn := range []NumWAL{1, 2, 3} {
+		w, err := m.Create(wn, 0 /* jobID */)
+		require.NoError(t, err)
+		_, err = w.WriteRecord([]byte("foo"), SyncOptions{}, nil)
+		require.NoError(t, err)
+		_, err = w.Close()
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, m.Stats().ArchivePendingCount)
+
+	// No WAL is obsolete until it has been archived, even if flushed.
+	toDelete, err := m.Obsolete(4, true /* noRecycle */)
+	require.NoError(t, err)
+	require.Empty(t, toDelete)
+
+	close(block)
+	require.Eventually(t, func() bool {
+		return m.Stats().ArchivePendingCount == 0
+	}, 10*time.Second, time.Millisecond)
+	mu.Lock()
+	require.Equal(t, []NumWAL{1, 2, 3}, archived)
+	mu.Unlock()
+
+	toDelete, err = m.Obsolete(3, true /* noRecycle */)
+	require.NoError(t, err)
+	require.Len(t, toDelete, 2)
+	require.Equal(t, NumWAL(1), toDelete[0].NumWAL)
+	require.Equal(t, NumWAL(2), toDelete[1].NumWAL)
+	require.NoError(t, m.Close())
+}
+
+type noopEventListener struct{}
+
+func (noopEventListener) LogCreated(CreateInfo) {}
+
+func TestArchiverCloseTimeout(t *testing.T) {
+	defer func(d time.Duration) { archiveCloseTimeout = d }(archiveCloseTimeout)
+	archiveCloseTimeout = 10 * time.Millisecond
+
+	fs := vfs.NewMem()
+	require.NoError(t, fs.MkdirAll("wal", 0755))
+	block := make(chan struct{})
+	var archived sync.WaitGroup
+	archived.Add(1)
+	m, err := Init(Options{
+		Primary:              Dir{FS: fs, Dirname: "wal"},
+		MaxNumRecyclableLogs: 1,
+		PreallocateSize:      func() int { return 0 },
+		Logger:               base.NoopLoggerAndTracer{},
+		EventListener:        noopEventListener{},
+		Archive: func(ll LogicalLog) error {
+			defer archived.Done()
+			<-block
+			return nil
+		},
+	}, nil /* initial logs */)
+	require.NoError(t, err)
+	for _, wn := range []NumWAL{1, 2} {
+		w, err := m.Create(wn, 0 /* jobID */)
+		require.NoError(t, err)
+		_, err = w.Close()
+		require.NoError(t, err)
+	}
+
+	// Close returns even though archival is blocked, and the second WAL is
+	// abandoned once the first attempt returns.
+	require.NoError(t, m.Close())
+	close(block)
+	archived.Wait()
+}
@@ -445,6 +445,8 @@ type failoverManager struct {
 	// include logs that were NOT created by the standalone manager, and
 	// multiple physical log files may form one logical WAL.
 	initialObsolete []DeletableLog
+	// archiver archives closed WALs if Options.Archive is set; nil otherwise.
+	archiver *archiver
 
 	// TODO(jackson/sumeer): read-path etc.
 
@@ -527,6 +529,10 @@ func (wm *failoverManager) init(o Options, initial Logs) error {
 			return err
 		}
 	}
+	wm.archiver = newArchiver(&wm.opts)
+	for _, ll := range initial {
+		wm.archiver.add(ll)
+	}
 	return nil
 }
 
@@ -566,9 +572,11 @@ func (wm *failoverManager) Obsolete(
 	defer wm.mu.Unlock()
 
 	// If this is the first call to Obsolete after Open, we may have deletable
-	// logs outside the queue.
-	toDelete, wm.initialObsolete = wm.initialObsolete, nil
+	// logs outside the queue. Logs that are yet to be archived are retained
+	// for a later call.
+	toDelete, wm.initialObsolete = splitDeletableLogs(wm.initialObsolete, wm.archiver.obsoleteBound(noBound))
 
+	minUnflushedNum = wm.archiver.obsoleteBound(minUnflushedNum)
 	i := 0
 	for ; i < len(wm.mu.closedWALs); i++ {
 		ll := wm.mu.closedWALs[i]
@@ -625,6 +633,7 @@ func (wm *failoverManager) Create(wn NumWAL, jobID int) (Writer, error) {
 		minSyncInterval:             wm.opts.MinSyncInterval,
 		fsyncLatency:                wm.opts.FsyncLatency,
 		queueSemChan:                wm.opts.QueueSemChan,
+		compression:                 wm.opts.Compression,
 		stopper:                     wm.stopper,
 		failoverWriteAndSyncLatency: wm.opts.FailoverWriteAndSyncLatency,
 		writerClosed:                wm.writerClosed,
@@ -659,6 +668,11 @@ func (wm *failoverManager) writerClosed(llse logicalLogWithSizesEtc) {
 	defer wm.mu.Unlock()
 	wm.mu.closedWALs = append(wm.mu.closedWALs, llse)
 	wm.mu.ww = nil
+	ll := LogicalLog{Num: llse.num, segments: make([]segment, len(llse.segments))}
+	for i := range llse.segments {
+		ll.segments[i] = llse.segments[i].segment
+	}
+	wm.archiver.add(ll)
 }
 
 // Stats implements Manager.
@@ -689,17 +703,19 @@ func (wm *failoverManager) Stats() Stats {
 		obsoleteLogSize += wm.initialObsolete[i].ApproxFileSize
 	}
 	return Stats{
-		ObsoleteFileCount: obsoleteLogsCount,
-		ObsoleteFileSize:  obsoleteLogSize,
-		LiveFileCount:     liveFileCount,
-		LiveFileSize:      liveFileSize,
-		Failover:          failoverStats,
+		ObsoleteFileCount:   obsoleteLogsCount,
+		ObsoleteFileSize:    obsoleteLogSize,
+		LiveFileCount:       liveFileCount,
+		LiveFileSize:        liveFileSize,
+		ArchivePendingCount: wm.archiver.pendingCount(),
+		Failover:            failoverStats,
 	}
 }
 
 // Close implements Manager.
 func (wm *failoverManager) Close() error {
 	wm.stopper.stop()
+	wm.archiver.close()
 	// Since all goroutines are stopped, can close the dirs.
 	var err error
 	for _, f := range wm.dirHandles {
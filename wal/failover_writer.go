@@ -456,6 +456,7 @@ type failoverWriterOpts struct {
 	minSyncInterval func() time.Duration
 	fsyncLatency    prometheus.Histogram
 	queueSemChan    chan struct{}
+	compression     func() record.Compression
 	stopper         *stopper
 
 	failoverWriteAndSyncLatency prometheus.Histogram
@@ -632,6 +633,7 @@ func (ww *failoverWriter) switchToNewDir(dir dirAndFileHandle) error {
 				WALFsyncLatency:           ww.opts.fsyncLatency,
 				QueueSemChan:              ww.opts.queueSemChan,
 				ExternalSyncQueueCallback: ww.doneSyncCallback,
+				Compression:               ww.opts.compression,
 			})
 		closeWriter := func() bool {
 			ww.mu.Lock()
@@ -26,6 +26,8 @@ type StandaloneManager struct {
 	// include logs that were NOT created by the standalone manager, and
 	// multiple physical log files may form one logical WAL.
 	initialObsolete []DeletableLog
+	// archiver archives closed WALs if Options.Archive is set; nil otherwise.
+	archiver *archiver
 
 	// External synchronization is relied on when accessing w in Manager.Create,
 	// Writer.{WriteRecord,Close}.
@@ -74,6 +76,10 @@ func (m *StandaloneManager) init(o Options, initial Logs) error {
 			return closeAndReturnErr(err)
 		}
 	}
+	m.archiver = newArchiver(&m.o)
+	for _, ll := range initial {
+		m.archiver.add(ll)
+	}
 	return nil
 }
 
@@ -99,9 +105,11 @@ func (m *StandaloneManager) Obsolete(
 	defer m.mu.Unlock()
 
 	// If this is the first call to Obsolete after Open, we may have deletable
-	// logs outside the queue.
-	toDelete, m.initialObsolete = m.initialObsolete, nil
+	// logs outside the queue. Logs that are yet to be archived are retained
+	// for a later call.
+	toDelete, m.initialObsolete = splitDeletableLogs(m.initialObsolete, m.archiver.obsoleteBound(noBound))
 
+	minUnflushedNum = m.archiver.obsoleteBound(minUnflushedNum)
 	i := 0
 	for ; i < len(m.mu.queue); i++ {
 		fi := m.mu.queue[i]
@@ -197,6 +205,7 @@ func (m *StandaloneManager) Create(wn NumWAL, jobID int) (Writer, error) {
 		WALFsyncLatency:    m.o.FsyncLatency,
 		WALMinSyncInterval: m.o.MinSyncInterval,
 		QueueSemChan:       m.o.QueueSemChan,
+		Compression:        m.o.Compression,
 	})
 	m.w = &standaloneWriter{
 		m: m,
@@ -229,10 +238,11 @@ func (m *StandaloneManager) Stats() Stats {
 		obsoleteLogSize += m.initialObsolete[i].ApproxFileSize
 	}
 	return Stats{
-		ObsoleteFileCount: obsoleteLogsCount,
-		ObsoleteFileSize:  obsoleteLogSize,
-		LiveFileCount:     len(m.mu.queue),
-		LiveFileSize:      fileSize,
+		ObsoleteFileCount:   obsoleteLogsCount,
+		ObsoleteFileSize:    obsoleteLogSize,
+		LiveFileCount:       len(m.mu.queue),
+		LiveFileSize:        fileSize,
+		ArchivePendingCount: m.archiver.pendingCount(),
 	}
 }
 
@@ -242,6 +252,7 @@ func (m *StandaloneManager) Close() error {
 	if m.w != nil {
 		_, err = m.w.Close()
 	}
+	m.archiver.close()
 	return firstError(err, m.walDir.Close())
 }
 
@@ -290,6 +301,10 @@ func (w *standaloneWriter) Close() (logicalOffset int64, err error) {
 		w.m.mu.queue[i].FileSize = uint64(logicalOffset)
 	}
 	w.m.w = nil
+	w.m.archiver.add(LogicalLog{
+		Num:      NumWAL(w.m.mu.queue[i].FileNum),
+		segments: []segment{{dir: w.m.o.Primary}},
+	})
 	return logicalOffset, err
 }
 
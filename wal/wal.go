@@ -134,6 +134,14 @@ type Options struct {
 	// there is no syncQueue, so the pushback into the commit pipeline is
 	// unnecessary, but possibly harmless.
 	QueueSemChan chan struct{}
+	// Compression returns the algorithm used to compress WAL records.
+	// Optional; if nil, records are not compressed.
+	Compression func() record.Compression
+	// Archive is called, from a background goroutine and in increasing NumWAL
+	// order, with each WAL once it is closed. A WAL is not returned by
+	// Manager.Obsolete until Archive has succeeded for it and all the WALs
+	// preceding it; failures are logged and retried. Optional.
+	Archive func(LogicalLog) error
 
 	// Logger for logging.
 	Logger base.Logger
@@ -281,6 +289,9 @@ type Stats struct {
 	// This is updated only when log files are closed, to minimize
 	// synchronization.
 	LiveFileSize uint64
+	// ArchivePendingCount is the number of closed WALs that are yet to be
+	// archived (see Options.Archive).
+	ArchivePendingCount int
 	// Failover contains failover stats.
 	Failover FailoverStats
 }
@@ -0,0 +1,384 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/batchrepr"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/pebble/vfs/atomicfs"
+	"github.com/cockroachdb/pebble/wal"
+)
+
+// WALArchiveOptions configures the archival of the DB's WALs to remote
+// storage, which allows a checkpoint of the DB to be brought forward to a
+// later point in time (see Restore).
+//
+// Every WAL is uploaded once it is closed, along with the current MANIFEST,
+// and is not deleted or recycled until it has been uploaded. A failed upload
+// is logged and retried.
+type WALArchiveOptions struct {
+	// Storage is the remote storage the WALs are uploaded to.
+	Storage remote.Storage
+	// Prefix is prepended to the names of the uploaded objects, which are
+	// otherwise the names of the WAL and MANIFEST files.
+	Prefix string
+	// TimestampInterval is the interval at which the DB records the current
+	// time in the WAL, if it was written to since the last time. These records
+	// determine the granularity of a Restore to a target time. Defaults to 1s.
+	TimestampInterval time.Duration
+}
+
+// EnsureDefaults ensures that the default values for all options are set if a
+// valid value was not already specified.
+func (o *WALArchiveOptions) EnsureDefaults() {
+	if o.TimestampInterval <= 0 {
+		o.TimestampInterval = time.Second
+	}
+}
+
+// walTimestampPrefix prefixes the LogData records with which the DB records
+// the current time in the WAL. It is followed by the time as a big-endian
+// count of nanoseconds since the Unix epoch.
+const walTimestampPrefix = "\x00pebble.wal-timestamp:"
+
+func encodeWALTimestamp(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64([]byte(walTimestampPrefix), uint64(t.UnixNano()))
+}
+
+func decodeWALTimestamp(data []byte) (time.Time, bool) {
+	if len(data) != len(walTimestampPrefix)+8 || !bytes.HasPrefix(data, []byte(walTimestampPrefix)) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data[len(walTimestampPrefix):]))), true
+}
+
+// archiveWAL uploads the segments of a closed WAL and the current MANIFEST.
+// It implements wal.Options.Archive.
+//
+// archiveWAL may be called while d.mu is held (by DB.Close), so it must not
+// acquire it.
+func (d *DB) archiveWAL(ll wal.LogicalLog) error {
+	o := d.opts.WALArchive
+	for i := 0; i < ll.NumSegments(); i++ {
+		fs, path := ll.SegmentLocation(i)
+		if err := archiveFile(o, fs, path); err != nil {
+			return err
+		}
+	}
+
+	// The current MANIFEST is the one the manifest marker points to. It may be
+	// rotated, and the MANIFEST the marker pointed to deleted, between reading
+	// the marker and uploading the MANIFEST, in which case the marker is read
+	// again.
+	var prev string
+	for {
+		filename, err := atomicfs.ReadMarker(d.opts.FS, d.dirname, manifestMarkerName)
+		if err != nil || filename == "" {
+			return err
+		}
+		err = archiveFile(o, d.opts.FS, d.opts.FS.PathJoin(d.dirname, filename))
+		if !oserror.IsNotExist(err) || filename == prev {
+			return err
+		}
+		prev = filename
+	}
+}
+
+// archiveFile uploads a file to o.Storage, unless an object of the same name
+// and size was already uploaded (e.g. before the DB was reopened).
+func archiveFile(o *WALArchiveOptions, fs vfs.FS, path string) error {
+	f, err := fs.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	objName := o.Prefix + fs.PathBase(path)
+	if size, err := o.Storage.Size(objName); err == nil && size == stat.Size() {
+		return nil
+	}
+	w, err := o.Storage.CreateObject(objName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.LimitReader(f, stat.Size())); err != nil {
+		return errors.CombineErrors(err, w.Close())
+	}
+	return w.Close()
+}
+
+// runWALTimestampLoop records the current time in the WAL every
+// WALArchive.TimestampInterval, if the DB was written to since the last time,
+// until the DB is closed.
+func (d *DB) runWALTimestampLoop() {
+	ticker := time.NewTicker(d.opts.WALArchive.TimestampInterval)
+	defer ticker.Stop()
+	var lastSeqNum uint64
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+		}
+		seqNum := d.mu.versions.visibleSeqNum.Load()
+		if seqNum == lastSeqNum {
+			continue
+		}
+		lastSeqNum = seqNum
+		// The timestamp is written while holding walTimestamps, which Close
+		// acquires before closing the DB, since writes must not race with
+		// Close.
+		d.walTimestamps.Lock()
+		if d.walTimestamps.closed {
+			d.walTimestamps.Unlock()
+			return
+		}
+		if err := d.LogData(encodeWALTimestamp(d.timeNow()), NoSync); err != nil {
+			d.opts.Logger.Errorf("pebble: failed to record WAL timestamp: %v", err)
+		}
+		d.walTimestamps.Unlock()
+	}
+}
+
+// RestoreOptions configures Restore.
+type RestoreOptions struct {
+	// Checkpoint is the directory, on Options.FS, of a checkpoint of the DB
+	// that is restored (see DB.Checkpoint).
+	Checkpoint string
+	// Storage and Prefix locate the WALs archived by the DB (see
+	// WALArchiveOptions).
+	Storage remote.Storage
+	Prefix  string
+	// TargetSeqNum, if non-zero, restores the DB as of the given sequence
+	// number: only the batches whose sequence numbers are all less than or
+	// equal to TargetSeqNum are applied.
+	TargetSeqNum uint64
+	// TargetTime, if non-zero, restores the DB as of the given time: only the
+	// batches that precede a timestamp recorded in the WAL at or before
+	// TargetTime are applied (see WALArchiveOptions.TimestampInterval).
+	TargetTime time.Time
+}
+
+// Restore creates a DB in dirname, which must not exist, from a checkpoint
+// of a DB that archived its WALs (see Options.WALArchive), and brings it
+// forward by applying the batches of the archived WALs that follow the
+// checkpoint, up to the target sequence number or time if one is given, or
+// to the end of the archive otherwise. The batches keep the sequence numbers
+// they were given by the DB, and Restore returns an error if the archive is
+// missing any of the batches in between. If Restore fails, dirname should be
+// removed.
+//
+// The sstables ingested by the DB after the checkpoint are not part of its
+// WALs, so they cannot be restored: Restore returns an error if it encounters
+// an ingestion that was recorded in the WAL, and ingestions that were not
+// recorded are missing from the restored DB.
+func Restore(dirname string, opts *Options, ropts RestoreOptions) error {
+	opts = opts.Clone()
+	opts.WALArchive = nil
+	opts.ReadOnly = false
+	fs := opts.FS
+	if fs == nil {
+		fs = vfs.Default
+		opts.FS = fs
+	}
+
+	if _, err := fs.Stat(dirname); !oserror.IsNotExist(err) {
+		if err == nil {
+			return &os.PathError{Op: "restore", Path: dirname, Err: oserror.ErrExist}
+		}
+		return err
+	}
+	if err := fs.MkdirAll(dirname, 0755); err != nil {
+		return err
+	}
+	ls, err := fs.List(ropts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	for _, filename := range ls {
+		src := fs.PathJoin(ropts.Checkpoint, filename)
+		if stat, err := fs.Stat(src); err != nil {
+			return err
+		} else if stat.IsDir() {
+			continue
+		}
+		if err := vfs.Copy(fs, src, fs.PathJoin(dirname, filename)); err != nil {
+			return err
+		}
+	}
+
+	d, err := Open(dirname, opts)
+	if err != nil {
+		return err
+	}
+	if err := d.applyArchivedWALs(ropts); err != nil {
+		return errors.CombineErrors(err, d.Close())
+	}
+	if err := d.Flush(); err != nil {
+		return errors.CombineErrors(err, d.Close())
+	}
+	return d.Close()
+}
+
+// applyArchivedWALs applies the batches of the archived WALs that follow the
+// DB's state, up to the target of the restore.
+func (d *DB) applyArchivedWALs(ropts RestoreOptions) error {
+	if ropts.TargetSeqNum != 0 && ropts.TargetSeqNum < d.mu.versions.visibleSeqNum.Load()-1 {
+		return errors.Errorf("pebble: checkpoint is more recent than the restore target seqnum %d",
+			errors.Safe(ropts.TargetSeqNum))
+	}
+
+	type archivedSegment struct {
+		objName string
+		num     wal.NumWAL
+		index   wal.LogNameIndex
+	}
+	names, err := ropts.Storage.List(ropts.Prefix, "")
+	if err != nil {
+		return err
+	}
+	var segments []archivedSegment
+	for _, name := range names {
+		// Some implementations of remote.Storage trim the prefix from the
+		// listed names while others do not.
+		name = strings.TrimPrefix(name, ropts.Prefix)
+		if num, index, ok := wal.ParseLogFilename(name); ok {
+			segments = append(segments, archivedSegment{objName: ropts.Prefix + name, num: num, index: index})
+		}
+	}
+	slices.SortFunc(segments, func(a, b archivedSegment) int {
+		if c := cmp.Compare(a.num, b.num); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.index, b.index)
+	})
+
+	// When restoring to a target time, the batches that follow the last
+	// timestamp are held back until a timestamp shows whether they precede
+	// the target.
+	var pending []*Batch
+	defer func() {
+		for _, b := range pending {
+			_ = b.Close()
+		}
+	}()
+	applyPending := func() error {
+		for i, b := range pending {
+			// ApplyReplicated fails if a batch is missing from the archive.
+			if err := d.ApplyReplicated(b, b.SeqNum(), NoSync); err != nil {
+				return err
+			}
+			_ = b.Close()
+			pending[i] = nil
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for _, s := range segments {
+		data, err := readArchivedObject(ropts.Storage, s.objName)
+		if err != nil {
+			return err
+		}
+		rr := record.NewReader(bytes.NewReader(data), base.DiskFileNum(s.num))
+		for {
+			r, err := rr.Next()
+			var buf []byte
+			if err == nil {
+				buf, err = io.ReadAll(r)
+			}
+			if err != nil {
+				// A segment ends with an invalid chunk if the WAL was recycled
+				// or its writer failed over to another segment.
+				if err == io.EOF || record.IsInvalidRecord(err) {
+					break
+				}
+				return errors.Wrapf(err, "pebble: reading archived WAL %s", errors.Safe(s.objName))
+			}
+			if len(buf) < batchrepr.HeaderLen {
+				return base.CorruptionErrorf("pebble: corrupt archived WAL %s", errors.Safe(s.objName))
+			}
+
+			b := d.NewBatch()
+			if err := b.SetRepr(buf); err != nil {
+				return err
+			}
+			seqNum, count := b.SeqNum(), uint64(b.Count())
+			br := b.Reader()
+			kind, ukey, _, ok, err := br.Next()
+			if err != nil {
+				return err
+			}
+			switch {
+			case count == 0:
+				_ = b.Close()
+				if !ok || kind != InternalKeyKindLogData || ropts.TargetTime.IsZero() {
+					continue
+				}
+				if t, ok := decodeWALTimestamp(ukey); ok {
+					if t.After(ropts.TargetTime) {
+						return nil
+					}
+					if err := applyPending(); err != nil {
+						return err
+					}
+				}
+				continue
+			case kind == InternalKeyKindIngestSST:
+				_ = b.Close()
+				if seqNum < d.mu.versions.logSeqNum.Load() {
+					continue
+				}
+				return errors.Errorf("pebble: archived WAL %s contains an ingestion at seqnum %d, which cannot be restored",
+					errors.Safe(s.objName), errors.Safe(seqNum))
+			case ropts.TargetSeqNum != 0 && seqNum+count-1 > ropts.TargetSeqNum:
+				_ = b.Close()
+				return applyPending()
+			}
+			pending = append(pending, b)
+			if ropts.TargetTime.IsZero() {
+				if err := applyPending(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if ropts.TargetTime.IsZero() {
+		return applyPending()
+	}
+	return nil
+}
+
+// readArchivedObject reads the entirety of an object.
+func readArchivedObject(storage remote.Storage, objName string) ([]byte, error) {
+	r, size, err := storage.ReadObject(context.Background(), objName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data := make([]byte, size)
+	if err := r.ReadAt(context.Background(), data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
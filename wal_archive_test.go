@@ -0,0 +1,175 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALArchiveRestore(t *testing.T) {
+	fs := vfs.NewMem()
+	storage := remote.NewInMem()
+	opts := &Options{
+		FS: fs,
+		WALArchive: &WALArchiveOptions{
+			Storage: storage,
+			Prefix:  "archive/",
+			// Timestamps are written explicitly below.
+			TimestampInterval: time.Hour,
+		},
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+
+	set := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%d", prefix, i)), []byte(prefix), NoSync))
+		}
+	}
+	set("a", 10)
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	set("b", 10)
+	seqNumB := d.mu.versions.visibleSeqNum.Load() - 1
+	t0 := time.Unix(1000, 0)
+	require.NoError(t, d.LogData(encodeWALTimestamp(t0), NoSync))
+	// Rotate the WAL, so that the archive contains several WALs.
+	require.NoError(t, d.Flush())
+	set("c", 10)
+	require.NoError(t, d.LogData(encodeWALTimestamp(t0.Add(time.Minute)), NoSync))
+	set("d", 10)
+	require.NoError(t, d.Close())
+
+	names, err := storage.List("archive/", "")
+	require.NoError(t, err)
+	var numWALs, numManifests int
+	for _, name := range names {
+		switch {
+		case strings.HasSuffix(name, ".log"):
+			numWALs++
+		case strings.Contains(name, "MANIFEST-"):
+			numManifests++
+		}
+	}
+	require.GreaterOrEqual(t, numWALs, 2)
+	require.GreaterOrEqual(t, numManifests, 1)
+
+	restoreOpts := &Options{FS: fs}
+	check := func(ropts RestoreOptions, dirname string, present, absent []string) {
+		t.Helper()
+		ropts.Checkpoint, ropts.Storage, ropts.Prefix = "checkpoint", storage, "archive/"
+		require.NoError(t, Restore(dirname, restoreOpts, ropts))
+		r, err := Open(dirname, restoreOpts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+		for _, prefix := range present {
+			for i := 0; i < 10; i++ {
+				v, closer, err := r.Get([]byte(fmt.Sprintf("%s%d", prefix, i)))
+				require.NoError(t, err)
+				require.Equal(t, prefix, string(v))
+				require.NoError(t, closer.Close())
+			}
+		}
+		for _, prefix := range absent {
+			_, _, err := r.Get([]byte(prefix + "0"))
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+	}
+
+	check(RestoreOptions{}, "all", []string{"a", "b", "c", "d"}, nil)
+	check(RestoreOptions{TargetSeqNum: seqNumB}, "seqnum", []string{"a", "b"}, []string{"c", "d"})
+	// The batches that follow the last timestamp at or before the target time
+	// are not restored, since they may have been written after it.
+	check(RestoreOptions{TargetTime: t0.Add(time.Second)}, "time", []string{"a", "b"}, []string{"c", "d"})
+	check(RestoreOptions{TargetTime: t0.Add(time.Hour)}, "time-latest", []string{"a", "b", "c"}, []string{"d"})
+
+	// The restored DB keeps the sequence numbers of the original.
+	r, err := Open("seqnum", restoreOpts)
+	require.NoError(t, err)
+	require.Equal(t, seqNumB+1, r.mu.versions.visibleSeqNum.Load())
+	require.NoError(t, r.Close())
+
+	require.Error(t, Restore("all", restoreOpts, RestoreOptions{
+		Checkpoint: "checkpoint", Storage: storage, Prefix: "archive/",
+	}))
+
+	// A restore fails rather than skip over the batches of a missing WAL.
+	var firstWAL string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".log") && (firstWAL == "" || name < firstWAL) {
+			firstWAL = name
+		}
+	}
+	require.NoError(t, storage.Delete(firstWAL))
+	err = Restore("gap", restoreOpts, RestoreOptions{
+		Checkpoint: "checkpoint", Storage: storage, Prefix: "archive/",
+	})
+	require.ErrorContains(t, err, "follows a gap")
+}
+
+func TestWALArchiveCurrentManifest(t *testing.T) {
+	fs := vfs.NewMem()
+	storage := remote.NewInMem()
+	d, err := Open("db", &Options{
+		FS:         fs,
+		WALArchive: &WALArchiveOptions{Storage: storage},
+	})
+	require.NoError(t, err)
+	// A MANIFEST left behind by a failed rotation has a larger file number than
+	// the current one, which the manifest marker points to.
+	f, err := fs.Create(fs.PathJoin("db", "MANIFEST-999999"), vfs.WriteCategoryUnspecified)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	current := base.MakeFilename(fileTypeManifest, d.mu.versions.manifestFileNum)
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Close())
+
+	names, err := storage.List("", "")
+	require.NoError(t, err)
+	require.Contains(t, names, current)
+	require.NotContains(t, names, "MANIFEST-999999")
+}
+
+func TestWALTimestampLoop(t *testing.T) {
+	fs := vfs.NewMem()
+	storage := remote.NewInMem()
+	d, err := Open("db", &Options{
+		FS: fs,
+		WALArchive: &WALArchiveOptions{
+			Storage:           storage,
+			TimestampInterval: time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), NoSync))
+	bytesIn := d.Metrics().WAL.BytesIn
+	require.Eventually(t, func() bool {
+		return d.Metrics().WAL.BytesIn > bytesIn
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, d.Close())
+
+	// The write is followed by a timestamp, so it is restored to the present.
+	restoreOpts := &Options{FS: fs}
+	require.NoError(t, Restore("restored", restoreOpts, RestoreOptions{
+		Checkpoint: "checkpoint",
+		Storage:    storage,
+		TargetTime: time.Now(),
+	}))
+	r, err := Open("restored", restoreOpts)
+	require.NoError(t, err)
+	v, closer, err := r.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, r.Close())
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+)
+
+// defaultWatchBufferSize is the default capacity of the channel returned by
+// DB.Watch.
+const defaultWatchBufferSize = 1024
+
+// WatchEvent is a change notification delivered by DB.Watch.
+type WatchEvent struct {
+	// Kind is the kind of the change. Point kinds (SET, DEL, SINGLEDEL,
+	// DELSIZED, MERGE, SETWITHDEL) describe a change to Key. Range kinds
+	// (RANGEDEL, RANGEKEYSET, RANGEKEYUNSET, RANGEKEYDEL) describe a change to
+	// the span [Key, EndKey), which may extend beyond the watched span.
+	Kind InternalKeyKind
+	// Key is the changed key, or the start of the changed span.
+	Key []byte
+	// EndKey is the exclusive end of the changed span, for range kinds.
+	EndKey []byte
+	// Value is the value of a SET, SETWITHDEL or MERGE, if
+	// WatchOptions.IncludeValues is set.
+	Value []byte
+	// SeqNum is the sequence number of the change. For a resync event, it is
+	// the sequence number of the first change that was not delivered.
+	SeqNum uint64
+	// Resync is set if changes within the watched span were not delivered,
+	// either because the consumer fell behind and the buffer filled up, or
+	// because an ingestion modified the span. The consumer must re-read the
+	// span: the re-read reflects every change that was not delivered. Changes
+	// after the resync event are delivered as usual, and may already be
+	// reflected by the re-read.
+	Resync bool
+}
+
+// WatchOptions configures a watch created by DB.Watch.
+type WatchOptions struct {
+	// IncludeValues includes the values of SET, SETWITHDEL and MERGE changes in
+	// the events.
+	IncludeValues bool
+	// BufferSize is the capacity of the channel of events. The commit pipeline
+	// never blocks on a watch: once the buffer is full, events are dropped and
+	// a resync event is delivered instead. Defaults to 1024; the minimum is 2.
+	BufferSize int
+}
+
+// Watch returns a channel of notifications of the changes within span made by
+// batches that commit after the watch starts. An empty span bound is
+// unbounded. Changes are delivered after they are published, so a read
+// performed upon receiving a change observes it, and in sequence number order.
+// All changes committed before Watch returns are visible to reads performed
+// after it returns.
+//
+// Ingestions are not delivered as individual changes: a resync event is
+// delivered to the watches whose spans overlap an ingestion.
+//
+// The channel is closed when ctx is done or the DB is closed. The consumer
+// must drain it promptly; see WatchOptions.BufferSize.
+func (d *DB) Watch(
+	ctx context.Context, span KeyRange, opts *WatchOptions,
+) (<-chan WatchEvent, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if span.Start != nil && span.End != nil && d.cmp(span.Start, span.End) >= 0 {
+		return nil, errors.New("invalid key-range specified (start >= end)")
+	}
+	bufferSize := defaultWatchBufferSize
+	var includeValues bool
+	if opts != nil {
+		includeValues = opts.IncludeValues
+		if opts.BufferSize != 0 {
+			bufferSize = opts.BufferSize
+		}
+	}
+	if bufferSize < 2 {
+		return nil, errors.Newf("pebble: watch buffer size %d is less than 2", bufferSize)
+	}
+	w := &watcher{
+		span: KeyRange{
+			Start: slices.Clone(span.Start),
+			End:   slices.Clone(span.End),
+		},
+		includeValues: includeValues,
+		ch:            make(chan WatchEvent, bufferSize),
+	}
+	d.watches.add(w, d.commit)
+
+	// Wait for the batches that were assigned sequence numbers before the watch
+	// started to be published.
+	d.commit.waitVisible(w.startSeqNum)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-d.closedCh:
+		}
+		d.watches.remove(w, d.commit)
+	}()
+	return w.ch, nil
+}
+
+// watcher is a watch created by DB.Watch.
+type watcher struct {
+	span          KeyRange
+	startSeqNum   uint64
+	includeValues bool
+	ch            chan WatchEvent
+	// dropping is set once a resync event has been queued in the last slot of
+	// ch because it filled up. Events are dropped until the consumer makes room.
+	dropping bool
+}
+
+// contains returns whether the watched span contains key.
+func (w *watcher) contains(cmp Compare, key []byte) bool {
+	return (w.span.Start == nil || cmp(w.span.Start, key) <= 0) &&
+		(w.span.End == nil || cmp(key, w.span.End) < 0)
+}
+
+// overlaps returns whether the watched span overlaps [start, end).
+func (w *watcher) overlaps(cmp Compare, start, end []byte) bool {
+	return (w.span.Start == nil || cmp(w.span.Start, end) < 0) &&
+		(w.span.End == nil || cmp(start, w.span.End) < 0)
+}
+
+// send queues ev, or a resync event if the channel is full. The last slot of
+// the channel is reserved for the resync event, so send never blocks.
+func (w *watcher) send(ev WatchEvent) {
+	if len(w.ch) >= cap(w.ch)-1 {
+		w.resync(ev.SeqNum)
+		return
+	}
+	w.dropping = false
+	w.ch <- ev
+}
+
+// resync queues a resync event, unless one is already queued because events
+// are being dropped.
+func (w *watcher) resync(seqNum uint64) {
+	if w.dropping {
+		return
+	}
+	w.dropping = len(w.ch) >= cap(w.ch)-1
+	w.ch <- WatchEvent{SeqNum: seqNum, Resync: true}
+}
+
+// watchRegistry holds the watches of a DB, and delivers the changes of
+// published batches to them.
+type watchRegistry struct {
+	cmp Compare
+	mu  sync.Mutex
+	// watchers is protected by mu. Sends on the channels of the watchers, and
+	// closing them, happen with mu held.
+	watchers map[*watcher]struct{}
+}
+
+func (r *watchRegistry) add(w *watcher, p *commitPipeline) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchers == nil {
+		r.watchers = make(map[*watcher]struct{})
+	}
+	// Every batch from startSeqNum on is passed to published, which acquires
+	// r.mu and so observes w.
+	w.startSeqNum = p.startWatching()
+	r.watchers[w] = struct{}{}
+}
+
+func (r *watchRegistry) remove(w *watcher, p *commitPipeline) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.watchers, w)
+	close(w.ch)
+	p.stopWatching()
+}
+
+// published delivers the changes of a published batch to the watches. It is
+// invoked by the commit pipeline in sequence number order.
+func (r *watchRegistry) published(b *Batch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.watchers) == 0 || b.Count() == 0 {
+		return
+	}
+	seqNum := b.SeqNum()
+	reader := b.Reader()
+	for {
+		kind, ukey, value, ok, err := reader.Next()
+		if !ok || err != nil {
+			return
+		}
+		var endKey []byte
+		switch kind {
+		case InternalKeyKindLogData, InternalKeyKindIngestSST:
+			// These records are not assigned sequence numbers.
+			continue
+		case InternalKeyKindRangeDelete:
+			endKey = value
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			s, err := rangekey.Decode(base.MakeInternalKey(ukey, seqNum, kind), value, nil)
+			if err != nil {
+				return
+			}
+			endKey = s.End
+		}
+		for w := range r.watchers {
+			if seqNum < w.startSeqNum {
+				continue
+			}
+			if endKey != nil {
+				if !w.overlaps(r.cmp, ukey, endKey) {
+					continue
+				}
+			} else if !w.contains(r.cmp, ukey) {
+				continue
+			}
+			ev := WatchEvent{
+				Kind:   kind,
+				Key:    slices.Clone(ukey),
+				EndKey: slices.Clone(endKey),
+				SeqNum: seqNum,
+			}
+			if w.includeValues {
+				switch kind {
+				case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindMerge:
+					ev.Value = slices.Clone(value)
+				}
+			}
+			w.send(ev)
+		}
+		seqNum++
+	}
+}
+
+// ingested delivers a resync event to the watches whose spans overlap the
+// bounds of an ingestion at seqNum.
+func (r *watchRegistry) ingested(bounds []base.UserKeyBounds, seqNum uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for w := range r.watchers {
+		if seqNum < w.startSeqNum {
+			continue
+		}
+		for i := range bounds {
+			if (w.span.Start == nil || bounds[i].End.IsUpperBoundFor(r.cmp, w.span.Start)) &&
+				(w.span.End == nil || r.cmp(bounds[i].Start, w.span.End) < 0) {
+				w.resync(seqNum)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Changes committed before the watch started are not delivered.
+	require.NoError(t, d.Set([]byte("b"), []byte("0"), nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Watch(ctx, KeyRange{Start: []byte("b"), End: []byte("d")}, &WatchOptions{IncludeValues: true})
+	require.NoError(t, err)
+
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.LogData([]byte("data"), nil))
+	require.NoError(t, b.Merge([]byte("c"), []byte("3"), nil))
+	require.NoError(t, b.Delete([]byte("d"), nil))
+	require.NoError(t, b.DeleteRange([]byte("a"), []byte("bb"), nil))
+	require.NoError(t, b.RangeKeySet([]byte("c"), []byte("e"), []byte("@1"), nil, nil))
+	require.NoError(t, b.Commit(nil))
+	seqNum := b.SeqNum()
+	require.NoError(t, d.Delete([]byte("c"), nil))
+
+	for _, expected := range []WatchEvent{
+		{Kind: InternalKeyKindSet, Key: []byte("b"), Value: []byte("2"), SeqNum: seqNum + 1},
+		{Kind: InternalKeyKindMerge, Key: []byte("c"), Value: []byte("3"), SeqNum: seqNum + 2},
+		{Kind: InternalKeyKindRangeDelete, Key: []byte("a"), EndKey: []byte("bb"), SeqNum: seqNum + 4},
+		{Kind: InternalKeyKindRangeKeySet, Key: []byte("c"), EndKey: []byte("e"), SeqNum: seqNum + 5},
+		{Kind: InternalKeyKindDelete, Key: []byte("c"), SeqNum: seqNum + 6},
+	} {
+		require.Equal(t, expected, <-ch)
+	}
+
+	// Canceling the context closes the channel.
+	cancel()
+	for range ch {
+		t.Fatal("unexpected event")
+	}
+
+	_, err = d.Watch(context.Background(), KeyRange{Start: []byte("b"), End: []byte("a")}, nil)
+	require.Error(t, err)
+	_, err = d.Watch(context.Background(), KeyRange{}, &WatchOptions{BufferSize: 1})
+	require.Error(t, err)
+}
+
+func TestWatchResync(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	ch, err := d.Watch(context.Background(), KeyRange{}, &WatchOptions{BufferSize: 4})
+	require.NoError(t, err)
+
+	// The slow consumer misses changes, and is told to resync.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%d", i)), nil, nil))
+	}
+	seqNum := d.mu.versions.visibleSeqNum.Load() - 10
+	for i := 0; i < 3; i++ {
+		require.Equal(t, fmt.Sprintf("k%d", i), string((<-ch).Key))
+	}
+	require.Equal(t, WatchEvent{SeqNum: seqNum + 3, Resync: true}, <-ch)
+	require.Empty(t, ch)
+	require.NoError(t, d.Set([]byte("k10"), nil, nil))
+	require.Equal(t, "k10", string((<-ch).Key))
+
+	// An ingestion overlapping the span requires a resync.
+	f, err := mem.Create("ext", vfs.WriteCategoryUnspecified)
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+	})
+	require.NoError(t, w.Set([]byte("k5"), []byte("v")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	ev := <-ch
+	require.True(t, ev.Resync)
+	require.Equal(t, d.mu.versions.visibleSeqNum.Load()-1, ev.SeqNum)
+}
+
+func TestWatchConcurrent(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const writers, writes = 8, 200
+	ch, err := d.Watch(context.Background(), KeyRange{}, &WatchOptions{BufferSize: writers*writes + 1})
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("w%d-%d", i, j)), nil, nil))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every change is delivered, in sequence number order.
+	require.Len(t, ch, writers*writes)
+	var prev uint64
+	for i := 0; i < writers*writes; i++ {
+		ev := <-ch
+		require.False(t, ev.Resync)
+		require.Greater(t, ev.SeqNum, prev)
+		prev = ev.SeqNum
+	}
+}
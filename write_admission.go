@@ -0,0 +1,390 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/tokenbucket"
+)
+
+// WritePriority is the priority of a write for admission control. While
+// writes are throttled (see Options.Experimental.WriteAdmission), writes of a
+// higher priority are admitted before waiting writes of a lower priority.
+type WritePriority int8
+
+const (
+	// WritePriorityBulk is the priority of background writes, such as bulk
+	// loads and backfills, which should yield to foreground writes.
+	WritePriorityBulk WritePriority = -1
+	// WritePriorityNormal is the default priority of foreground writes.
+	WritePriorityNormal WritePriority = 0
+	// WritePriorityHigh is the priority of latency-critical writes.
+	WritePriorityHigh WritePriority = 1
+)
+
+// String implements fmt.Stringer.
+func (p WritePriority) String() string {
+	switch p {
+	case WritePriorityBulk:
+		return "bulk"
+	case WritePriorityNormal:
+		return "normal"
+	case WritePriorityHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+func (o *WriteAdmissionOptions) enabled() bool {
+	return o.L0SublevelThreshold > 0 || o.CompactionDebtThreshold > 0
+}
+
+const (
+	// writeAdmissionBurstDuration is the duration's worth of writes that may
+	// be admitted in a single burst while throttled.
+	writeAdmissionBurstDuration = 100 * time.Millisecond
+	// writeAdmissionMinBurst is the minimum burst size, in bytes.
+	writeAdmissionMinBurst = 64 << 10 // 64 KB
+)
+
+// writeAdmissionController meters the bytes of the batches applied to a DB.
+// While the LSM is healthy, its rate is zero and every write is admitted
+// immediately. While the LSM is overloaded, writes draw from a token bucket.
+// As with diskIOLimiter, only the waiter at the head of the queue attempts to
+// acquire tokens. The head is the waiter with the highest priority; among
+// those, the waiter whose tenant has been admitted the fewest bytes since the
+// queue was last empty; and among those, the oldest waiter.
+type writeAdmissionController struct {
+	minRate float64
+	nowFn   func() time.Time
+
+	mu struct {
+		sync.Mutex
+		tb tokenbucket.TokenBucket
+		// rate is the rate of the token bucket in bytes per second, or zero if
+		// writes are not throttled.
+		rate float64
+		// flushRate is a moving average of the peak flush throughput, in bytes
+		// per second. It estimates the rate at which the LSM can absorb writes.
+		flushRate float64
+		waiters   []*writeAdmissionWaiter
+		nextSeq   uint64
+		// tenantBytes is the number of bytes admitted per tenant since the
+		// queue was last empty.
+		tenantBytes map[string]uint64
+	}
+
+	queued            atomic.Int64
+	admittedCount     atomic.Uint64
+	admittedBytes     atomic.Uint64
+	throttledCount    atomic.Uint64
+	throttledDuration atomic.Int64
+}
+
+type writeAdmissionWaiter struct {
+	priority WritePriority
+	tenant   string
+	seq      uint64
+	// signal is notified when the waiter may have reached the head of the
+	// queue, or when writes are no longer throttled. It has a buffer of one,
+	// and signals are never blocking.
+	signal chan struct{}
+}
+
+func (w *writeAdmissionWaiter) notify() {
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func newWriteAdmissionController(
+	opts WriteAdmissionOptions, nowFn func() time.Time,
+) *writeAdmissionController {
+	c := &writeAdmissionController{
+		minRate: float64(opts.MinBytesPerSec),
+		nowFn:   nowFn,
+	}
+	c.mu.tb.InitWithNowFn(tokenbucket.TokensPerSecond(c.minRate), writeAdmissionBurst(c.minRate), nowFn)
+	c.mu.tenantBytes = make(map[string]uint64)
+	return c
+}
+
+func writeAdmissionBurst(rate float64) tokenbucket.Tokens {
+	return tokenbucket.Tokens(max(rate*writeAdmissionBurstDuration.Seconds(), writeAdmissionMinBurst))
+}
+
+// adjust sets the rate of admission given the overload of the LSM, the ratio
+// of its current state to the configured thresholds, and the peak flush
+// throughput observed since the last adjustment (zero if nothing was
+// flushed). Writes are throttled to the flush throughput divided by the
+// overload, so that the LSM converges back below the thresholds.
+func (c *writeAdmissionController) adjust(overload float64, flushPeakRate int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if flushPeakRate > 0 {
+		if c.mu.flushRate == 0 {
+			c.mu.flushRate = float64(flushPeakRate)
+		} else {
+			c.mu.flushRate = (c.mu.flushRate + float64(flushPeakRate)) / 2
+		}
+	}
+	if overload <= 1 {
+		c.setRateLocked(0)
+		return
+	}
+	c.setRateLocked(max(c.minRate, c.mu.flushRate/overload))
+}
+
+// setRateLocked updates the rate of admission, and wakes up the waiters that
+// may be affected. c.mu must be held.
+func (c *writeAdmissionController) setRateLocked(rate float64) {
+	if rate == c.mu.rate {
+		return
+	}
+	c.mu.rate = rate
+	if rate == 0 {
+		for _, w := range c.mu.waiters {
+			w.notify()
+		}
+		return
+	}
+	c.mu.tb.UpdateConfig(tokenbucket.TokensPerSecond(rate), writeAdmissionBurst(rate))
+	if len(c.mu.waiters) > 0 {
+		c.headLocked().notify()
+	}
+}
+
+// release admits every waiting write, and stops throttling until the next
+// adjustment. It is called when the DB is closed.
+func (c *writeAdmissionController) release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setRateLocked(0)
+}
+
+// headLocked returns the waiter at the head of the queue. c.mu must be held,
+// and the queue must not be empty.
+func (c *writeAdmissionController) headLocked() *writeAdmissionWaiter {
+	head := c.mu.waiters[0]
+	for _, w := range c.mu.waiters[1:] {
+		if w.priority != head.priority {
+			if w.priority > head.priority {
+				head = w
+			}
+			continue
+		}
+		if wb, hb := c.mu.tenantBytes[w.tenant], c.mu.tenantBytes[head.tenant]; wb != hb {
+			if wb < hb {
+				head = w
+			}
+			continue
+		}
+		if w.seq < head.seq {
+			head = w
+		}
+	}
+	return head
+}
+
+// removeLocked removes w from the queue. c.mu must be held.
+func (c *writeAdmissionController) removeLocked(w *writeAdmissionWaiter) {
+	for i := range c.mu.waiters {
+		if c.mu.waiters[i] == w {
+			c.mu.waiters = append(c.mu.waiters[:i], c.mu.waiters[i+1:]...)
+			break
+		}
+	}
+	if len(c.mu.waiters) == 0 {
+		clear(c.mu.tenantBytes)
+		return
+	}
+	if c.mu.rate != 0 {
+		c.headLocked().notify()
+	}
+}
+
+// admit blocks until a write of n bytes with the given priority and tenant is
+// admitted. A nil controller never blocks.
+func (c *writeAdmissionController) admit(priority WritePriority, tenant string, n int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	// Fast path: writes are not throttled, or nobody is waiting and there are
+	// enough tokens.
+	if c.mu.rate == 0 {
+		c.mu.Unlock()
+		c.recordAdmitted(n)
+		return
+	}
+	if len(c.mu.waiters) == 0 {
+		if ok, _ := c.mu.tb.TryToFulfill(tokenbucket.Tokens(n)); ok {
+			c.mu.Unlock()
+			c.recordAdmitted(n)
+			return
+		}
+	}
+
+	start := c.nowFn()
+	w := &writeAdmissionWaiter{
+		priority: priority,
+		tenant:   tenant,
+		seq:      c.mu.nextSeq,
+		signal:   make(chan struct{}, 1),
+	}
+	c.mu.nextSeq++
+	if _, ok := c.mu.tenantBytes[tenant]; !ok {
+		// A tenant that starts waiting is granted the share of the waiting
+		// tenant that was admitted the fewest bytes, rather than a share of
+		// zero that would starve the other tenants until it caught up.
+		var share uint64
+		for i, o := range c.mu.waiters {
+			if b := c.mu.tenantBytes[o.tenant]; i == 0 || b < share {
+				share = b
+			}
+		}
+		c.mu.tenantBytes[tenant] = share
+	}
+	c.mu.waiters = append(c.mu.waiters, w)
+	c.queued.Add(1)
+
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		if c.mu.rate != 0 && c.headLocked() != w {
+			c.mu.Unlock()
+			<-w.signal
+			c.mu.Lock()
+			continue
+		}
+		ok, tryAgainAfter := true, time.Duration(0)
+		if c.mu.rate != 0 {
+			ok, tryAgainAfter = c.mu.tb.TryToFulfill(tokenbucket.Tokens(n))
+		}
+		if ok {
+			c.mu.tenantBytes[tenant] += uint64(n)
+			c.removeLocked(w)
+			c.mu.Unlock()
+			c.queued.Add(-1)
+			c.throttledCount.Add(1)
+			c.throttledDuration.Add(int64(c.nowFn().Sub(start)))
+			c.recordAdmitted(n)
+			return
+		}
+		c.mu.Unlock()
+		if timer == nil {
+			timer = time.NewTimer(tryAgainAfter)
+		} else {
+			timer.Reset(tryAgainAfter)
+		}
+		select {
+		case <-timer.C:
+		case <-w.signal:
+			// The rate changed, or a waiter of a higher priority arrived.
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		c.mu.Lock()
+	}
+}
+
+// refund returns the n bytes of an admitted write that was not applied, such
+// as a replicated batch rejected by the commit pipeline (see
+// DB.ApplyReplicated). A nil controller does nothing.
+func (c *writeAdmissionController) refund(n int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.mu.rate != 0 {
+		c.mu.tb.Adjust(tokenbucket.Tokens(n))
+		if len(c.mu.waiters) > 0 {
+			c.headLocked().notify()
+		}
+	}
+	c.mu.Unlock()
+	c.admittedCount.Add(^uint64(0))
+	c.admittedBytes.Add(^uint64(n - 1))
+}
+
+func (c *writeAdmissionController) recordAdmitted(n int64) {
+	c.admittedCount.Add(1)
+	c.admittedBytes.Add(uint64(n))
+}
+
+// metrics populates the WriteAdmission metrics.
+func (c *writeAdmissionController) metrics(m *Metrics) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	m.WriteAdmission.BytesPerSec = uint64(c.mu.rate)
+	c.mu.Unlock()
+	m.WriteAdmission.Queued = c.queued.Load()
+	m.WriteAdmission.AdmittedCount = c.admittedCount.Load()
+	m.WriteAdmission.AdmittedBytes = c.admittedBytes.Load()
+	m.WriteAdmission.ThrottledCount = c.throttledCount.Load()
+	m.WriteAdmission.ThrottledDuration = time.Duration(c.throttledDuration.Load())
+}
+
+// writeOverloadLocked returns the overload of the LSM: the largest ratio of
+// the number of L0 sublevels and of the estimated compaction debt to their
+// thresholds in Options.Experimental.WriteAdmission.
+//
+// d.mu must be held when calling this.
+func (d *DB) writeOverloadLocked() float64 {
+	o := &d.opts.Experimental.WriteAdmission
+	var overload float64
+	if o.L0SublevelThreshold > 0 {
+		sublevels := d.mu.versions.currentVersion().L0Sublevels.ReadAmplification()
+		overload = float64(sublevels) / float64(o.L0SublevelThreshold)
+	}
+	if o.CompactionDebtThreshold > 0 {
+		debt := d.mu.versions.picker.estimatedCompactionDebt(0)
+		overload = max(overload, float64(debt)/float64(o.CompactionDebtThreshold))
+	}
+	return overload
+}
+
+// runWriteAdmissionLoop samples the health of the LSM and adjusts the rate of
+// write admission every WriteAdmission.Interval, until the DB is closed.
+func (d *DB) runWriteAdmissionLoop() {
+	ticker := time.NewTicker(d.opts.Experimental.WriteAdmission.Interval)
+	defer ticker.Stop()
+	var prevFlush ThroughputMetric
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		if d.closed.Load() != nil {
+			d.mu.Unlock()
+			return
+		}
+		overload := d.writeOverloadLocked()
+		flush := d.mu.compact.flushWriteThroughput
+		d.mu.Unlock()
+
+		delta := flush
+		delta.Subtract(prevFlush)
+		prevFlush = flush
+		d.writeAdmission.adjust(overload, delta.PeakRate())
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAdmissionRate(t *testing.T) {
+	const MB = 1 << 20
+	c := newWriteAdmissionController(WriteAdmissionOptions{MinBytesPerSec: MB}, time.Now)
+	rate := func() uint64 {
+		var m Metrics
+		c.metrics(&m)
+		return m.WriteAdmission.BytesPerSec / MB
+	}
+
+	// Writes are not throttled while the LSM is below the thresholds.
+	c.adjust(0.5, 40*MB)
+	require.Zero(t, rate())
+	// Once overloaded, writes are admitted at the flush throughput divided by
+	// the overload.
+	c.adjust(2, 0)
+	require.Equal(t, uint64(20), rate())
+	// The flush throughput is smoothed across adjustments.
+	c.adjust(2, 20*MB)
+	require.Equal(t, uint64(15), rate())
+	// The rate is never reduced below MinBytesPerSec.
+	c.adjust(1000, 0)
+	require.Equal(t, uint64(1), rate())
+	c.adjust(1, 0)
+	require.Zero(t, rate())
+}
+
+func TestWriteAdmissionOrdering(t *testing.T) {
+	// 10 MB/s with a 1 MB burst; every write of 1 MB that is not served by the
+	// burst takes ~100ms.
+	const MB = 1 << 20
+	c := newWriteAdmissionController(WriteAdmissionOptions{MinBytesPerSec: MB}, time.Now)
+	c.adjust(2, 20*MB)
+
+	// Exhaust the burst so that subsequent writes queue up.
+	c.admit(WritePriorityNormal, "a", MB)
+
+	type write struct {
+		priority WritePriority
+		tenant   string
+	}
+	var mu sync.Mutex
+	var order []write
+	var wg sync.WaitGroup
+	start := func(w write) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.admit(w.priority, w.tenant, MB)
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, w)
+		}()
+	}
+	queued := func(n int) func() bool {
+		return func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return len(c.mu.waiters) == n
+		}
+	}
+	writes := []write{
+		{WritePriorityBulk, "a"},
+		{WritePriorityNormal, "a"},
+		{WritePriorityNormal, "a"},
+		{WritePriorityNormal, "b"},
+		{WritePriorityHigh, "a"},
+	}
+	for i, w := range writes {
+		start(w)
+		require.Eventually(t, queued(i+1), 10*time.Second, time.Millisecond)
+	}
+	wg.Wait()
+
+	// The bulk write arrived first and may have been at the head of the queue
+	// when the tokens became available. Otherwise, the high priority write is
+	// admitted first. The bytes it admitted count against the share of tenant
+	// a, so tenant b is admitted before the normal priority writes of tenant
+	// a.
+	require.Len(t, order, 5)
+	if order[0].priority == WritePriorityBulk {
+		order = order[1:]
+	} else {
+		require.Equal(t, WritePriorityBulk, order[4].priority, "order: %v", order)
+		order = order[:4]
+	}
+	require.Equal(t, []write{
+		{WritePriorityHigh, "a"},
+		{WritePriorityNormal, "b"},
+		{WritePriorityNormal, "a"},
+		{WritePriorityNormal, "a"},
+	}, order)
+
+	var m Metrics
+	c.metrics(&m)
+	require.Zero(t, m.WriteAdmission.Queued)
+	require.Equal(t, uint64(6), m.WriteAdmission.AdmittedCount)
+	require.Equal(t, uint64(6*MB), m.WriteAdmission.AdmittedBytes)
+	require.Equal(t, uint64(5), m.WriteAdmission.ThrottledCount)
+	require.Greater(t, m.WriteAdmission.ThrottledDuration, time.Duration(0))
+}
+
+func TestWriteAdmissionRelease(t *testing.T) {
+	c := newWriteAdmissionController(WriteAdmissionOptions{MinBytesPerSec: 1}, time.Now)
+	c.adjust(2, 0)
+	// Exhaust the burst. At one byte per second, the following writes would
+	// never be admitted unless released.
+	c.admit(WritePriorityNormal, "", 1<<20)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.admit(WritePriorityNormal, "", 1<<20)
+		}()
+	}
+	require.Eventually(t, func() bool { return c.queued.Load() == 3 }, 10*time.Second, time.Millisecond)
+	c.release()
+	wg.Wait()
+}
+
+func TestWriteAdmissionOverload(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.WriteAdmission = WriteAdmissionOptions{
+		L0SublevelThreshold: 2,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Every flush of overlapping keys adds an L0 sublevel.
+	for i := 0; i < 4; i++ {
+		require.NoError(t, d.Set([]byte("a"), []byte(fmt.Sprint(i)), NoSync))
+		require.NoError(t, d.Set([]byte("b"), []byte(fmt.Sprint(i)), &WriteOptions{
+			Priority: WritePriorityBulk,
+			Tenant:   "t",
+		}))
+		require.NoError(t, d.Flush())
+	}
+	d.mu.Lock()
+	overload := d.writeOverloadLocked()
+	d.mu.Unlock()
+	require.Equal(t, 2.0, overload)
+
+	m := d.Metrics()
+	require.Equal(t, uint64(8), m.WriteAdmission.AdmittedCount)
+	require.Contains(t, m.String(), "Write admission: ")
+}
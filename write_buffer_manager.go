@@ -0,0 +1,355 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WriteBufferManagerOptions configures a WriteBufferManager.
+type WriteBufferManagerOptions struct {
+	// Limit is the budget, in bytes, for the memtables of all the DBs that
+	// share the WriteBufferManager.
+	Limit int64
+
+	// Cache, if non-nil, is charged for the memtables of all the DBs instead
+	// of their Options.Cache, so that memtables and cached blocks draw from a
+	// single memory budget.
+	Cache *Cache
+
+	// AllowStall enables stalling writes that need a new memtable while the
+	// budget is exhausted, until the immutable memtables of their DB have been
+	// flushed. If false, the budget is only enforced by flushing memtables,
+	// and may be exceeded while flushes are in progress.
+	AllowStall bool
+}
+
+// WriteBufferUsage is the memtable memory of a DB that shares a
+// WriteBufferManager.
+type WriteBufferUsage struct {
+	// Dirname is the directory of the DB.
+	Dirname string
+	// Bytes is the memory allocated for the memtables of the DB, including
+	// memtables that are being flushed or are retained for recycling.
+	Bytes int64
+}
+
+// WriteBufferManager enforces a memory budget for the memtables of multiple
+// DBs (see Options.WriteBufferManager). Every DB sizes its memtables
+// independently (see Options.MemTableSize); once the memtables of all the DBs,
+// other than those already queued for flushing, use more than 7/8 of the
+// budget, the manager flushes the mutable memtable that holds the most data
+// across all the DBs, one at a time, until the usage falls below that
+// threshold. A mutable memtable that holds less than 1/8 of its size is not
+// flushed, as it would be replaced by a memtable of the same size. While over
+// the threshold, the memory of flushed memtables is released rather than
+// retained for recycling.
+type WriteBufferManager struct {
+	opts WriteBufferManagerOptions
+
+	// flushPending is set while a flush requested by the manager is in
+	// progress, so that a single flush is requested at a time.
+	flushPending atomic.Bool
+	// flushCount is the number of flushes requested by the manager.
+	flushCount atomic.Int64
+
+	mu struct {
+		sync.Mutex
+		members []*writeBufferMember
+	}
+}
+
+// NewWriteBufferManager creates a new WriteBufferManager.
+func NewWriteBufferManager(opts WriteBufferManagerOptions) *WriteBufferManager {
+	return &WriteBufferManager{opts: opts}
+}
+
+// Limit returns the budget of the manager, in bytes.
+func (m *WriteBufferManager) Limit() int64 {
+	return m.opts.Limit
+}
+
+// Usage returns the memory allocated for the memtables of all the DBs that
+// share the manager, in bytes.
+func (m *WriteBufferManager) Usage() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usageLocked()
+}
+
+// UsageByDB returns the memory allocated for the memtables of every DB that
+// shares the manager.
+func (m *WriteBufferManager) UsageByDB() []WriteBufferUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := make([]WriteBufferUsage, len(m.mu.members))
+	for i, mb := range m.mu.members {
+		usage[i] = WriteBufferUsage{Dirname: mb.dirname, Bytes: mb.reserved.Load()}
+	}
+	return usage
+}
+
+// FlushCount returns the number of flushes requested by the manager to
+// enforce its budget.
+func (m *WriteBufferManager) FlushCount() int64 {
+	return m.flushCount.Load()
+}
+
+func (m *WriteBufferManager) usageLocked() int64 {
+	var usage int64
+	for _, mb := range m.mu.members {
+		usage += mb.reserved.Load()
+	}
+	return usage
+}
+
+// unqueuedUsageLocked returns the usage, excluding the memory of the memtables
+// and large batches that are queued for flushing, which is released once they
+// are flushed.
+func (m *WriteBufferManager) unqueuedUsageLocked() int64 {
+	var usage int64
+	for _, mb := range m.mu.members {
+		usage += mb.reserved.Load() - mb.queued.Load()
+	}
+	return usage
+}
+
+// flushThreshold returns the usage above which memtables are flushed.
+func (m *WriteBufferManager) flushThreshold() int64 {
+	return m.opts.Limit - m.opts.Limit/8
+}
+
+// overThreshold returns true if the usage exceeds the flush threshold.
+func (m *WriteBufferManager) overThreshold() bool {
+	return m.Usage() > m.flushThreshold()
+}
+
+// exhausted returns true if the usage has reached the limit.
+func (m *WriteBufferManager) exhausted() bool {
+	return m.Usage() >= m.opts.Limit
+}
+
+// maybeFlush requests a flush of the mutable memtable that holds the most data
+// (and among those, the oldest) if the usage, excluding the memtables already
+// queued for flushing, exceeds the flush threshold and no other flush
+// requested by the manager is in progress.
+func (m *WriteBufferManager) maybeFlush() {
+	if m.flushPending.Load() {
+		return
+	}
+	m.mu.Lock()
+	if m.unqueuedUsageLocked() <= m.flushThreshold() {
+		m.mu.Unlock()
+		return
+	}
+	var victim *writeBufferMember
+	var victimBytes uint64
+	var victimSince int64
+	for _, mb := range m.mu.members {
+		mem := mb.mutable.Load()
+		if mem == nil || !mb.opened.Load() {
+			continue
+		}
+		n, since := mem.inuseBytes(), mb.mutableSince.Load()
+		if n < mem.totalBytes()/8 {
+			continue
+		}
+		if n > victimBytes || (n == victimBytes && victim != nil && since < victimSince) {
+			victim, victimBytes, victimSince = mb, n, since
+		}
+	}
+	m.mu.Unlock()
+	if victim == nil || victimBytes == 0 {
+		return
+	}
+	if !m.flushPending.CompareAndSwap(false, true) {
+		return
+	}
+	m.flushCount.Add(1)
+	go victim.d.flushForWriteBuffer()
+}
+
+// flushDone is called once a flush requested by the manager has completed (or
+// will never complete because its DB was closed), and requests another one if
+// the usage still exceeds the flush threshold.
+func (m *WriteBufferManager) flushDone() {
+	m.flushPending.Store(false)
+	m.maybeFlush()
+}
+
+// register adds a DB to the manager.
+func (m *WriteBufferManager) register(d *DB) *writeBufferMember {
+	if m == nil {
+		return nil
+	}
+	if m.opts.Cache != nil {
+		m.opts.Cache.Ref()
+	}
+	mb := &writeBufferMember{manager: m, d: d, dirname: d.dirname}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.members = append(m.mu.members, mb)
+	return mb
+}
+
+// writeBufferMember is the accounting of a single DB within a
+// WriteBufferManager.
+type writeBufferMember struct {
+	manager *WriteBufferManager
+	d       *DB
+	dirname string
+	// reserved is the memory allocated for the memtables of the DB, and queued
+	// the part of it allocated for the memtables and large batches that are
+	// queued for flushing.
+	reserved atomic.Int64
+	queued   atomic.Int64
+	// mutable is the mutable memtable of the DB, and mutableSince the time
+	// (in nanoseconds since the Unix epoch) at which it was allocated.
+	mutable      atomic.Pointer[memTable]
+	mutableSince atomic.Int64
+	// opened is set once the DB has been opened. The manager does not flush
+	// the memtables of a DB while it is replaying its WAL.
+	opened atomic.Bool
+}
+
+// markOpened records that the DB has been opened, making its memtables
+// eligible for flushing.
+func (mb *writeBufferMember) markOpened() {
+	if mb == nil {
+		return
+	}
+	mb.opened.Store(true)
+	mb.manager.maybeFlush()
+}
+
+// unregister removes the DB from the manager. Memtables released afterwards
+// (e.g. by iterators leaked past DB.Close) are no longer accounted for.
+func (mb *writeBufferMember) unregister() {
+	if mb == nil {
+		return
+	}
+	m := mb.manager
+	m.mu.Lock()
+	for i := range m.mu.members {
+		if m.mu.members[i] == mb {
+			m.mu.members = append(m.mu.members[:i], m.mu.members[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+	mb.mutable.Store(nil)
+	if m.opts.Cache != nil {
+		m.opts.Cache.Unref()
+	}
+}
+
+// allocated records the allocation of the given number of bytes for a
+// memtable or a large batch.
+func (mb *writeBufferMember) allocated(size int64) {
+	if mb == nil {
+		return
+	}
+	mb.reserved.Add(size)
+}
+
+// setMutable records that mem is the new mutable memtable of the DB, which
+// queues the previous one for flushing, and flushes memtables if the usage
+// exceeds the flush threshold.
+func (mb *writeBufferMember) setMutable(mem *memTable) {
+	if mb == nil {
+		return
+	}
+	if prev := mb.mutable.Swap(mem); prev != nil {
+		mb.queued.Add(int64(len(prev.arenaBuf)))
+	}
+	mb.mutableSince.Store(mb.d.timeNow().UnixNano())
+	mb.manager.maybeFlush()
+}
+
+// queuedBatch records that a large batch of the given size was queued for
+// flushing.
+func (mb *writeBufferMember) queuedBatch(size int64) {
+	if mb == nil {
+		return
+	}
+	mb.queued.Add(size)
+}
+
+// releasedBatch records that a large batch of the given size that was queued
+// for flushing has been released.
+func (mb *writeBufferMember) releasedBatch(size int64) {
+	if mb == nil {
+		return
+	}
+	mb.queued.Add(-size)
+}
+
+// releasedMemTable records that a memtable has been released, either after it
+// was queued for flushing and flushed, or, if it is still the mutable
+// memtable, because the DB was closed.
+func (mb *writeBufferMember) releasedMemTable(mem *memTable) {
+	if mb == nil || mb.mutable.Load() == mem {
+		return
+	}
+	mb.queued.Add(-int64(len(mem.arenaBuf)))
+}
+
+// freed records the release of the given number of bytes allocated for a
+// memtable or a large batch.
+func (mb *writeBufferMember) freed(size int64) {
+	if mb == nil {
+		return
+	}
+	mb.reserved.Add(-size)
+}
+
+// recycleAllowed returns false if the memory of obsolete memtables should be
+// released rather than retained for recycling.
+func (mb *writeBufferMember) recycleAllowed() bool {
+	return mb == nil || !mb.manager.overThreshold()
+}
+
+// shouldStall returns true if a write that needs a new memtable should wait
+// for the immutable memtables of the DB to be flushed.
+func (mb *writeBufferMember) shouldStall() bool {
+	return mb != nil && mb.manager.opts.AllowStall && mb.manager.exhausted()
+}
+
+// memTableCache returns the cache charged for the memory of memtables.
+func (d *DB) memTableCache() *Cache {
+	if wb := d.opts.WriteBufferManager; wb != nil && wb.opts.Cache != nil {
+		return wb.opts.Cache
+	}
+	return d.opts.Cache
+}
+
+// flushForWriteBuffer flushes the mutable memtable on behalf of the
+// WriteBufferManager, and notifies the manager once the flush completes.
+func (d *DB) flushForWriteBuffer() {
+	m := d.opts.WriteBufferManager
+	flushed, ok := func() (<-chan struct{}, bool) {
+		d.commit.mu.Lock()
+		defer d.commit.mu.Unlock()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.closed.Load() != nil || d.mu.mem.mutable.empty() {
+			return nil, false
+		}
+		flushed := d.mu.mem.queue[len(d.mu.mem.queue)-1].flushed
+		if err := d.makeRoomForWrite(nil); err != nil {
+			d.opts.Logger.Errorf("pebble: write buffer flush failed: %v", err)
+			return nil, false
+		}
+		return flushed, true
+	}()
+	if ok {
+		select {
+		case <-flushed:
+		case <-d.closedCh:
+		}
+	}
+	m.flushDone()
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBufferManager(t *testing.T) {
+	const MB = 1 << 20
+	c := NewCache(16 * MB)
+	defer c.Unref()
+	wbm := NewWriteBufferManager(WriteBufferManagerOptions{
+		Limit:      MB,
+		Cache:      c,
+		AllowStall: true,
+	})
+	open := func(dirname string) *DB {
+		d, err := Open(dirname, &Options{
+			FS:                 vfs.NewMem(),
+			MemTableSize:       256 << 10,
+			WriteBufferManager: wbm,
+		})
+		require.NoError(t, err)
+		return d
+	}
+	a, b, c2, d := open("a"), open("b"), open("c"), open("d")
+	memTableSize := func(d *DB) int64 {
+		m := d.Metrics()
+		return int64(m.MemTable.Size + m.MemTable.ZombieSize)
+	}
+	require.Equal(t, []WriteBufferUsage{
+		{Dirname: "a", Bytes: memTableSize(a)},
+		{Dirname: "b", Bytes: memTableSize(b)},
+		{Dirname: "c", Bytes: memTableSize(c2)},
+		{Dirname: "d", Bytes: memTableSize(d)},
+	}, wbm.UsageByDB())
+	require.Equal(t, memTableSize(a)+memTableSize(b)+memTableSize(c2)+memTableSize(d), wbm.Usage())
+	// The usage exceeds the flush threshold, but empty memtables are not
+	// flushed.
+	require.Greater(t, wbm.Usage(), wbm.flushThreshold())
+	require.Zero(t, wbm.FlushCount())
+
+	// Fill most of the memtable of b, then write enough to a for its memtable
+	// to be rotated. The memtable of a that is queued for flushing does not
+	// count towards the flush threshold, but the new one does, and the manager
+	// flushes b, which holds the most data in its mutable memtable.
+	value := make([]byte, 1<<10)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("b%04d", i)), value, NoSync))
+	}
+	for i := 0; i < 300; i++ {
+		require.NoError(t, a.Set([]byte(fmt.Sprintf("a%04d", i)), value, NoSync))
+	}
+	require.Eventually(t, func() bool {
+		return b.Metrics().Flush.Count > 0
+	}, 10*time.Second, time.Millisecond)
+	require.Greater(t, wbm.FlushCount(), int64(0))
+	require.Zero(t, c2.Metrics().Flush.Count)
+	require.Zero(t, d.Metrics().Flush.Count)
+
+	// Once flushes complete, the memtables fit within the budget again.
+	require.NoError(t, a.Flush())
+	require.NoError(t, b.Flush())
+	require.LessOrEqual(t, wbm.Usage(), wbm.Limit())
+
+	require.NoError(t, a.Close())
+	require.NoError(t, c2.Close())
+	require.NoError(t, d.Close())
+	require.Equal(t, []WriteBufferUsage{
+		{Dirname: "b", Bytes: memTableSize(b)},
+	}, wbm.UsageByDB())
+	require.NoError(t, b.Close())
+	require.Zero(t, wbm.Usage())
+}